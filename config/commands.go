@@ -0,0 +1,211 @@
+// Package config config/commands.go
+package config
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Commands holds the commands sent to a device, whose syntax differs between PAN-OS versions
+type Commands struct {
+	// SystemInfo is the XML API op command returning the system information
+	SystemInfo string
+	// DeviceCertificateStatus is the XML API op command returning the device certificate status
+	DeviceCertificateStatus string
+	// WildFireRegistration is the CLI command triggering WildFire registration. Its %s verb is
+	// replaced by the registration channel; without one, only the public cloud is supported.
+	WildFireRegistration string
+	// WildFireStatus is the CLI command showing the WildFire registration status
+	WildFireStatus string
+	// DNSSettings is the XML API op command returning the configured DNS servers
+	DNSSettings string
+	// NTPStatus is the XML API op command returning the NTP synchronization status
+	NTPStatus string
+	// Jobs is the XML API op command listing the jobs, used to spot a running auto-commit
+	Jobs string
+}
+
+// VersionCommands maps a PAN-OS major version to the commands used from that version onwards
+var VersionCommands = map[int]Commands{
+	8: {
+		SystemInfo:              "<show><system><info/></system></show>",
+		DeviceCertificateStatus: "<show><device-certificate><status/></device-certificate></show>",
+		WildFireRegistration:    "request wildfire registration",
+		WildFireStatus:          "show wildfire status",
+		DNSSettings:             "<show><config><running><xpath>devices/entry/deviceconfig/system/dns-setting</xpath></running></config></show>",
+		NTPStatus:               "<show><ntp></ntp></show>",
+		Jobs:                    "<show><jobs><all/></jobs></show>",
+	},
+	9: {
+		SystemInfo:              "<show><system><info/></system></show>",
+		DeviceCertificateStatus: "<show><device-certificate><status/></device-certificate></show>",
+		WildFireRegistration:    "request wildfire registration channel %s",
+		WildFireStatus:          "show wildfire status",
+		DNSSettings:             "<show><config><running><xpath>devices/entry/deviceconfig/system/dns-setting</xpath></running></config></show>",
+		NTPStatus:               "<show><ntp></ntp></show>",
+		Jobs:                    "<show><jobs><all/></jobs></show>",
+	},
+}
+
+// CommandsForMajor returns the commands for a PAN-OS major version: the entry of VersionCommands
+// with the highest version not above major. Versions older than every entry use the oldest entry,
+// and an unknown version (0) uses the newest one.
+func CommandsForMajor(major int) Commands {
+	versions := make([]int, 0, len(VersionCommands))
+	for version := range VersionCommands {
+		versions = append(versions, version)
+	}
+	return VersionCommands[NearestMajor(versions, major)]
+}
+
+// NearestMajor returns the version of versions that applies to a PAN-OS major version, following
+// the rules of CommandsForMajor. versions must not be empty.
+func NearestMajor(versions []int, major int) int {
+	sorted := append([]int(nil), versions...)
+	sort.Ints(sorted)
+
+	if major <= 0 {
+		return sorted[len(sorted)-1]
+	}
+
+	selected := sorted[0]
+	for _, version := range sorted {
+		if version <= major {
+			selected = version
+		}
+	}
+	return selected
+}
+
+// DeviceMajor returns the PAN-OS major version of a device from its "parsed_version_major" field
+// or, when the version was not parsed yet, from its "sw-version" field. It is 0 when unknown.
+func DeviceMajor(device map[string]string) int {
+	major, err := strconv.Atoi(device["parsed_version_major"])
+	if err != nil {
+		majorText, _, _ := strings.Cut(device["sw-version"], ".")
+		major, _ = strconv.Atoi(majorText)
+	}
+	return major
+}
+
+// CommandsForDevice returns the commands for the PAN-OS major version of a device, see DeviceMajor.
+func CommandsForDevice(device map[string]string) Commands {
+	return CommandsForMajor(DeviceMajor(device))
+}
+
+// WildFireRegistrationCommand returns the WildFire registration command for a channel and,
+// when server is not empty, for that WildFire cloud or appliance server.
+func (c Commands) WildFireRegistrationCommand(channel, server string) (string, error) {
+	if !strings.Contains(c.WildFireRegistration, "%s") {
+		if channel != "public" {
+			return "", fmt.Errorf("WildFire registration channel %s is not supported by this PAN-OS version", channel)
+		}
+		if server != "" {
+			return "", fmt.Errorf("WildFire registration server %s is not supported by this PAN-OS version", server)
+		}
+		return c.WildFireRegistration, nil
+	}
+	cmd := fmt.Sprintf(c.WildFireRegistration, channel)
+	if server != "" {
+		cmd += " server " + server
+	}
+	return cmd, nil
+}
+
+// CommandOverride replaces compiled-in commands and registration outputs from a PAN-OS major
+// version onwards. It is read from the commands section of the config file, so that the tool can
+// adapt to a new PAN-OS release without a rebuild. Empty fields keep the compiled-in value.
+type CommandOverride struct {
+	SystemInfo              string `yaml:"system_info,omitempty"`
+	DeviceCertificateStatus string `yaml:"device_certificate_status,omitempty"`
+	WildFireRegistration    string `yaml:"wildfire_registration,omitempty"`
+	WildFireStatus          string `yaml:"wildfire_status,omitempty"`
+	DNSSettings             string `yaml:"dns_settings,omitempty"`
+	NTPStatus               string `yaml:"ntp_status,omitempty"`
+	Jobs                    string `yaml:"jobs,omitempty"`
+	// SuccessMessages maps a registration channel to the output expected from the registration command
+	SuccessMessages map[string]string `yaml:"success_messages,omitempty"`
+}
+
+// ValidateCommandOverrides checks the command overrides of a config file: the major versions must
+// be positive, the XML API commands well-formed and the registration template may only contain a
+// single %s verb for the channel.
+func ValidateCommandOverrides(overrides map[int]CommandOverride) error {
+	for major, override := range overrides {
+		if major <= 0 {
+			return fmt.Errorf("invalid PAN-OS major version %d in commands", major)
+		}
+		for name, cmd := range map[string]string{
+			"system_info":               override.SystemInfo,
+			"device_certificate_status": override.DeviceCertificateStatus,
+			"dns_settings":              override.DNSSettings,
+			"ntp_status":                override.NTPStatus,
+			"jobs":                      override.Jobs,
+		} {
+			if cmd != "" && !wellFormedXML(cmd) {
+				return fmt.Errorf("commands %d %s is not a well-formed XML API command: %s", major, name, cmd)
+			}
+		}
+		if template := override.WildFireRegistration; strings.Count(template, "%") != strings.Count(template, "%s") || strings.Count(template, "%s") > 1 {
+			return fmt.Errorf("commands %d wildfire_registration may only contain a single %%s for the channel: %s", major, template)
+		}
+		if strings.Contains(override.WildFireStatus, "%") {
+			return fmt.Errorf("commands %d wildfire_status must not contain a format verb: %s", major, override.WildFireStatus)
+		}
+		for channel, message := range override.SuccessMessages {
+			if strings.TrimSpace(channel) == "" || strings.TrimSpace(message) == "" {
+				return fmt.Errorf("commands %d success_messages must map a channel to a non-empty output", major)
+			}
+		}
+	}
+	return nil
+}
+
+// wellFormedXML reports whether cmd is a single well-formed XML element
+func wellFormedXML(cmd string) bool {
+	var element struct {
+		XMLName xml.Name
+	}
+	decoder := xml.NewDecoder(strings.NewReader(strings.TrimSpace(cmd)))
+	if err := decoder.Decode(&element); err != nil {
+		return false
+	}
+	_, err := decoder.Token()
+	return err == io.EOF
+}
+
+// ApplyCommandOverrides layers the command overrides onto VersionCommands. An override for a
+// version without an entry starts from the commands that version used so far.
+func ApplyCommandOverrides(overrides map[int]CommandOverride) {
+	// Older versions first, as a new version starts from the commands of the versions below it
+	majors := make([]int, 0, len(overrides))
+	for major := range overrides {
+		majors = append(majors, major)
+	}
+	sort.Ints(majors)
+	for _, major := range majors {
+		override := overrides[major]
+		commands := CommandsForMajor(major)
+		for _, field := range []struct {
+			value  string
+			target *string
+		}{
+			{override.SystemInfo, &commands.SystemInfo},
+			{override.DeviceCertificateStatus, &commands.DeviceCertificateStatus},
+			{override.WildFireRegistration, &commands.WildFireRegistration},
+			{override.WildFireStatus, &commands.WildFireStatus},
+			{override.DNSSettings, &commands.DNSSettings},
+			{override.NTPStatus, &commands.NTPStatus},
+			{override.Jobs, &commands.Jobs},
+		} {
+			if field.value != "" {
+				*field.target = field.value
+			}
+		}
+		VersionCommands[major] = commands
+	}
+}
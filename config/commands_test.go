@@ -0,0 +1,103 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandsForMajor(t *testing.T) {
+	assert.Equal(t, VersionCommands[8], CommandsForMajor(8))
+	assert.Equal(t, VersionCommands[8], CommandsForMajor(7), "older versions use the oldest commands")
+	assert.Equal(t, VersionCommands[9], CommandsForMajor(9))
+	assert.Equal(t, VersionCommands[9], CommandsForMajor(11))
+	assert.Equal(t, VersionCommands[9], CommandsForMajor(0), "unknown versions use the newest commands")
+}
+
+func TestNearestMajor(t *testing.T) {
+	versions := []int{10, 8, 9}
+	assert.Equal(t, 8, NearestMajor(versions, 7))
+	assert.Equal(t, 9, NearestMajor(versions, 9))
+	assert.Equal(t, 10, NearestMajor(versions, 11))
+	assert.Equal(t, 10, NearestMajor(versions, 0))
+	assert.Equal(t, []int{10, 8, 9}, versions, "versions are not reordered")
+}
+
+func TestCommandsForDevice(t *testing.T) {
+	panos81 := CommandsForDevice(map[string]string{"parsed_version_major": "8", "sw-version": "8.1.21-h3"})
+	panos111 := CommandsForDevice(map[string]string{"sw-version": "11.1.0-h2"})
+
+	cmd, err := panos81.WildFireRegistrationCommand("public", "")
+	require.NoError(t, err)
+	assert.Equal(t, "request wildfire registration", cmd)
+
+	cmd, err = panos111.WildFireRegistrationCommand("public", "")
+	require.NoError(t, err)
+	assert.Equal(t, "request wildfire registration channel public", cmd)
+
+	cmd, err = panos111.WildFireRegistrationCommand("private", "wf500.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "request wildfire registration channel private server wf500.example.com", cmd)
+
+	_, err = panos81.WildFireRegistrationCommand("private", "")
+	assert.ErrorContains(t, err, "not supported")
+
+	_, err = panos81.WildFireRegistrationCommand("public", "eu.wildfire.paloaltonetworks.com")
+	assert.ErrorContains(t, err, "not supported")
+
+	assert.Equal(t, panos81.DeviceCertificateStatus, panos111.DeviceCertificateStatus)
+}
+
+func TestValidateCommandOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[int]CommandOverride
+		expected  string
+	}{
+		{"Valid", map[int]CommandOverride{12: {
+			WildFireRegistration: "request wildfire registration channel %s now",
+			DNSSettings:          "<show><dns/></show>",
+			SuccessMessages:      map[string]string{"public": "registration triggered"},
+		}}, ""},
+		{"Template without channel", map[int]CommandOverride{12: {WildFireRegistration: "request wildfire registration"}}, ""},
+		{"Invalid version", map[int]CommandOverride{0: {}}, "invalid PAN-OS major version"},
+		{"Two verbs", map[int]CommandOverride{12: {WildFireRegistration: "request wildfire registration channel %s %s"}}, "single %s"},
+		{"Other verb", map[int]CommandOverride{12: {WildFireRegistration: "request wildfire registration channel %d"}}, "single %s"},
+		{"Malformed XML", map[int]CommandOverride{12: {SystemInfo: "<show><system><info/></show>"}}, "well-formed"},
+		{"Trailing text", map[int]CommandOverride{12: {NTPStatus: "<show><ntp/></show> extra"}}, "well-formed"},
+		{"Status with verb", map[int]CommandOverride{12: {WildFireStatus: "show wildfire status %s"}}, "format verb"},
+		{"Empty success message", map[int]CommandOverride{12: {SuccessMessages: map[string]string{"public": " "}}}, "non-empty output"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCommandOverrides(tt.overrides)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyCommandOverrides(t *testing.T) {
+	original := make(map[int]Commands, len(VersionCommands))
+	for version, commands := range VersionCommands {
+		original[version] = commands
+	}
+	t.Cleanup(func() { VersionCommands = original })
+
+	ApplyCommandOverrides(map[int]CommandOverride{
+		9:  {WildFireStatus: "show wildfire status verbose"},
+		12: {WildFireRegistration: "request wildfire registration cloud %s"},
+	})
+
+	assert.Equal(t, "show wildfire status", CommandsForMajor(8).WildFireStatus)
+	assert.Equal(t, "show wildfire status verbose", CommandsForMajor(11).WildFireStatus)
+	assert.Equal(t, "request wildfire registration channel %s", CommandsForMajor(11).WildFireRegistration)
+	assert.Equal(t, "request wildfire registration cloud %s", CommandsForMajor(12).WildFireRegistration)
+	assert.Equal(t, "show wildfire status verbose", CommandsForMajor(12).WildFireStatus, "a new version starts from the overridden commands below it")
+	assert.Equal(t, original[9].SystemInfo, CommandsForMajor(12).SystemInfo)
+}
@@ -3,8 +3,12 @@ package config
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -20,7 +24,48 @@ type Config struct {
 	} `yaml:"panorama"`
 	Auth           AuthConfig
 	HostnameFilter string
-	ReportOnly     bool
+	// HostnameExclude lists the hostname patterns of the devices dropped after HostnameFilter
+	HostnameExclude string
+	OnlySerials     string
+	MergeInventory  bool
+	// InventoryFile is the path of the inventory file, JSON or YAML depending on its extension
+	InventoryFile string
+	Concurrency   int
+	// RampUp spreads the start of the Concurrency workers of each phase over this duration
+	RampUp              time.Duration
+	PanoramaConcurrency int
+	SkipFailedPanoramas bool
+	PanoramaScope       string
+	DeviceTimeout       int
+	APITimeout          time.Duration
+	// Retries is how often a failed client initialization or op command is retried, waiting
+	// RetryBackoff before the first retry and doubling the wait for each following one
+	Retries       int
+	RetryBackoff  time.Duration
+	ReportOnly    bool
+	CollectDNSNTP bool
+	// HealthGate skips the registration of devices that are not in normal operational mode or run
+	// an auto-commit, and queries the jobs of inventory devices to spot the auto-commit
+	HealthGate bool
+	// AllowedIssuers, when set, are the only device certificate issuers accepted as compliant
+	AllowedIssuers []string
+
+	// SerializeRegistrations runs the WildFire registrations one at a time, waiting
+	// RegistrationCooldown after each one
+	SerializeRegistrations bool
+	RegistrationCooldown   time.Duration
+
+	// InventoryFieldMapping maps custom inventory column names to the canonical
+	// inventory fields, e.g. {"mgmt_ip": "ip_address", "name": "hostname"}
+	InventoryFieldMapping map[string]string `yaml:"inventory_field_mapping,omitempty"`
+
+	// Commands override the compiled-in commands and registration outputs per PAN-OS major
+	// version, see CommandOverride
+	Commands map[int]CommandOverride `yaml:"commands,omitempty"`
+
+	// HTTPHeaders are added to every XML API request, e.g. the token required by a WAF in front
+	// of the devices. The -http-header flags take precedence over the config file.
+	HTTPHeaders map[string]string `yaml:"http_headers,omitempty"`
 }
 
 // AuthConfig represents the authentication configuration.
@@ -29,10 +74,12 @@ type AuthConfig struct {
 		Panorama struct {
 			Username string `yaml:"username"`
 			Password string `yaml:"password"`
+			ApiKey   string `yaml:"api_key"`
 		} `yaml:"panorama"`
 		Firewall struct {
 			Username string `yaml:"username"`
 			Password string `yaml:"password"`
+			ApiKey   string `yaml:"api_key"`
 		} `yaml:"firewall"`
 	} `yaml:"auth"`
 }
@@ -51,17 +98,32 @@ type DeviceEntry struct {
 	AVVersion       string                  `xml:"av-version"`
 	WildfireVersion string                  `xml:"wildfire-version"`
 	ThreatVersion   string                  `xml:"threat-version"`
+	SystemMode      string                  `xml:"system-mode"`
+	OperationalMode string                  `xml:"operational-mode"`
+	Connected       string                  `xml:"connected"`
+	HA              HAStatus                `xml:"ha"`
 	Result          string                  `json:"result,omitempty"`
 	Errors          []string                `json:"errors,omitempty"`
 	DeviceCert      DeviceCertificateStatus `json:"deviceCert,omitempty"`
 }
 
+// HAStatus represents the high availability state Panorama reports for a device in an HA pair.
+type HAStatus struct {
+	State string `xml:"state"`
+	Peer  struct {
+		Serial string `xml:"serial"`
+	} `xml:"peer"`
+}
+
 // DevicesResponse represents the structure of the XML response from Panorama.
 type DevicesResponse struct {
 	XMLName xml.Name `xml:"response"`
 	Status  string   `xml:"status,attr"`
 	Result  struct {
-		Devices struct {
+		// TotalCount and Count are the number of devices, when Panorama reports it
+		TotalCount string `xml:"total-count,attr"`
+		Count      string `xml:"count,attr"`
+		Devices    struct {
 			Entries []DeviceEntry `xml:"entry"`
 		} `xml:"devices"`
 	} `xml:"result"`
@@ -69,6 +131,7 @@ type DevicesResponse struct {
 
 // DeviceCertificateStatus represents the response of command `show device-certificate status`.
 type DeviceCertificateStatus struct {
+	Issuer          string `xml:"issuer"`
 	Msg             string `xml:"msg"`
 	NotValidAfter   string `xml:"not_valid_after"`
 	NotValidBefore  string `xml:"not_valid_before"`
@@ -78,15 +141,26 @@ type DeviceCertificateStatus struct {
 	Validity        string `xml:"validity"`
 }
 
-// Inventory represents the structure of the inventory.yaml file
+// Inventory represents the structure of the inventory file, inventory.yaml or its JSON equivalent
 type Inventory struct {
-	Inventory []InventoryDevice `yaml:"inventory"`
+	Inventory []InventoryDevice `yaml:"inventory" json:"inventory"`
 }
 
 // InventoryDevice represents a single device in the inventory
 type InventoryDevice struct {
-	Hostname  string `yaml:"hostname"`
-	IPAddress string `yaml:"ip_address"`
+	Hostname  string   `yaml:"hostname" json:"hostname"`
+	IPAddress string   `yaml:"ip_address" json:"ip_address"`
+	Tags      []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Timeout   int      `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Channel   string   `yaml:"channel,omitempty" json:"channel,omitempty"`
+	// SkipRegistration audits the device without ever registering it with WildFire
+	SkipRegistration bool `yaml:"skip_registration,omitempty" json:"skip_registration,omitempty"`
+	// Username, Password and ApiKey override the firewall credentials of the secrets file for this device
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	ApiKey   string `yaml:"api_key,omitempty" json:"api_key,omitempty"`
+	// Facts are additional fields recorded on the device, e.g. a site or owner column of an inventory database
+	Facts map[string]string `yaml:"facts,omitempty" json:"facts,omitempty"`
 }
 
 // Load reads configuration and secrets from YAML files and returns a Config struct.
@@ -94,19 +168,73 @@ type InventoryDevice struct {
 // from a secrets file, combining them into a single Config struct.
 func Load(configFile, secretsFile string, flags *Flags) (*Config, error) {
 	var config Config
-	if err := readYAMLFile(configFile, &config); err != nil {
+	// With a connection URL, the Panorama config file is optional and a missing file reads as empty
+	if err := readYAMLFile(configFile, &config); err != nil && !(errors.Is(err, fs.ErrNotExist) && flags.Connect != "") {
 		return nil, fmt.Errorf("failed to read Panorama config: %w", err)
 	}
-	if err := readYAMLFile(secretsFile, &config.Auth); err != nil {
+	if err := ValidateCommandOverrides(config.Commands); err != nil {
+		return nil, fmt.Errorf("invalid Panorama config: %w", err)
+	}
+	// The secrets file may be omitted when the environment or the connection URL provides the
+	// credentials, e.g. in CI
+	if err := readYAMLFile(secretsFile, &config.Auth); err != nil && !(errors.Is(err, fs.ErrNotExist) && (hasEnvCredentials() || hasConnectCredentials(flags.Connect))) {
 		return nil, fmt.Errorf("failed to read secrets: %w", err)
 	}
+	applyEnvCredentials(&config)
 
 	// Merge flags into the config
 	config.HostnameFilter = flags.HostnameFilter
+	config.HostnameExclude = flags.HostnameExclude
+	config.OnlySerials = flags.OnlySerials
+	config.MergeInventory = flags.MergeInventory
+	config.InventoryFile = flags.InventoryFile
+	config.Concurrency = flags.Concurrency
+	config.RampUp = flags.RampUp
+	config.PanoramaConcurrency = flags.PanoramaConcurrency
+	config.SkipFailedPanoramas = flags.SkipFailedPanoramas
+	config.PanoramaScope = flags.PanoramaScope
+	config.DeviceTimeout = flags.DeviceTimeout
+	config.APITimeout = flags.APITimeout
+	config.Retries = flags.Retries
+	config.RetryBackoff = flags.RetryBackoff
+	config.CollectDNSNTP = flags.CollectDNSNTP
+	config.HealthGate = flags.HealthGate
+	config.AllowedIssuers = ParseAllowedIssuers(flags.CertAllowedIssuers)
+	config.SerializeRegistrations = flags.SerializeRegistrations
+	config.RegistrationCooldown = flags.RegistrationCooldown
+	headers, err := mergeHTTPHeaders(config.HTTPHeaders, flags.HTTPHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP headers: %w", err)
+	}
+	config.HTTPHeaders = headers
+
+	// A connection URL overrides the Panorama from the config file
+	if flags.Connect != "" {
+		if err := applyConnectURL(&config, flags.Connect); err != nil {
+			return nil, fmt.Errorf("failed to apply connection URL: %w", err)
+		}
+		// The URL only holds the Panorama credentials, and the devices of Panorama are contacted
+		// with the firewall credentials, which may now be missing with the secrets file
+		if err := checkFirewallCredentials(&config); err != nil {
+			return nil, err
+		}
+	}
 
 	return &config, nil
 }
 
+// ParseAllowedIssuers splits a semicolon-separated list of certificate issuers, as issuer names
+// contain commas. Empty entries are ignored.
+func ParseAllowedIssuers(list string) []string {
+	var issuers []string
+	for _, issuer := range strings.Split(list, ";") {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers
+}
+
 // readYAMLFile reads and unmarshals YAML data from a file into a provided interface.
 // This function reads the contents of a YAML file specified by the filename,
 // and unmarshals the data into the provided interface.
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,15 +46,18 @@ auth:
 						Panorama struct {
 							Username string `yaml:"username"`
 							Password string `yaml:"password"`
+							ApiKey   string `yaml:"api_key"`
 						} `yaml:"panorama"`
 						Firewall struct {
 							Username string `yaml:"username"`
 							Password string `yaml:"password"`
+							ApiKey   string `yaml:"api_key"`
 						} `yaml:"firewall"`
 					}{
 						Panorama: struct {
 							Username string `yaml:"username"`
 							Password string `yaml:"password"`
+							ApiKey   string `yaml:"api_key"`
 						}{
 							Username: "panorama-user",
 							Password: "panorama-pass",
@@ -61,6 +65,7 @@ auth:
 						Firewall: struct {
 							Username string `yaml:"username"`
 							Password string `yaml:"password"`
+							ApiKey   string `yaml:"api_key"`
 						}{
 							Username: "firewall-user",
 							Password: "firewall-pass",
@@ -68,6 +73,7 @@ auth:
 					},
 				},
 				HostnameFilter: "",
+				Concurrency:    runtime.NumCPU(),
 			},
 			expectError: false,
 		},
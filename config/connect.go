@@ -0,0 +1,96 @@
+// Package config config/connect.go
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ConnectTarget is the Panorama host and credentials parsed from a connection URL
+type ConnectTarget struct {
+	Hostname string
+	Username string
+	Password string
+}
+
+// ParseConnectURL parses a connection URL such as "user:pass@panorama.example.com".
+// The scheme is optional, and special characters in the username and password must be
+// URL-encoded, e.g. "p%40ss" for "p@ss". The credentials may be omitted entirely.
+func ParseConnectURL(raw string) (*ConnectTarget, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("empty connection URL")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("connection URL has no host")
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("connection URL must only contain credentials and a host")
+	}
+
+	target := &ConnectTarget{Hostname: u.Host}
+	if u.User != nil {
+		target.Username = u.User.Username()
+		target.Password, _ = u.User.Password()
+	}
+	return target, nil
+}
+
+// hasConnectCredentials reports whether a connection URL includes both a username and a password
+func hasConnectCredentials(raw string) bool {
+	if raw == "" {
+		return false
+	}
+	target, err := ParseConnectURL(raw)
+	return err == nil && target.Username != "" && target.Password != ""
+}
+
+// applyConnectURL replaces the configured Panorama with the one from the connection URL.
+// Credentials in the URL take precedence; when they are omitted, the PANOS_PANO_USERNAME and
+// PANOS_PANO_PASSWORD environment variables are used, falling back to the secrets file.
+func applyConnectURL(config *Config, raw string) error {
+	target, err := ParseConnectURL(raw)
+	if err != nil {
+		return err
+	}
+
+	config.Panorama = []struct {
+		Hostname string `yaml:"hostname"`
+	}{{Hostname: target.Hostname}}
+
+	username, password := target.Username, target.Password
+	if username == "" {
+		username = os.Getenv(EnvPanoramaUsername)
+	}
+	if password == "" {
+		password = os.Getenv(EnvPanoramaPassword)
+	}
+
+	if username != "" {
+		config.Auth.Credentials.Panorama.Username = username
+	}
+	if password != "" {
+		config.Auth.Credentials.Panorama.Password = password
+	}
+	return nil
+}
+
+// checkFirewallCredentials returns an error when the firewall username, or both the firewall
+// password and API key, are missing. The Panorama credentials are never used for the firewalls.
+func checkFirewallCredentials(config *Config) error {
+	firewall := config.Auth.Credentials.Firewall
+	if firewall.Username == "" || (firewall.Password == "" && firewall.ApiKey == "") {
+		return fmt.Errorf("no firewall credentials: set the firewall username and password or API key in the secrets file, or set %s and %s; -connect only sets the Panorama credentials", EnvFirewallUsername, EnvFirewallPassword)
+	}
+	return nil
+}
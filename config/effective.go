@@ -0,0 +1,85 @@
+// Package config config/effective.go
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Setting is a single effective configuration value, as listed in the report appendix
+type Setting struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// EffectiveSettings lists the command-line flags of the run and the configuration loaded from
+// the config and secrets files, with passwords redacted, so a stored report is self-describing.
+func EffectiveSettings(conf *Config) []Setting {
+	return effectiveSettings(flag.CommandLine, conf)
+}
+
+// effectiveSettings lists every flag of the flag set in lexical order, followed by the Panorama
+// hosts, the credentials and the HTTP header names from the loaded configuration.
+func effectiveSettings(fs *flag.FlagSet, conf *Config) []Setting {
+	var settings []Setting
+	fs.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, Setting{Name: "-" + f.Name, Value: f.Value.String()})
+	})
+
+	if conf != nil {
+		settings = append(settings, configSettings(conf)...)
+	}
+
+	// Every value goes through the same redaction as the other outputs, so a new secret cannot slip through
+	for i := range settings {
+		settings[i].Value = RedactSecrets(settings[i].Name, settings[i].Value)
+	}
+	return settings
+}
+
+// configSettings lists the Panorama hosts, the credentials and the HTTP headers of the loaded configuration
+func configSettings(conf *Config) []Setting {
+	hostnames := make([]string, 0, len(conf.Panorama))
+	for _, pano := range conf.Panorama {
+		hostnames = append(hostnames, pano.Hostname)
+	}
+	credentials := conf.Auth.Credentials
+	return []Setting{
+		{Name: "panorama.hostname", Value: strings.Join(hostnames, ", ")},
+		{Name: "auth.panorama.username", Value: credentials.Panorama.Username},
+		{Name: "auth.panorama.password", Value: credentials.Panorama.Password},
+		{Name: "auth.panorama.api_key", Value: credentials.Panorama.ApiKey},
+		{Name: "auth.firewall.username", Value: credentials.Firewall.Username},
+		{Name: "auth.firewall.password", Value: credentials.Firewall.Password},
+		{Name: "auth.firewall.api_key", Value: credentials.Firewall.ApiKey},
+		{Name: "http_headers", Value: headerNames(conf.HTTPHeaders)},
+	}
+}
+
+// headerNames lists the names of the HTTP headers with their values redacted
+func headerNames(headers map[string]string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	return redactHeaders(names)
+}
+
+// DumpSettings renders the effective settings, already redacted, in the given format (json or yaml)
+func DumpSettings(settings []Setting, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(settings, "", "  ")
+	case "yaml":
+		return yaml.Marshal(settings)
+	default:
+		return nil, fmt.Errorf("unsupported dump format: %s", format)
+	}
+}
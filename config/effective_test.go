@@ -0,0 +1,56 @@
+package config
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveSettings(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	setupFlags(fs, &Flags{})
+	require.NoError(t, fs.Parse([]string{
+		"-connect", "admin:url%40secret@panorama.example.com",
+		"-filter", "dallas-",
+		"-reportonly",
+	}))
+
+	conf := &Config{}
+	conf.Panorama = []struct {
+		Hostname string `yaml:"hostname"`
+	}{{Hostname: "panorama.example.com"}}
+	conf.Auth.Credentials.Panorama.Username = "pano-user"
+	conf.Auth.Credentials.Panorama.Password = "pano-secret"
+	conf.Auth.Credentials.Firewall.Username = "fw-user"
+	conf.Auth.Credentials.Firewall.Password = "fw-secret"
+
+	settings := effectiveSettings(fs, conf)
+
+	values := make(map[string]string)
+	for _, setting := range settings {
+		values[setting.Name] = setting.Value
+		for _, secret := range []string{"url@secret", "url%40secret", "pano-secret", "fw-secret"} {
+			assert.NotContains(t, setting.Value, secret, "secret leaked in %s", setting.Name)
+		}
+	}
+
+	assert.Equal(t, "dallas-", values["-filter"])
+	assert.Equal(t, "true", values["-reportonly"])
+	assert.Equal(t, "panorama.yaml", values["-config"])
+	assert.Equal(t, "admin:"+Redacted+"@panorama.example.com", values["-connect"])
+	assert.Equal(t, "panorama.example.com", values["panorama.hostname"])
+	assert.Equal(t, "pano-user", values["auth.panorama.username"])
+	assert.Equal(t, Redacted, values["auth.panorama.password"])
+	assert.Equal(t, "fw-user", values["auth.firewall.username"])
+	assert.Equal(t, Redacted, values["auth.firewall.password"])
+}
+
+func TestRedactFlag(t *testing.T) {
+	assert.Equal(t, "dallas-", redactFlag("filter", "dallas-"))
+	assert.Equal(t, "", redactFlag("connect", ""))
+	assert.Equal(t, "panorama.example.com", redactFlag("connect", "panorama.example.com"))
+	assert.Equal(t, "admin@panorama.example.com", redactFlag("connect", "admin@panorama.example.com"))
+	assert.Equal(t, Redacted, redactFlag("connect", "admin:secret@"))
+}
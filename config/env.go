@@ -0,0 +1,42 @@
+// Package config config/env.go
+package config
+
+import "os"
+
+// Environment variables overriding the credentials of the secrets file
+const (
+	EnvFirewallUsername = "PANOS_FW_USERNAME"
+	EnvFirewallPassword = "PANOS_FW_PASSWORD"
+	EnvPanoramaUsername = "PANOS_PANO_USERNAME"
+	EnvPanoramaPassword = "PANOS_PANO_PASSWORD"
+)
+
+// applyEnvCredentials replaces the credentials read from the secrets file with the ones set in the
+// environment. A variable that is unset or empty keeps the value of the secrets file.
+func applyEnvCredentials(config *Config) {
+	credentials := &config.Auth.Credentials
+	overrides := []struct {
+		variable string
+		value    *string
+	}{
+		{EnvFirewallUsername, &credentials.Firewall.Username},
+		{EnvFirewallPassword, &credentials.Firewall.Password},
+		{EnvPanoramaUsername, &credentials.Panorama.Username},
+		{EnvPanoramaPassword, &credentials.Panorama.Password},
+	}
+	for _, override := range overrides {
+		if value := os.Getenv(override.variable); value != "" {
+			*override.value = value
+		}
+	}
+}
+
+// hasEnvCredentials reports whether any credential is set in the environment
+func hasEnvCredentials() bool {
+	for _, variable := range []string{EnvFirewallUsername, EnvFirewallPassword, EnvPanoramaUsername, EnvPanoramaPassword} {
+		if os.Getenv(variable) != "" {
+			return true
+		}
+	}
+	return false
+}
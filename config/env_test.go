@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unsetEnvCredentials clears the credential variables for the duration of the test
+func unsetEnvCredentials(t *testing.T) {
+	for _, variable := range []string{EnvFirewallUsername, EnvFirewallPassword, EnvPanoramaUsername, EnvPanoramaPassword} {
+		t.Setenv(variable, "")
+	}
+}
+
+func TestLoadEnvCredentials(t *testing.T) {
+	configFile := createTempFile(t, "config", "panorama:\n  - hostname: panorama.example.com\n")
+	defer os.Remove(configFile.Name())
+	secretsFile := createTempFile(t, "secrets", `
+auth:
+  panorama:
+    username: file-pano-user
+    password: file-pano-pass
+  firewall:
+    username: file-fw-user
+    password: file-fw-pass
+`)
+	defer os.Remove(secretsFile.Name())
+
+	t.Run("Unset variables fall back to the secrets file", func(t *testing.T) {
+		unsetEnvCredentials(t)
+
+		conf, err := Load(configFile.Name(), secretsFile.Name(), createTestFlags())
+
+		require.NoError(t, err)
+		assert.Equal(t, "file-fw-user", conf.Auth.Credentials.Firewall.Username)
+		assert.Equal(t, "file-fw-pass", conf.Auth.Credentials.Firewall.Password)
+		assert.Equal(t, "file-pano-user", conf.Auth.Credentials.Panorama.Username)
+		assert.Equal(t, "file-pano-pass", conf.Auth.Credentials.Panorama.Password)
+	})
+
+	t.Run("Variables take precedence over the secrets file", func(t *testing.T) {
+		unsetEnvCredentials(t)
+		t.Setenv(EnvFirewallUsername, "env-fw-user")
+		t.Setenv(EnvFirewallPassword, "env-fw-pass")
+		t.Setenv(EnvPanoramaUsername, "env-pano-user")
+		t.Setenv(EnvPanoramaPassword, "env-pano-pass")
+
+		conf, err := Load(configFile.Name(), secretsFile.Name(), createTestFlags())
+
+		require.NoError(t, err)
+		assert.Equal(t, "env-fw-user", conf.Auth.Credentials.Firewall.Username)
+		assert.Equal(t, "env-fw-pass", conf.Auth.Credentials.Firewall.Password)
+		assert.Equal(t, "env-pano-user", conf.Auth.Credentials.Panorama.Username)
+		assert.Equal(t, "env-pano-pass", conf.Auth.Credentials.Panorama.Password)
+	})
+
+	t.Run("Variables override individual values", func(t *testing.T) {
+		unsetEnvCredentials(t)
+		t.Setenv(EnvFirewallPassword, "env-fw-pass")
+
+		conf, err := Load(configFile.Name(), secretsFile.Name(), createTestFlags())
+
+		require.NoError(t, err)
+		assert.Equal(t, "file-fw-user", conf.Auth.Credentials.Firewall.Username)
+		assert.Equal(t, "env-fw-pass", conf.Auth.Credentials.Firewall.Password)
+		assert.Equal(t, "file-pano-user", conf.Auth.Credentials.Panorama.Username)
+	})
+
+	t.Run("Missing secrets file with variables", func(t *testing.T) {
+		unsetEnvCredentials(t)
+		t.Setenv(EnvFirewallUsername, "env-fw-user")
+		t.Setenv(EnvFirewallPassword, "env-fw-pass")
+
+		conf, err := Load(configFile.Name(), filepath.Join(t.TempDir(), "missing.yaml"), createTestFlags())
+
+		require.NoError(t, err)
+		assert.Equal(t, "env-fw-user", conf.Auth.Credentials.Firewall.Username)
+		assert.Equal(t, "env-fw-pass", conf.Auth.Credentials.Firewall.Password)
+	})
+
+	t.Run("Missing secrets file without variables", func(t *testing.T) {
+		unsetEnvCredentials(t)
+
+		_, err := Load(configFile.Name(), filepath.Join(t.TempDir(), "missing.yaml"), createTestFlags())
+
+		assert.Error(t, err)
+	})
+}
@@ -3,41 +3,212 @@ package config
 import (
 	"flag"
 	"runtime"
+	"time"
+)
+
+// DefaultInventoryQuery is the query run against the -inventory-dsn database by default
+const DefaultInventoryQuery = "SELECT hostname, ip_address FROM devices"
+
+// DefaultInventoryFile is the inventory file read when -inventory is not given
+const DefaultInventoryFile = "inventory.yaml"
+
+// DefaultMaxCellLength is the maximum number of characters of a PDF report cell when -max-cell-length is not given
+const DefaultMaxCellLength = 120
+
+// Scopes of the devices queried from Panorama with -panorama-scope: the connected devices, or all
+// managed devices including the disconnected ones
+const (
+	PanoramaScopeConnected = "connected"
+	PanoramaScopeAll       = "all"
 )
 
 // Flags represents the command-line flags
 type Flags struct {
-	DebugLevel     int
-	Concurrency    int
-	ConfigFile     string
-	SecretsFile    string
-	HostnameFilter string
-	Verbose        bool
-	NoPanorama     bool
-	ReportOnly     bool
+	DebugLevel             int
+	Concurrency            int
+	RampUp                 time.Duration
+	PanoramaConcurrency    int
+	MaxConnections         int
+	SkipFailedPanoramas    bool
+	PanoramaScope          string
+	DeviceTimeout          int
+	APITimeout             time.Duration
+	Retries                int
+	RetryBackoff           time.Duration
+	Timeout                time.Duration
+	Retention              time.Duration
+	ConfigFile             string
+	SecretsFile            string
+	Connect                string
+	HostnameFilter         string
+	HostnameExclude        string
+	Models                 string
+	Families               string
+	Verbose                bool
+	ConsoleBufferSize      int
+	GroupByRelease         bool
+	NoPanorama             bool
+	MergeInventory         bool
+	InventoryFile          string
+	InventoryDSN           string
+	InventoryDriver        string
+	InventoryQuery         string
+	ReportOnly             bool
+	CertCheck              bool
+	HealthGate             bool
+	CertRecheckGrace       time.Duration
+	Confirm                bool
+	SerializeRegistrations bool
+	SinglePass             bool
+	RegistrationCooldown   time.Duration
+	NoSafety               bool
+	Strict                 bool
+	StrictSoftFail         bool
+	FailThresholdPct       float64
+	WildFireChannel        string
+	WildFireServer         string
+	SSHKey                 string
+	SSHKeyPassphrase       string
+	SSHPort                int
+	SSHSocketTimeout       time.Duration
+	SSHOpsTimeout          time.Duration
+	SSHFamilyOpsTimeout    string
+	WildFireRecheck        time.Duration
+	WildFireSuccessMatch   string
+	WildFireCommandPrefix  string
+	WildFireVerifyTimeout  time.Duration
+	WildFireVerifyInterval time.Duration
+	Clean                  bool
+	MetricsFile            string
+	JSONReport             string
+	JSONIncludeRaw         bool
+	SummaryJSON            string
+	JUnitReport            string
+	DeltaFrom              string
+	DeltaReport            string
+	ReportPassword         string
+	MaxCellLength          int
+	UpgradeExport          string
+	Runbook                string
+	ExportGroup            string
+	Export                 string
+	ExportFormat           string
+	RunID                  string
+	LogRunID               bool
+	DumpVersions           string
+	DumpConfig             string
+	Explain                string
+	OnlySerials            string
+	FilterCertStatus       string
+	OrderBy                string
+	CertExpiryReport       bool
+	Preflight              bool
+	ValidateInventory      bool
+	JSONL                  bool
+	CollectDNSNTP          bool
+	CertAllowedIssuers     string
+	HTTPHeaders            HeaderList
 }
 
 // setupFlags sets up the flags without parsing them
 func setupFlags(fs *flag.FlagSet, cfg *Flags) {
 	fs.IntVar(&cfg.DebugLevel, "debug", 0, "Debug level: 0=INFO, 1=DEBUG")
-	fs.IntVar(&cfg.Concurrency, "concurrency", runtime.NumCPU(), "Number of concurrent operations")
+	fs.IntVar(&cfg.Concurrency, "concurrency", runtime.NumCPU(), "Maximum number of inventory devices queried and of WildFire registrations run at once")
+	fs.IntVar(&cfg.MaxConnections, "max-connections", 0, "Maximum number of simultaneous firewall connections, shared by the certificate check and WildFire registration (0 disables)")
+	fs.DurationVar(&cfg.RampUp, "ramp-up", 0, "Ramp up from one to -concurrency workers over this duration at the start of the inventory queries and of the registrations (e.g. 30s, 0 disables)")
+	fs.IntVar(&cfg.PanoramaConcurrency, "panorama-concurrency", 4, "Maximum number of Panoramas queried simultaneously")
+	fs.StringVar(&cfg.PanoramaScope, "panorama-scope", PanoramaScopeConnected, "Devices queried from Panorama: connected, or all to also report the managed devices that are disconnected, which are not registered")
+	fs.BoolVar(&cfg.SkipFailedPanoramas, "skip-failed-panoramas", false, "Continue with the devices of the other Panoramas when a Panorama fails, e.g. on an authentication failure")
+	fs.IntVar(&cfg.DeviceTimeout, "device-timeout", 0, "Timeout in seconds for API calls to each device (0 uses the SDK default)")
+	fs.IntVar(&cfg.Retries, "retries", 2, "Number of retries of a failed XML API client initialization or op command (0 disables)")
+	fs.DurationVar(&cfg.RetryBackoff, "retry-backoff", time.Second, "Wait before the first retry, doubled for each following retry")
+	fs.DurationVar(&cfg.APITimeout, "api-timeout", 0, "Maximum duration of each XML API op command, independent of SSH timeouts (e.g. 30s, 0 disables)")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "Overall timeout of the run, e.g. 30m (0 disables); like a SIGINT, it stops starting new device work and reports the remaining devices as failed")
 	fs.StringVar(&cfg.ConfigFile, "config", "panorama.yaml", "Path to the Panorama configuration file")
 	fs.StringVar(&cfg.SecretsFile, "secrets", ".secrets.yaml", "Path to the secrets file")
-	fs.StringVar(&cfg.HostnameFilter, "filter", "", "Comma-separated list of hostname patterns to filter devices")
+	fs.StringVar(&cfg.Connect, "connect", "", "Panorama connection URL, e.g. user:pass@panorama.example.com, overriding the config and secrets files")
+	fs.StringVar(&cfg.HostnameFilter, "filter", "", "Comma-separated list of hostname patterns to filter devices: prefixes, globs such as *-edge-* or regex:<expression>")
+	fs.StringVar(&cfg.HostnameExclude, "exclude", "", "Comma-separated list of hostname patterns, as with -filter, of devices to drop even if they match -filter")
+	fs.StringVar(&cfg.Models, "model", "", "Comma-separated list of models, e.g. PA-VM, to restrict the registration to")
+	fs.StringVar(&cfg.Families, "family", "", "Comma-separated list of hardware families, e.g. 3200, to restrict the registration to")
 	fs.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose logging")
-	fs.BoolVar(&cfg.NoPanorama, "nopanorama", false, "Use inventory.yaml instead of querying Panorama")
-	fs.BoolVar(&cfg.ReportOnly, "reportonly", false, "Run in report-only mode without connecting to devices")
+	fs.IntVar(&cfg.ConsoleBufferSize, "console-buffer-size", 64*1024, "Size in bytes of the buffer for the device list and registration results console output (0 writes every device as it is printed)")
+	fs.BoolVar(&cfg.GroupByRelease, "group-by-release", false, "Print the devices grouped by PAN-OS feature release with the recommended fix")
+	fs.BoolVar(&cfg.NoPanorama, "nopanorama", false, "Use the inventory file instead of querying Panorama")
+	fs.BoolVar(&cfg.MergeInventory, "merge-inventory", false, "Query Panorama and also merge devices from the inventory file, reconciling duplicates by serial")
+	fs.StringVar(&cfg.InventoryFile, "inventory", DefaultInventoryFile, "Path to the inventory file, read as JSON if it ends in .json and as YAML if it ends in .yaml or .yml")
+	fs.StringVar(&cfg.InventoryDSN, "inventory-dsn", "", "Read the inventory devices from this database instead of the inventory file (requires a binary built with a database driver)")
+	fs.StringVar(&cfg.InventoryDriver, "inventory-driver", "postgres", "database/sql driver name of -inventory-dsn")
+	fs.StringVar(&cfg.InventoryQuery, "inventory-query", DefaultInventoryQuery, "Query returning the hostname and ip_address columns, and optional tags, timeout, channel and fact columns, of the -inventory-dsn devices")
+	fs.BoolVar(&cfg.ReportOnly, "reportonly", false, "Run in report-only mode without registering WildFire")
+	fs.BoolVar(&cfg.CertCheck, "cert-check", true, "In report-only mode, still collect the read-only device certificate status of the devices (set -cert-check=false to not connect to the devices)")
+	fs.BoolVar(&cfg.HealthGate, "health-gate", true, "Skip the registration of devices that are not in normal operational mode, e.g. in maintenance mode, or are running an auto-commit (set -health-gate=false to register them anyway)")
+	fs.DurationVar(&cfg.CertRecheckGrace, "cert-recheck-grace", 0, "After registration, wait this long and collect the device certificate status of the newly registered devices again, as they may re-fetch their certificate (e.g. 2m, 0 disables)")
+	fs.StringVar(&cfg.WildFireChannel, "wildfire-channel", "public", "WildFire registration channel: public or private (inventory devices can override it with a channel field or a wildfire-channel=<channel> tag)")
+	fs.StringVar(&cfg.WildFireServer, "wildfire-server", "", "WildFire cloud or appliance server to register with, e.g. a regional cloud or a WF-500 for the private channel (default: the server configured on the device)")
+	fs.StringVar(&cfg.SSHKey, "ssh-key", "", "Path of the SSH private key for the WildFire registration, tried before the firewall password (no key by default)")
+	fs.StringVar(&cfg.SSHKeyPassphrase, "ssh-key-passphrase", "", "Passphrase decrypting the -ssh-key private key, if it is encrypted")
+	fs.IntVar(&cfg.SSHPort, "ssh-port", 22, "SSH port of the firewalls for the WildFire registration")
+	fs.DurationVar(&cfg.SSHSocketTimeout, "ssh-socket-timeout", 45*time.Second, "Timeout for establishing the SSH connection for the WildFire registration")
+	fs.DurationVar(&cfg.SSHOpsTimeout, "ssh-ops-timeout", 45*time.Second, "Timeout for each command sent over SSH for the WildFire registration")
+	fs.StringVar(&cfg.SSHFamilyOpsTimeout, "ssh-family-ops-timeout", "", "Comma-separated <family>=<duration> entries overriding -ssh-ops-timeout for the devices of a hardware family, e.g. 7000=3m for large chassis")
+	fs.StringVar(&cfg.WildFireCommandPrefix, "wildfire-command-prefix", "", "Prefix of the WildFire registration and status commands, e.g. run to send them from configuration mode (default: leave configuration mode first)")
+	fs.StringVar(&cfg.WildFireSuccessMatch, "wildfire-success-match", "", "Semicolon-separated <major>=<output> entries overriding the expected WildFire registration output from that PAN-OS major version onwards")
+	fs.DurationVar(&cfg.WildFireRecheck, "wildfire-recheck", 0, "On unexpected WildFire registration output, wait this long and recheck show wildfire status once before failing (e.g. 10s, 0 disables)")
+	fs.DurationVar(&cfg.WildFireVerifyTimeout, "wildfire-verify-timeout", 0, "After triggering WildFire registration, poll show wildfire status for up to this long until the device reports Registered (e.g. 2m, 0 disables)")
+	fs.DurationVar(&cfg.WildFireVerifyInterval, "wildfire-verify-interval", 10*time.Second, "Interval between WildFire status polls when -wildfire-verify-timeout is set")
+	fs.BoolVar(&cfg.NoSafety, "no-safety", false, "Allow registration when every collected device is a registration candidate")
+	fs.BoolVar(&cfg.Strict, "strict", false, "Exit with status 1 when any WildFire registration failed; soft failures are excluded")
+	fs.BoolVar(&cfg.StrictSoftFail, "strict-soft-fail", false, "With -strict, also exit with status 1 on soft failures such as a busy or locked device")
+	fs.Float64Var(&cfg.FailThresholdPct, "fail-threshold-pct", 0, "Exit with status 1 when more than this percentage of the scheduled WildFire registrations failed (0 disables)")
+	fs.BoolVar(&cfg.SerializeRegistrations, "serialize-registrations", false, "Register WildFire on one device at a time")
+	fs.BoolVar(&cfg.SinglePass, "single-pass", false, "Register each inventory device over SSH right after collecting its facts over the API, in the same worker, instead of in a separate phase (requires -nopanorama and -no-safety)")
+	fs.DurationVar(&cfg.RegistrationCooldown, "registration-cooldown", 0, "Wait this long after each WildFire registration before the next one, implies -serialize-registrations (e.g. 30s, 0 disables)")
+	fs.BoolVar(&cfg.Confirm, "confirm", false, "Print the registration candidates and ask for confirmation before registering them")
+	fs.BoolVar(&cfg.Clean, "clean", false, "Remove generated files older than -retention from the report directory and exit")
+	fs.DurationVar(&cfg.Retention, "retention", 0, "Automatically remove generated files older than this duration after each run (e.g. 720h, 0 disables)")
+	fs.StringVar(&cfg.RunID, "run-id", "", "Identifier of this run in the reports and metrics (default: generated from the start time)")
+	fs.BoolVar(&cfg.LogRunID, "log-run-id", false, "Prefix every log line with the run ID")
+	fs.StringVar(&cfg.ReportPassword, "report-password", "", "Encrypt the PDF report with this password")
+	fs.IntVar(&cfg.MaxCellLength, "max-cell-length", DefaultMaxCellLength, "Maximum number of characters of a PDF report cell, longer values are truncated with an ellipsis (0 disables truncation)")
+	fs.StringVar(&cfg.SummaryJSON, "summary-json", "", "Write a machine-readable run summary with the counts per status, the exit code and the result of each device to this file; the run then exits with status 1 when any WildFire registration failed, as with -strict")
+	fs.StringVar(&cfg.JSONReport, "json-report", "", "Write a JSON report with per-device status and timings to this file")
+	fs.BoolVar(&cfg.JSONIncludeRaw, "json-include-raw", false, "Embed the complete collected device map, secrets excluded, under a raw key of each device of the JSON report")
+	fs.StringVar(&cfg.JUnitReport, "junit", "", "Write a JUnit XML report with one test case per device to this file")
+	fs.StringVar(&cfg.DeltaFrom, "delta-from", "", "JSON report of a previous run to compare this run to, printing the newly affected, fixed, registered and unreachable devices")
+	fs.StringVar(&cfg.DeltaReport, "delta-report", "", "Write the comparison with -delta-from as JSON to this file")
+	fs.StringVar(&cfg.UpgradeExport, "upgrade-export", "", "Write the unsupported-version devices with their minimum fix and recommended release to this file, as CSV for a .csv path and JSON otherwise")
+	fs.StringVar(&cfg.Runbook, "runbook", "", "Write a markdown runbook with the upgrade steps of the unsupported-version devices to this file")
+	fs.StringVar(&cfg.Export, "export", "", "Write the collected devices, with all their fields, to this file")
+	fs.StringVar(&cfg.ExportFormat, "export-format", "json", "Format of the -export file: json or csv")
+	fs.StringVar(&cfg.ExportGroup, "export-group", "", "Write the serials of the unsupported-version devices as a named group to a YAML file, given as <name>=<file>")
+	fs.StringVar(&cfg.MetricsFile, "metrics-file", "", "Write run metrics in OpenMetrics format to this file at completion")
+	fs.StringVar(&cfg.OnlySerials, "only-serials", "", "Comma-separated list of serial numbers to restrict the run to")
+	fs.StringVar(&cfg.OrderBy, "order-by", "", "Order in which the registration candidates are registered: version (lowest first), cert-expiry (soonest first) or hostname (default: classification order)")
+	fs.StringVar(&cfg.FilterCertStatus, "filter-cert-status", "", "Comma-separated list of certificate statuses (valid, expired, needs-enrollment, unknown, invalid) to restrict the run to")
+	fs.BoolVar(&cfg.CollectDNSNTP, "collect-dns-ntp", false, "Collect the configured DNS servers and NTP status alongside the device certificate status")
+	fs.StringVar(&cfg.CertAllowedIssuers, "cert-allowed-issuers", "", "Semicolon-separated device certificate issuers, full names or common names, accepted as compliant (default: any issuer)")
+	fs.Var(&cfg.HTTPHeaders, "http-header", "Additional HTTP header of the XML API requests to Panorama and the firewalls, as Name: value, e.g. for a WAF token (repeatable)")
+	fs.StringVar(&cfg.DumpVersions, "dump-versions", "", "Print the minimum patched versions table as json or yaml and exit")
+	fs.StringVar(&cfg.DumpConfig, "dump-config", "", "Print the effective configuration, with secrets redacted, as json or yaml and exit")
+	fs.BoolVar(&cfg.CertExpiryReport, "cert-expiry-report", false, "Collect the devices, print their device certificate expiry sorted by soonest expiry and exit without registering")
+	fs.BoolVar(&cfg.JSONL, "jsonl", false, "Stream one JSON object per device to stdout as soon as its registration result is known, moving the log and console output to stderr")
+	fs.BoolVar(&cfg.Preflight, "preflight", false, "Open and close an SSH connection to each registration candidate, print which are reachable, refuse the credentials or are unreachable, and exit without registering")
+	fs.BoolVar(&cfg.ValidateInventory, "validate-inventory", false, "TCP-dial the API (443) and SSH ports of each inventory device, print which are reachable and exit, without authenticating or running any command")
+	fs.StringVar(&cfg.Explain, "explain", "", "Explain the classification of a \"<version> <model>\" string, e.g. \"10.1.6-h2 PA-3260\", and exit")
 }
 
-// ParseFlags parses command-line flags and returns a configuration object.
+// ParseFlags parses command-line flags and returns a configuration object. The combination of the
+// flags is checked separately by Flags.Validate.
 func ParseFlags() (*Flags, *Config) {
 	cfg := &Flags{}
 	setupFlags(flag.CommandLine, cfg)
 	flag.Parse()
 
 	config := &Config{
-		HostnameFilter: cfg.HostnameFilter,
-		ReportOnly:     cfg.ReportOnly,
+		HostnameFilter:  cfg.HostnameFilter,
+		HostnameExclude: cfg.HostnameExclude,
+		ReportOnly:      cfg.ReportOnly,
 	}
 
 	return cfg, config
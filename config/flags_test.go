@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func createTestFlags() *Flags {
@@ -17,6 +18,8 @@ func createTestFlags() *Flags {
 		HostnameFilter: "",
 		Verbose:        false,
 		NoPanorama:     false,
+
+		WildFireChannel: "public",
 	}
 }
 
@@ -30,13 +33,31 @@ func TestSetupFlags(t *testing.T) {
 			name: "Default values",
 			args: []string{},
 			expected: &Flags{
-				DebugLevel:     0,
-				Concurrency:    runtime.NumCPU(),
-				ConfigFile:     "panorama.yaml",
-				SecretsFile:    ".secrets.yaml",
-				HostnameFilter: "",
-				Verbose:        false,
-				NoPanorama:     false,
+				DebugLevel:             0,
+				Concurrency:            runtime.NumCPU(),
+				PanoramaConcurrency:    4,
+				Retries:                2,
+				RetryBackoff:           time.Second,
+				InventoryFile:          DefaultInventoryFile,
+				MaxCellLength:          DefaultMaxCellLength,
+				PanoramaScope:          PanoramaScopeConnected,
+				InventoryDriver:        "postgres",
+				InventoryQuery:         DefaultInventoryQuery,
+				ExportFormat:           "json",
+				CertCheck:              true,
+				HealthGate:             true,
+				SSHPort:                22,
+				SSHSocketTimeout:       45 * time.Second,
+				SSHOpsTimeout:          45 * time.Second,
+				ConsoleBufferSize:      64 * 1024,
+				WildFireVerifyInterval: 10 * time.Second,
+				ConfigFile:             "panorama.yaml",
+				SecretsFile:            ".secrets.yaml",
+				HostnameFilter:         "",
+				Verbose:                false,
+				NoPanorama:             false,
+
+				WildFireChannel: "public",
 			},
 		},
 		{
@@ -51,13 +72,31 @@ func TestSetupFlags(t *testing.T) {
 				"-nopanorama",
 			},
 			expected: &Flags{
-				DebugLevel:     1,
-				Concurrency:    4,
-				ConfigFile:     "custom.yaml",
-				SecretsFile:    "custom_secrets.yaml",
-				HostnameFilter: "fw-*",
-				Verbose:        true,
-				NoPanorama:     true,
+				DebugLevel:             1,
+				Concurrency:            4,
+				PanoramaConcurrency:    4,
+				Retries:                2,
+				RetryBackoff:           time.Second,
+				InventoryFile:          DefaultInventoryFile,
+				MaxCellLength:          DefaultMaxCellLength,
+				PanoramaScope:          PanoramaScopeConnected,
+				InventoryDriver:        "postgres",
+				InventoryQuery:         DefaultInventoryQuery,
+				ExportFormat:           "json",
+				CertCheck:              true,
+				HealthGate:             true,
+				SSHPort:                22,
+				SSHSocketTimeout:       45 * time.Second,
+				SSHOpsTimeout:          45 * time.Second,
+				ConsoleBufferSize:      64 * 1024,
+				WildFireVerifyInterval: 10 * time.Second,
+				ConfigFile:             "custom.yaml",
+				SecretsFile:            "custom_secrets.yaml",
+				HostnameFilter:         "fw-*",
+				Verbose:                true,
+				NoPanorama:             true,
+
+				WildFireChannel: "public",
 			},
 		},
 	}
@@ -0,0 +1,97 @@
+// Package config config/headers.go
+package config
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// HeaderList is the value of the repeatable -http-header flag, one "Name: value" entry per use
+type HeaderList []string
+
+// String lists the header names with their values redacted, as header values are often tokens
+func (h *HeaderList) String() string {
+	if h == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*h))
+	for _, entry := range *h {
+		name, _, _ := strings.Cut(entry, ":")
+		names = append(names, name)
+	}
+	return redactHeaders(names)
+}
+
+// Set adds an entry, rejecting one that is not a valid "Name: value" header
+func (h *HeaderList) Set(entry string) error {
+	if _, _, err := ParseHeader(entry); err != nil {
+		return err
+	}
+	*h = append(*h, entry)
+	return nil
+}
+
+// ParseHeader splits a "Name: value" entry into the canonical header name and the value
+func ParseHeader(entry string) (name, value string, err error) {
+	name, value, ok := strings.Cut(entry, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid HTTP header %q, expected Name: value", entry)
+	}
+	name = strings.TrimSpace(name)
+	if err := validateHeader(name, value); err != nil {
+		return "", "", err
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// validateHeader rejects an empty header name, a name with characters other than letters, digits
+// and the punctuation allowed by RFC 7230, and a value with line breaks
+func validateHeader(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("empty HTTP header name")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return fmt.Errorf("invalid HTTP header name %q", name)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid value of HTTP header %s: line breaks are not allowed", name)
+	}
+	return nil
+}
+
+// mergeHTTPHeaders validates the http_headers of the config file and adds the -http-header entries,
+// which take precedence. It returns nil when no header is configured.
+func mergeHTTPHeaders(configured map[string]string, entries HeaderList) (map[string]string, error) {
+	if len(configured) == 0 && len(entries) == 0 {
+		return nil, nil
+	}
+	headers := make(map[string]string, len(configured)+len(entries))
+	for name, value := range configured {
+		if err := validateHeader(name, value); err != nil {
+			return nil, err
+		}
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	for _, entry := range entries {
+		name, value, err := ParseHeader(entry)
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// redactHeaders lists the header names, sorted and separated by semicolons, each with a redacted value
+func redactHeaders(names []string) string {
+	redacted := make([]string, 0, len(names))
+	for _, name := range names {
+		redacted = append(redacted, strings.TrimSpace(name)+": "+Redacted)
+	}
+	sort.Strings(redacted)
+	return strings.Join(redacted, "; ")
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		entry     string
+		wantName  string
+		wantValue string
+		wantErr   bool
+	}{
+		{"X-WAF-Token: abc123", "X-Waf-Token", "abc123", false},
+		{"x-custom:value with spaces ", "X-Custom", "value with spaces", false},
+		{"Authorization: Bearer a:b", "Authorization", "Bearer a:b", false},
+		{"X-Empty:", "X-Empty", "", false},
+		{"no-colon", "", "", true},
+		{": value", "", "", true},
+		{"Bad Name: value", "", "", true},
+		{"X-Split: a\r\nInjected: b", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			name, value, err := ParseHeader(tt.entry)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantValue, value)
+		})
+	}
+}
+
+func TestHTTPHeaderFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var headers HeaderList
+	fs.Var(&headers, "http-header", "")
+
+	require.NoError(t, fs.Parse([]string{"-http-header", "X-WAF-Token: abc123", "-http-header", "X-Source: cdss"}))
+	assert.Equal(t, HeaderList{"X-WAF-Token: abc123", "X-Source: cdss"}, headers)
+	assert.Equal(t, "X-Source: "+Redacted+"; X-WAF-Token: "+Redacted, headers.String(), "values must never be printed")
+
+	assert.Error(t, fs.Parse([]string{"-http-header", "missing-colon"}))
+}
+
+func TestLoadHTTPHeaders(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "panorama.yaml")
+	secretsFile := filepath.Join(dir, ".secrets.yaml")
+	require.NoError(t, os.WriteFile(secretsFile, []byte("auth: {}\n"), 0o600))
+
+	t.Run("Flags take precedence over the config file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configFile, []byte("http_headers:\n  x-waf-token: from-file\n  X-Source: cdss\n"), 0o600))
+
+		conf, err := Load(configFile, secretsFile, &Flags{HTTPHeaders: HeaderList{"X-WAF-Token: from-flag"}})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"X-Waf-Token": "from-flag", "X-Source": "cdss"}, conf.HTTPHeaders)
+		assert.Contains(t, configSettings(conf), Setting{Name: "http_headers", Value: "X-Source: " + Redacted + "; X-Waf-Token: " + Redacted})
+	})
+
+	t.Run("Invalid header in the config file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configFile, []byte("http_headers:\n  'bad name': value\n"), 0o600))
+
+		_, err := Load(configFile, secretsFile, &Flags{})
+
+		assert.ErrorContains(t, err, "invalid HTTP header name")
+	})
+}
@@ -0,0 +1,83 @@
+// Package config config/redact.go
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces secret values wherever settings or device fields are printed
+const Redacted = "********"
+
+// secretNameTokens are the words of a setting, flag or device field name that mark its value as a secret
+var secretNameTokens = map[string]bool{
+	"password":   true,
+	"passwd":     true,
+	"passphrase": true,
+	"secret":     true,
+	"token":      true,
+	"apikey":     true,
+	"dsn":        true,
+}
+
+// secretNamePairs are consecutive words of a name that mark its value as a secret, e.g. "api-key"
+var secretNamePairs = [][2]string{{"api", "key"}, {"private", "key"}}
+
+// IsSecretName reports whether a setting, flag or device field name holds a secret.
+// The name is split into words on any non-alphanumeric character, so "auth.panorama.password",
+// "-api-key" and "vault_token" are all secret names, while "-secrets", the path of the secrets file, is not.
+func IsSecretName(name string) bool {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for i, word := range words {
+		if secretNameTokens[word] {
+			return true
+		}
+		if i == 0 {
+			continue
+		}
+		for _, pair := range secretNamePairs {
+			if words[i-1] == pair[0] && word == pair[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RedactSecrets returns the value of a setting, flag or device field safe for printing. It is shared
+// by the report appendix, -dump-config and the verbose device list, so a new secret-bearing field is
+// redacted in every output as soon as its name is recognized.
+func RedactSecrets(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.TrimLeft(name, "-") == "connect" {
+		return redactFlag("connect", value)
+	}
+	if IsSecretName(name) {
+		return Redacted
+	}
+	return value
+}
+
+// redactFlag removes secrets from the value of a flag
+func redactFlag(name, value string) string {
+	if name != "connect" || value == "" {
+		return value
+	}
+
+	target, err := ParseConnectURL(value)
+	if err != nil {
+		// An unparseable URL may still hold credentials
+		return Redacted
+	}
+	if target.Username == "" {
+		return target.Hostname
+	}
+	if target.Password == "" {
+		return url.User(target.Username).String() + "@" + target.Hostname
+	}
+	return url.User(target.Username).String() + ":" + Redacted + "@" + target.Hostname
+}
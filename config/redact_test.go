@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSecretName(t *testing.T) {
+	for _, name := range []string{"auth.panorama.password", "-api-key", "api_key", "vault_token", "client-secret", "private-key", "apikey", "-inventory-dsn"} {
+		assert.True(t, IsSecretName(name), name)
+	}
+	for _, name := range []string{"-secrets", "hostname", "auth.firewall.username", "-keyfile", "key", "sw-version"} {
+		assert.False(t, IsSecretName(name), name)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	assert.Equal(t, Redacted, RedactSecrets("auth.firewall.password", "fw-secret"))
+	assert.Equal(t, "", RedactSecrets("auth.firewall.password", ""))
+	assert.Equal(t, ".secrets.yaml", RedactSecrets("-secrets", ".secrets.yaml"))
+	assert.Equal(t, "admin:"+Redacted+"@panorama.example.com", RedactSecrets("-connect", "admin:secret@panorama.example.com"))
+	assert.Equal(t, "fw-1", RedactSecrets("hostname", "fw-1"))
+}
@@ -0,0 +1,159 @@
+// Package config config/validate.go
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// givenFlag pairs a flag with whether it was given, for the combination checks of Validate
+type givenFlag struct {
+	name string
+	set  bool
+}
+
+// givenFlags returns the names of the flags that were given
+func givenFlags(flags ...givenFlag) []string {
+	var names []string
+	for _, f := range flags {
+		if f.set {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// Validate checks the combination of the flags. It returns an error naming every contradictory
+// combination, which would otherwise silently do something surprising, and a warning for each
+// flag that has no effect combined with the others.
+func (f *Flags) Validate() (warnings []string, err error) {
+	var errs []error
+
+	// Only one of the modes that print their output and exit can run
+	if modes := givenFlags(
+		givenFlag{"-clean", f.Clean},
+		givenFlag{"-dump-versions", f.DumpVersions != ""},
+		givenFlag{"-explain", f.Explain != ""},
+		givenFlag{"-dump-config", f.DumpConfig != ""},
+		givenFlag{"-cert-expiry-report", f.CertExpiryReport},
+		givenFlag{"-preflight", f.Preflight},
+		givenFlag{"-validate-inventory", f.ValidateInventory},
+	); len(modes) > 1 {
+		errs = append(errs, fmt.Errorf("%s cannot be combined, as each of them prints its output and exits", strings.Join(modes, " and ")))
+	}
+
+	// Panorama and the inventory
+	if f.NoPanorama {
+		if f.MergeInventory {
+			errs = append(errs, errors.New("-merge-inventory merges the inventory into the devices of Panorama and cannot be combined with -nopanorama"))
+		}
+		if f.Connect != "" {
+			errs = append(errs, errors.New("-connect sets the Panorama to query and cannot be combined with -nopanorama"))
+		}
+		if f.SkipFailedPanoramas {
+			warnings = append(warnings, "-skip-failed-panoramas has no effect with -nopanorama")
+		}
+		if f.PanoramaScope == PanoramaScopeAll {
+			warnings = append(warnings, "-panorama-scope has no effect with -nopanorama")
+		}
+	}
+	if f.PanoramaScope != PanoramaScopeConnected && f.PanoramaScope != PanoramaScopeAll {
+		errs = append(errs, fmt.Errorf("-panorama-scope must be %s or %s, got %q", PanoramaScopeConnected, PanoramaScopeAll, f.PanoramaScope))
+	}
+	if !f.NoPanorama && !f.MergeInventory {
+		for _, name := range givenFlags(
+			givenFlag{"-inventory", f.InventoryFile != DefaultInventoryFile},
+			givenFlag{"-inventory-dsn", f.InventoryDSN != ""},
+		) {
+			warnings = append(warnings, name+" has no effect without -nopanorama or -merge-inventory")
+		}
+	} else if f.InventoryDSN != "" {
+		if f.InventoryFile != DefaultInventoryFile {
+			warnings = append(warnings, "-inventory has no effect with -inventory-dsn, which replaces the inventory file")
+		}
+		// Database drivers are compiled in with build tags, so a missing one is reported before connecting
+		if drivers := sql.Drivers(); !slices.Contains(drivers, f.InventoryDriver) {
+			registered := strings.Join(drivers, ", ")
+			if registered == "" {
+				registered = "none"
+			}
+			errs = append(errs, fmt.Errorf("-inventory-driver %q is not compiled into this build (registered drivers: %s); build with -tags postgres for PostgreSQL", f.InventoryDriver, registered))
+		}
+	}
+
+	// Registration
+	if f.ReportOnly {
+		for _, name := range givenFlags(
+			givenFlag{"-confirm", f.Confirm},
+			givenFlag{"-serialize-registrations", f.SerializeRegistrations},
+			givenFlag{"-registration-cooldown", f.RegistrationCooldown > 0},
+			givenFlag{"-wildfire-recheck", f.WildFireRecheck > 0},
+			givenFlag{"-wildfire-verify-timeout", f.WildFireVerifyTimeout > 0},
+			givenFlag{"-cert-recheck-grace", f.CertRecheckGrace > 0},
+			givenFlag{"-no-safety", f.NoSafety},
+			givenFlag{"-strict", f.Strict},
+			givenFlag{"-fail-threshold-pct", f.FailThresholdPct > 0},
+			givenFlag{"-model", f.Models != ""},
+			givenFlag{"-family", f.Families != ""},
+		) {
+			warnings = append(warnings, name+" has no effect with -reportonly, as no device is registered")
+		}
+	} else if !f.CertCheck {
+		warnings = append(warnings, "-cert-check=false has no effect without -reportonly, as the certificate status is always collected when registering")
+	}
+	if f.WildFireChannel != "public" && f.WildFireChannel != "private" {
+		errs = append(errs, fmt.Errorf("-wildfire-channel must be public or private, got %q", f.WildFireChannel))
+	}
+	if f.FailThresholdPct < 0 || f.FailThresholdPct > 100 {
+		errs = append(errs, fmt.Errorf("-fail-threshold-pct must be between 0 and 100, got %g", f.FailThresholdPct))
+	}
+	if f.StrictSoftFail && !f.Strict && f.SummaryJSON == "" && f.FailThresholdPct == 0 {
+		warnings = append(warnings, "-strict-soft-fail has no effect without -strict, -summary-json or -fail-threshold-pct")
+	}
+	if f.SSHKeyPassphrase != "" && f.SSHKey == "" {
+		warnings = append(warnings, "-ssh-key-passphrase has no effect without -ssh-key")
+	}
+
+	// Single-pass registration registers each device before the complete device list is known
+	if f.SinglePass {
+		if !f.NoPanorama {
+			errs = append(errs, errors.New("-single-pass registers the inventory devices as they are collected and requires -nopanorama"))
+		}
+		if !f.NoSafety {
+			errs = append(errs, errors.New("-single-pass requires -no-safety, as the check that not every collected device is a registration candidate needs the complete device list"))
+		}
+		for _, name := range givenFlags(
+			givenFlag{"-reportonly", f.ReportOnly},
+			givenFlag{"-confirm", f.Confirm},
+			givenFlag{"-preflight", f.Preflight},
+			givenFlag{"-cert-expiry-report", f.CertExpiryReport},
+			givenFlag{"-filter-cert-status", f.FilterCertStatus != ""},
+			givenFlag{"-serialize-registrations", f.SerializeRegistrations},
+			givenFlag{"-registration-cooldown", f.RegistrationCooldown > 0},
+		) {
+			errs = append(errs, fmt.Errorf("-single-pass registers each device as soon as it is collected and cannot be combined with %s", name))
+		}
+		if f.OrderBy != "" {
+			warnings = append(warnings, "-order-by has no effect with -single-pass, as the devices are registered in the order they are collected")
+		}
+	}
+
+	// Outputs
+	if f.Clean && f.Retention <= 0 {
+		errs = append(errs, errors.New("-clean requires -retention to set the age of the generated files to remove, e.g. -retention 720h"))
+	}
+	if f.Retention < 0 {
+		errs = append(errs, fmt.Errorf("-retention must not be negative, got %s", f.Retention))
+	}
+	if f.DeltaReport != "" && f.DeltaFrom == "" {
+		errs = append(errs, errors.New("-delta-report requires -delta-from"))
+	}
+	if f.Export == "" && f.ExportFormat != "" && f.ExportFormat != "json" {
+		warnings = append(warnings, "-export-format has no effect without -export")
+	}
+
+	return warnings, errors.Join(errs...)
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubInventoryDriver is a database driver registered for the -inventory-driver checks; it never connects
+type stubInventoryDriver struct{}
+
+func (stubInventoryDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("stub driver")
+}
+
+func init() {
+	sql.Register("stubinventory", stubInventoryDriver{})
+}
+
+// parseTestFlags parses args with the flags of the command line
+func parseTestFlags(t *testing.T, args ...string) *Flags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags := &Flags{}
+	setupFlags(fs, flags)
+	require.NoError(t, fs.Parse(args))
+	return flags
+}
+
+func TestValidateDefaults(t *testing.T) {
+	warnings, err := parseTestFlags(t).Validate()
+
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestValidateContradictoryFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"Merge without Panorama", []string{"-nopanorama", "-merge-inventory"}, "-merge-inventory merges the inventory into the devices of Panorama and cannot be combined with -nopanorama"},
+		{"Panorama URL without Panorama", []string{"-nopanorama", "-connect", "admin:secret@panorama.example.com"}, "-connect sets the Panorama to query and cannot be combined with -nopanorama"},
+		{"Two exiting modes", []string{"-preflight", "-cert-expiry-report"}, "-cert-expiry-report and -preflight cannot be combined"},
+		{"Inventory check with preflight", []string{"-validate-inventory", "-preflight"}, "-preflight and -validate-inventory cannot be combined"},
+		{"Clean without retention", []string{"-clean"}, "-clean requires -retention"},
+		{"Negative retention", []string{"-retention", "-1h"}, "-retention must not be negative, got -1h0m0s"},
+		{"Delta report without previous run", []string{"-delta-report", "delta.json"}, "-delta-report requires -delta-from"},
+		{"Failure threshold out of range", []string{"-fail-threshold-pct", "150"}, "-fail-threshold-pct must be between 0 and 100, got 150"},
+		{"Single pass with Panorama", []string{"-single-pass", "-no-safety"}, "-single-pass registers the inventory devices as they are collected and requires -nopanorama"},
+		{"Single pass without -no-safety", []string{"-single-pass", "-nopanorama"}, "-single-pass requires -no-safety"},
+		{"Single pass in report-only mode", []string{"-single-pass", "-nopanorama", "-no-safety", "-reportonly"}, "-single-pass registers each device as soon as it is collected and cannot be combined with -reportonly"},
+		{"Single pass with confirmation", []string{"-single-pass", "-nopanorama", "-no-safety", "-confirm"}, "cannot be combined with -confirm"},
+		{"Inventory driver not compiled in", []string{"-nopanorama", "-inventory-driver", "missingdb", "-inventory-dsn", "missingdb://cmdb"}, `-inventory-driver "missingdb" is not compiled into this build`},
+		{"Unknown WildFire channel", []string{"-wildfire-channel", "Private"}, `-wildfire-channel must be public or private, got "Private"`},
+		{"Unknown Panorama scope", []string{"-panorama-scope", "disconnected"}, `-panorama-scope must be connected or all, got "disconnected"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestFlags(t, tt.args...).Validate()
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	t.Run("Clean with retention", func(t *testing.T) {
+		warnings, err := parseTestFlags(t, "-clean", "-retention", "720h").Validate()
+
+		assert.NoError(t, err)
+		assert.Empty(t, warnings)
+	})
+
+	t.Run("Every contradiction is reported", func(t *testing.T) {
+		_, err := parseTestFlags(t, "-nopanorama", "-merge-inventory", "-delta-report", "delta.json").Validate()
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "-merge-inventory")
+		assert.Contains(t, err.Error(), "-delta-report")
+		assert.NotContains(t, err.Error(), "admin:secret", "the error must not echo flag values such as passwords")
+	})
+}
+
+func TestValidateNoOpFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantWarnings []string
+	}{
+		{
+			"Registration settings in report-only mode",
+			[]string{"-reportonly", "-confirm", "-wildfire-verify-timeout", "2m", "-strict"},
+			[]string{
+				"-confirm has no effect with -reportonly, as no device is registered",
+				"-wildfire-verify-timeout has no effect with -reportonly, as no device is registered",
+				"-strict has no effect with -reportonly, as no device is registered",
+			},
+		},
+		{
+			"Certificate check opt-out when registering",
+			[]string{"-cert-check=false"},
+			[]string{"-cert-check=false has no effect without -reportonly, as the certificate status is always collected when registering"},
+		},
+		{
+			"Inventory without inventory mode",
+			[]string{"-inventory", "devices.json", "-inventory-dsn", "postgres://cmdb"},
+			[]string{"-inventory has no effect without -nopanorama or -merge-inventory", "-inventory-dsn has no effect without -nopanorama or -merge-inventory"},
+		},
+		{
+			"Inventory file replaced by a database",
+			[]string{"-nopanorama", "-inventory", "devices.json", "-inventory-driver", "stubinventory", "-inventory-dsn", "postgres://cmdb"},
+			[]string{"-inventory has no effect with -inventory-dsn, which replaces the inventory file"},
+		},
+		{
+			"Panorama settings without Panorama",
+			[]string{"-nopanorama", "-skip-failed-panoramas", "-panorama-scope", "all"},
+			[]string{"-skip-failed-panoramas has no effect with -nopanorama", "-panorama-scope has no effect with -nopanorama"},
+		},
+		{
+			"Registration order with single pass",
+			[]string{"-single-pass", "-nopanorama", "-no-safety", "-order-by", "hostname"},
+			[]string{"-order-by has no effect with -single-pass, as the devices are registered in the order they are collected"},
+		},
+		{
+			"Dependent flags without the flag they depend on",
+			[]string{"-strict-soft-fail", "-ssh-key-passphrase", "secret", "-export-format", "csv"},
+			[]string{"-strict-soft-fail has no effect without -strict, -summary-json or -fail-threshold-pct", "-ssh-key-passphrase has no effect without -ssh-key", "-export-format has no effect without -export"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := parseTestFlags(t, tt.args...).Validate()
+
+			require.NoError(t, err, "flags without effect are warned about, not refused")
+			assert.Equal(t, tt.wantWarnings, warnings)
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
 // MinimumPatchedVersion represents the minimum patched version for a specific release
 type MinimumPatchedVersion struct {
-	Maintenance int
-	Hotfix      int
+	Maintenance int `yaml:"maintenance" json:"maintenance"`
+	Hotfix      int `yaml:"hotfix" json:"hotfix"`
 }
 
 // MinimumPatchedVersions represents the minimum patched versions for each PAN-OS feature release
@@ -88,3 +95,16 @@ var MinimumPatchedVersions = map[string][]MinimumPatchedVersion{
 		{Maintenance: 2, Hotfix: 3},
 	},
 }
+
+// DumpMinimumPatchedVersions serializes the MinimumPatchedVersions table in the requested format.
+// Supported formats are "json" and "yaml".
+func DumpMinimumPatchedVersions(format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(MinimumPatchedVersions, "", "  ")
+	case "yaml":
+		return yaml.Marshal(MinimumPatchedVersions)
+	default:
+		return nil, fmt.Errorf("unsupported dump format: %s", format)
+	}
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestMinimumPatchedVersions(t *testing.T) {
@@ -58,3 +61,39 @@ func TestMinimumPatchedVersionStruct(t *testing.T) {
 	assert.Equal(t, 5, mpv.Maintenance, "Unexpected Maintenance value")
 	assert.Equal(t, 3, mpv.Hotfix, "Unexpected Hotfix value")
 }
+
+func TestDumpMinimumPatchedVersions(t *testing.T) {
+	expectedVersions := []string{
+		"8.1", "9.0", "9.1", "10.0", "10.1", "10.2", "10.2-gp",
+		"11.0", "11.0-gp", "11.1", "11.1-gp",
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		dump, err := DumpMinimumPatchedVersions("json")
+		require.NoError(t, err)
+
+		var parsed map[string][]MinimumPatchedVersion
+		require.NoError(t, json.Unmarshal(dump, &parsed))
+		for _, version := range expectedVersions {
+			assert.Contains(t, parsed, version)
+		}
+		assert.Equal(t, MinimumPatchedVersions, parsed)
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		dump, err := DumpMinimumPatchedVersions("yaml")
+		require.NoError(t, err)
+
+		var parsed map[string][]MinimumPatchedVersion
+		require.NoError(t, yaml.Unmarshal(dump, &parsed))
+		for _, version := range expectedVersions {
+			assert.Contains(t, parsed, version)
+		}
+		assert.Equal(t, MinimumPatchedVersions, parsed)
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		_, err := DumpMinimumPatchedVersions("xml")
+		assert.Error(t, err)
+	})
+}
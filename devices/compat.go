@@ -0,0 +1,74 @@
+// Package devices devices/compat.go
+package devices
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/PaloAltoNetworks/pango/version"
+)
+
+// PAN-OS major versions known to work with the op commands of this tool and the pango SDK. Older
+// releases predate every entry of config.VersionCommands, and the responses of newer releases were
+// never checked against the pango structs.
+const (
+	oldestSupportedMajor = 8
+	newestTestedMajor    = 11
+)
+
+// swVersionPattern finds the PAN-OS version in a raw system information response
+var swVersionPattern = regexp.MustCompile(`<sw-version>\s*([^<\s]+)\s*</sw-version>`)
+
+// CompatibilityIssue returns why the responses of a PAN-OS version may not be handled by the pango
+// SDK or the commands of this tool, or an empty string when the version is known to work. A version
+// that was not collected is not an issue.
+func CompatibilityIssue(swVersion string) string {
+	if swVersion == "" {
+		return ""
+	}
+	number, err := parsePangoVersion(swVersion)
+	if err != nil {
+		return fmt.Sprintf("PAN-OS version %q cannot be parsed by the pango SDK: %v", swVersion, err)
+	}
+	switch {
+	case number.Major < oldestSupportedMajor:
+		return fmt.Sprintf("PAN-OS %s is older than %d.0, the oldest release supported by this tool", swVersion, oldestSupportedMajor)
+	case number.Major > newestTestedMajor:
+		return fmt.Sprintf("PAN-OS %s is newer than %d.x, the newest release tested with the pango SDK", swVersion, newestTestedMajor)
+	}
+	return ""
+}
+
+// parsePangoVersion parses a version the way the pango SDK does when initializing a client. pango
+// panics on versions with fewer than three parts, such as "10.2", which is returned as an error.
+func parsePangoVersion(swVersion string) (number version.Number, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("expected a major.feature.maintenance version")
+		}
+	}()
+	return version.New(swVersion)
+}
+
+// responseCompatibilityIssue returns the compatibility issue of the PAN-OS version found in a raw
+// response that could not be parsed, so the parse error can point at its likely cause.
+func responseCompatibilityIssue(response []byte) string {
+	match := swVersionPattern.FindSubmatch(response)
+	if match == nil {
+		return ""
+	}
+	return CompatibilityIssue(string(match[1]))
+}
+
+// flagCompatibilityIssues records the compatibility issue of each collected device in its
+// "compatibility" field and warns about it right after collection, before a later command fails
+// with a parse error. The devices are returned for chaining.
+func (dm *DeviceManager) flagCompatibilityIssues(deviceList []map[string]string) []map[string]string {
+	for _, device := range deviceList {
+		if issue := CompatibilityIssue(device["sw-version"]); issue != "" {
+			device["compatibility"] = issue
+			dm.logger.Warn(fmt.Sprintf("Device %s may not be handled correctly: %s", device["hostname"], issue))
+		}
+	}
+	return deviceList
+}
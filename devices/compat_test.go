@@ -0,0 +1,66 @@
+package devices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompatibilityIssue(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{version: "", expected: ""},
+		{version: "8.1.25", expected: ""},
+		{version: "10.2.4-h2", expected: ""},
+		{version: "11.1.2-h3", expected: ""},
+		{version: "7.1.26", expected: "older than 8.0"},
+		{version: "12.1.0", expected: "newer than 11.x"},
+		{version: "10.2", expected: "cannot be parsed by the pango SDK"},
+		{version: "10.2.4-h2-x", expected: "cannot be parsed by the pango SDK"},
+		{version: "eleven.1.0", expected: "cannot be parsed by the pango SDK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			issue := CompatibilityIssue(tt.version)
+			if tt.expected == "" {
+				assert.Empty(t, issue)
+				return
+			}
+			assert.Contains(t, issue, tt.expected)
+		})
+	}
+}
+
+func TestFlagCompatibilityIssues(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	deviceList := []map[string]string{
+		{"hostname": "fw-supported", "sw-version": "10.1.0"},
+		{"hostname": "fw-too-new", "sw-version": "12.1.0"},
+	}
+
+	flagged := dm.flagCompatibilityIssues(deviceList)
+
+	require.Len(t, flagged, 2)
+	assert.NotContains(t, flagged[0], "compatibility")
+	assert.Contains(t, flagged[1]["compatibility"], "PAN-OS 12.1.0 is newer than 11.x")
+}
+
+func TestGetNgfwDeviceInfoFlagsUnparsableVersion(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	mockClient := new(MockNgfwClient)
+	// A future release answering with a response that is not well-formed XML
+	response := `<response status="success"><result><system><hostname>fw-1</hostname><sw-version>12.1.0</sw-version><uptime>1 day & 2 hours</uptime></system></result></response>`
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(response), nil)
+
+	_, err := dm.getNgfwDeviceInfo(context.Background(), mockClient, "fw-1")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "PAN-OS 12.1.0 is newer than 11.x")
+}
@@ -0,0 +1,87 @@
+// Package devices devices/credentials.go
+package devices
+
+import (
+	"sync"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+)
+
+// Credentials are the credentials used to connect to a single firewall
+type Credentials struct {
+	Username string
+	Password string
+	ApiKey   string
+}
+
+// isSet reports whether any credential is set
+func (c Credentials) isSet() bool {
+	return c.Username != "" || c.Password != "" || c.ApiKey != ""
+}
+
+// withDefaults fills the credentials missing from c with those of defaults. The username and the
+// password default on their own, so devices sharing an account name can have distinct passwords and
+// a device with only an API key still has a password for SSH. The API key only defaults when the
+// device has neither, so a device password is never overridden by a shared API key.
+func (c Credentials) withDefaults(defaults Credentials) Credentials {
+	if c.Username == "" {
+		c.Username = defaults.Username
+	}
+	if c.Password == "" && c.ApiKey == "" {
+		c.ApiKey = defaults.ApiKey
+	}
+	if c.Password == "" {
+		c.Password = defaults.Password
+	}
+	return c
+}
+
+// inventoryCredentials returns the credentials of an inventory device, falling back to the firewall
+// credentials of the secrets file
+func (dm *DeviceManager) inventoryCredentials(device config.InventoryDevice) Credentials {
+	own := Credentials{Username: device.Username, Password: device.Password, ApiKey: device.ApiKey}
+	return own.withDefaults(dm.sharedFirewallCredentials())
+}
+
+// sharedFirewallCredentials returns the firewall credentials of the secrets file
+func (dm *DeviceManager) sharedFirewallCredentials() Credentials {
+	firewall := dm.config.Auth.Credentials.Firewall
+	return Credentials{Username: firewall.Username, Password: firewall.Password, ApiKey: firewall.ApiKey}
+}
+
+// credentialStore holds the inventory credentials of the collected devices, by serial. A nil
+// credentialStore holds nothing.
+type credentialStore struct {
+	mu          sync.Mutex
+	credentials map[string]Credentials
+}
+
+func newCredentialStore() *credentialStore {
+	return &credentialStore{credentials: make(map[string]Credentials)}
+}
+
+// record remembers the credentials of a device, unless none are set
+func (s *credentialStore) record(serial string, credentials Credentials) {
+	if s == nil || serial == "" || !credentials.isSet() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[serial] = credentials
+}
+
+// lookup returns the credentials recorded for a device, if any
+func (s *credentialStore) lookup(serial string) Credentials {
+	if s == nil {
+		return Credentials{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.credentials[serial]
+}
+
+// FirewallCredentials returns the credentials to connect to a collected device: its own credentials
+// from the inventory, matched by serial, or the firewall credentials of the secrets file.
+func (dm *DeviceManager) FirewallCredentials(device map[string]string) Credentials {
+	return dm.deviceCredentials.lookup(device["serial"]).withDefaults(dm.sharedFirewallCredentials())
+}
@@ -0,0 +1,76 @@
+package devices
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// systemInfoClient answers show system info with the serial of the device it was created for
+type systemInfoClient struct {
+	serial string
+}
+
+func (c *systemInfoClient) Initialize() error { return nil }
+
+func (c *systemInfoClient) Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error) {
+	return []byte(fmt.Sprintf(`<response status="success"><result><system><hostname>fw</hostname><serial>%s</serial></system></result></response>`, c.serial)), nil
+}
+
+func TestWithDefaults(t *testing.T) {
+	shared := Credentials{Username: "admin", Password: "shared-pass", ApiKey: "shared-key"}
+
+	assert.Equal(t, shared, Credentials{}.withDefaults(shared))
+	assert.Equal(t, Credentials{Username: "admin", Password: "own-pass"}, Credentials{Password: "own-pass"}.withDefaults(shared), "a device password is not overridden by the shared API key")
+	assert.Equal(t, Credentials{Username: "local", Password: "own-pass"}, Credentials{Username: "local", Password: "own-pass"}.withDefaults(shared))
+	assert.Equal(t, Credentials{Username: "admin", Password: "shared-pass", ApiKey: "own-key"}, Credentials{ApiKey: "own-key"}.withDefaults(shared), "a device with only an API key has a password for SSH")
+	assert.Equal(t, Credentials{Username: "local", Password: "shared-pass", ApiKey: "shared-key"}, Credentials{Username: "local"}.withDefaults(shared))
+}
+
+func TestGetDevicesFromInventoryPerDeviceCredentials(t *testing.T) {
+	conf := &config.Config{}
+	conf.Auth.Credentials.Firewall.Username = "admin"
+	conf.Auth.Credentials.Firewall.Password = "shared-pass"
+	dm := NewDeviceManager(conf, logger.New(0, false))
+	dm.SetInventorySource(stubInventorySource{
+		{Hostname: "fw-own", IPAddress: "192.0.2.1", Username: "local", Password: "own-pass"},
+		{Hostname: "fw-password", IPAddress: "192.0.2.2", Password: "other-pass"},
+		{Hostname: "fw-key", IPAddress: "192.0.2.3", ApiKey: "own-key"},
+		{Hostname: "fw-shared", IPAddress: "192.0.2.4"},
+	})
+
+	var mu sync.Mutex
+	received := make(map[string]Credentials)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		mu.Lock()
+		defer mu.Unlock()
+		received[hostname] = Credentials{Username: username, Password: password, ApiKey: apiKey}
+		return &systemInfoClient{serial: "serial-" + hostname}
+	}
+
+	deviceList, err := dm.getDevicesFromInventory(context.Background())
+	require.NoError(t, err)
+	require.Len(t, deviceList, 4)
+
+	expected := map[string]Credentials{
+		"192.0.2.1": {Username: "local", Password: "own-pass"},
+		"192.0.2.2": {Username: "admin", Password: "other-pass"},
+		"192.0.2.3": {Username: "admin", Password: "shared-pass", ApiKey: "own-key"},
+		"192.0.2.4": {Username: "admin", Password: "shared-pass"},
+	}
+	assert.Equal(t, expected, received, "each device reaches the client factory with its own credentials")
+
+	// Later connections to the collected devices, matched by serial, use the same credentials
+	for address, credentials := range expected {
+		assert.Equal(t, credentials, dm.FirewallCredentials(map[string]string{"serial": "serial-" + address}))
+	}
+	for _, device := range deviceList {
+		assert.NotContains(t, device, "password", "credentials are not recorded on the device")
+	}
+}
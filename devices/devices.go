@@ -2,10 +2,17 @@
 package devices
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/PaloAltoNetworks/pango"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/dnscache"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -15,14 +22,22 @@ type PanosClient interface {
 	Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error)
 }
 
-// PanosClientFactory is a function type that creates a PanosClient
-type PanosClientFactory func(hostname, username, password string) PanosClient
+// PanosClientFactory is a function type that creates a PanosClient. A non-empty apiKey takes
+// precedence over the username and password.
+type PanosClientFactory func(hostname, username, password, apiKey string) PanosClient
 
 // DeviceManager handles device-related operations
 type DeviceManager struct {
 	config             *config.Config
 	logger             *logger.Logger
 	panosClientFactory PanosClientFactory
+	connections        *limiter.Limiter
+	inventorySource    InventorySource
+	onCollected        CollectedFunc
+
+	// deviceCredentials holds the inventory credentials of the collected devices. It is shared by
+	// the copies of the DeviceManager querying each source.
+	deviceCredentials *credentialStore
 }
 
 // NewDeviceManager creates a new instance of DeviceManager with the provided configuration and logger.
@@ -33,14 +48,43 @@ func NewDeviceManager(conf *config.Config, l *logger.Logger) *DeviceManager {
 		config:             conf,
 		logger:             l,
 		panosClientFactory: nil, // This is set later based on the workflow
+		deviceCredentials:  newCredentialStore(),
 	}
 }
 
+// SetConnectionLimiter bounds the simultaneous device connections of the certificate check with a
+// limiter that can be shared with the other phases of the run. A nil limiter removes the bound.
+func (dm *DeviceManager) SetConnectionLimiter(connections *limiter.Limiter) {
+	dm.connections = connections
+}
+
+// SetInventorySource replaces the inventory.yaml file as the source of the inventory devices, e.g.
+// with an SQLInventorySource. A nil source restores the inventory file.
+func (dm *DeviceManager) SetInventorySource(source InventorySource) {
+	dm.inventorySource = source
+}
+
+// CollectedFunc is called with an inventory device right after its facts were collected
+type CollectedFunc func(ctx context.Context, device map[string]string)
+
+// SetOnCollected sets the function called with each inventory device once its facts were collected,
+// in the worker that collected them and before the next device is started, e.g. to register it in
+// the same pass. Devices dropped by OnlySerials are not passed to it. A nil function removes it.
+func (dm *DeviceManager) SetOnCollected(onCollected CollectedFunc) {
+	dm.onCollected = onCollected
+}
+
 // GetDeviceList retrieves a list of devices and their information.
 // If noPanorama is true, it retrieves the devices from the local inventory file.
-// If noPanorama is false, it retrieves the devices from Panorama.
+// If noPanorama is false, it retrieves the devices from Panorama, and when MergeInventory is set
+// it also retrieves the devices from the inventory file and reconciles both lists by serial.
 // It returns the list of devices as an array of maps, where each map contains the device information.
-func (dm *DeviceManager) GetDeviceList(noPanorama bool) ([]map[string]string, error) {
+// Once ctx is done, no further device is queried and the cancellation is returned as an error.
+func (dm *DeviceManager) GetDeviceList(ctx context.Context, noPanorama bool) ([]map[string]string, error) {
+	if !noPanorama && dm.config.MergeInventory {
+		return dm.getMergedDeviceList(ctx)
+	}
+
 	if dm.panosClientFactory == nil {
 		if noPanorama {
 			dm.SetNgfwWorkflow()
@@ -53,22 +97,122 @@ func (dm *DeviceManager) GetDeviceList(noPanorama bool) ([]map[string]string, er
 	var err error
 
 	if noPanorama {
-		deviceList, err = dm.getDevicesFromInventory()
+		deviceList, err = dm.getDevicesFromInventory(ctx)
 	} else {
-		deviceList, err = dm.getDevicesFromPanorama()
+		deviceList, err = dm.getDevicesFromPanorama(ctx)
 	}
 
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get devices: %w", err)
 	}
 
-	return deviceList, nil
+	return dm.flagCompatibilityIssues(dm.applySerialFilter(deviceList)), nil
+}
+
+// getMergedDeviceList retrieves the devices from both Panorama and the inventory file concurrently
+// and reconciles devices that appear in both sources. A failure of one source is logged and the
+// devices from the other source are still returned; an error is only returned if both sources fail.
+func (dm *DeviceManager) getMergedDeviceList(ctx context.Context) ([]map[string]string, error) {
+	panoramaManager, inventoryManager := *dm, *dm
+	if dm.panosClientFactory == nil {
+		panoramaManager.SetPanoramaWorkflow()
+		inventoryManager.SetNgfwWorkflow()
+	}
+
+	var panoramaDevices, inventoryDevices []map[string]string
+	var panoramaErr, inventoryErr error
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		panoramaDevices, panoramaErr = panoramaManager.getDevicesFromPanorama(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		inventoryDevices, inventoryErr = inventoryManager.getDevicesFromInventory(ctx)
+	}()
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+	if panoramaErr != nil && inventoryErr != nil {
+		return nil, fmt.Errorf("failed to get devices from Panorama: %v; failed to get devices from inventory: %w", panoramaErr, inventoryErr)
+	}
+	if panoramaErr != nil {
+		dm.logger.Warn("Failed to get devices from Panorama, continuing with inventory devices only:", panoramaErr)
+	}
+	if inventoryErr != nil {
+		dm.logger.Warn("Failed to get devices from inventory, continuing with Panorama devices only:", inventoryErr)
+	}
+
+	return dm.flagCompatibilityIssues(dm.applySerialFilter(reconcileDevices(panoramaDevices, inventoryDevices, dm.logger))), nil
+}
+
+// applySerialFilter restricts the list to the explicitly requested serials, if any.
+func (dm *DeviceManager) applySerialFilter(deviceList []map[string]string) []map[string]string {
+	if dm.config.OnlySerials != "" {
+		return filterDevicesBySerial(deviceList, strings.Split(dm.config.OnlySerials, ","), dm.logger)
+	}
+	return deviceList
+}
+
+// selectedBySerial reports whether a device is kept by applySerialFilter
+func (dm *DeviceManager) selectedBySerial(device map[string]string) bool {
+	restricted := false
+	for _, serial := range strings.Split(dm.config.OnlySerials, ",") {
+		if serial = strings.TrimSpace(serial); serial != "" {
+			if serial == device["serial"] {
+				return true
+			}
+			restricted = true
+		}
+	}
+	return !restricted
+}
+
+// filterDevicesBySerial keeps only the devices whose serial number is in the given list.
+// Requested serials that were not found in the device list are reported as warnings.
+func filterDevicesBySerial(devices []map[string]string, serials []string, l *logger.Logger) []map[string]string {
+	wanted := make(map[string]bool)
+	for _, serial := range serials {
+		if serial = strings.TrimSpace(serial); serial != "" {
+			wanted[serial] = false
+		}
+	}
+	if len(wanted) == 0 {
+		return devices
+	}
+
+	var filteredDevices []map[string]string
+	for _, device := range devices {
+		if _, ok := wanted[device["serial"]]; ok {
+			wanted[device["serial"]] = true
+			filteredDevices = append(filteredDevices, device)
+		}
+	}
+
+	for _, serial := range serials {
+		serial = strings.TrimSpace(serial)
+		if found, ok := wanted[serial]; ok && !found {
+			l.Warn("Requested serial not found in device list:", serial)
+			wanted[serial] = true // only warn once per serial
+		}
+	}
+
+	l.Info("Devices matching requested serials:", len(filteredDevices), "out of", len(devices))
+	return filteredDevices
 }
 
 // GetDeviceCertificateStatus retrieves the output from the command `show device-certificate status`
 // It will always leverage the pango SDK, and only interact with NGFW devices
 // It will update each device in the deviceList with the certificate status information
-func (dm *DeviceManager) GetDeviceCertificateStatus(deviceList []map[string]string) {
+// Once ctx is done, the devices not queried yet record the cancellation as an error
+func (dm *DeviceManager) GetDeviceCertificateStatus(ctx context.Context, deviceList []map[string]string) {
 	// Always set to NGFW workflow for this operation
 	dm.SetNgfwWorkflow()
 
@@ -81,22 +225,30 @@ func (dm *DeviceManager) GetDeviceCertificateStatus(deviceList []map[string]stri
 
 			device := deviceList[index]
 			hostname := device["hostname"]
-			ipAddress := device["ip-address"]
+			if err := dm.connections.AcquireContext(ctx); err != nil {
+				errMsg := fmt.Sprintf("Failed to get device certificate status for %s: %v", hostname, err)
+				dm.logger.Error(errMsg)
+				deviceList[index]["errors"] = appendError(deviceList[index]["errors"], errMsg)
+				return
+			}
+			defer dm.connections.Release()
+			defer dm.recoverWorker(func(err error) {
+				errMsg := fmt.Sprintf("Failed to get device certificate status for %s: %v", hostname, err)
+				dm.logger.Error(errMsg)
+				deviceList[index]["errors"] = appendError(deviceList[index]["errors"], errMsg)
+			})
 
 			// Initialize the errors slice if it doesn't exist
 			if _, ok := device["errors"]; !ok {
 				deviceList[index]["errors"] = "[]"
 			}
 
-			// Create a new pango client for each device
-			client := dm.panosClientFactory(
-				ipAddress,
-				dm.config.Auth.Credentials.Firewall.Username,
-				dm.config.Auth.Credentials.Firewall.Password,
-			)
-
-			// Initialize the client
-			if err := client.Initialize(); err != nil {
+			// Create and initialize a new pango client for each device, with its own credentials if the
+			// inventory has any
+			credentials := dm.FirewallCredentials(device)
+			deviceTimeout, _ := strconv.Atoi(device["timeout"])
+			client, err := dm.connectClient(ctx, device["ip-address"], hostname, credentials, dm.deviceTimeout(deviceTimeout))
+			if err != nil {
 				errMsg := fmt.Sprintf("Failed to initialize client for %s: %v", hostname, err)
 				dm.logger.Error(errMsg)
 				deviceList[index]["errors"] = appendError(deviceList[index]["errors"], errMsg)
@@ -104,7 +256,7 @@ func (dm *DeviceManager) GetDeviceCertificateStatus(deviceList []map[string]stri
 			}
 
 			// Get device certificate status
-			certStatus, err := dm.showDeviceCertificateStatus(client, hostname)
+			certStatus, err := dm.showDeviceCertificateStatus(ctx, client, device)
 			if err != nil {
 				errMsg := fmt.Sprintf("Failed to get device certificate status for %s: %v", hostname, err)
 				dm.logger.Error(errMsg)
@@ -112,6 +264,11 @@ func (dm *DeviceManager) GetDeviceCertificateStatus(deviceList []map[string]stri
 				return
 			}
 
+			// Collect DNS and NTP alongside the certificate status to help diagnose enrollment failures
+			if dm.config.CollectDNSNTP {
+				dm.addDNSNTP(ctx, client, device, certStatus)
+			}
+
 			// Update the device entry with certificate status information
 			deviceList[index]["deviceCert"] = certStatusToJSON(certStatus)
 		}(i)
@@ -135,6 +292,94 @@ func (dm *DeviceManager) GetDeviceCertificateStatus(deviceList []map[string]stri
 	}
 }
 
+// addDNSNTP adds the DNS and NTP settings of a device to its certificate status. When the certificate
+// is not valid, DNS or NTP problems are appended to the guidance. Failures are only logged, as the
+// values are diagnostics.
+func (dm *DeviceManager) addDNSNTP(ctx context.Context, client PanosClient, device map[string]string, certStatus map[string]string) {
+	diagnostics, err := dm.showDNSNTP(ctx, client, device)
+	if err != nil {
+		dm.logger.Warn(fmt.Sprintf("Failed to get DNS and NTP settings for %s: %v", device["hostname"], err))
+		return
+	}
+	for key, value := range diagnostics {
+		certStatus[key] = value
+	}
+
+	if certStatus["state"] == CertStateValid {
+		return
+	}
+	if hint := diagnosticsGuidance(diagnostics); hint != "" {
+		if certStatus["guidance"] != "" {
+			hint = certStatus["guidance"] + ". " + hint
+		}
+		certStatus["guidance"] = hint
+	}
+}
+
+// deviceTimeout returns the API timeout in seconds for a device.
+// A per-device timeout from the inventory takes precedence over the global device timeout.
+func (dm *DeviceManager) deviceTimeout(perDevice int) int {
+	if perDevice > 0 {
+		return perDevice
+	}
+	return dm.config.DeviceTimeout
+}
+
+// applyTimeout sets the API timeout on pango clients. A timeout of zero keeps the SDK default.
+func applyTimeout(client PanosClient, timeout int) {
+	if timeout <= 0 {
+		return
+	}
+	switch c := client.(type) {
+	case *pango.Firewall:
+		c.Timeout = timeout
+	case *pango.Panorama:
+		c.Timeout = timeout
+	}
+}
+
+// resolveAddresses returns the addresses to connect to for a device address; tests replace it to avoid lookups
+var resolveAddresses = dnscache.Default.Addresses
+
+// connectClient creates the XML API client of a device and initializes it, trying each address of
+// the device in turn until one initializes. Permanent failures, such as rejected credentials, are
+// not tried against the other addresses.
+func (dm *DeviceManager) connectClient(ctx context.Context, address, hostname string, credentials Credentials, timeout int) (PanosClient, error) {
+	var err error
+	for _, candidate := range resolveAddresses(address) {
+		client := dm.panosClientFactory(candidate, credentials.Username, credentials.Password, credentials.ApiKey)
+		applyTimeout(client, timeout)
+		applyHeaders(client, dm.config.HTTPHeaders)
+		if err = dm.initialize(ctx, client, hostname); err == nil {
+			return client, nil
+		}
+		if isPermanentFailure(err) || ctx.Err() != nil {
+			break
+		}
+		dm.logger.Debug(fmt.Sprintf("Client initialization for %s failed at %s: %v", hostname, candidate, err))
+	}
+	return nil, err
+}
+
+// applyHeaders adds the configured HTTP headers to every XML API request of pango clients
+func applyHeaders(client PanosClient, headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	switch c := client.(type) {
+	case *pango.Firewall:
+		c.Headers = headers
+	case *pango.Panorama:
+		c.Headers = headers
+	}
+}
+
+// SetPanosClientFactory replaces the PAN-OS client factory, e.g. with clients that do not contact
+// real devices. A nil factory restores the real client of the workflow.
+func (dm *DeviceManager) SetPanosClientFactory(factory PanosClientFactory) {
+	dm.panosClientFactory = factory
+}
+
 // SetNgfwWorkflow sets the PAN-OS client factory to create a real PAN-OS client for NGFW.
 func (dm *DeviceManager) SetNgfwWorkflow() {
 	dm.panosClientFactory = defaultNgfwClientFactory
@@ -153,6 +398,16 @@ func certStatusToJSON(certStatus map[string]string) string {
 	return string(jsonBytes)
 }
 
+// recoverWorker recovers a panic of a worker goroutine, e.g. a nil map access while processing a
+// device, and passes it to onPanic as an error so the other devices are still processed.
+// It must be deferred directly by the worker.
+func (dm *DeviceManager) recoverWorker(onPanic func(err error)) {
+	if r := recover(); r != nil {
+		dm.logger.Debug("Recovered panic:", r, string(debug.Stack()))
+		onPanic(fmt.Errorf("panic: %v", r))
+	}
+}
+
 func appendError(errorsJSON, newError string) string {
 	var errors []string
 	if err := json.Unmarshal([]byte(errorsJSON), &errors); err != nil {
@@ -1,13 +1,24 @@
 package devices
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/PaloAltoNetworks/pango"
+	pangoerrors "github.com/PaloAltoNetworks/pango/errors"
 	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockPanosClient is a mock implementation of the PanosClient interface
@@ -76,7 +87,7 @@ func TestSetNgfwWorkflow(t *testing.T) {
 	assert.NotNil(t, dm.panosClientFactory)
 
 	// Test the factory creates a PanosClient
-	client := dm.panosClientFactory("test", "user", "pass")
+	client := dm.panosClientFactory("test", "user", "pass", "")
 	assert.NotNil(t, client)
 }
 
@@ -89,6 +100,225 @@ func TestSetPanoramaWorkflow(t *testing.T) {
 	assert.NotNil(t, dm.panosClientFactory)
 
 	// Test the factory creates a PanosClient
-	client := dm.panosClientFactory("test", "user", "pass")
+	client := dm.panosClientFactory("test", "user", "pass", "")
 	assert.NotNil(t, client)
 }
+
+func TestFilterDevicesBySerial(t *testing.T) {
+	l := logger.New(0, false)
+	devices := []map[string]string{
+		{"hostname": "fw-1", "serial": "111"},
+		{"hostname": "fw-2", "serial": "222"},
+		{"hostname": "fw-3", "serial": "333"},
+	}
+
+	t.Run("Matching serials", func(t *testing.T) {
+		filtered := filterDevicesBySerial(devices, []string{"111", " 333"}, l)
+		assert.Len(t, filtered, 2)
+		assert.Equal(t, "fw-1", filtered[0]["hostname"])
+		assert.Equal(t, "fw-3", filtered[1]["hostname"])
+	})
+
+	t.Run("Serial not found", func(t *testing.T) {
+		var buf bytes.Buffer
+		bufLogger := logger.New(0, false)
+		bufLogger.SetOutput(&buf)
+
+		filtered := filterDevicesBySerial(devices, []string{"222", "999"}, bufLogger)
+		assert.Len(t, filtered, 1)
+		assert.Equal(t, "fw-2", filtered[0]["hostname"])
+		assert.Contains(t, buf.String(), "[WARN] Requested serial not found in device list: 999")
+	})
+
+	t.Run("Empty list", func(t *testing.T) {
+		filtered := filterDevicesBySerial(devices, []string{""}, l)
+		assert.Len(t, filtered, 3)
+	})
+}
+
+func TestGetDeviceListOnlySerials(t *testing.T) {
+	conf, err := setupTestConfig()
+	assert.NoError(t, err)
+	conf.OnlySerials = "22222"
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	mockClient := new(MockPanosClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(nil)
+	mockResponse := `
+	<response status="success">
+		<result>
+			<devices>
+				<entry><hostname>fw-1</hostname><serial>11111</serial></entry>
+				<entry><hostname>fw-2</hostname><serial>22222</serial></entry>
+			</devices>
+		</result>
+	</response>`
+	noDeviceTags(mockClient)
+	mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return([]byte(mockResponse), nil)
+
+	devices, err := dm.GetDeviceList(context.Background(), false)
+
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "fw-2", devices[0]["hostname"])
+}
+
+func TestDeviceTimeout(t *testing.T) {
+	conf := &config.Config{DeviceTimeout: 30}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	t.Run("Global timeout", func(t *testing.T) {
+		assert.Equal(t, 30, dm.deviceTimeout(0))
+	})
+
+	t.Run("Device timeout overrides global", func(t *testing.T) {
+		assert.Equal(t, 120, dm.deviceTimeout(120))
+	})
+
+	t.Run("Applied to pango clients", func(t *testing.T) {
+		fw := defaultNgfwClientFactory("slow-site", "user", "pass", "")
+		applyTimeout(fw, dm.deviceTimeout(120))
+		assert.Equal(t, 120, fw.(*pango.Firewall).Timeout)
+
+		pano := defaultPanoramaClientFactory("panorama", "user", "pass", "")
+		applyTimeout(pano, dm.deviceTimeout(0))
+		assert.Equal(t, 30, pano.(*pango.Panorama).Timeout)
+	})
+
+	t.Run("Zero keeps SDK default", func(t *testing.T) {
+		fw := defaultNgfwClientFactory("site", "user", "pass", "")
+		applyTimeout(fw, 0)
+		assert.Equal(t, 0, fw.(*pango.Firewall).Timeout)
+	})
+}
+
+func TestCancelledContext(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{Concurrency: 2, Retries: 2}, logger.New(0, false))
+	dm.SetInventorySource(stubInventorySource{{Hostname: "fw-1", IPAddress: "192.0.2.1"}, {Hostname: "fw-2", IPAddress: "192.0.2.2"}})
+	mockClient := new(MockPanosClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("Collection", func(t *testing.T) {
+		_, err := dm.GetDeviceList(cancelled, true)
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Certificate status", func(t *testing.T) {
+		deviceList := []map[string]string{{"hostname": "fw-1"}, {"hostname": "fw-2"}}
+
+		dm.GetDeviceCertificateStatus(cancelled, deviceList)
+
+		for _, device := range deviceList {
+			assert.Contains(t, device["errors"], "context canceled")
+			assert.NotContains(t, device, "deviceCert")
+		}
+	})
+
+	mockClient.AssertNotCalled(t, "Initialize")
+}
+
+func TestInitializeHungClient(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{Retries: 2}, logger.New(0, false))
+	mockClient := new(MockPanosClient)
+	mockClient.On("Initialize").Return(nil).After(2 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := dm.initialize(ctx, mockClient, "hung-panorama")
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "a hung client must not block the run")
+	mockClient.AssertNumberOfCalls(t, "Initialize", 1)
+}
+
+func TestApplyHeadersStubServer(t *testing.T) {
+	var mu sync.Mutex
+	var received []http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Clone())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<response status="success"><result><system><hostname>stub</hostname><sw-version>10.2.3</sw-version><model>PA-VM</model><serial>1</serial></system></result></response>`))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	headers := map[string]string{"X-Waf-Token": "s3cret", "X-Request-Source": "cdss"}
+	for name, factory := range map[string]PanosClientFactory{"NGFW": defaultNgfwClientFactory, "Panorama": defaultPanoramaClientFactory} {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			received = nil
+			mu.Unlock()
+
+			client := factory(host, "", "", "api-key")
+			applyHeaders(client, headers)
+			// The outcome of the initialization does not matter, only the requests sent to the stub
+			_ = client.Initialize()
+
+			mu.Lock()
+			defer mu.Unlock()
+			require.NotEmpty(t, received, "no request reached the stub server")
+			for _, header := range received {
+				assert.Equal(t, "s3cret", header.Get("X-Waf-Token"))
+				assert.Equal(t, "cdss", header.Get("X-Request-Source"))
+			}
+		})
+	}
+}
+
+func TestApplyHeadersWithoutHeaders(t *testing.T) {
+	fw := defaultNgfwClientFactory("site", "user", "pass", "")
+	applyHeaders(fw, nil)
+	assert.Nil(t, fw.(*pango.Firewall).Headers)
+}
+
+func TestConnectClientAddressFallback(t *testing.T) {
+	original := resolveAddresses
+	resolveAddresses = func(address string) []string { return []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} }
+	t.Cleanup(func() { resolveAddresses = original })
+
+	newManager := func(clients map[string]*MockNgfwClient) (*DeviceManager, *[]string) {
+		var dialled []string
+		dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+		dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+			dialled = append(dialled, hostname)
+			return clients[hostname]
+		}
+		return dm, &dialled
+	}
+
+	t.Run("Next address after a connection failure", func(t *testing.T) {
+		unreachable, reachable := new(MockNgfwClient), new(MockNgfwClient)
+		unreachable.On("Initialize").Return(errors.New("dial tcp 192.0.2.1:443: connect: no route to host"))
+		reachable.On("Initialize").Return(nil)
+		dm, dialled := newManager(map[string]*MockNgfwClient{"192.0.2.1": unreachable, "192.0.2.2": reachable})
+
+		client, err := dm.connectClient(context.Background(), "fw-1.example.com", "fw-1", Credentials{}, 0)
+
+		require.NoError(t, err)
+		assert.Same(t, reachable, client)
+		assert.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, *dialled)
+	})
+
+	t.Run("Rejected credentials are not tried again", func(t *testing.T) {
+		rejecting := new(MockNgfwClient)
+		rejecting.On("Initialize").Return(pangoerrors.Panos{Msg: "Invalid Credential", Code: 403})
+		dm, dialled := newManager(map[string]*MockNgfwClient{"192.0.2.1": rejecting})
+
+		_, err := dm.connectClient(context.Background(), "fw-1.example.com", "fw-1", Credentials{}, 0)
+
+		assert.EqualError(t, err, "Invalid Credential")
+		assert.Equal(t, []string{"192.0.2.1"}, *dialled)
+	})
+}
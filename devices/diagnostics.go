@@ -0,0 +1,132 @@
+// Package devices devices/diagnostics.go
+package devices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+)
+
+// ntpNotSynchronized is the "synched" value PAN-OS reports when the clock is not synchronized to an NTP server
+const ntpNotSynchronized = "LOCAL"
+
+// dnsSetting represents the configured DNS servers returned by the DNS settings op command
+type dnsSetting struct {
+	Primary   string `xml:"dns-setting>servers>primary"`
+	Secondary string `xml:"dns-setting>servers>secondary"`
+}
+
+// ntpServer represents one configured NTP server in the `show ntp` output
+type ntpServer struct {
+	Name      string `xml:"name"`
+	Status    string `xml:"status"`
+	Reachable string `xml:"reachable"`
+}
+
+// ntpStatus represents the `show ntp` output
+type ntpStatus struct {
+	Synched string    `xml:"synched"`
+	Server1 ntpServer `xml:"ntp-server-1"`
+	Server2 ntpServer `xml:"ntp-server-2"`
+}
+
+// showDNSNTP retrieves the configured DNS servers and the NTP synchronization status of a device.
+// Device certificate fetch failures are often caused by bad DNS or NTP, so the values are reported
+// alongside the certificate status. The returned map holds "dns_servers", "ntp_synched" and "ntp_servers".
+func (dm *DeviceManager) showDNSNTP(ctx context.Context, client PanosClient, device map[string]string) (map[string]string, error) {
+	commands := config.CommandsForDevice(device)
+
+	response, err := dm.op(ctx, client, commands.DNSSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform op command: %w %s", err, device["hostname"])
+	}
+	dns, err := parseDNSSetting(response)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err = dm.op(ctx, client, commands.NTPStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform op command: %w %s", err, device["hostname"])
+	}
+	ntp, err := parseNTPStatus(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"dns_servers": strings.Join(dnsServers(dns), ", "),
+		"ntp_synched": ntp.Synched,
+		"ntp_servers": strings.Join(ntpServers(ntp), ", "),
+	}, nil
+}
+
+// parseDNSSetting unmarshals the response of the DNS settings op command
+func parseDNSSetting(response []byte) (dnsSetting, error) {
+	var result dnsSetting
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
+		return dnsSetting{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if status != "success" {
+		return dnsSetting{}, fmt.Errorf("operation failed: %s", status)
+	}
+	return result, nil
+}
+
+// parseNTPStatus unmarshals the response of the `show ntp` op command
+func parseNTPStatus(response []byte) (ntpStatus, error) {
+	var result ntpStatus
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
+		return ntpStatus{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if status != "success" {
+		return ntpStatus{}, fmt.Errorf("operation failed: %s", status)
+	}
+	return result, nil
+}
+
+// dnsServers lists the configured DNS servers, primary first
+func dnsServers(dns dnsSetting) []string {
+	var servers []string
+	for _, server := range []string{dns.Primary, dns.Secondary} {
+		if server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// ntpServers lists the configured NTP servers with their reachability, e.g. "0.pool.ntp.org (reachable: yes)"
+func ntpServers(ntp ntpStatus) []string {
+	var servers []string
+	for _, server := range []ntpServer{ntp.Server1, ntp.Server2} {
+		if server.Name == "" {
+			continue
+		}
+		reachable := server.Reachable
+		if reachable == "" {
+			reachable = "unknown"
+		}
+		servers = append(servers, fmt.Sprintf("%s (reachable: %s)", server.Name, reachable))
+	}
+	return servers
+}
+
+// diagnosticsGuidance explains the DNS and NTP problems that can prevent fetching a device certificate
+func diagnosticsGuidance(diagnostics map[string]string) string {
+	var hints []string
+	if diagnostics["dns_servers"] == "" {
+		hints = append(hints, "no DNS servers are configured")
+	}
+	if synched := diagnostics["ntp_synched"]; synched == "" || strings.EqualFold(synched, ntpNotSynchronized) {
+		hints = append(hints, "the clock is not synchronized to an NTP server")
+	}
+	if len(hints) == 0 {
+		return ""
+	}
+	return "Check DNS and NTP: " + strings.Join(hints, " and ")
+}
@@ -0,0 +1,78 @@
+package devices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dnsSettingResponse = `
+<response status="success">
+	<result>
+		<dns-setting>
+			<servers>
+				<primary>10.0.0.53</primary>
+				<secondary>8.8.8.8</secondary>
+			</servers>
+		</dns-setting>
+	</result>
+</response>`
+
+const ntpStatusResponse = `
+<response status="success">
+	<result>
+		<synched>0.pool.ntp.org</synched>
+		<ntp-server-1>
+			<status>synched</status>
+			<authentication-type>none</authentication-type>
+			<reachable>yes</reachable>
+			<name>0.pool.ntp.org</name>
+		</ntp-server-1>
+		<ntp-server-2>
+			<status>available</status>
+			<authentication-type>none</authentication-type>
+			<reachable>no</reachable>
+			<name>1.pool.ntp.org</name>
+		</ntp-server-2>
+	</result>
+</response>`
+
+func TestShowDNSNTP(t *testing.T) {
+	commands := config.CommandsForMajor(0)
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Op", commands.DNSSettings, "", nil, nil).Return([]byte(dnsSettingResponse), nil)
+	mockClient.On("Op", commands.NTPStatus, "", nil, nil).Return([]byte(ntpStatusResponse), nil)
+
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	diagnostics, err := dm.showDNSNTP(context.Background(), mockClient, map[string]string{"hostname": "fw-1", "sw-version": "10.1.0"})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"dns_servers": "10.0.0.53, 8.8.8.8",
+		"ntp_synched": "0.pool.ntp.org",
+		"ntp_servers": "0.pool.ntp.org (reachable: yes), 1.pool.ntp.org (reachable: no)",
+	}, diagnostics)
+	assert.Empty(t, diagnosticsGuidance(diagnostics))
+	mockClient.AssertExpectations(t)
+}
+
+func TestAddDNSNTPGuidance(t *testing.T) {
+	commands := config.CommandsForMajor(0)
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Op", commands.DNSSettings, "", nil, nil).
+		Return([]byte(`<response status="success"><result></result></response>`), nil)
+	mockClient.On("Op", commands.NTPStatus, "", nil, nil).
+		Return([]byte(`<response status="success"><result><synched>LOCAL</synched></result></response>`), nil)
+
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	certStatus := map[string]string{"state": CertStateNeedsEnrollment, "guidance": "Fetch a device certificate"}
+	dm.addDNSNTP(context.Background(), mockClient, map[string]string{"hostname": "fw-1"}, certStatus)
+
+	assert.Equal(t, "", certStatus["dns_servers"])
+	assert.Equal(t, "LOCAL", certStatus["ntp_synched"])
+	assert.Equal(t, "Fetch a device certificate. Check DNS and NTP: no DNS servers are configured and the clock is not synchronized to an NTP server", certStatus["guidance"])
+}
@@ -0,0 +1,50 @@
+// Package devices devices/health.go
+package devices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+)
+
+// autoCommitJobType is the type of the job PAN-OS runs to commit its configuration after a boot
+const autoCommitJobType = "AutoCom"
+
+// runningJobStatuses are the statuses of a job that is queued or still running
+var runningJobStatuses = map[string]bool{"ACT": true, "PEND": true}
+
+// job represents one job of the `show jobs all` output
+type job struct {
+	ID     string `xml:"id"`
+	Type   string `xml:"type"`
+	Status string `xml:"status"`
+}
+
+// showAutoCommit reports whether an auto-commit job is queued or running on a device, such as
+// right after a reboot, when registering WildFire is pointless.
+func (dm *DeviceManager) showAutoCommit(ctx context.Context, client PanosClient, device map[string]string) (bool, error) {
+	response, err := dm.op(ctx, client, config.CommandsForDevice(device).Jobs)
+	if err != nil {
+		return false, fmt.Errorf("failed to perform op command: %w %s", err, device["hostname"])
+	}
+
+	var result struct {
+		Jobs []job `xml:"job"`
+	}
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if status != "success" {
+		return false, fmt.Errorf("operation failed: %s", status)
+	}
+
+	for _, j := range result.Jobs {
+		if strings.TrimSpace(j.Type) == autoCommitJobType && runningJobStatuses[strings.TrimSpace(j.Status)] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
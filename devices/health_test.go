@@ -0,0 +1,88 @@
+package devices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowAutoCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name: "Auto-commit running",
+			response: `<response status="success"><result>
+				<job><id>1</id><type>AutoCom</type><status>ACT</status></job>
+			</result></response>`,
+			want: true,
+		},
+		{
+			name: "Auto-commit finished",
+			response: `<response status="success"><result>
+				<job><id>2</id><type>Commit</type><status>ACT</status></job>
+				<job><id>1</id><type>AutoCom</type><status>FIN</status></job>
+			</result></response>`,
+			want: false,
+		},
+		{name: "No jobs", response: `<response status="success"><result></result></response>`, want: false},
+		{name: "Failed command", response: `<response status="error"><result></result></response>`, wantErr: true},
+	}
+
+	device := map[string]string{"hostname": "fw-1", "sw-version": "10.1.0"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", config.CommandsForDevice(device).Jobs, "", nil, nil).Return([]byte(tt.response), nil)
+			dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+
+			running, err := dm.showAutoCommit(context.Background(), mockClient, device)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, running)
+		})
+	}
+}
+
+func TestGetDevicesFromInventoryHealthFacts(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{HealthGate: true}, logger.New(0, false))
+	dm.SetInventorySource(stubInventorySource{{Hostname: "maintenance-fw", IPAddress: "192.0.2.1"}})
+	mockClient := new(MockNgfwClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+
+	mockClient.On("Initialize").Return(nil)
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(`
+	<response status="success">
+		<result>
+			<system>
+				<hostname>maintenance-fw</hostname>
+				<serial>12345</serial>
+				<family>3200</family>
+				<sw-version>10.1.0</sw-version>
+				<operational-mode>maintenance</operational-mode>
+			</system>
+		</result>
+	</response>`), nil)
+	mockClient.On("Op", "<show><jobs><all/></jobs></show>", "", nil, nil).Return([]byte(`<response status="success"><result><job><type>AutoCom</type><status>PEND</status></job></result></response>`), nil)
+
+	devices, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "maintenance", devices[0]["operational-mode"])
+	assert.Equal(t, "true", devices[0]["autocommit"])
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,150 @@
+// Package devices devices/inventory_source.go
+package devices
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+)
+
+// InventorySource provides the inventory devices, the firewalls queried directly instead of
+// through Panorama.
+type InventorySource interface {
+	InventoryDevices() ([]config.InventoryDevice, error)
+}
+
+// fileInventorySource reads the inventory devices from an inventory YAML file
+type fileInventorySource struct {
+	path         string
+	fieldMapping map[string]string
+}
+
+// InventoryDevices reads the inventory file, applying the field mapping
+func (s fileInventorySource) InventoryDevices() ([]config.InventoryDevice, error) {
+	inventory, err := readInventoryFile(s.path, s.fieldMapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %w", err)
+	}
+	return inventory.Inventory, nil
+}
+
+// SQLInventorySource reads the inventory devices from a database through database/sql.
+// The query must return a hostname and an ip_address column. Optional tags (comma-separated),
+// timeout, channel, username, password, api_key and skip_registration columns fill the matching inventory fields,
+// and any other column is kept as a fact of the device.
+type SQLInventorySource struct {
+	DB    *sql.DB
+	Query string
+}
+
+// NewSQLInventorySource opens the inventory database with a registered database/sql driver.
+// No driver is compiled in by default; see the README for building with one.
+func NewSQLInventorySource(driver, dsn, query string) (*SQLInventorySource, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open inventory database: %w", err)
+	}
+	if query == "" {
+		query = config.DefaultInventoryQuery
+	}
+	return &SQLInventorySource{DB: db, Query: query}, nil
+}
+
+// InventoryDevices runs the query and returns one inventory device per row
+func (s *SQLInventorySource) InventoryDevices() ([]config.InventoryDevice, error) {
+	rows, err := s.DB.Query(s.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inventory database: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory columns: %w", err)
+	}
+	if !hasColumns(columns, "hostname", "ip_address") {
+		return nil, fmt.Errorf("inventory query must return hostname and ip_address columns, got %s", strings.Join(columns, ", "))
+	}
+
+	var devices []config.InventoryDevice
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to read inventory row: %w", err)
+		}
+
+		var device config.InventoryDevice
+		for i, column := range columns {
+			value := values[i].String
+			switch strings.ToLower(column) {
+			case "hostname":
+				device.Hostname = value
+			case "ip_address":
+				device.IPAddress = value
+			case "tags":
+				for _, tag := range strings.Split(value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						device.Tags = append(device.Tags, tag)
+					}
+				}
+			case "timeout":
+				if value != "" {
+					if device.Timeout, err = strconv.Atoi(value); err != nil {
+						return nil, fmt.Errorf("invalid timeout %q for %s: %w", value, device.Hostname, err)
+					}
+				}
+			case "channel":
+				device.Channel = value
+			case "username":
+				device.Username = value
+			case "password":
+				device.Password = value
+			case "api_key":
+				device.ApiKey = value
+			case "skip_registration":
+				if value != "" {
+					if device.SkipRegistration, err = strconv.ParseBool(value); err != nil {
+						return nil, fmt.Errorf("invalid skip_registration %q for %s: %w", value, device.Hostname, err)
+					}
+				}
+			default:
+				if values[i].Valid {
+					if device.Facts == nil {
+						device.Facts = make(map[string]string)
+					}
+					device.Facts[column] = value
+				}
+			}
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read inventory rows: %w", err)
+	}
+
+	return devices, nil
+}
+
+// hasColumns reports whether every required column is in columns, ignoring case
+func hasColumns(columns []string, required ...string) bool {
+	for _, name := range required {
+		found := false
+		for _, column := range columns {
+			if strings.EqualFold(column, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInventoryTables maps a data source name to the columns and rows every query returns
+var fakeInventoryTables = map[string]fakeInventoryTable{
+	"devices": {
+		columns: []string{"hostname", "ip_address", "tags", "timeout", "site"},
+		rows: [][]driver.Value{
+			{"fw-db-1", "192.168.1.1", "dc1, core", int64(30), "Dallas"},
+			{"fw-db-2", "192.168.1.2", nil, nil, nil},
+		},
+	},
+	"no-address": {
+		columns: []string{"hostname"},
+		rows:    [][]driver.Value{{"fw-db-1"}},
+	},
+}
+
+func init() {
+	sql.Register("fakeinventory", fakeInventoryDriver{})
+}
+
+type fakeInventoryTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeInventoryDriver is a database/sql driver serving fakeInventoryTables, standing in for a real database
+type fakeInventoryDriver struct{}
+
+func (fakeInventoryDriver) Open(name string) (driver.Conn, error) {
+	table, ok := fakeInventoryTables[name]
+	if !ok {
+		return nil, errors.New("unknown database " + name)
+	}
+	return fakeInventoryConn{table: table}, nil
+}
+
+type fakeInventoryConn struct{ table fakeInventoryTable }
+
+func (c fakeInventoryConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeInventoryStmt(c), nil
+}
+func (c fakeInventoryConn) Close() error              { return nil }
+func (c fakeInventoryConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeInventoryStmt struct{ table fakeInventoryTable }
+
+func (s fakeInventoryStmt) Close() error  { return nil }
+func (s fakeInventoryStmt) NumInput() int { return 0 }
+func (s fakeInventoryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s fakeInventoryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeInventoryRows{table: s.table}, nil
+}
+
+type fakeInventoryRows struct {
+	table fakeInventoryTable
+	next  int
+}
+
+func (r *fakeInventoryRows) Columns() []string { return r.table.columns }
+func (r *fakeInventoryRows) Close() error      { return nil }
+func (r *fakeInventoryRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.table.rows) {
+		return io.EOF
+	}
+	copy(dest, r.table.rows[r.next])
+	r.next++
+	return nil
+}
+
+func TestSQLInventorySource(t *testing.T) {
+	source, err := NewSQLInventorySource("fakeinventory", "devices", "")
+	require.NoError(t, err)
+	defer source.DB.Close()
+	assert.Equal(t, config.DefaultInventoryQuery, source.Query)
+
+	inventory, err := source.InventoryDevices()
+
+	require.NoError(t, err)
+	assert.Equal(t, []config.InventoryDevice{
+		{Hostname: "fw-db-1", IPAddress: "192.168.1.1", Tags: []string{"dc1", "core"}, Timeout: 30, Facts: map[string]string{"site": "Dallas"}},
+		{Hostname: "fw-db-2", IPAddress: "192.168.1.2"},
+	}, inventory)
+}
+
+func TestSQLInventorySourceMissingColumns(t *testing.T) {
+	source, err := NewSQLInventorySource("fakeinventory", "no-address", "SELECT hostname FROM devices")
+	require.NoError(t, err)
+	defer source.DB.Close()
+
+	_, err = source.InventoryDevices()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must return hostname and ip_address columns")
+}
+
+func TestNewSQLInventorySourceUnknownDriver(t *testing.T) {
+	_, err := NewSQLInventorySource("not-compiled-in", "devices", "")
+	assert.Error(t, err)
+}
+
+func TestGetDevicesFromInventorySource(t *testing.T) {
+	source, err := NewSQLInventorySource("fakeinventory", "devices", "")
+	require.NoError(t, err)
+	defer source.DB.Close()
+
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	dm.SetInventorySource(source)
+	mockClient := new(MockNgfwClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(nil)
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(`
+	<response status="success">
+		<result>
+			<system>
+				<hostname>fw-db</hostname>
+				<serial>12345</serial>
+				<family>3200</family>
+				<sw-version>10.1.0</sw-version>
+			</system>
+		</result>
+	</response>`), nil)
+
+	devices, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	sites := []string{devices[0]["site"], devices[1]["site"]}
+	assert.ElementsMatch(t, []string{"Dallas", ""}, sites, "inventory facts are recorded on the device")
+	for _, device := range devices {
+		assert.Equal(t, "fw-db", device["hostname"], "collected information takes precedence over inventory facts")
+	}
+}
@@ -2,58 +2,125 @@
 package devices
 
 import (
-	"encoding/xml"
+	"context"
+	"encoding/json"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/PaloAltoNetworks/pango"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/dnscache"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
 )
 
-// defaultNgfwClientFactory is a function that creates a PAN-OS client for NGFW with the given hostname, username, and password.
+// Device certificate states derived from the `show device-certificate status` output
+const (
+	CertStateValid           = "valid"
+	CertStateExpired         = "expired"
+	CertStateNeedsEnrollment = "needs device certificate enrollment"
+	CertStateUnknown         = "unknown"
+)
+
+// SourceInventory is the "source" of the devices read from the inventory, while the devices
+// collected from Panorama have the hostname of their Panorama as source
+const SourceInventory = "inventory"
+
+// noCertificateStatuses are the lowercase values of the status or validity of a device certificate
+// that PAN-OS reports when no device certificate is present
+var noCertificateStatuses = []string{"not fetched", "not present", "none"}
+
+// noCertificateMessages are the lowercase messages PAN-OS answers when no device certificate is present
+var noCertificateMessages = []string{"device certificate not found", "no device certificate", "device certificate not present"}
+
+// defaultNgfwClientFactory is a function that creates a PAN-OS client for NGFW with the given hostname, username, and password,
+// or with the given API key instead when one is set.
 // It returns a PanosClient interface that can be used for PAN-OS operations.
-func defaultNgfwClientFactory(hostname, username, password string) PanosClient {
+func defaultNgfwClientFactory(hostname, username, password, apiKey string) PanosClient {
 	return &pango.Firewall{
-		Client: pango.Client{
-			Hostname: hostname,
-			Username: username,
-			Password: password,
-			Logging:  pango.LogAction | pango.LogOp,
-		},
+		Client: newPangoClient(hostname, username, password, apiKey),
+	}
+}
+
+// newPangoClient builds the pango client configuration, authenticating with the API key when one
+// is set and with the username and password otherwise.
+func newPangoClient(hostname, username, password, apiKey string) pango.Client {
+	client := pango.Client{
+		Hostname: hostname,
+		Logging:  pango.LogAction | pango.LogOp,
+	}
+	if apiKey != "" {
+		client.ApiKey = apiKey
+	} else {
+		client.Username = username
+		client.Password = password
 	}
+	return client
 }
 
-// getDevicesFromInventory retrieves the devices from the inventory file and
-// collects their information by initializing the NGFW client for each device.
-// It returns a list of devices as an array of maps, where each map contains
+// getDevicesFromInventory retrieves the devices from the inventory source, the inventory file
+// unless another source was set, and collects their information by initializing the NGFW client
+// for each device. It returns a list of devices as an array of maps, where each map contains
 // the device information. If any errors occur during the retrieval process,
 // an error is returned.
-func (dm *DeviceManager) getDevicesFromInventory() ([]map[string]string, error) {
-	inventory, err := readInventoryFile("inventory.yaml")
+func (dm *DeviceManager) getDevicesFromInventory(ctx context.Context) ([]map[string]string, error) {
+	inventory, err := dm.InventoryDevices()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read inventory file: %w", err)
+		return nil, err
+	}
+
+	// Resolve each unique inventory address once up front; later phases reuse the cached results
+	addresses := make([]string, 0, len(inventory))
+	for _, device := range inventory {
+		addresses = append(addresses, device.IPAddress)
 	}
+	dnscache.Default.Prefetch(addresses, dm.config.Concurrency)
 
 	var deviceList []map[string]string
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	errorList := make([]string, 0)
 
-	for _, device := range inventory.Inventory {
+	// At most Concurrency devices are queried at once, ramping up to it if configured
+	workers := limiter.New(dm.config.Concurrency)
+	workers.RampUp(dm.config.RampUp)
+
+	for _, device := range inventory {
 		wg.Add(1)
 		go func(device config.InventoryDevice) {
 			defer wg.Done()
+			// Once the run is cancelled, the devices not queried yet are reported as failed
+			if err := workers.AcquireContext(ctx); err != nil {
+				errorMsg := fmt.Sprintf("Failed to get device info for %s: %v", device.Hostname, err)
+				dm.logger.Debug(errorMsg)
+				mu.Lock()
+				errorList = append(errorList, errorMsg)
+				mu.Unlock()
+				return
+			}
+			defer workers.Release()
+			defer dm.recoverWorker(func(err error) {
+				errorMsg := fmt.Sprintf("Failed to get device info for %s: %v", device.Hostname, err)
+				dm.logger.Debug(errorMsg)
+				mu.Lock()
+				errorList = append(errorList, errorMsg)
+				mu.Unlock()
+			})
 
-			ngfwClient := dm.panosClientFactory(
-				device.IPAddress,
-				dm.config.Auth.Credentials.Firewall.Username,
-				dm.config.Auth.Credentials.Firewall.Password,
-			)
+			// Devices with their own credentials in the inventory use them instead of the shared ones
+			credentials := dm.inventoryCredentials(device)
+			timeout := dm.deviceTimeout(device.Timeout)
 
+			start := time.Now()
 			dm.logger.Info("Initializing NGFW client for", device.Hostname)
-			if err := ngfwClient.Initialize(); err != nil {
+			ngfwClient, err := dm.connectClient(ctx, device.IPAddress, device.Hostname, credentials, timeout)
+			if err != nil {
 				errorMsg := fmt.Sprintf("Failed to initialize NGFW client for %s: %v", device.Hostname, err)
 				dm.logger.Debug(errorMsg)
 				mu.Lock()
@@ -62,7 +129,7 @@ func (dm *DeviceManager) getDevicesFromInventory() ([]map[string]string, error)
 				return
 			}
 
-			deviceInfo, err := dm.getNgfwDeviceInfo(ngfwClient, device.Hostname)
+			deviceInfo, err := dm.getNgfwDeviceInfo(ctx, ngfwClient, device.Hostname)
 			if err != nil {
 				errorMsg := fmt.Sprintf("Failed to get device info for %s: %v", device.Hostname, err)
 				dm.logger.Debug(errorMsg)
@@ -72,6 +139,42 @@ func (dm *DeviceManager) getDevicesFromInventory() ([]map[string]string, error)
 				return
 			}
 
+			// A running auto-commit makes the device unhealthy for registration, see filters.UnhealthyReason
+			if dm.config.HealthGate {
+				running, err := dm.showAutoCommit(ctx, ngfwClient, deviceInfo)
+				if err != nil {
+					dm.logger.Debug("Failed to get the auto-commit status of", device.Hostname, err)
+				} else {
+					deviceInfo["autocommit"] = strconv.FormatBool(running)
+				}
+			}
+
+			deviceInfo["collect_duration_ms"] = strconv.FormatInt(time.Since(start).Milliseconds(), 10)
+			deviceInfo["source"] = SourceInventory
+			// Later connections to the device, such as the WildFire registration, use the same credentials
+			dm.deviceCredentials.record(deviceInfo["serial"], Credentials{Username: device.Username, Password: device.Password, ApiKey: device.ApiKey})
+			if len(device.Tags) > 0 {
+				deviceInfo["tags"] = strings.Join(device.Tags, ",")
+			}
+			if device.Timeout > 0 {
+				deviceInfo["timeout"] = strconv.Itoa(device.Timeout)
+			}
+			if device.Channel != "" {
+				deviceInfo["channel"] = device.Channel
+			}
+			if device.SkipRegistration {
+				deviceInfo["skip_registration"] = "true"
+			}
+			// Facts from the inventory complement, but never override, the collected information
+			for key, value := range device.Facts {
+				if _, ok := deviceInfo[key]; !ok {
+					deviceInfo[key] = value
+				}
+			}
+			if dm.onCollected != nil && dm.selectedBySerial(deviceInfo) {
+				dm.onCollected(ctx, deviceInfo)
+			}
+
 			mu.Lock()
 			deviceList = append(deviceList, deviceInfo)
 			mu.Unlock()
@@ -82,108 +185,304 @@ func (dm *DeviceManager) getDevicesFromInventory() ([]map[string]string, error)
 
 	// Print errors if any
 	if len(errorList) > 0 {
-		fmt.Println("Errors occurred while processing devices:")
-		for _, errMsg := range errorList {
-			fmt.Println(errMsg)
-		}
-		fmt.Println() // Add a blank line for better readability
+		dm.logger.Console("Errors occurred while processing devices:\n%s\n\n", strings.Join(errorList, "\n"))
 	}
 
 	return deviceList, nil
 }
 
+// InventoryDevices returns the devices of the inventory source, the inventory file unless another
+// source was set, without contacting them.
+func (dm *DeviceManager) InventoryDevices() ([]config.InventoryDevice, error) {
+	source := dm.inventorySource
+	if source == nil {
+		path := dm.config.InventoryFile
+		if path == "" {
+			path = config.DefaultInventoryFile
+		}
+		source = fileInventorySource{path: path, fieldMapping: dm.config.InventoryFieldMapping}
+	}
+	return source.InventoryDevices()
+}
+
 // getNgfwDeviceInfo retrieves the device information from a specific NGFW device using the provided PanosClient and hostname.
-// It sends an "op" command to the device to get the system information.
+// It sends an "op" command to the device to get the system information. The PAN-OS version is not known
+// before this command, so the command of the newest PAN-OS version is used.
 // The method returns a map of device information, including serial number, hostname, IP address, model, software version,
 // application version, antivirus version, Wildfire version, and threat version.
-// If any errors occur during the process, an error is returned.
-func (dm *DeviceManager) getNgfwDeviceInfo(client PanosClient, hostname string) (map[string]string, error) {
-	cmd := "<show><system><info/></system></show>"
-	response, err := client.Op(cmd, "", nil, nil)
+// If any errors occur during the process, or the device turns out to be a Panorama, an error is returned.
+func (dm *DeviceManager) getNgfwDeviceInfo(ctx context.Context, client PanosClient, hostname string) (map[string]string, error) {
+	cmd := config.CommandsForMajor(0).SystemInfo
+	response, err := dm.op(ctx, client, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform op command: %w %s", err, hostname)
 	}
 
-	var resp struct {
-		XMLName xml.Name `xml:"response"`
-		Status  string   `xml:"status,attr"`
-		Result  struct {
-			System config.DeviceEntry `xml:"system"`
-		} `xml:"result"`
+	var result struct {
+		System config.DeviceEntry `xml:"system"`
 	}
 
-	if err := xml.Unmarshal(response, &resp); err != nil {
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
+		// A response of an unsupported PAN-OS version is reported as such rather than as a bare parse error
+		if issue := responseCompatibilityIssue(response); issue != "" {
+			return nil, fmt.Errorf("failed to unmarshal response, %s: %w", issue, err)
+		}
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	if resp.Status != "success" {
-		return nil, fmt.Errorf("operation failed: %s", resp.Status)
+	if status != "success" {
+		return nil, fmt.Errorf("operation failed: %s", status)
 	}
 
-	return map[string]string{
-		"serial":           resp.Result.System.Serial,
-		"hostname":         resp.Result.System.Hostname,
-		"ip-address":       resp.Result.System.IPAddress,
-		"ipv6-address":     resp.Result.System.IPv6Address,
-		"model":            resp.Result.System.Model,
-		"family":           resp.Result.System.Family,
-		"sw-version":       resp.Result.System.SWVersion,
-		"app-version":      resp.Result.System.AppVersion,
-		"av-version":       resp.Result.System.AVVersion,
-		"wildfire-version": resp.Result.System.WildfireVersion,
-		"threat-version":   resp.Result.System.ThreatVersion,
+	// A Panorama listed in the inventory answers with its own system information, which holds no firewall facts
+	if TargetKind(result.System) == TargetPanorama {
+		return nil, fmt.Errorf("%s is a Panorama (model %s), not a firewall: remove it from inventory.yaml and query it without -nopanorama", hostname, result.System.Model)
+	}
+
+	deviceInfo := map[string]string{
+		"serial":           result.System.Serial,
+		"hostname":         result.System.Hostname,
+		"ip-address":       result.System.IPAddress,
+		"ipv6-address":     result.System.IPv6Address,
+		"model":            result.System.Model,
+		"family":           result.System.Family,
+		"sw-version":       result.System.SWVersion,
+		"app-version":      result.System.AppVersion,
+		"av-version":       result.System.AVVersion,
+		"wildfire-version": result.System.WildfireVersion,
+		"threat-version":   result.System.ThreatVersion,
 		"result":           "",
-	}, nil
+	}
+	if result.System.OperationalMode != "" {
+		deviceInfo["operational-mode"] = result.System.OperationalMode
+	}
+	return deviceInfo, nil
 }
 
 // showDeviceCertificateStatus retrieves the output from the command `show device-certificate status` from
-// a PAN-OS NGFW using the provided PanosClient
+// a PAN-OS NGFW using the provided PanosClient, in the syntax of the device's PAN-OS version
 // The method returns a map of the device certificate information, including status and expiration information
-func (dm *DeviceManager) showDeviceCertificateStatus(client PanosClient, hostname string) (map[string]string, error) {
-	cmd := "<show><device-certificate><status/></device-certificate></show>"
-	response, err := client.Op(cmd, "", nil, nil)
+func (dm *DeviceManager) showDeviceCertificateStatus(ctx context.Context, client PanosClient, device map[string]string) (map[string]string, error) {
+	hostname := device["hostname"]
+	cmd := config.CommandsForDevice(device).DeviceCertificateStatus
+	response, err := dm.op(ctx, client, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform op command: %w %s", err, hostname)
 	}
 
-	var resp struct {
-		XMLName xml.Name `xml:"response"`
-		Status  string   `xml:"status,attr"`
-		Result  struct {
-			DeviceCertificate config.DeviceCertificateStatus `xml:"device-certificate"`
-		} `xml:"result"`
+	var result struct {
+		DeviceCertificate config.DeviceCertificateStatus `xml:"device-certificate"`
 	}
 
-	if err := xml.Unmarshal(response, &resp); err != nil {
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	if resp.Status != "success" {
-		return nil, fmt.Errorf("operation failed: %s", resp.Status)
+	if status != "success" {
+		return nil, fmt.Errorf("operation failed: %s", status)
+	}
+
+	state, guidance := classifyCertificateStatus(result.DeviceCertificate)
+
+	certStatus := map[string]string{
+		"issuer":            result.DeviceCertificate.Issuer,
+		"msg":               result.DeviceCertificate.Msg,
+		"not_valid_after":   result.DeviceCertificate.NotValidAfter,
+		"not_valid_before":  result.DeviceCertificate.NotValidBefore,
+		"seconds-to-expire": result.DeviceCertificate.SecondsToExpire,
+		"status":            result.DeviceCertificate.Status,
+		"timestamp":         result.DeviceCertificate.Timestamp,
+		"validity":          result.DeviceCertificate.Validity,
+		"state":             state,
+		"guidance":          guidance,
+	}
+
+	// A certificate from an issuer outside the allowlist is flagged, whatever its state
+	if len(dm.config.AllowedIssuers) > 0 && state != CertStateNeedsEnrollment {
+		certStatus["issuer_allowed"] = "yes"
+		if !issuerAllowed(result.DeviceCertificate.Issuer, dm.config.AllowedIssuers) {
+			certStatus["issuer_allowed"] = "no"
+			dm.logger.Warn(fmt.Sprintf("Device certificate of %s was issued by %q, which is not an allowed issuer", hostname, result.DeviceCertificate.Issuer))
+			if certStatus["guidance"] == "" {
+				certStatus["guidance"] = "The device certificate was not issued by an allowed issuer, fetch a new device certificate from the Customer Support Portal"
+			}
+		}
+	}
+
+	return certStatus, nil
+}
+
+// issuerAllowed reports whether a certificate issuer matches an allowed issuer, ignoring case,
+// either as a whole or by its common name. An unknown issuer is never allowed.
+func issuerAllowed(issuer string, allowed []string) bool {
+	issuer = strings.TrimSpace(issuer)
+	if issuer == "" {
+		return false
+	}
+	commonName := ""
+	for _, part := range strings.FieldsFunc(issuer, func(r rune) bool { return r == ',' || r == '/' }) {
+		if key, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok && strings.EqualFold(key, "CN") {
+			commonName = strings.TrimSpace(value)
+		}
+	}
+	for _, entry := range allowed {
+		if strings.EqualFold(entry, issuer) || (commonName != "" && strings.EqualFold(entry, commonName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateMissing reports whether the status, the validity or the message of the `show
+// device-certificate status` output is, as a whole, one PAN-OS answers when no device certificate is
+// present. Fragments are not matched, as e.g. an issuer "not found" says nothing about the certificate.
+func certificateMissing(cert config.DeviceCertificateStatus) bool {
+	normalize := func(value string) string {
+		return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), ".")
+	}
+	for _, value := range []string{normalize(cert.Status), normalize(cert.Validity)} {
+		if slices.Contains(noCertificateStatuses, value) {
+			return true
+		}
+	}
+	return slices.Contains(noCertificateMessages, normalize(cert.Msg))
+}
+
+// classifyCertificateStatus derives the certificate state from the `show device-certificate status` output.
+// Devices without a device certificate are reported as needing enrollment, which is distinct from an
+// expired certificate that only needs to be renewed.
+func classifyCertificateStatus(cert config.DeviceCertificateStatus) (string, string) {
+	combined := strings.ToLower(strings.Join([]string{cert.Status, cert.Validity, cert.Msg}, " "))
+
+	if certificateMissing(cert) {
+		return CertStateNeedsEnrollment, "Fetch a device certificate with a one-time password from the Customer Support Portal (Device > Setup > Management > Device Certificate)"
+	}
+	if cert.Status == "" && cert.Validity == "" && cert.NotValidAfter == "" {
+		return CertStateNeedsEnrollment, "No device certificate information was returned, verify a device certificate has been fetched"
+	}
+
+	if strings.Contains(combined, "expired") {
+		return CertStateExpired, "Renew the device certificate from the Customer Support Portal"
+	}
+	if seconds, err := strconv.ParseInt(cert.SecondsToExpire, 10, 64); err == nil && seconds <= 0 {
+		return CertStateExpired, "Renew the device certificate from the Customer Support Portal"
+	}
+
+	if strings.EqualFold(cert.Validity, "valid") || strings.EqualFold(cert.Status, "valid") {
+		return CertStateValid, ""
 	}
 
-	return map[string]string{
-		"msg":               resp.Result.DeviceCertificate.Msg,
-		"not_valid_after":   resp.Result.DeviceCertificate.NotValidAfter,
-		"not_valid_before":  resp.Result.DeviceCertificate.NotValidBefore,
-		"seconds-to-expire": resp.Result.DeviceCertificate.SecondsToExpire,
-		"status":            resp.Result.DeviceCertificate.Status,
-		"timestamp":         resp.Result.DeviceCertificate.Timestamp,
-		"validity":          resp.Result.DeviceCertificate.Validity,
-	}, nil
+	return CertStateUnknown, ""
+}
+
+// inventoryFormat encodes and decodes an inventory file format
+type inventoryFormat struct {
+	name      string
+	unmarshal func([]byte, interface{}) error
+	marshal   func(interface{}) ([]byte, error)
+}
+
+var (
+	inventoryJSON = inventoryFormat{name: "JSON", unmarshal: json.Unmarshal, marshal: json.Marshal}
+	inventoryYAML = inventoryFormat{name: "YAML", unmarshal: yaml.Unmarshal, marshal: yaml.Marshal}
+)
+
+// inventoryFormatOf returns the format of an inventory file from its extension: .json for JSON,
+// .yaml or .yml for YAML.
+func inventoryFormatOf(filename string) (inventoryFormat, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return inventoryJSON, nil
+	case ".yaml", ".yml":
+		return inventoryYAML, nil
+	default:
+		return inventoryFormat{}, fmt.Errorf("unsupported inventory file extension of %s: use .json, .yaml or .yml", filename)
+	}
 }
 
-func readInventoryFile(filename string) (*config.Inventory, error) {
+// readInventoryFile reads the inventory file as JSON or YAML depending on its extension, renaming
+// custom column names to the canonical inventory fields according to fieldMapping before
+// unmarshaling.
+func readInventoryFile(filename string, fieldMapping map[string]string) (*config.Inventory, error) {
+	format, err := inventoryFormatOf(filename)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	if len(fieldMapping) > 0 {
+		data, err = applyInventoryFieldMapping(data, fieldMapping, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkInventoryKeys(data, format); err != nil {
+		return nil, err
+	}
+
 	var inventory config.Inventory
-	err = yaml.Unmarshal(data, &inventory)
+	err = format.unmarshal(data, &inventory)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", format.name, err)
 	}
 
 	return &inventory, nil
 }
+
+// misspelledInventoryKeys maps inventory keys that are easily confused with a canonical inventory
+// field, such as the "ip-address" key of the device maps, to that field
+var misspelledInventoryKeys = map[string]string{
+	"ip-address": "ip_address",
+}
+
+// checkInventoryKeys returns an error naming the entry and the expected key when an inventory entry
+// uses a key of misspelledInventoryKeys, which would otherwise be ignored and leave the field empty.
+func checkInventoryKeys(data []byte, format inventoryFormat) error {
+	var raw struct {
+		Inventory []map[string]interface{} `yaml:"inventory" json:"inventory"`
+	}
+	if err := format.unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", format.name, err)
+	}
+
+	for i, entry := range raw.Inventory {
+		for key := range entry {
+			if canonical, ok := misspelledInventoryKeys[key]; ok {
+				return fmt.Errorf("inventory entry %d (%v) uses the key %q, which is not an inventory field: rename it to %q", i+1, entry["hostname"], key, canonical)
+			}
+		}
+	}
+	return nil
+}
+
+// applyInventoryFieldMapping renames the keys of every inventory entry according to fieldMapping
+// and returns the inventory re-encoded in the same format. Keys that are not in the mapping are
+// kept unchanged.
+func applyInventoryFieldMapping(data []byte, fieldMapping map[string]string, format inventoryFormat) ([]byte, error) {
+	var raw struct {
+		Inventory []map[string]interface{} `yaml:"inventory" json:"inventory"`
+	}
+	if err := format.unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", format.name, err)
+	}
+
+	for i, entry := range raw.Inventory {
+		mapped := make(map[string]interface{}, len(entry))
+		for key, value := range entry {
+			if canonical, ok := fieldMapping[key]; ok {
+				key = canonical
+			}
+			mapped[key] = value
+		}
+		raw.Inventory[i] = mapped
+	}
+
+	return format.marshal(raw)
+}
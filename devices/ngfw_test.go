@@ -1,12 +1,20 @@
 package devices
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockNgfwClient is a mock implementation of the PanosClient interface
@@ -38,20 +46,21 @@ type TestDeviceManager struct {
 }
 
 // getDevicesFromInventory overrides the original method for testing
-func (tdm *TestDeviceManager) getDevicesFromInventory() ([]map[string]string, error) {
+func (tdm *TestDeviceManager) getDevicesFromInventory(ctx context.Context) ([]map[string]string, error) {
 	var deviceList []map[string]string
 	for _, device := range testInventory.Inventory {
 		ngfwClient := tdm.panosClientFactory(
 			device.IPAddress,
 			tdm.config.Auth.Credentials.Firewall.Username,
 			tdm.config.Auth.Credentials.Firewall.Password,
+			tdm.config.Auth.Credentials.Firewall.ApiKey,
 		)
 
 		if err := ngfwClient.Initialize(); err != nil {
 			return nil, err
 		}
 
-		deviceInfo, err := tdm.getNgfwDeviceInfo(ngfwClient, device.Hostname)
+		deviceInfo, err := tdm.getNgfwDeviceInfo(ctx, ngfwClient, device.Hostname)
 		if err != nil {
 			return nil, err
 		}
@@ -71,15 +80,18 @@ func TestGetDevicesFromInventory(t *testing.T) {
 				Panorama struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"panorama"`
 				Firewall struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"firewall"`
 			}{
 				Firewall: struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				}{
 					Username: "test-user",
 					Password: "test-pass",
@@ -91,7 +103,7 @@ func TestGetDevicesFromInventory(t *testing.T) {
 	dm := &TestDeviceManager{DeviceManager: *NewDeviceManager(conf, l)}
 
 	mockClient := new(MockNgfwClient)
-	dm.panosClientFactory = func(hostname, username, password string) PanosClient {
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
 		return mockClient
 	}
 
@@ -113,7 +125,7 @@ func TestGetDevicesFromInventory(t *testing.T) {
 	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(mockResponse), nil)
 
 	// Test
-	devices, err := dm.getDevicesFromInventory()
+	devices, err := dm.getDevicesFromInventory(context.Background())
 
 	// Assert
 	assert.NoError(t, err)
@@ -127,3 +139,388 @@ func TestGetDevicesFromInventory(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestShowDeviceCertificateStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      string
+		expectedState string
+	}{
+		{
+			name: "Valid certificate",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<msg>Success</msg>
+						<not_valid_after>Aug 28 13:01:06 2025 GMT</not_valid_after>
+						<not_valid_before>Aug 28 13:01:06 2024 GMT</not_valid_before>
+						<seconds-to-expire>28512000</seconds-to-expire>
+						<status>Valid</status>
+						<validity>Valid</validity>
+					</device-certificate>
+				</result>
+			</response>`,
+			expectedState: CertStateValid,
+		},
+		{
+			name: "No certificate",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<status>not fetched</status>
+						<msg>Device certificate not found</msg>
+					</device-certificate>
+				</result>
+			</response>`,
+			expectedState: CertStateNeedsEnrollment,
+		},
+		{
+			name: "Valid certificate with an unrelated message",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<msg>OCSP responder not found, none configured</msg>
+						<seconds-to-expire>28512000</seconds-to-expire>
+						<status>Valid</status>
+						<validity>Valid</validity>
+					</device-certificate>
+				</result>
+			</response>`,
+			expectedState: CertStateValid,
+		},
+		{
+			name: "No certificate message only",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<msg>No device certificate.</msg>
+					</device-certificate>
+				</result>
+			</response>`,
+			expectedState: CertStateNeedsEnrollment,
+		},
+		{
+			name: "Expired certificate",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<not_valid_after>Aug 28 13:01:06 2023 GMT</not_valid_after>
+						<seconds-to-expire>0</seconds-to-expire>
+						<status>Valid</status>
+						<validity>Expired</validity>
+					</device-certificate>
+				</result>
+			</response>`,
+			expectedState: CertStateExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", "<show><device-certificate><status/></device-certificate></show>", "", nil, nil).Return([]byte(tt.response), nil)
+
+			certStatus, err := dm.showDeviceCertificateStatus(context.Background(), mockClient, map[string]string{"hostname": "test-fw"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedState, certStatus["state"])
+			if tt.expectedState == CertStateValid {
+				assert.Empty(t, certStatus["guidance"])
+			} else {
+				assert.NotEmpty(t, certStatus["guidance"])
+			}
+		})
+	}
+}
+
+func TestShowDeviceCertificateStatusAllowedIssuers(t *testing.T) {
+	response := func(issuer string) []byte {
+		return []byte(`
+		<response status="success">
+			<result>
+				<device-certificate>
+					<issuer>` + issuer + `</issuer>
+					<seconds-to-expire>28512000</seconds-to-expire>
+					<status>Valid</status>
+					<validity>Valid</validity>
+				</device-certificate>
+			</result>
+		</response>`)
+	}
+	tests := []struct {
+		name     string
+		issuer   string
+		expected string
+	}{
+		{"Allowed issuer", "CN=Palo Alto Networks Device Issuing CA, O=Palo Alto Networks", "yes"},
+		{"Allowed common name", "C=US, O=Palo Alto Networks, CN=PAN-OS Device CA", "yes"},
+		{"Disallowed issuer", "CN=Corporate Intermediate CA, O=Example", "no"},
+		{"Unknown issuer", "", "no"},
+	}
+
+	allowed := config.ParseAllowedIssuers("CN=Palo Alto Networks Device Issuing CA, O=Palo Alto Networks; pan-os device ca")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDeviceManager(&config.Config{AllowedIssuers: allowed}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", "<show><device-certificate><status/></device-certificate></show>", "", nil, nil).Return(response(tt.issuer), nil)
+
+			certStatus, err := dm.showDeviceCertificateStatus(context.Background(), mockClient, map[string]string{"hostname": "test-fw"})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.issuer, certStatus["issuer"])
+			assert.Equal(t, tt.expected, certStatus["issuer_allowed"])
+			assert.Equal(t, CertStateValid, certStatus["state"], "the issuer does not change the certificate state")
+			assert.Equal(t, tt.expected == "no", certStatus["guidance"] != "")
+		})
+	}
+
+	t.Run("No allowlist", func(t *testing.T) {
+		dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+		mockClient := new(MockNgfwClient)
+		mockClient.On("Op", "<show><device-certificate><status/></device-certificate></show>", "", nil, nil).Return(response("CN=Corporate Intermediate CA"), nil)
+
+		certStatus, err := dm.showDeviceCertificateStatus(context.Background(), mockClient, map[string]string{"hostname": "test-fw"})
+
+		require.NoError(t, err)
+		assert.NotContains(t, certStatus, "issuer_allowed")
+	})
+}
+
+func TestReadInventoryFileFieldMapping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.yaml")
+	content := `
+inventory:
+  - name: fw-custom-1
+    mgmt_ip: 10.1.1.1
+    tags: [lab]
+  - hostname: fw-standard-2
+    ip_address: 10.1.1.2
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	t.Run("Custom column names are mapped", func(t *testing.T) {
+		inventory, err := readInventoryFile(path, map[string]string{"name": "hostname", "mgmt_ip": "ip_address"})
+		require.NoError(t, err)
+		require.Len(t, inventory.Inventory, 2)
+		assert.Equal(t, "fw-custom-1", inventory.Inventory[0].Hostname)
+		assert.Equal(t, "10.1.1.1", inventory.Inventory[0].IPAddress)
+		assert.Equal(t, []string{"lab"}, inventory.Inventory[0].Tags)
+		assert.Equal(t, "fw-standard-2", inventory.Inventory[1].Hostname)
+		assert.Equal(t, "10.1.1.2", inventory.Inventory[1].IPAddress)
+	})
+
+	t.Run("Without mapping custom columns are ignored", func(t *testing.T) {
+		inventory, err := readInventoryFile(path, nil)
+		require.NoError(t, err)
+		require.Len(t, inventory.Inventory, 2)
+		assert.Empty(t, inventory.Inventory[0].Hostname)
+		assert.Empty(t, inventory.Inventory[0].IPAddress)
+	})
+}
+
+func TestReadInventoryFileWrongIPKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.yaml")
+	content := `
+inventory:
+  - hostname: fw-1
+    ip_address: 10.1.1.1
+  - hostname: fw-2
+    ip-address: 10.1.1.2
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	t.Run("Wrong key is rejected with a hint", func(t *testing.T) {
+		inventory, err := readInventoryFile(path, nil)
+		require.Error(t, err, "expected an error instead of a blank IP address")
+		assert.Nil(t, inventory)
+		assert.Contains(t, err.Error(), `inventory entry 2 (fw-2) uses the key "ip-address"`)
+		assert.Contains(t, err.Error(), `rename it to "ip_address"`)
+	})
+
+	t.Run("Wrong key is accepted when mapped", func(t *testing.T) {
+		inventory, err := readInventoryFile(path, map[string]string{"ip-address": "ip_address"})
+		require.NoError(t, err)
+		assert.Equal(t, "10.1.1.2", inventory.Inventory[1].IPAddress)
+	})
+}
+
+func TestReadInventoryFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"inventory.yaml": `
+inventory:
+  - name: fw-1
+    ip_address: 10.1.1.1
+    tags: [lab, edge]
+    timeout: 30
+    channel: private
+    skip_registration: true
+    facts:
+      site: ams
+  - hostname: fw-2
+    ip_address: 10.1.1.2
+    username: local-admin
+    password: local-pass
+`,
+		"inventory.yml": `
+inventory:
+  - {name: fw-1, ip_address: 10.1.1.1, tags: [lab, edge], timeout: 30, channel: private, skip_registration: true, facts: {site: ams}}
+  - {hostname: fw-2, ip_address: 10.1.1.2, username: local-admin, password: local-pass}
+`,
+		"inventory.json": `{
+  "inventory": [
+    {"name": "fw-1", "ip_address": "10.1.1.1", "tags": ["lab", "edge"], "timeout": 30, "channel": "private", "skip_registration": true, "facts": {"site": "ams"}},
+    {"hostname": "fw-2", "ip_address": "10.1.1.2", "username": "local-admin", "password": "local-pass"}
+  ]
+}`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	fieldMapping := map[string]string{"name": "hostname"}
+
+	expected, err := readInventoryFile(filepath.Join(dir, "inventory.yaml"), fieldMapping)
+	require.NoError(t, err)
+	require.Len(t, expected.Inventory, 2)
+	assert.Equal(t, "fw-1", expected.Inventory[0].Hostname)
+	assert.Equal(t, map[string]string{"site": "ams"}, expected.Inventory[0].Facts)
+
+	for _, name := range []string{"inventory.yml", "inventory.json"} {
+		t.Run(name, func(t *testing.T) {
+			inventory, err := readInventoryFile(filepath.Join(dir, name), fieldMapping)
+			require.NoError(t, err)
+			assert.Equal(t, expected, inventory)
+		})
+	}
+
+	t.Run("Wrong key is rejected in JSON", func(t *testing.T) {
+		path := filepath.Join(dir, "wrong-key.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"inventory": [{"hostname": "fw-1", "ip-address": "10.1.1.1"}]}`), 0600))
+		_, err := readInventoryFile(path, nil)
+		assert.ErrorContains(t, err, `rename it to "ip_address"`)
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		require.NoError(t, os.WriteFile(path, []byte(`inventory: []`), 0600))
+		_, err := readInventoryFile(path, nil)
+		assert.ErrorContains(t, err, "failed to unmarshal JSON")
+	})
+
+	t.Run("Unsupported extension", func(t *testing.T) {
+		_, err := readInventoryFile(filepath.Join(dir, "inventory.txt"), nil)
+		assert.ErrorContains(t, err, "unsupported inventory file extension")
+	})
+}
+
+// stubInventorySource returns a fixed list of inventory devices
+type stubInventorySource []config.InventoryDevice
+
+func (s stubInventorySource) InventoryDevices() ([]config.InventoryDevice, error) {
+	return s, nil
+}
+
+func TestGetDevicesFromInventoryConcurrencyBound(t *testing.T) {
+	var inventory stubInventorySource
+	for i := 0; i < 10; i++ {
+		inventory = append(inventory, config.InventoryDevice{Hostname: fmt.Sprintf("fw-%d", i), IPAddress: fmt.Sprintf("192.0.2.%d", i+1)})
+	}
+	dm := NewDeviceManager(&config.Config{Concurrency: 3}, logger.New(0, false))
+	dm.SetInventorySource(inventory)
+
+	var active, maxSeen int32
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return &concurrencyTrackingClient{active: &active, maxSeen: &maxSeen}
+	}
+
+	_, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxSeen), int32(3), "no more than -concurrency devices may be queried at once")
+}
+
+// newSystemInfoClients returns a client factory whose mock clients answer the system information of
+// the inventory devices of onCollectedInventory, recording each op command in events
+func newSystemInfoClients(events *[]string, mu *sync.Mutex, clients *[]*MockNgfwClient) PanosClientFactory {
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, event)
+	}
+	return func(hostname, username, password, apiKey string) PanosClient {
+		name := map[string]string{"192.0.2.1": "fw-1", "192.0.2.2": "fw-2"}[hostname]
+		client := new(MockNgfwClient)
+		client.On("Initialize").Return(nil)
+		client.On("Op", "<show><system><info/></system></show>", "", nil, nil).
+			Run(func(mock.Arguments) { record("api " + name) }).
+			Return([]byte(fmt.Sprintf(`<response status="success"><result><system><hostname>%s</hostname><serial>%s</serial><sw-version>10.2.4</sw-version></system></result></response>`, name, name)), nil)
+		mu.Lock()
+		*clients = append(*clients, client)
+		mu.Unlock()
+		return client
+	}
+}
+
+// onCollectedInventory holds the inventory devices of the SetOnCollected tests
+var onCollectedInventory = stubInventorySource{
+	{Hostname: "fw-1", IPAddress: "192.0.2.1"},
+	{Hostname: "fw-2", IPAddress: "192.0.2.2"},
+}
+
+func TestGetDevicesFromInventoryOnCollected(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{Concurrency: 2}, logger.New(0, false))
+	dm.SetInventorySource(onCollectedInventory)
+
+	var mu sync.Mutex
+	var events []string
+	var clients []*MockNgfwClient
+	dm.panosClientFactory = newSystemInfoClients(&events, &mu, &clients)
+	dm.SetOnCollected(func(ctx context.Context, device map[string]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, "collected "+device["hostname"])
+	})
+
+	devices, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	assert.Len(t, devices, 2)
+	assert.Len(t, events, 4)
+	for _, name := range []string{"fw-1", "fw-2"} {
+		api, collected := slices.Index(events, "api "+name), slices.Index(events, "collected "+name)
+		require.NotEqual(t, -1, api, name)
+		require.NotEqual(t, -1, collected, name)
+		assert.Less(t, api, collected, "%s is handed over once its facts were collected", name)
+	}
+	for _, client := range clients {
+		client.AssertExpectations(t)
+	}
+}
+
+func TestGetDevicesFromInventoryOnCollectedOnlySerials(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{Concurrency: 2, OnlySerials: "fw-2"}, logger.New(0, false))
+	dm.SetInventorySource(onCollectedInventory)
+
+	var mu sync.Mutex
+	var events, collected []string
+	var clients []*MockNgfwClient
+	dm.panosClientFactory = newSystemInfoClients(&events, &mu, &clients)
+	dm.SetOnCollected(func(ctx context.Context, device map[string]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		collected = append(collected, device["hostname"])
+	})
+
+	_, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fw-2"}, collected, "devices dropped by -only-serials must not be handed over")
+}
@@ -0,0 +1,77 @@
+// Package devices devices/op.go
+package devices
+
+import (
+	"context"
+	"fmt"
+)
+
+// opResult carries the outcome of an op command from the goroutine running it
+type opResult struct {
+	response []byte
+	err      error
+}
+
+// opWithContext runs an op command on the client and returns early with the context's error
+// if the context is done before the command completes. pango's Op is not context-aware, so
+// an abandoned command finishes in the background and its result is discarded. A panic of the
+// command is returned as its error.
+func opWithContext(ctx context.Context, client PanosClient, cmd interface{}) ([]byte, error) {
+	done := make(chan opResult, 1)
+	go func() {
+		// A panic inside the client is returned as the command's error, as this goroutine has no caller to recover it
+		defer func() {
+			if r := recover(); r != nil {
+				done <- opResult{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		response, err := client.Op(cmd, "", nil, nil)
+		done <- opResult{response: response, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.response, result.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("op command did not complete: %w", ctx.Err())
+	}
+}
+
+// initializeWithContext initializes the client and returns early with the context's error if the
+// context is done before the initialization completes, like opWithContext.
+func initializeWithContext(ctx context.Context, client PanosClient) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- client.Initialize()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("client initialization did not complete: %w", ctx.Err())
+	}
+}
+
+// op runs an op command on the client until ctx is done, bounded by the configured API timeout if
+// one is set. Failed commands are retried as configured, each attempt with its own timeout.
+func (dm *DeviceManager) op(ctx context.Context, client PanosClient, cmd interface{}) ([]byte, error) {
+	var response []byte
+	err := dm.withRetries(ctx, fmt.Sprintf("Op command %v", cmd), func() error {
+		ctx := ctx
+		if dm.config.APITimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, dm.config.APITimeout)
+			defer cancel()
+		}
+		var err error
+		response, err = opWithContext(ctx, client, cmd)
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,58 @@
+package devices
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpAPITimeout(t *testing.T) {
+	mockClient := new(MockPanosClient)
+	mockClient.On("Op", mock.Anything, "", nil, nil).
+		Return([]byte(`<response status="success"><result></result></response>`), nil).
+		After(2 * time.Second)
+
+	dm := &DeviceManager{
+		config: &config.Config{APITimeout: 20 * time.Millisecond},
+		logger: logger.New(0, false),
+	}
+
+	start := time.Now()
+	_, err := dm.showDeviceCertificateStatus(context.Background(), mockClient, map[string]string{"hostname": "slow-fw"})
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestOpWithinAPITimeout(t *testing.T) {
+	response := []byte(`<response status="success"><result><system><hostname>test-fw</hostname></system></result></response>`)
+	mockClient := new(MockPanosClient)
+	mockClient.On("Op", mock.Anything, "", nil, nil).Return(response, nil)
+
+	dm := &DeviceManager{
+		config: &config.Config{APITimeout: time.Second},
+		logger: logger.New(0, false),
+	}
+
+	result, err := dm.op(context.Background(), mockClient, "<show><system><info/></system></show>")
+
+	require.NoError(t, err)
+	assert.Equal(t, response, result)
+	mockClient.AssertExpectations(t)
+}
+
+func TestOpRecoversPanic(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+
+	_, err := dm.op(context.Background(), &panickingClient{}, "<show><system><info/></system></show>")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "panic: runtime error: invalid memory address or nil pointer dereference")
+}
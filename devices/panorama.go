@@ -2,66 +2,199 @@
 package devices
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/PaloAltoNetworks/pango"
+	pangoerrors "github.com/PaloAltoNetworks/pango/errors"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // defaultPanoramaClientFactory creates a real Panorama client
-func defaultPanoramaClientFactory(hostname, username, password string) PanosClient {
+func defaultPanoramaClientFactory(hostname, username, password, apiKey string) PanosClient {
 	return &pango.Panorama{
-		Client: pango.Client{
-			Hostname: hostname,
-			Username: username,
-			Password: password,
-			Logging:  pango.LogAction | pango.LogOp,
-		},
+		Client: newPangoClient(hostname, username, password, apiKey),
 	}
 }
 
-// getDevicesFromPanorama retrieves the devices from Panorama and collects their information.
+// defaultPanoramaConcurrency bounds the simultaneous Panorama connections when no bound is configured
+const defaultPanoramaConcurrency = 4
+
+// getDevicesFromPanorama retrieves the devices from every configured Panorama and collects their information.
+// The Panoramas are queried concurrently, with at most PanoramaConcurrency connections at a time.
 // It returns a list of devices as an array of maps, where each map contains the device information.
-// If any errors occur during the retrieval process, an error is returned.
-func (dm *DeviceManager) getDevicesFromPanorama() ([]map[string]string, error) {
+// A failing Panorama makes the whole query fail, unless SkipFailedPanoramas is set: the failures,
+// such as rejected credentials, are then summarized and the devices of the other Panoramas are
+// still returned. An error is always returned if every Panorama fails.
+func (dm *DeviceManager) getDevicesFromPanorama(ctx context.Context) ([]map[string]string, error) {
 	if len(dm.config.Panorama) == 0 {
 		return nil, fmt.Errorf("no Panorama configuration found in the YAML file")
 	}
 
-	// Use the first Panorama configuration
-	pano := dm.config.Panorama[0]
+	limit := dm.config.PanoramaConcurrency
+	if limit <= 0 {
+		limit = defaultPanoramaConcurrency
+	}
+	semaphore := limiter.New(limit)
+
+	// Results are kept per Panorama so the device order follows the configuration
+	results := make([][]map[string]string, len(dm.config.Panorama))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errorList := make([]string, 0)
+	var errs []error
+
+	for i, pano := range dm.config.Panorama {
+		wg.Add(1)
+		go func(i int, hostname string) {
+			defer wg.Done()
+			// Once the run is cancelled, the Panoramas not queried yet fail with the cancellation
+			var devices []map[string]string
+			err := semaphore.AcquireContext(ctx)
+			if err == nil {
+				devices, err = dm.queryPanoramaHost(ctx, hostname)
+				semaphore.Release()
+			}
+			if err != nil {
+				reason := "failed"
+				if isAuthFailure(err) {
+					reason = "authentication failed"
+				}
+				errorMsg := fmt.Sprintf("  %s: %s: %v", hostname, reason, err)
+				dm.logger.Debug(errorMsg)
+				mu.Lock()
+				errorList = append(errorList, errorMsg)
+				errs = append(errs, fmt.Errorf("panorama %s: %w", hostname, err))
+				mu.Unlock()
+				return
+			}
+			results[i] = devices
+		}(i, pano.Hostname)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		if !dm.config.SkipFailedPanoramas || len(errs) == len(dm.config.Panorama) {
+			return nil, errors.Join(errs...)
+		}
+		sort.Strings(errorList)
+		dm.logger.Console("Panorama summary: %d of %d Panorama(s) failed, continuing with the devices of the others:\n%s\n\n",
+			len(errs), len(dm.config.Panorama), strings.Join(errorList, "\n"))
+	}
+
+	var deviceList []map[string]string
+	for _, devices := range results {
+		deviceList = append(deviceList, devices...)
+	}
+
+	dm.logger.Debug("Total devices in list:", len(deviceList))
+
+	// Apply hostname filters if they exist in the config
+	if dm.config.HostnameFilter != "" || dm.config.HostnameExclude != "" {
+		deviceList = filterDevices(deviceList, splitPatterns(dm.config.HostnameFilter), splitPatterns(dm.config.HostnameExclude), dm.logger)
+	}
+
+	return deviceList, nil
+}
+
+// authFailureCodes are the codes of the PAN-OS API errors returned for rejected credentials: 403 for
+// an invalid username, password or API key and 16 for an account whose role does not allow the request
+var authFailureCodes = []int{403, 16}
+
+// isAuthFailure reports whether a query failed because the credentials were rejected. Only the
+// code of the PAN-OS API error is checked, as a hostname or URL in the message may contain anything.
+func isAuthFailure(err error) bool {
+	var panosErr pangoerrors.Panos
+	return errors.As(err, &panosErr) && slices.Contains(authFailureCodes, panosErr.Code)
+}
 
+// queryPanoramaHost retrieves the connected devices from a single Panorama, turning a panic while
+// processing its response into an error so the other Panoramas are still queried.
+func (dm *DeviceManager) queryPanoramaHost(ctx context.Context, hostname string) (devices []map[string]string, err error) {
+	defer dm.recoverWorker(func(panicErr error) {
+		devices, err = nil, panicErr
+	})
+	return dm.getDevicesFromPanoramaHost(ctx, hostname)
+}
+
+// getDevicesFromPanoramaHost retrieves the connected devices from a single Panorama.
+func (dm *DeviceManager) getDevicesFromPanoramaHost(ctx context.Context, hostname string) ([]map[string]string, error) {
 	panoramaClient := dm.panosClientFactory(
-		pano.Hostname,
+		hostname,
 		dm.config.Auth.Credentials.Panorama.Username,
 		dm.config.Auth.Credentials.Panorama.Password,
+		dm.config.Auth.Credentials.Panorama.ApiKey,
 	)
+	applyTimeout(panoramaClient, dm.config.DeviceTimeout)
+	applyHeaders(panoramaClient, dm.config.HTTPHeaders)
 
-	dm.logger.Info("Initializing Panorama client for", pano.Hostname)
-	if err := panoramaClient.Initialize(); err != nil {
-		return nil, fmt.Errorf("failed to initialize Panorama client: %v", err)
+	start := time.Now()
+	dm.logger.Info("Initializing Panorama client for", hostname)
+	if err := dm.initialize(ctx, panoramaClient, hostname); err != nil {
+		return nil, fmt.Errorf("failed to initialize Panorama client: %w", err)
 	}
-	dm.logger.Info("Panorama client initialized for", pano.Hostname)
+	dm.logger.Info("Panorama client initialized for", hostname)
 
-	cmd := "<show><devices><connected/></devices></show>"
-	dm.logger.Debug("Sending command to get connected devices")
-	response, err := panoramaClient.Op(cmd, "", nil, nil)
+	cmd := panoramaDevicesCommand(dm.config.PanoramaScope)
+	dm.logger.Debug("Sending command to get devices:", cmd)
+	response, err := dm.op(ctx, panoramaClient, cmd)
 	if err != nil {
+		if mismatch := dm.checkPanoramaTarget(ctx, panoramaClient, hostname); mismatch != nil {
+			return nil, mismatch
+		}
 		return nil, fmt.Errorf("failed to perform op command: %w", err)
 	}
-	dm.logger.Debug("Received response for connected devices")
+	dm.logger.Debug("Received response for devices:", len(response), "bytes")
 
-	var resp config.DevicesResponse
-	if err := xml.Unmarshal(response, &resp); err != nil {
+	resp, truncated, err := parseDevicesResponse(response)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	// A truncated response is retried once before falling back to the partial list
+	if truncated {
+		dm.logger.Warn(fmt.Sprintf("Response from Panorama %s appears truncated after %d device entries, retrying", hostname, len(resp.Result.Devices.Entries)))
+		retryResponse, err := dm.op(ctx, panoramaClient, cmd)
+		if err == nil {
+			if retryResp, retryTruncated, err := parseDevicesResponse(retryResponse); err == nil && !retryTruncated {
+				resp, truncated = retryResp, false
+			}
+		}
+	}
+	if truncated {
+		dm.logger.Warn(fmt.Sprintf("Response from Panorama %s is still truncated: processing only the %d device entries received, remaining devices are missing from this run", hostname, len(resp.Result.Devices.Entries)))
+	}
+
 	if resp.Status != "success" {
+		if mismatch := dm.checkPanoramaTarget(ctx, panoramaClient, hostname); mismatch != nil {
+			return nil, mismatch
+		}
 		return nil, fmt.Errorf("operation failed: %s", resp.Status)
 	}
 
+	// A response cut off at an entry boundary is well-formed, so the entries are also checked against
+	// the device count Panorama reports, if any
+	if reported, ok := reportedDeviceCount(resp); ok && reported != len(resp.Result.Devices.Entries) {
+		dm.logger.Warn(fmt.Sprintf("Panorama %s reports %d devices but its response holds %d device entries: the response may be incomplete and devices may be missing from this run", hostname, reported, len(resp.Result.Devices.Entries)))
+	}
+
+	// All devices are collected by the same Panorama query, so they share its duration
+	collectDuration := strconv.FormatInt(time.Since(start).Milliseconds(), 10)
+
 	var deviceList []map[string]string
 	dm.logger.Debug("Number of devices found:", len(resp.Result.Devices.Entries))
 	for _, entry := range resp.Result.Devices.Entries {
@@ -79,41 +212,261 @@ func (dm *DeviceManager) getDevicesFromPanorama() ([]map[string]string, error) {
 			"threat-version":   entry.ThreatVersion,
 			"result":           entry.Result,
 		}
+		device["collect_duration_ms"] = collectDuration
+		device["source"] = hostname
+		if entry.OperationalMode != "" {
+			device["operational-mode"] = entry.OperationalMode
+		}
+		device["connected"] = connectionState(entry.Connected, dm.config.PanoramaScope)
+		if entry.HA.State != "" {
+			device["ha-state"] = entry.HA.State
+			device["ha-peer-serial"] = entry.HA.Peer.Serial
+		}
 		deviceList = append(deviceList, device)
 		dm.logger.Debug("Added device to list:", entry.Hostname)
 	}
 
-	dm.logger.Debug("Total devices in list:", len(deviceList))
-
-	// Apply hostname filter if it exists in the config
-	if dm.config.HostnameFilter != "" {
-		deviceList = filterDevices(deviceList, strings.Split(dm.config.HostnameFilter, ","), dm.logger)
+	// The tags assigned to the managed devices, such as wildfire-channel=<channel>, are only found in
+	// the Panorama configuration. Without them the devices still run, with the default channel.
+	if len(deviceList) > 0 {
+		tags, err := dm.getPanoramaDeviceTags(ctx, panoramaClient)
+		if err != nil {
+			dm.logger.Warn(fmt.Sprintf("Failed to read the device tags from Panorama %s, its devices are collected without tags: %v", hostname, err))
+		}
+		for _, device := range deviceList {
+			if deviceTags := tags[device["serial"]]; len(deviceTags) > 0 {
+				device["tags"] = strings.Join(deviceTags, ",")
+			}
+		}
 	}
 
 	return deviceList, nil
 }
 
+// panoramaDeviceTagsCommand reads the managed devices, with their tags, from the running
+// configuration of Panorama
+const panoramaDeviceTagsCommand = "<show><config><running><xpath>mgt-config/devices</xpath></running></config></show>"
+
+// panoramaDeviceTagsResponse is the managed devices section of the Panorama running configuration
+type panoramaDeviceTagsResponse struct {
+	Status  string `xml:"status,attr"`
+	Entries []struct {
+		Serial string   `xml:"name,attr"`
+		Tags   []string `xml:"tags>member"`
+	} `xml:"result>devices>entry"`
+}
+
+// getPanoramaDeviceTags returns the tags Panorama assigns to its managed devices, by serial
+func (dm *DeviceManager) getPanoramaDeviceTags(ctx context.Context, client PanosClient) (map[string][]string, error) {
+	response, err := dm.op(ctx, client, panoramaDeviceTagsCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform op command: %w", err)
+	}
+
+	var resp panoramaDeviceTagsResponse
+	if err := xml.Unmarshal(response, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("operation failed: %s", resp.Status)
+	}
+
+	tags := make(map[string][]string, len(resp.Entries))
+	for _, entry := range resp.Entries {
+		if len(entry.Tags) > 0 {
+			tags[entry.Serial] = entry.Tags
+		}
+	}
+	return tags, nil
+}
+
+// panoramaDevicesCommand returns the op command listing the devices of a -panorama-scope
+func panoramaDevicesCommand(scope string) string {
+	if scope == config.PanoramaScopeAll {
+		return "<show><devices><all/></devices></show>"
+	}
+	return "<show><devices><connected/></devices></show>"
+}
+
+// connectionState returns the "connected" field of a device, "yes" or "no" as reported by Panorama.
+// Every device of the connected scope is connected, even if Panorama does not say so.
+func connectionState(connected, scope string) string {
+	connected = strings.ToLower(strings.TrimSpace(connected))
+	if connected == "" && scope != config.PanoramaScopeAll {
+		return "yes"
+	}
+	return connected
+}
+
+// parseDevicesResponse unmarshals the connected devices response from Panorama.
+// If the XML ends before the document is complete, the device entries that were
+// fully received are salvaged and truncated is set to true so the caller can react.
+func parseDevicesResponse(response []byte) (*config.DevicesResponse, bool, error) {
+	var resp config.DevicesResponse
+	err := xml.Unmarshal(response, &resp)
+	if err == nil {
+		return &resp, false, nil
+	}
+	if !isTruncatedXML(err) {
+		return nil, false, err
+	}
+
+	partial := &config.DevicesResponse{}
+	decoder := xml.NewDecoder(bytes.NewReader(response))
+	var path []string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "response" && len(path) == 0 {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "status" {
+						partial.Status = attr.Value
+					}
+				}
+			}
+			if t.Name.Local == "result" && strings.Join(path, "/") == "response" {
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "total-count":
+						partial.Result.TotalCount = attr.Value
+					case "count":
+						partial.Result.Count = attr.Value
+					}
+				}
+			}
+			if t.Name.Local == "entry" && strings.Join(path, "/") == "response/result/devices" {
+				var entry config.DeviceEntry
+				if err := decoder.DecodeElement(&entry, &t); err != nil {
+					return partial, true, nil
+				}
+				partial.Result.Devices.Entries = append(partial.Result.Devices.Entries, entry)
+				continue
+			}
+			path = append(path, t.Name.Local)
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+
+	return partial, true, nil
+}
+
+// reportedDeviceCount returns the number of devices Panorama reports in the total-count, or else the
+// count, attribute of the result. Not every PAN-OS release reports it.
+func reportedDeviceCount(resp *config.DevicesResponse) (int, bool) {
+	for _, value := range []string{resp.Result.TotalCount, resp.Result.Count} {
+		if count, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && count >= 0 {
+			return count, true
+		}
+	}
+	return 0, false
+}
+
+// isTruncatedXML reports whether an XML decoding error was caused by the input ending prematurely.
+func isTruncatedXML(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var syntaxErr *xml.SyntaxError
+	return errors.As(err, &syntaxErr) && strings.Contains(syntaxErr.Msg, "unexpected EOF")
+}
+
+// splitPatterns splits a comma-separated list of hostname patterns, returning nil for an empty list
+func splitPatterns(patterns string) []string {
+	if patterns == "" {
+		return nil
+	}
+	return strings.Split(patterns, ",")
+}
+
 // filterDevices filters a list of devices based on hostname filters.
-// This function takes a list of devices and filters, and returns a new list
-// containing only the devices whose hostnames start with any of the given filters.
+// This function takes a list of devices, include filters and exclude filters, and returns a new list
+// containing only the devices whose hostname matches any of the include filters, or all devices
+// when there are none, and none of the exclude filters, see hostnameMatcher.
+// A device matching an exclude filter is dropped even if it matched an include filter.
+// Invalid filters are logged and match no device.
 // It also logs debug and info messages about the filtering process.
-func filterDevices(devices []map[string]string, filters []string, l *logger.Logger) []map[string]string {
-	if len(filters) == 0 {
+func filterDevices(devices []map[string]string, filters, excludes []string, l *logger.Logger) []map[string]string {
+	if len(filters) == 0 && len(excludes) == 0 {
 		return devices
 	}
 
+	includeMatchers := hostnameMatchers(filters, "filter", l)
+	excludeMatchers := hostnameMatchers(excludes, "exclude", l)
+
 	var filteredDevices []map[string]string
 	for _, device := range devices {
 		hostname := device["hostname"]
-		for _, filter := range filters {
-			if strings.HasPrefix(hostname, strings.TrimSpace(filter)) {
-				filteredDevices = append(filteredDevices, device)
-				l.Debug("Device matched filter:", hostname)
-				break
+		if len(filters) > 0 {
+			if !anyMatches(includeMatchers, hostname) {
+				continue
 			}
+			l.Debug("Device matched filter:", hostname)
+		}
+		if anyMatches(excludeMatchers, hostname) {
+			l.Debug("Device matched exclude:", hostname)
+			continue
 		}
+		filteredDevices = append(filteredDevices, device)
 	}
 
 	l.Info("Filtered devices:", len(filteredDevices), "out of", len(devices))
 	return filteredDevices
 }
+
+// hostnameMatchers returns the matchers of hostname filter tokens, logging and skipping the invalid
+// ones. The kind of the filter, "filter" or "exclude", names the flag in the warning.
+func hostnameMatchers(filters []string, kind string, l *logger.Logger) []func(string) bool {
+	matchers := make([]func(string) bool, 0, len(filters))
+	for _, filter := range filters {
+		matcher, err := hostnameMatcher(strings.TrimSpace(filter))
+		if err != nil {
+			l.Warn(fmt.Sprintf("Ignoring hostname %s %q: %v", kind, filter, err))
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers
+}
+
+// anyMatches reports whether the hostname matches any of the matchers
+func anyMatches(matchers []func(string) bool, hostname string) bool {
+	for _, matches := range matchers {
+		if matches(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostnameMatcher returns the matcher of a hostname filter token. A "regex:" prefix makes the rest
+// of the token a regular expression, a token with glob metacharacters (*, ? or [) is a shell-style
+// glob matching the whole hostname, and any other token is a hostname prefix.
+func hostnameMatcher(token string) (func(string) bool, error) {
+	if expression, ok := strings.CutPrefix(token, "regex:"); ok {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	if strings.ContainsAny(token, "*?[") {
+		if _, err := path.Match(token, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob: %w", err)
+		}
+		return func(hostname string) bool {
+			matched, _ := path.Match(token, hostname)
+			return matched
+		}, nil
+	}
+	return func(hostname string) bool {
+		return strings.HasPrefix(hostname, token)
+	}, nil
+}
@@ -1,13 +1,25 @@
 package devices
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/PaloAltoNetworks/pango"
+	pangoerrors "github.com/PaloAltoNetworks/pango/errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockPanoramaClient is a mock implementation of the PanosClient interface
@@ -25,10 +37,71 @@ func (m *MockPanoramaClient) Op(cmd interface{}, vsys string, extras interface{}
 	return args.Get(0).([]byte), args.Error(1)
 }
 
+// noDeviceTags answers the device tags query of a mock Panorama client with no tags
+func noDeviceTags(client interface {
+	On(methodName string, arguments ...interface{}) *mock.Call
+}) {
+	client.On("Op", panoramaDeviceTagsCommand, "", nil, nil).Return([]byte(`<response status="success"><result><devices/></result></response>`), nil)
+}
+
 func TestDefaultPanoramaClientFactory(t *testing.T) {
-	client := defaultPanoramaClientFactory("test-host", "test-user", "test-pass")
+	client := defaultPanoramaClientFactory("test-host", "test-user", "test-pass", "")
 	assert.NotNil(t, client)
 	assert.IsType(t, &pango.Panorama{}, client)
+
+	t.Run("Password authentication", func(t *testing.T) {
+		pano := client.(*pango.Panorama)
+		assert.Equal(t, "test-host", pano.Hostname)
+		assert.Equal(t, "test-user", pano.Username)
+		assert.Equal(t, "test-pass", pano.Password)
+		assert.Empty(t, pano.ApiKey)
+	})
+
+	t.Run("API key authentication", func(t *testing.T) {
+		pano := defaultPanoramaClientFactory("test-host", "test-user", "test-pass", "test-key").(*pango.Panorama)
+		assert.Equal(t, "test-host", pano.Hostname)
+		assert.Equal(t, "test-key", pano.ApiKey)
+		assert.Empty(t, pano.Username, "the API key replaces the username")
+		assert.Empty(t, pano.Password, "the API key replaces the password")
+	})
+}
+
+func TestDefaultNgfwClientFactory(t *testing.T) {
+	t.Run("Password authentication", func(t *testing.T) {
+		fw := defaultNgfwClientFactory("fw-host", "fw-user", "fw-pass", "").(*pango.Firewall)
+		assert.Equal(t, "fw-host", fw.Hostname)
+		assert.Equal(t, "fw-user", fw.Username)
+		assert.Equal(t, "fw-pass", fw.Password)
+		assert.Empty(t, fw.ApiKey)
+	})
+
+	t.Run("API key authentication", func(t *testing.T) {
+		fw := defaultNgfwClientFactory("fw-host", "fw-user", "fw-pass", "fw-key").(*pango.Firewall)
+		assert.Equal(t, "fw-key", fw.ApiKey)
+		assert.Empty(t, fw.Username)
+		assert.Empty(t, fw.Password)
+	})
+}
+
+func TestClientFactoryReceivesAPIKey(t *testing.T) {
+	conf := &config.Config{
+		Panorama: []struct {
+			Hostname string `yaml:"hostname"`
+		}{{Hostname: "panorama"}},
+	}
+	conf.Auth.Credentials.Panorama.ApiKey = "pano-key"
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	var gotKey string
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		gotKey = apiKey
+		return &concurrencyTrackingClient{active: new(int32), maxSeen: new(int32)}
+	}
+
+	_, err := dm.getDevicesFromPanoramaHost(context.Background(), "panorama")
+
+	require.NoError(t, err)
+	assert.Equal(t, "pano-key", gotKey)
 }
 
 func TestGetDevicesFromPanorama(t *testing.T) {
@@ -44,15 +117,18 @@ func TestGetDevicesFromPanorama(t *testing.T) {
 				Panorama struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"panorama"`
 				Firewall struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"firewall"`
 			}{
 				Panorama: struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				}{
 					Username: "test-user",
 					Password: "test-pass",
@@ -64,7 +140,7 @@ func TestGetDevicesFromPanorama(t *testing.T) {
 	dm := NewDeviceManager(conf, l)
 
 	mockClient := new(MockPanoramaClient)
-	dm.panosClientFactory = func(hostname, username, password string) PanosClient {
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
 		return mockClient
 	}
 
@@ -87,10 +163,11 @@ func TestGetDevicesFromPanorama(t *testing.T) {
 			</devices>
 		</result>
 	</response>`
+	noDeviceTags(mockClient)
 	mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return([]byte(mockResponse), nil)
 
 	// Test
-	devices, err := dm.getDevicesFromPanorama()
+	devices, err := dm.getDevicesFromPanorama(context.Background())
 
 	// Assert
 	assert.NoError(t, err)
@@ -105,6 +182,51 @@ func TestGetDevicesFromPanorama(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
+func TestGetDevicesFromPanoramaTags(t *testing.T) {
+	devicesResponse := `<response status="success"><result><devices>
+		<entry><hostname>fw-private</hostname><serial>111</serial></entry>
+		<entry><hostname>fw-untagged</hostname><serial>222</serial></entry>
+	</devices></result></response>`
+
+	for _, tt := range []struct {
+		name         string
+		tagsResponse string
+		expected     string
+	}{
+		{
+			name: "Tagged device",
+			tagsResponse: `<response status="success"><result><devices>
+				<entry name="111"><tags><member>branch</member><member>wildfire-channel=private</member></tags></entry>
+				<entry name="222"/>
+			</devices></result></response>`,
+			expected: "branch,wildfire-channel=private",
+		},
+		{
+			name:         "Tags not readable",
+			tagsResponse: `<response status="error"><msg>Unauthorized</msg></response>`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Config{}
+			dm := NewDeviceManager(conf, logger.New(0, false))
+			mockClient := new(MockPanoramaClient)
+			mockClient.On("Initialize").Return(nil)
+			mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return([]byte(devicesResponse), nil)
+			mockClient.On("Op", panoramaDeviceTagsCommand, "", nil, nil).Return([]byte(tt.tagsResponse), nil)
+			dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+				return mockClient
+			}
+
+			devices, err := dm.getDevicesFromPanoramaHost(context.Background(), "panorama")
+
+			require.NoError(t, err, "the devices are collected without their tags")
+			require.Len(t, devices, 2)
+			assert.Equal(t, tt.expected, devices[0]["tags"])
+			assert.NotContains(t, devices[1], "tags")
+		})
+	}
+}
+
 func TestFilterDevices(t *testing.T) {
 	l := logger.New(0, false)
 	devices := []map[string]string{
@@ -115,25 +237,489 @@ func TestFilterDevices(t *testing.T) {
 	}
 
 	t.Run("No Filter", func(t *testing.T) {
-		filtered := filterDevices(devices, []string{}, l)
+		filtered := filterDevices(devices, []string{}, nil, l)
 		assert.Len(t, filtered, 4)
 	})
 
 	t.Run("Single Filter", func(t *testing.T) {
-		filtered := filterDevices(devices, []string{"fw-1"}, l)
+		filtered := filterDevices(devices, []string{"fw-1"}, nil, l)
 		assert.Len(t, filtered, 1)
 		assert.Equal(t, "fw-1-a", filtered[0]["hostname"])
 	})
 
 	t.Run("Multiple Filters", func(t *testing.T) {
-		filtered := filterDevices(devices, []string{"fw-1", "fw-2"}, l)
+		filtered := filterDevices(devices, []string{"fw-1", "fw-2"}, nil, l)
 		assert.Len(t, filtered, 2)
 		assert.Equal(t, "fw-1-a", filtered[0]["hostname"])
 		assert.Equal(t, "fw-2-b", filtered[1]["hostname"])
 	})
 
 	t.Run("No Matches", func(t *testing.T) {
-		filtered := filterDevices(devices, []string{"nonexistent"}, l)
+		filtered := filterDevices(devices, []string{"nonexistent"}, nil, l)
 		assert.Len(t, filtered, 0)
 	})
+
+	hostnames := func(devices []map[string]string) []string {
+		var names []string
+		for _, device := range devices {
+			names = append(names, device["hostname"])
+		}
+		return names
+	}
+
+	t.Run("Glob", func(t *testing.T) {
+		assert.Equal(t, []string{"fw-1-a", "fw-2-b", "fw-3-c"}, hostnames(filterDevices(devices, []string{"fw-*"}, nil, l)))
+		assert.Equal(t, []string{"fw-2-b"}, hostnames(filterDevices(devices, []string{"*-2-*"}, nil, l)))
+		assert.Equal(t, []string{"fw-1-a", "fw-3-c"}, hostnames(filterDevices(devices, []string{"fw-[13]-?"}, nil, l)))
+	})
+
+	t.Run("Regex", func(t *testing.T) {
+		assert.Equal(t, []string{"fw-1-a", "other-fw"}, hostnames(filterDevices(devices, []string{"regex:(-a|-fw)$"}, nil, l)))
+		assert.Equal(t, []string{"fw-3-c"}, hostnames(filterDevices(devices, []string{"regex:^fw-[3-9]"}, nil, l)))
+	})
+
+	t.Run("Mixed tokens", func(t *testing.T) {
+		filtered := filterDevices(devices, []string{"other", " *-2-*", "regex:c$"}, nil, l)
+		assert.Equal(t, []string{"fw-2-b", "fw-3-c", "other-fw"}, hostnames(filtered))
+	})
+
+	t.Run("Invalid tokens match nothing", func(t *testing.T) {
+		filtered := filterDevices(devices, []string{"regex:(", "fw-[", "fw-1"}, nil, l)
+		assert.Equal(t, []string{"fw-1-a"}, hostnames(filtered))
+	})
+
+	t.Run("Exclude only", func(t *testing.T) {
+		filtered := filterDevices(devices, nil, []string{"fw-2", "regex:^other"}, l)
+		assert.Equal(t, []string{"fw-1-a", "fw-3-c"}, hostnames(filtered))
+	})
+
+	t.Run("Exclude wins over filter", func(t *testing.T) {
+		filtered := filterDevices(devices, []string{"fw-*"}, []string{"*-3-*"}, l)
+		assert.Equal(t, []string{"fw-1-a", "fw-2-b"}, hostnames(filtered))
+	})
+
+	t.Run("Invalid excludes drop nothing", func(t *testing.T) {
+		filtered := filterDevices(devices, []string{"fw-"}, []string{"regex:(", " fw-1"}, l)
+		assert.Equal(t, []string{"fw-2-b", "fw-3-c"}, hostnames(filtered))
+	})
+}
+
+func TestGetDevicesFromPanoramaTruncatedResponse(t *testing.T) {
+	conf, err := setupTestConfig()
+	assert.NoError(t, err)
+	l := logger.New(0, false)
+	dm := NewDeviceManager(conf, l)
+
+	mockClient := new(MockPanoramaClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(nil)
+
+	// The response is cut off in the middle of the third entry
+	truncatedResponse := `
+	<response status="success">
+		<result>
+			<devices>
+				<entry name="11111">
+					<hostname>test-fw-1</hostname>
+					<serial>11111</serial>
+					<sw-version>10.1.0</sw-version>
+				</entry>
+				<entry name="22222">
+					<hostname>test-fw-2</hostname>
+					<serial>22222</serial>
+					<sw-version>10.2.0</sw-version>
+				</entry>
+				<entry name="33333">
+					<hostname>test-fw-3</hos`
+	noDeviceTags(mockClient)
+	mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return([]byte(truncatedResponse), nil)
+
+	devices, err := dm.getDevicesFromPanorama(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, devices, 2)
+	assert.Equal(t, "test-fw-1", devices[0]["hostname"])
+	assert.Equal(t, "test-fw-2", devices[1]["hostname"])
+
+	// The truncated response is retried once before falling back to the partial list, then the
+	// device tags are read
+	mockClient.AssertNumberOfCalls(t, "Op", 3)
+}
+
+// largeDevicesResponse builds a connected devices response of count entries, whose result reports
+// the given total-count unless it is empty
+func largeDevicesResponse(count int, totalCount string) []byte {
+	var b strings.Builder
+	b.WriteString(`<response status="success"><result`)
+	if totalCount != "" {
+		fmt.Fprintf(&b, ` total-count=%q`, totalCount)
+	}
+	b.WriteString(`><devices>`)
+	for i := 0; i < count; i++ {
+		serial := fmt.Sprintf("%012d", i)
+		fmt.Fprintf(&b, `<entry name="%s"><serial>%s</serial><hostname>fw-%d</hostname><ip-address>10.%d.%d.%d</ip-address>`+
+			`<model>PA-VM</model><family>vm</family><sw-version>10.2.4</sw-version><connected>yes</connected></entry>`, serial, serial, i, i>>16&255, i>>8&255, i&255)
+	}
+	b.WriteString(`</devices></result></response>`)
+	return []byte(b.String())
+}
+
+func TestGetDevicesFromPanoramaLargeResponse(t *testing.T) {
+	const count = 10000
+	tests := []struct {
+		name       string
+		totalCount string
+		warning    string
+	}{
+		{"Count matches", strconv.Itoa(count), ""},
+		{"No count reported", "", ""},
+		{"Count mismatch", strconv.Itoa(count + 250), fmt.Sprintf("reports %d devices but its response holds %d device entries", count+250, count)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := setupTestConfig()
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			l := logger.New(0, false)
+			l.SetOutput(&buf)
+			dm := NewDeviceManager(conf, l)
+
+			mockClient := new(MockPanoramaClient)
+			dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+				return mockClient
+			}
+			mockClient.On("Initialize").Return(nil)
+			noDeviceTags(mockClient)
+			mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return(largeDevicesResponse(count, tt.totalCount), nil)
+
+			devices, err := dm.getDevicesFromPanorama(context.Background())
+
+			require.NoError(t, err)
+			require.Len(t, devices, count)
+			serials := make(map[string]bool, count)
+			for _, device := range devices {
+				serials[device["serial"]] = true
+			}
+			assert.Len(t, serials, count, "every entry must be parsed once")
+			assert.Equal(t, "fw-9999", devices[count-1]["hostname"])
+			assert.Equal(t, "10.0.39.15", devices[count-1]["ip-address"])
+			if tt.warning == "" {
+				assert.NotContains(t, buf.String(), "[WARN]")
+			} else {
+				assert.Contains(t, buf.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestGetDevicesFromPanoramaScope(t *testing.T) {
+	connectedResponse := `
+	<response status="success">
+		<result>
+			<devices>
+				<entry name="11111">
+					<hostname>fw-up</hostname>
+					<serial>11111</serial>
+					<connected>yes</connected>
+				</entry>
+			</devices>
+		</result>
+	</response>`
+	allResponse := `
+	<response status="success">
+		<result>
+			<devices>
+				<entry name="11111">
+					<hostname>fw-up</hostname>
+					<serial>11111</serial>
+					<connected>yes</connected>
+				</entry>
+				<entry name="22222">
+					<hostname>fw-down</hostname>
+					<serial>22222</serial>
+					<connected>no</connected>
+				</entry>
+			</devices>
+		</result>
+	</response>`
+	tests := []struct {
+		name      string
+		scope     string
+		command   string
+		response  string
+		connected map[string]string
+	}{
+		{"Default scope", "", "<show><devices><connected/></devices></show>", connectedResponse, map[string]string{"fw-up": "yes"}},
+		{"Connected scope", config.PanoramaScopeConnected, "<show><devices><connected/></devices></show>", connectedResponse, map[string]string{"fw-up": "yes"}},
+		{"All scope", config.PanoramaScopeAll, "<show><devices><all/></devices></show>", allResponse, map[string]string{"fw-up": "yes", "fw-down": "no"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := setupTestConfig()
+			require.NoError(t, err)
+			conf.PanoramaScope = tt.scope
+			dm := NewDeviceManager(conf, logger.New(0, false))
+
+			mockClient := new(MockPanoramaClient)
+			dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+				return mockClient
+			}
+			mockClient.On("Initialize").Return(nil)
+			mockClient.On("Op", tt.command, "", nil, nil).Return([]byte(tt.response), nil)
+
+			devices, err := dm.getDevicesFromPanorama(context.Background())
+
+			require.NoError(t, err)
+			connected := make(map[string]string)
+			for _, device := range devices {
+				connected[device["hostname"]] = device["connected"]
+			}
+			assert.Equal(t, tt.connected, connected)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestParseDevicesResponse(t *testing.T) {
+	t.Run("Complete response", func(t *testing.T) {
+		resp, truncated, err := parseDevicesResponse([]byte(`<response status="success"><result><devices><entry><serial>1</serial></entry></devices></result></response>`))
+		assert.NoError(t, err)
+		assert.False(t, truncated)
+		assert.Len(t, resp.Result.Devices.Entries, 1)
+	})
+
+	t.Run("Truncated response", func(t *testing.T) {
+		resp, truncated, err := parseDevicesResponse([]byte(`<response status="success"><result total-count="2"><devices><entry><serial>1</serial></entry><entry><ser`))
+		assert.NoError(t, err)
+		assert.True(t, truncated)
+		assert.Equal(t, "success", resp.Status)
+		assert.Equal(t, "2", resp.Result.TotalCount)
+		assert.Len(t, resp.Result.Devices.Entries, 1)
+		assert.Equal(t, "1", resp.Result.Devices.Entries[0].Serial)
+	})
+
+	t.Run("Malformed response", func(t *testing.T) {
+		_, _, err := parseDevicesResponse([]byte(`<response><result></devices></result></response>`))
+		assert.Error(t, err)
+	})
+}
+
+// concurrencyTrackingClient records the number of clients initializing at the same time
+type concurrencyTrackingClient struct {
+	active  *int32
+	maxSeen *int32
+}
+
+func (c *concurrencyTrackingClient) Initialize() error {
+	current := atomic.AddInt32(c.active, 1)
+	defer atomic.AddInt32(c.active, -1)
+	for {
+		seen := atomic.LoadInt32(c.maxSeen)
+		if current <= seen || atomic.CompareAndSwapInt32(c.maxSeen, seen, current) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func (c *concurrencyTrackingClient) Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error) {
+	return []byte(`<response status="success"><result><devices><entry><hostname>fw</hostname></entry></devices></result></response>`), nil
+}
+
+func TestGetDevicesFromPanoramaConcurrencyBound(t *testing.T) {
+	conf := &config.Config{PanoramaConcurrency: 2}
+	for i := 0; i < 6; i++ {
+		conf.Panorama = append(conf.Panorama, struct {
+			Hostname string `yaml:"hostname"`
+		}{Hostname: fmt.Sprintf("panorama-%d", i)})
+	}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	var active, maxSeen int32
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return &concurrencyTrackingClient{active: &active, maxSeen: &maxSeen}
+	}
+
+	devices, err := dm.getDevicesFromPanorama(context.Background())
+
+	require.NoError(t, err)
+	assert.Len(t, devices, 6)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxSeen))
+}
+
+func TestGetDevicesFromPanoramaPartialAuthFailure(t *testing.T) {
+	conf := &config.Config{
+		Panorama: []struct {
+			Hostname string `yaml:"hostname"`
+		}{
+			{Hostname: "panorama-ok"},
+			{Hostname: "panorama-auth"},
+		},
+	}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	okClient := new(MockPanoramaClient)
+	okClient.On("Initialize").Return(nil)
+	noDeviceTags(okClient)
+	okClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).
+		Return([]byte(`<response status="success"><result><devices><entry><hostname>fw-1</hostname></entry></devices></result></response>`), nil)
+	authClient := new(MockPanoramaClient)
+	authClient.On("Initialize").Return(pangoerrors.Panos{Msg: "Invalid Credential", Code: 403})
+
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		if hostname == "panorama-auth" {
+			return authClient
+		}
+		return okClient
+	}
+
+	// Without -skip-failed-panoramas, a failing Panorama aborts the query
+	_, err := dm.getDevicesFromPanorama(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid Credential")
+
+	// With it, the devices of the other Panoramas are returned and the failure is summarized
+	conf.SkipFailedPanoramas = true
+	var devices []map[string]string
+	output := captureOutput(t, func() {
+		devices, err = dm.getDevicesFromPanorama(context.Background())
+	})
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "fw-1", devices[0]["hostname"])
+	assert.Contains(t, output, "1 of 2 Panorama(s) failed")
+	assert.Contains(t, output, "panorama-auth: authentication failed")
+
+	// The query still fails when every Panorama fails
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient { return authClient }
+	_, err = dm.getDevicesFromPanorama(context.Background())
+	require.Error(t, err)
+}
+
+func TestIsAuthFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"Invalid credentials", pangoerrors.Panos{Msg: "Invalid Credential", Code: 403}, true},
+		{"Wrapped invalid credentials", fmt.Errorf("failed to initialize Panorama client: %w", pangoerrors.Panos{Msg: "Invalid Credential", Code: 403}), true},
+		{"Role without access", pangoerrors.Panos{Msg: "Unauthorized", Code: 16}, true},
+		{"Other PAN-OS error", pangoerrors.Panos{Msg: "Object not found", Code: 7}, false},
+		{"Status code in the hostname", errors.New("dial tcp: lookup panorama-403.example.com: no such host"), false},
+		{"Authentication in the URL", errors.New(`Post "https://authentication.example.com/api": connection refused`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isAuthFailure(tt.err))
+		})
+	}
+}
+
+func TestGetDevicesFromPanoramaSource(t *testing.T) {
+	conf := &config.Config{
+		Panorama: []struct {
+			Hostname string `yaml:"hostname"`
+		}{
+			{Hostname: "panorama-east"},
+			{Hostname: "panorama-west"},
+		},
+	}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	clients := make(map[string]*MockPanoramaClient)
+	for hostname, firewall := range map[string]string{"panorama-east": "fw-east", "panorama-west": "fw-west"} {
+		client := new(MockPanoramaClient)
+		client.On("Initialize").Return(nil)
+		noDeviceTags(client)
+		client.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).
+			Return([]byte(fmt.Sprintf(`<response status="success"><result><devices><entry><hostname>%s</hostname></entry></devices></result></response>`, firewall)), nil)
+		clients[hostname] = client
+	}
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return clients[hostname]
+	}
+
+	devices, err := dm.getDevicesFromPanorama(context.Background())
+
+	require.NoError(t, err)
+	sources := make(map[string]string)
+	for _, device := range devices {
+		sources[device["hostname"]] = device["source"]
+	}
+	assert.Equal(t, map[string]string{"fw-east": "panorama-east", "fw-west": "panorama-west"}, sources)
+}
+
+// captureOutput returns what f writes to os.Stdout, where the logger writes
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	old := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = w
+
+	f()
+
+	require.NoError(t, w.Close())
+	os.Stdout = old
+	output, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	return string(output)
+}
+
+// panickingClient panics on every call, like a nil dereference inside the client
+type panickingClient struct {
+	session *struct{ key string }
+}
+
+func (c *panickingClient) Initialize() error {
+	_ = c.session.key
+	return nil
+}
+
+func (c *panickingClient) Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error) {
+	return []byte(c.session.key), nil
+}
+
+func TestGetDevicesFromPanoramaRecoversPanic(t *testing.T) {
+	conf := &config.Config{
+		Panorama: []struct {
+			Hostname string `yaml:"hostname"`
+		}{
+			{Hostname: "panorama-ok"},
+			{Hostname: "panorama-panic"},
+		},
+		SkipFailedPanoramas: true,
+	}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	okClient := new(MockPanoramaClient)
+	okClient.On("Initialize").Return(nil)
+	noDeviceTags(okClient)
+	okClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).
+		Return([]byte(`<response status="success"><result><devices><entry><hostname>fw-1</hostname></entry></devices></result></response>`), nil)
+
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		if hostname == "panorama-panic" {
+			return &panickingClient{}
+		}
+		return okClient
+	}
+
+	var devices []map[string]string
+	var err error
+	output := captureOutput(t, func() {
+		devices, err = dm.getDevicesFromPanorama(context.Background())
+	})
+
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "fw-1", devices[0]["hostname"])
+	assert.Contains(t, output, "panorama-panic: failed: failed to initialize Panorama client: panic: runtime error: invalid memory address or nil pointer dereference")
 }
@@ -0,0 +1,86 @@
+// Package devices devices/reconcile.go
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+)
+
+// reconcileDevices merges the devices collected from Panorama and from the inventory file.
+// Devices are matched by serial number. When the same serial appears in both sources the
+// following field-level precedence applies:
+//   - non-empty Panorama values win, since Panorama reports the managed device facts
+//   - inventory values fill fields Panorama left empty
+//   - inventory-only fields are kept
+//   - the tags of both sources are combined, the inventory tags first so that they win over the
+//     Panorama tags, e.g. for a wildfire-channel=<channel> tag
+//   - the source lists both sources, e.g. "panorama.example.com, inventory"
+//
+// Devices found in only one source, or without a serial, are kept unchanged. The order
+// is Panorama devices first, followed by inventory-only devices.
+func reconcileDevices(panoramaDevices, inventoryDevices []map[string]string, l *logger.Logger) []map[string]string {
+	inventoryBySerial := make(map[string]map[string]string)
+	for _, device := range inventoryDevices {
+		if serial := device["serial"]; serial != "" {
+			inventoryBySerial[serial] = device
+		}
+	}
+
+	merged := make([]map[string]string, 0, len(panoramaDevices)+len(inventoryDevices))
+	matched := make(map[string]bool)
+
+	for _, panoramaDevice := range panoramaDevices {
+		serial := panoramaDevice["serial"]
+		inventoryDevice, ok := inventoryBySerial[serial]
+		if serial == "" || !ok {
+			merged = append(merged, panoramaDevice)
+			continue
+		}
+
+		l.Info(fmt.Sprintf("Device %s (serial %s) found in both Panorama and inventory, preferring Panorama facts", panoramaDevice["hostname"], serial))
+		matched[serial] = true
+
+		device := make(map[string]string, len(inventoryDevice)+len(panoramaDevice))
+		for k, v := range inventoryDevice {
+			device[k] = v
+		}
+		for k, v := range panoramaDevice {
+			if v != "" {
+				device[k] = v
+			}
+		}
+		if panoramaDevice["tags"] != "" && inventoryDevice["tags"] != "" {
+			device["tags"] = combineTags(inventoryDevice["tags"], panoramaDevice["tags"])
+		}
+		// The device came from both sources
+		if panoramaDevice["source"] != "" && inventoryDevice["source"] != "" {
+			device["source"] = panoramaDevice["source"] + ", " + inventoryDevice["source"]
+		}
+		merged = append(merged, device)
+	}
+
+	for _, inventoryDevice := range inventoryDevices {
+		if serial := inventoryDevice["serial"]; serial == "" || !matched[serial] {
+			merged = append(merged, inventoryDevice)
+		}
+	}
+
+	return merged
+}
+
+// combineTags joins comma-separated tag lists in order, leaving out repeated tags
+func combineTags(lists ...string) string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, tag := range strings.Split(list, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return strings.Join(tags, ",")
+}
@@ -0,0 +1,166 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileDevices(t *testing.T) {
+	l := logger.New(0, false)
+
+	panoramaDevices := []map[string]string{
+		{"serial": "111", "hostname": "fw-1", "ip-address": "10.0.0.1", "sw-version": "10.2.4", "model": "", "source": "panorama-1"},
+		{"serial": "222", "hostname": "fw-2", "ip-address": "10.0.0.2", "sw-version": "11.0.1", "tags": "branch", "source": "panorama-1"},
+		{"serial": "444", "hostname": "fw-4", "tags": "branch,wildfire-channel=public", "source": "panorama-1"},
+	}
+	inventoryDevices := []map[string]string{
+		{"serial": "444", "hostname": "fw-4", "tags": "wildfire-channel=private, branch", "source": SourceInventory},
+		{"serial": "111", "hostname": "fw-1-local", "ip-address": "192.168.1.1", "sw-version": "10.2.3", "model": "PA-3260", "tags": "lab,edge", "source": SourceInventory},
+		{"serial": "333", "hostname": "fw-3", "ip-address": "192.168.1.3", "sw-version": "10.1.0", "source": SourceInventory},
+	}
+
+	merged := reconcileDevices(panoramaDevices, inventoryDevices, l)
+
+	assert.Len(t, merged, 4)
+
+	// Same serial in both sources: Panorama facts win, inventory fills gaps and keeps its own fields
+	assert.Equal(t, "111", merged[0]["serial"])
+	assert.Equal(t, "fw-1", merged[0]["hostname"])
+	assert.Equal(t, "10.0.0.1", merged[0]["ip-address"])
+	assert.Equal(t, "10.2.4", merged[0]["sw-version"])
+	assert.Equal(t, "PA-3260", merged[0]["model"])
+	assert.Equal(t, "lab,edge", merged[0]["tags"])
+	assert.Equal(t, "panorama-1, inventory", merged[0]["source"])
+
+	// Devices only present in one source are kept
+	assert.Equal(t, "fw-2", merged[1]["hostname"])
+	assert.Equal(t, "panorama-1", merged[1]["source"])
+	assert.Equal(t, "branch", merged[1]["tags"])
+	assert.Equal(t, "fw-3", merged[3]["hostname"])
+	assert.Equal(t, SourceInventory, merged[3]["source"])
+
+	// Tags from both sources are combined, the inventory tags first
+	assert.Equal(t, "wildfire-channel=private,branch,wildfire-channel=public", merged[2]["tags"])
+}
+
+func TestReconcileDevicesWithoutSerial(t *testing.T) {
+	l := logger.New(0, false)
+
+	merged := reconcileDevices(
+		[]map[string]string{{"serial": "", "hostname": "pano-fw"}},
+		[]map[string]string{{"serial": "", "hostname": "inv-fw"}},
+		l,
+	)
+
+	assert.Len(t, merged, 2)
+	assert.Equal(t, "pano-fw", merged[0]["hostname"])
+	assert.Equal(t, "inv-fw", merged[1]["hostname"])
+}
+
+// rendezvousClient is a PanosClient whose Initialize only succeeds once the other
+// source has also started, which proves the two sources are collected concurrently.
+type rendezvousClient struct {
+	started  chan struct{}
+	other    chan struct{}
+	once     sync.Once
+	response string
+}
+
+func (c *rendezvousClient) Initialize() error {
+	c.once.Do(func() { close(c.started) })
+	select {
+	case <-c.other:
+		return nil
+	case <-time.After(2 * time.Second):
+		return errors.New("other source was not queried concurrently")
+	}
+}
+
+func (c *rendezvousClient) Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error) {
+	return []byte(c.response), nil
+}
+
+// chdirTemp switches to a temporary directory containing the given files for the duration of the test
+func chdirTemp(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+}
+
+func TestGetMergedDeviceListConcurrent(t *testing.T) {
+	chdirTemp(t, map[string]string{
+		"inventory.yaml": "inventory:\n  - hostname: inv-fw\n    ip_address: 192.168.1.10\n",
+	})
+
+	conf, err := setupTestConfig()
+	require.NoError(t, err)
+	conf.MergeInventory = true
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	panoramaStarted, inventoryStarted := make(chan struct{}), make(chan struct{})
+	panoramaClient := &rendezvousClient{
+		started:  panoramaStarted,
+		other:    inventoryStarted,
+		response: `<response status="success"><result><devices><entry><hostname>pano-fw</hostname><serial>111</serial></entry></devices></result></response>`,
+	}
+	inventoryClient := &rendezvousClient{
+		started:  inventoryStarted,
+		other:    panoramaStarted,
+		response: `<response status="success"><result><system><hostname>inv-fw</hostname><serial>222</serial></system></result></response>`,
+	}
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		if hostname == "test-panorama.example.com" {
+			return panoramaClient
+		}
+		return inventoryClient
+	}
+
+	devices, err := dm.GetDeviceList(context.Background(), false)
+
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	assert.Equal(t, "pano-fw", devices[0]["hostname"])
+	assert.Equal(t, "inv-fw", devices[1]["hostname"])
+}
+
+func TestGetMergedDeviceListSourceFailure(t *testing.T) {
+	// No inventory.yaml in the working directory, so only the Panorama source succeeds
+	chdirTemp(t, nil)
+
+	conf, err := setupTestConfig()
+	require.NoError(t, err)
+	conf.MergeInventory = true
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	mockClient := new(MockPanosClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(nil)
+	noDeviceTags(mockClient)
+	mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).Return(
+		[]byte(`<response status="success"><result><devices><entry><hostname>pano-fw</hostname><serial>111</serial></entry></devices></result></response>`), nil)
+
+	devices, err := dm.GetDeviceList(context.Background(), false)
+
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "pano-fw", devices[0]["hostname"])
+}
@@ -0,0 +1,63 @@
+// Package devices devices/retry.go
+package devices
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// retrySleep waits between attempts until ctx is done; tests replace it to avoid waiting
+var retrySleep = func(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// isPermanentFailure reports whether an attempt failed in a way another attempt cannot fix, such as
+// rejected credentials or an untrusted certificate
+func isPermanentFailure(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return true
+	}
+	return isAuthFailure(err) || strings.Contains(err.Error(), "x509:")
+}
+
+// withRetries runs fn until it succeeds or the configured number of retries is exhausted, waiting
+// RetryBackoff before the first retry and twice as long before each following one. The error of
+// the last attempt is returned unchanged. Permanent failures are not retried, and once ctx is done,
+// no further attempt is made. Retries are logged at debug level.
+func (dm *DeviceManager) withRetries(ctx context.Context, action string, fn func() error) error {
+	backoff := dm.config.RetryBackoff
+	err := fn()
+	for attempt := 1; err != nil && attempt <= dm.config.Retries && ctx.Err() == nil; attempt++ {
+		if isPermanentFailure(err) {
+			dm.logger.Debug(fmt.Sprintf("%s failed permanently, not retrying: %v", action, err))
+			break
+		}
+		dm.logger.Debug(fmt.Sprintf("%s failed, retry %d of %d in %s: %v", action, attempt, dm.config.Retries, backoff, err))
+		retrySleep(ctx, backoff)
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
+// initialize initializes the client of a device until ctx is done, retrying transient failures
+func (dm *DeviceManager) initialize(ctx context.Context, client PanosClient, hostname string) error {
+	return dm.withRetries(ctx, "Client initialization for "+hostname, func() error {
+		return initializeWithContext(ctx, client)
+	})
+}
@@ -0,0 +1,125 @@
+package devices
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	pangoerrors "github.com/PaloAltoNetworks/pango/errors"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordRetrySleeps replaces the wait between retries for the duration of the test and returns the waits
+func recordRetrySleeps(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	original := retrySleep
+	retrySleep = func(ctx context.Context, d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { retrySleep = original })
+	return &waits
+}
+
+func TestInitializeRetries(t *testing.T) {
+	waits := recordRetrySleeps(t)
+	dm := NewDeviceManager(&config.Config{Retries: 3, RetryBackoff: time.Second}, logger.New(1, false))
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Initialize").Return(errors.New("tls: handshake timeout")).Twice()
+	mockClient.On("Initialize").Return(nil).Once()
+
+	err := dm.initialize(context.Background(), mockClient, "fw-1")
+
+	require.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "Initialize", 3)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *waits)
+}
+
+func TestInitializeGivesUp(t *testing.T) {
+	waits := recordRetrySleeps(t)
+	dm := NewDeviceManager(&config.Config{Retries: 2, RetryBackoff: time.Second}, logger.New(0, false))
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Initialize").Return(errors.New("connection refused"))
+
+	err := dm.initialize(context.Background(), mockClient, "fw-1")
+
+	assert.EqualError(t, err, "connection refused", "the error of the last attempt is returned unchanged")
+	mockClient.AssertNumberOfCalls(t, "Initialize", 3)
+	assert.Len(t, *waits, 2)
+}
+
+func TestInitializeDoesNotRetryPermanentFailures(t *testing.T) {
+	for _, failure := range []error{
+		pangoerrors.Panos{Msg: "Invalid Credential", Code: 403},
+		errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"),
+		x509.HostnameError{Certificate: &x509.Certificate{}, Host: "fw-1"},
+	} {
+		t.Run(failure.Error(), func(t *testing.T) {
+			waits := recordRetrySleeps(t)
+			dm := NewDeviceManager(&config.Config{Retries: 3, RetryBackoff: time.Second}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Initialize").Return(failure)
+
+			err := dm.initialize(context.Background(), mockClient, "fw-1")
+
+			assert.Equal(t, failure, err)
+			mockClient.AssertNumberOfCalls(t, "Initialize", 1)
+			assert.Empty(t, *waits)
+		})
+	}
+}
+
+func TestRetrySleepStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	retrySleep(ctx, time.Hour)
+
+	assert.Less(t, time.Since(start), time.Second, "the wait ends once ctx is done")
+}
+
+func TestOpRetries(t *testing.T) {
+	recordRetrySleeps(t)
+	dm := NewDeviceManager(&config.Config{Retries: 1}, logger.New(0, false))
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Op", "<show/>", "", nil, nil).Return([]byte(nil), errors.New("connection reset by peer")).Once()
+	mockClient.On("Op", "<show/>", "", nil, nil).Return([]byte("<response/>"), nil).Once()
+
+	response, err := dm.op(context.Background(), mockClient, "<show/>")
+
+	require.NoError(t, err)
+	assert.Equal(t, "<response/>", string(response))
+}
+
+func TestGetDevicesFromInventoryRetriesInitialize(t *testing.T) {
+	recordRetrySleeps(t)
+	dm := NewDeviceManager(&config.Config{Retries: 2, RetryBackoff: time.Second}, logger.New(0, false))
+	dm.SetInventorySource(stubInventorySource{{Hostname: "fw-1", IPAddress: "192.0.2.1"}})
+	mockClient := new(MockNgfwClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(errors.New("tls: handshake timeout")).Twice()
+	mockClient.On("Initialize").Return(nil).Once()
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(`
+	<response status="success">
+		<result>
+			<system>
+				<hostname>fw-1</hostname>
+				<serial>12345</serial>
+				<sw-version>10.1.0</sw-version>
+			</system>
+		</result>
+	</response>`), nil)
+
+	devices, err := dm.getDevicesFromInventory(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, devices, 1, "a device is not dropped after transient initialization failures")
+	assert.Equal(t, "12345", devices[0]["serial"])
+	mockClient.AssertNumberOfCalls(t, "Initialize", 3)
+}
@@ -0,0 +1,71 @@
+// Package devices devices/target.go
+package devices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+)
+
+// Kinds of PAN-OS targets, derived from their system information
+const (
+	TargetFirewall = "firewall"
+	TargetPanorama = "panorama"
+)
+
+// panoramaModelPrefixes are the model prefixes of Panorama virtual and M-Series appliances
+var panoramaModelPrefixes = []string{"panorama", "m-"}
+
+// TargetKind reports whether the system information of a target belongs to a Panorama or a firewall.
+// A Panorama is recognized by its system mode, model or family, anything else is a firewall.
+func TargetKind(system config.DeviceEntry) string {
+	mode := strings.ToLower(strings.TrimSpace(system.SystemMode))
+	if mode == "panorama" || mode == "management-only" || mode == "logger" {
+		return TargetPanorama
+	}
+
+	model := strings.ToLower(strings.TrimSpace(system.Model))
+	for _, prefix := range panoramaModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return TargetPanorama
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(system.Family), "m") {
+		return TargetPanorama
+	}
+
+	return TargetFirewall
+}
+
+// probeTargetKind retrieves the system information of a target and returns its kind.
+func (dm *DeviceManager) probeTargetKind(ctx context.Context, client PanosClient) (string, config.DeviceEntry, error) {
+	response, err := dm.op(ctx, client, config.CommandsForMajor(0).SystemInfo)
+	if err != nil {
+		return "", config.DeviceEntry{}, err
+	}
+
+	var result struct {
+		System config.DeviceEntry `xml:"system"`
+	}
+	status, err := unmarshalOpResult(response, &result)
+	if err != nil {
+		return "", config.DeviceEntry{}, err
+	}
+	if status != "success" {
+		return "", config.DeviceEntry{}, fmt.Errorf("operation failed: %s", status)
+	}
+
+	return TargetKind(result.System), result.System, nil
+}
+
+// checkPanoramaTarget is called when a Panorama query fails. It returns an error explaining
+// the mismatch when the target turns out to be a firewall, and nil otherwise.
+func (dm *DeviceManager) checkPanoramaTarget(ctx context.Context, client PanosClient, hostname string) error {
+	kind, system, err := dm.probeTargetKind(ctx, client)
+	if err != nil || kind != TargetFirewall {
+		return nil
+	}
+	return fmt.Errorf("%s is a firewall (model %s), not a Panorama: list it in inventory.yaml and use -nopanorama instead", hostname, system.Model)
+}
@@ -0,0 +1,120 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const panoramaSystemInfo = `
+<response status="success">
+	<result>
+		<system>
+			<hostname>panorama-1</hostname>
+			<serial>000710001234</serial>
+			<model>Panorama</model>
+			<family>pc</family>
+			<sw-version>11.1.2</sw-version>
+			<system-mode>panorama</system-mode>
+		</system>
+	</result>
+</response>`
+
+const firewallSystemInfo = `
+<response status="success">
+	<result>
+		<system>
+			<hostname>fw-1</hostname>
+			<serial>013201001234</serial>
+			<model>PA-3260</model>
+			<family>3200</family>
+			<sw-version>10.1.0</sw-version>
+		</system>
+	</result>
+</response>`
+
+func TestTargetKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		system   config.DeviceEntry
+		expected string
+	}{
+		{name: "Panorama virtual appliance", system: config.DeviceEntry{Model: "Panorama", Family: "pc"}, expected: TargetPanorama},
+		{name: "M-Series appliance", system: config.DeviceEntry{Model: "M-600", Family: "m"}, expected: TargetPanorama},
+		{name: "Log collector mode", system: config.DeviceEntry{Model: "M-200", SystemMode: "logger"}, expected: TargetPanorama},
+		{name: "Hardware firewall", system: config.DeviceEntry{Model: "PA-3260", Family: "3200"}, expected: TargetFirewall},
+		{name: "VM-Series firewall", system: config.DeviceEntry{Model: "PA-VM", Family: "vm"}, expected: TargetFirewall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, TargetKind(tt.system))
+		})
+	}
+}
+
+func TestProbeTargetKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected string
+	}{
+		{name: "Panorama system info", response: panoramaSystemInfo, expected: TargetPanorama},
+		{name: "Firewall system info", response: firewallSystemInfo, expected: TargetFirewall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(tt.response), nil)
+
+			kind, _, err := dm.probeTargetKind(context.Background(), mockClient)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, kind)
+		})
+	}
+}
+
+func TestGetNgfwDeviceInfoRejectsPanorama(t *testing.T) {
+	dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+	mockClient := new(MockNgfwClient)
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(panoramaSystemInfo), nil)
+
+	_, err := dm.getNgfwDeviceInfo(context.Background(), mockClient, "panorama-1")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is a Panorama")
+}
+
+func TestGetDevicesFromPanoramaRejectsFirewall(t *testing.T) {
+	conf := &config.Config{
+		Panorama: []struct {
+			Hostname string `yaml:"hostname"`
+		}{
+			{Hostname: "fw-1"},
+		},
+	}
+	dm := NewDeviceManager(conf, logger.New(0, false))
+
+	mockClient := new(MockPanoramaClient)
+	dm.panosClientFactory = func(hostname, username, password, apiKey string) PanosClient {
+		return mockClient
+	}
+	mockClient.On("Initialize").Return(nil)
+	mockClient.On("Op", "<show><devices><connected/></devices></show>", "", nil, nil).
+		Return([]byte{}, errors.New("show -> devices is unexpected"))
+	mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(firewallSystemInfo), nil)
+
+	_, err := dm.getDevicesFromPanorama(context.Background())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is a firewall")
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,64 @@
+// Package devices devices/xml.go
+package devices
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// unmarshalOpResult unmarshals the <result> element of a PAN-OS op response into result
+// and returns the response status. It tolerates the variations some PAN-OS releases emit:
+//   - the whole result body wrapped in a CDATA section
+//   - CDATA-wrapped field values with surrounding whitespace
+//   - namespace prefixes on elements, which are matched by their local name
+func unmarshalOpResult(response []byte, result interface{}) (string, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"response"`
+		Status  string   `xml:"status,attr"`
+		Result  struct {
+			Inner []byte `xml:",innerxml"`
+		} `xml:"result"`
+	}
+	if err := xml.Unmarshal(response, &resp); err != nil {
+		return "", err
+	}
+
+	inner := bytes.TrimSpace(resp.Result.Inner)
+	if bytes.HasPrefix(inner, []byte("<![CDATA[")) && bytes.HasSuffix(inner, []byte("]]>")) {
+		inner = inner[len("<![CDATA[") : len(inner)-len("]]>")]
+	}
+
+	wrapped := make([]byte, 0, len(inner)+len("<result></result>"))
+	wrapped = append(wrapped, "<result>"...)
+	wrapped = append(wrapped, inner...)
+	wrapped = append(wrapped, "</result>"...)
+	if err := xml.Unmarshal(wrapped, result); err != nil {
+		return "", fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+
+	trimStringFields(reflect.ValueOf(result))
+	return strings.TrimSpace(resp.Status), nil
+}
+
+// trimStringFields removes surrounding whitespace from every string field of a struct, recursively.
+func trimStringFields(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			trimStringFields(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				trimStringFields(v.Field(i))
+			}
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(strings.TrimSpace(v.String()))
+		}
+	}
+}
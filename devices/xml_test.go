@@ -0,0 +1,136 @@
+package devices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNgfwDeviceInfoXMLVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{
+			name: "CDATA-wrapped values",
+			response: `
+			<response status="success">
+				<result>
+					<system>
+						<hostname>
+							<![CDATA[test-fw]]>
+						</hostname>
+						<serial><![CDATA[12345]]></serial>
+						<sw-version><![CDATA[10.1.0]]></sw-version>
+					</system>
+				</result>
+			</response>`,
+		},
+		{
+			name: "CDATA-wrapped result",
+			response: `
+			<response status="success">
+				<result><![CDATA[
+					<system>
+						<hostname>test-fw</hostname>
+						<serial>12345</serial>
+						<sw-version>10.1.0</sw-version>
+					</system>
+				]]></result>
+			</response>`,
+		},
+		{
+			name: "Namespaced elements",
+			response: `
+			<pan:response xmlns:pan="urn:paloaltonetworks:panos" status="success">
+				<pan:result>
+					<pan:system>
+						<pan:hostname>test-fw</pan:hostname>
+						<pan:serial>12345</pan:serial>
+						<pan:sw-version>10.1.0</pan:sw-version>
+					</pan:system>
+				</pan:result>
+			</pan:response>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", "<show><system><info/></system></show>", "", nil, nil).Return([]byte(tt.response), nil)
+
+			device, err := dm.getNgfwDeviceInfo(context.Background(), mockClient, "test-fw")
+
+			require.NoError(t, err)
+			assert.Equal(t, "test-fw", device["hostname"])
+			assert.Equal(t, "12345", device["serial"])
+			assert.Equal(t, "10.1.0", device["sw-version"])
+		})
+	}
+}
+
+func TestShowDeviceCertificateStatusXMLVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{
+			name: "CDATA-wrapped values",
+			response: `
+			<response status="success">
+				<result>
+					<device-certificate>
+						<status><![CDATA[Valid]]></status>
+						<validity> <![CDATA[Valid]]> </validity>
+						<not_valid_after><![CDATA[Aug 28 13:01:06 2025 GMT]]></not_valid_after>
+					</device-certificate>
+				</result>
+			</response>`,
+		},
+		{
+			name: "Namespaced elements",
+			response: `
+			<response xmlns:pan="urn:paloaltonetworks:panos" status="success">
+				<result>
+					<pan:device-certificate>
+						<pan:status>Valid</pan:status>
+						<pan:validity>Valid</pan:validity>
+						<pan:not_valid_after>Aug 28 13:01:06 2025 GMT</pan:not_valid_after>
+					</pan:device-certificate>
+				</result>
+			</response>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := NewDeviceManager(&config.Config{}, logger.New(0, false))
+			mockClient := new(MockNgfwClient)
+			mockClient.On("Op", "<show><device-certificate><status/></device-certificate></show>", "", nil, nil).Return([]byte(tt.response), nil)
+
+			certStatus, err := dm.showDeviceCertificateStatus(context.Background(), mockClient, map[string]string{"hostname": "test-fw"})
+
+			require.NoError(t, err)
+			assert.Equal(t, "Valid", certStatus["status"])
+			assert.Equal(t, "Valid", certStatus["validity"])
+			assert.Equal(t, "Aug 28 13:01:06 2025 GMT", certStatus["not_valid_after"])
+			assert.Equal(t, CertStateValid, certStatus["state"])
+		})
+	}
+}
+
+func TestUnmarshalOpResultErrors(t *testing.T) {
+	var result struct{}
+
+	_, err := unmarshalOpResult([]byte(`<response status="success"><result>`), &result)
+	assert.Error(t, err)
+
+	status, err := unmarshalOpResult([]byte(`<response status="error"><result></result></response>`), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", status)
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+// Package main driver_postgres.go
+package main
+
+// The PostgreSQL driver of -inventory-dsn, compiled in with -tags postgres
+import _ "github.com/lib/pq"
@@ -5,8 +5,10 @@ go 1.21.6
 require (
 	github.com/PaloAltoNetworks/pango v0.10.2
 	github.com/johnfercher/maroto/v2 v2.1.1
+	github.com/lib/pq v1.12.3
 	github.com/scrapli/scrapligo v1.3.2
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.23.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -27,7 +29,6 @@ require (
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/sirikothe/gotextfsm v1.0.1-0.20200816110946-6aa2cfd355e4 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/sys v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
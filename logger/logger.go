@@ -2,8 +2,10 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
 )
 
 // Logger is a custom logger with debug levels.
@@ -13,6 +15,28 @@ type Logger struct {
 	exitFunc func(int) // New field for custom exit function
 }
 
+// syncWriter serializes writes to the underlying writer so that output from
+// concurrent goroutines is never interleaved within a line or block.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p to the underlying writer while holding the lock.
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// stdoutWriter resolves os.Stdout on every write so redirected output is honored.
+type stdoutWriter struct{}
+
+// Write writes p to the current os.Stdout.
+func (stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
 // New creates and returns a new Logger instance with specified debug level and verbosity.
 func New(debugLevel int, verbose bool) *Logger {
 	if verbose {
@@ -20,11 +44,18 @@ func New(debugLevel int, verbose bool) *Logger {
 	}
 	return &Logger{
 		debugLevel: debugLevel,
-		Logger:     log.New(os.Stdout, "", log.Ldate|log.Ltime),
+		Logger:     log.New(&syncWriter{w: stdoutWriter{}}, "", log.Ldate|log.Ltime),
 		exitFunc:   os.Exit, // Default to os.Exit
 	}
 }
 
+// SetRunID prefixes every subsequent log message, after the timestamp, with the run ID.
+// Console output is not prefixed.
+func (l *Logger) SetRunID(runID string) {
+	l.SetPrefix("[run " + runID + "] ")
+	l.SetFlags(l.Flags() | log.Lmsgprefix)
+}
+
 // Debug logs a debug message if the debug level is set to 1 or higher.
 func (l *Logger) Debug(v ...interface{}) {
 	if l.debugLevel >= 1 {
@@ -39,18 +70,27 @@ func (l *Logger) Info(v ...interface{}) {
 	}
 }
 
+// Console writes user-facing output without the log prefix.
+// The formatted text is emitted in a single write through the same writer as the log
+// messages, so a multi-line block printed by one goroutine is never split by another.
+func (l *Logger) Console(format string, v ...interface{}) {
+	_, _ = fmt.Fprintf(l.Writer(), format, v...)
+}
+
 // Fatalf logs a fatal error message and terminates the program.
 func (l *Logger) Fatalf(format string, v ...interface{}) {
 	l.Printf("[FATAL] "+format, v...)
 	l.exitFunc(1)
 }
 
-// Error logs an error message.
+// Error logs an error message regardless of the debug level.
 func (l *Logger) Error(v ...interface{}) {
 	l.Printf("[ERROR] %v", fmt.Sprintln(v...))
 }
 
-// Warn logs a warning message.
+// Warn logs a warning message if the debug level is sufficient.
 func (l *Logger) Warn(v ...interface{}) {
-	l.Printf("[WARN] %v", fmt.Sprintln(v...))
+	if l.debugLevel >= 0 {
+		l.Printf("[WARN] %v", fmt.Sprintln(v...))
+	}
 }
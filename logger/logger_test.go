@@ -2,7 +2,11 @@ package logger
 
 import (
 	"bytes"
+	"fmt"
 	"log"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -59,6 +63,40 @@ func TestInfo(t *testing.T) {
 	})
 }
 
+func TestWarn(t *testing.T) {
+	var buf bytes.Buffer
+
+	t.Run("Debug level 0", func(t *testing.T) {
+		logger := &Logger{debugLevel: 0, Logger: log.New(&buf, "", 0)}
+		logger.Warn("This is a warning message:", 2)
+		assert.Contains(t, buf.String(), "[WARN] This is a warning message: 2")
+	})
+
+	t.Run("Negative debug level", func(t *testing.T) {
+		buf.Reset()
+		logger := &Logger{debugLevel: -1, Logger: log.New(&buf, "", 0)}
+		logger.Warn("This is a warning message")
+		assert.Empty(t, buf.String())
+	})
+}
+
+func TestError(t *testing.T) {
+	var buf bytes.Buffer
+
+	t.Run("Debug level 0", func(t *testing.T) {
+		logger := &Logger{debugLevel: 0, Logger: log.New(&buf, "", 0)}
+		logger.Error("This is an error message:", fmt.Errorf("timeout"))
+		assert.Contains(t, buf.String(), "[ERROR] This is an error message: timeout")
+	})
+
+	t.Run("Negative debug level", func(t *testing.T) {
+		buf.Reset()
+		logger := &Logger{debugLevel: -1, Logger: log.New(&buf, "", 0)}
+		logger.Error("This is an error message")
+		assert.Contains(t, buf.String(), "[ERROR] This is an error message")
+	})
+}
+
 func TestFatalf(t *testing.T) {
 	var buf bytes.Buffer
 	var exitCode int
@@ -76,3 +114,55 @@ func TestFatalf(t *testing.T) {
 	assert.Equal(t, 1, exitCode)
 	assert.Contains(t, buf.String(), "[FATAL] This is a fatal error: test")
 }
+
+func TestConsole(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{debugLevel: 0, Logger: log.New(&buf, "", log.Ldate)}
+	logger.Console("Device %d:\n  Hostname: %s\n", 1, "fw-1")
+	assert.Equal(t, "Device 1:\n  Hostname: fw-1\n", buf.String())
+}
+
+func TestConcurrentOutput(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{debugLevel: 0, Logger: log.New(&syncWriter{w: &buf}, "", 0)}
+
+	const goroutines = 20
+	const linesPerGoroutine = 50
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < linesPerGoroutine; i++ {
+				if i%2 == 0 {
+					logger.Info(fmt.Sprintf("worker-%d message-%d", id, i))
+				} else {
+					logger.Console("worker-%d message-%d\n", id, i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, goroutines*linesPerGoroutine)
+
+	linePattern := regexp.MustCompile(`^(\[INFO\] )?worker-\d+ message-\d+$`)
+	for _, line := range lines {
+		assert.Regexp(t, linePattern, line)
+	}
+}
+
+func TestSetRunID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{debugLevel: 0, Logger: log.New(&buf, "", log.Ldate)}
+	logger.SetRunID("20240701T120000Z-0a1b2c3d")
+
+	logger.Info("Tagged message")
+	logger.Console("Console output\n")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Regexp(t, `^\d{4}/\d{2}/\d{2} \[run 20240701T120000Z-0a1b2c3d\] \[INFO\] Tagged message$`, lines[0])
+	assert.Equal(t, "Console output", lines[1])
+}
@@ -2,17 +2,38 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/devices"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/cleanup"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/consoleprint"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/export"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonl"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/junit"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/metrics"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/pdf"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/reachability"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/remediation"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/wildfire"
-	"log"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Main function to register WildFire on multiple devices concurrently.
@@ -20,124 +41,1227 @@ import (
 // and concurrently registers WildFire on each device. It uses goroutines for parallel processing
 // and reports the results for each device.
 func main() {
+	start := time.Now()
+
 	// Parse command-line flags
 	flags, _ := config.ParseFlags()
 
 	// Initialize logger
 	l := logger.New(flags.DebugLevel, flags.Verbose)
 
+	// Identify this run in the logs, reports and metrics
+	if flags.RunID == "" {
+		flags.RunID = newRunID(start)
+	}
+	if flags.LogRunID {
+		l.SetRunID(flags.RunID)
+	}
+	// Streamed results and dumps own stdout, so the log and console output move to stderr and the
+	// output stays parseable
+	if flags.JSONL || flags.DumpVersions != "" || flags.DumpConfig != "" {
+		l.SetOutput(os.Stderr)
+	}
+	var stream *jsonl.Writer
+	if flags.JSONL {
+		stream = jsonl.NewWriter(os.Stdout, flags.RunID)
+	}
+	l.Info("Run ID:", flags.RunID)
+	consoleprint.BufferSize = flags.ConsoleBufferSize
+	pdf.MaxCellLength = flags.MaxCellLength
+
+	// Refuse contradictory flags up front and point out the ones without effect
+	warnings, err := flags.Validate()
+	if err != nil {
+		l.Fatalf("Invalid flags: %v", err)
+	}
+	for _, warning := range warnings {
+		l.Warn(warning)
+	}
+
+	// Dump the minimum patched versions table and exit if requested
+	if flags.DumpVersions != "" {
+		dump, err := config.DumpMinimumPatchedVersions(flags.DumpVersions)
+		if err != nil {
+			l.Fatalf("Failed to dump minimum patched versions: %v", err)
+		}
+		fmt.Println(string(dump))
+		return
+	}
+
+	// Explain the classification of a single version and model and exit if requested
+	if flags.Explain != "" {
+		device, classification, err := filters.Explain(flags.Explain)
+		if err != nil {
+			l.Fatalf("Failed to explain %q: %v", flags.Explain, err)
+		}
+		consoleprint.PrintExplanation(device, classification, l)
+		return
+	}
+
+	// Remove generated files and exit if requested
+	if flags.Clean {
+		removed, err := cleanup.RemoveStale(cleanup.DefaultDirectories, flags.Retention, nil, time.Now())
+		if err != nil {
+			l.Fatalf("Failed to clean generated files: %v", err)
+		}
+		l.Info(fmt.Sprintf("Removed %d generated file(s)", len(removed)))
+		return
+	}
+
 	// Load configuration
 	conf, err := config.Load(flags.ConfigFile, flags.SecretsFile, flags)
 	if err != nil {
 		l.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Commands and registration outputs of the config file override the compiled-in ones
+	config.ApplyCommandOverrides(conf.Commands)
+	wildfire.SetSuccessMessages(conf.Commands)
+
+	// Dump the effective configuration and exit if requested
+	if flags.DumpConfig != "" {
+		dump, err := config.DumpSettings(config.EffectiveSettings(conf), flags.DumpConfig)
+		if err != nil {
+			l.Fatalf("Failed to dump the effective configuration: %v", err)
+		}
+		fmt.Println(string(dump))
+		return
+	}
+
+	// Cancel the run on SIGINT or once the overall timeout expires. Devices not processed yet then
+	// fail with the cancellation, while the reports are still written.
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		// A second SIGINT terminates the run immediately
+		<-sigCtx.Done()
+		stop()
+	}()
+	ctx := sigCtx
+	if flags.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(sigCtx, flags.Timeout)
+		defer cancel()
+	}
+
 	// Create DeviceManager
 	dm := devices.NewDeviceManager(conf, l)
 
+	// Read the inventory devices from a database instead of inventory.yaml
+	if flags.InventoryDSN != "" {
+		source, err := devices.NewSQLInventorySource(flags.InventoryDriver, flags.InventoryDSN, flags.InventoryQuery)
+		if err != nil {
+			l.Fatalf("Failed to set up the inventory database: %v", err)
+		}
+		defer source.DB.Close()
+		dm.SetInventorySource(source)
+	}
+
+	// Check that the management ports of the inventory devices accept connections and exit if requested
+	if flags.ValidateInventory {
+		inventory, err := dm.InventoryDevices()
+		if err != nil {
+			l.Fatalf("Failed to read the inventory: %v", err)
+		}
+		// The addresses are dialled by name, so each address of a DNS name is tried in turn
+		targets := make([]reachability.Target, 0, len(inventory))
+		for _, device := range inventory {
+			targets = append(targets, reachability.Target{Hostname: device.Hostname, Address: device.IPAddress})
+		}
+		l.Console("Checking TCP connectivity to the inventory devices...\n")
+		ports := []int{reachability.HTTPSPort, flags.SSHPort}
+		consoleprint.PrintReachability(reachability.CheckAll(ctx, targets, ports, reachability.DefaultTimeout, conf.Concurrency), l)
+		return
+	}
+
+	// The device export is written right after collection, so its format is checked up front
+	if flags.Export != "" && flags.ExportFormat != export.FormatJSON && flags.ExportFormat != export.FormatCSV {
+		l.Fatalf("Invalid -export-format %q: expected %s or %s", flags.ExportFormat, export.FormatJSON, export.FormatCSV)
+	}
+
+	// Read the state of the previous run up front, as this run may overwrite it with its own JSON report
+	var previousRun *jsonreport.Report
+	if flags.DeltaFrom != "" {
+		previous, err := jsonreport.ReadFile(flags.DeltaFrom)
+		if errors.Is(err, fs.ErrNotExist) {
+			l.Warn("No previous run found at", flags.DeltaFrom+", comparing to an empty run")
+		} else if err != nil {
+			l.Fatalf("Failed to read -delta-from: %v", err)
+		}
+		previousRun = &previous
+	}
+
+	// Named group export of the affected devices, validated before connecting to any device
+	var groupName, groupFile string
+	if flags.ExportGroup != "" {
+		if groupName, groupFile, err = remediation.ParseGroupExport(flags.ExportGroup); err != nil {
+			l.Fatalf("Invalid -export-group: %v", err)
+		}
+	}
+
+	// Expected WildFire registration output overrides, per PAN-OS major version
+	successMatches, err := wildfire.ParseSuccessMatches(flags.WildFireSuccessMatch)
+	if err != nil {
+		l.Fatalf("Invalid -wildfire-success-match: %v", err)
+	}
+	familyOpsTimeouts, err := wildfire.ParseFamilyTimeouts(flags.SSHFamilyOpsTimeout)
+	if err != nil {
+		l.Fatalf("Invalid -ssh-family-ops-timeout: %v", err)
+	}
+
+	// The WildFire registration settings, also used for the connectivity preflight
+	wildfireOptions := wildfire.Options{
+		Channel:           flags.WildFireChannel,
+		Server:            flags.WildFireServer,
+		SSHKey:            flags.SSHKey,
+		SSHKeyPassphrase:  flags.SSHKeyPassphrase,
+		Port:              flags.SSHPort,
+		SocketTimeout:     flags.SSHSocketTimeout,
+		OpsTimeout:        flags.SSHOpsTimeout,
+		FamilyOpsTimeouts: familyOpsTimeouts,
+		RecheckDelay:      flags.WildFireRecheck,
+		SuccessMatches:    successMatches,
+		CommandPrefix:     flags.WildFireCommandPrefix,
+		VerifyTimeout:     flags.WildFireVerifyTimeout,
+		VerifyInterval:    flags.WildFireVerifyInterval,
+	}
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		err := wildfire.RegisterWildFire(ctx, device, username, password, wildfireOptions, l)
+		recordCommandError(device, err)
+		return err
+	}
+
+	// Bound the simultaneous firewall connections across the certificate check and registration phases
+	connections := limiter.New(flags.MaxConnections)
+	dm.SetConnectionLimiter(connections)
+
+	// Restrict the run to devices with a matching certificate status if requested. The certificate
+	// status is then collected before classification instead of after registration.
+	// Ordering by certificate expiry needs the certificate status before registration as well.
+	orderBy, err := filters.ParseOrder(flags.OrderBy)
+	if err != nil {
+		l.Fatalf("Invalid -order-by: %v", err)
+	}
+	var collector deviceCollector = dm
+	var certFilter *certStatusCollector
+	if flags.FilterCertStatus != "" || orderBy == filters.OrderCertExpiry {
+		var statuses []string
+		if flags.FilterCertStatus != "" {
+			if statuses, err = filters.ParseCertStatuses(flags.FilterCertStatus); err != nil {
+				l.Fatalf("Invalid -filter-cert-status: %v", err)
+			}
+		}
+		certFilter = &certStatusCollector{source: dm, statuses: statuses, l: l}
+		collector = certFilter
+	}
+
+	// Each registration holds a connection slot and streams its result as soon as it is known
+	registerDevice := limitConnections(withDeviceCredentials(register, dm.FirewallCredentials), connections)
+	if stream != nil {
+		registerDevice = streamResults(registerDevice, stream)
+	}
+
+	// Register each inventory device right after collecting its facts, in the same worker, if requested
+	if flags.SinglePass {
+		dm.SetOnCollected(singlePassRegistration(registerDevice, filters.NewHardwareSelection(flags.Models, flags.Families), conf, l))
+	}
+
+	// Collect and classify the devices once; everything below works on these cached lists
+	deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates, versionParseFailed, err := collectAndClassify(ctx, collector, flags.NoPanorama)
+	if err != nil {
+		l.Fatalf("Failed to collect devices: %v", err)
+	}
+
+	// Devices whose PAN-OS version could not be parsed are not classified, they are reported but never registered
+	for _, device := range versionParseFailed {
+		l.Error(fmt.Sprintf("Skipping %s: %s", device["hostname"], device["version_error"]))
+	}
+	streamSkipped(stream, versionParseFailed, l)
+
+	// The safety guard compares the candidates against every collected device, before certificate filtering
+	rawDeviceList := deviceList
+	if certFilter != nil {
+		rawDeviceList = certFilter.collected
+	}
+
+	// Export the collected devices for other systems
+	if flags.Export != "" {
+		if err := export.WriteFile(flags.Export, flags.ExportFormat, deviceList); err != nil {
+			l.Error("Failed to export devices:", err)
+		}
+	}
+
+	// Print the device certificate expiry watchlist and exit if requested
+	if flags.CertExpiryReport {
+		if certFilter == nil {
+			consoleprint.PrintStartingDeviceCertificateVerification(l)
+			dm.GetDeviceCertificateStatus(ctx, deviceList)
+		}
+		consoleprint.PrintCertExpiryReport(compliance.SortByExpiry(deviceList, time.Now()), l)
+		return
+	}
+
+	// Print registration candidates list
+	consoleprint.PrintDeviceList(registrationCandidates, l, flags.Verbose)
+
+	// Print the devices grouped by feature release for upgrade planning
+	if flags.GroupByRelease {
+		classified := append(append(append([]map[string]string{}, ineligibleHardware...), unsupportedVersions...), registrationCandidates...)
+		consoleprint.PrintReleaseGroups(filters.GroupByFeatureRelease(classified), l)
+	}
+
+	// Refuse to register devices that did not go through classification
+	if !flags.ReportOnly {
+		if err := checkRegistrationSafety(rawDeviceList, registrationCandidates, flags.NoSafety); err != nil {
+			l.Fatalf("Refusing to run WildFire registration: %v", err)
+		}
+	}
+
+	// Devices marked skip_registration are audited, including their certificate status, but never registered
+	eligible, skippedByPolicy := skipByPolicy(registrationCandidates)
+	streamSkipped(stream, skippedByPolicy, l)
+
+	// Managed devices that are disconnected from Panorama are reported but not registered
+	eligible, disconnected := skipDisconnected(eligible)
+	if len(disconnected) > 0 {
+		l.Info(fmt.Sprintf("Skipping the WildFire registration of %d candidate(s) disconnected from Panorama", len(disconnected)))
+		streamSkipped(stream, disconnected, l)
+	}
+
+	// Only the selected models and families are registered, for a phased rollout
+	if selection := filters.NewHardwareSelection(flags.Models, flags.Families); !selection.IsEmpty() {
+		var unselected []map[string]string
+		eligible, unselected = skipUnselectedHardware(eligible, selection)
+		l.Info(fmt.Sprintf("Hardware selection: registering %d of %d candidate(s)", len(eligible), len(eligible)+len(unselected)))
+		streamSkipped(stream, unselected, l)
+	}
+
+	// Devices in maintenance mode or running an auto-commit are skipped, as registering them is pointless
+	if flags.HealthGate {
+		var unhealthy []map[string]string
+		eligible, unhealthy = skipUnhealthy(eligible, l)
+		streamSkipped(stream, unhealthy, l)
+	}
+
+	// Flag the candidates of split-brain HA pairs, whose peers both report active
+	splitBrain := flagSplitBrain(eligible, rawDeviceList, l)
+
+	// Check the SSH reachability and credentials of the candidates without registering and exit if requested
+	if flags.Preflight {
+		l.Console("Checking SSH connectivity to the registration candidates...\n")
+		preflight := func(device map[string]string, username, password string) wildfire.PreflightResult {
+			return wildfire.Preflight(device, username, password, wildfireOptions, l)
+		}
+		consoleprint.PrintPreflight(runPreflight(eligible, preflight, dm.FirewallCredentials, limiter.New(conf.Concurrency), connections), l)
+		return
+	}
+
+	// scheduled holds the candidates handed to registration, to spot devices that were never attempted
+	var processedResults []string
+	var scheduled []map[string]string
+	// The confirmation prompts share one reader, so input buffered by a prompt is kept for the next
+	answers := bufio.NewReader(os.Stdin)
+
+	switch {
+	case flags.ReportOnly:
+		// Report-only mode: the candidates are not registered, but the read-only certificate check still runs
+		var certs certChecker
+		if certFilter == nil && flags.CertCheck {
+			certs = dm
+		}
+		reportWithoutRegistration(ctx, eligible, deviceList, certs, l)
+		streamSkipped(stream, eligible, l)
+	case flags.Confirm && !confirmRegistration(answers, l, len(eligible)):
+		// The operator declined after reviewing the candidates
+		for i := range eligible {
+			eligible[i]["result"] = "Skipped WildFire registration (not confirmed)"
+		}
+		streamSkipped(stream, eligible, l)
+	default:
+		// Print message before starting firewall connections
+		consoleprint.PrintStartingFirewallConnections(l)
+
+		// Register WildFire for the already collected registration candidates, except the ones
+		// registered while they were collected with -single-pass
+		toRegister, registered := withoutSinglePass(eligible)
+		for _, device := range registered {
+			processedResults = append(processedResults, device["hostname"]+": "+device["result"])
+		}
+		scheduled = registered
+		// Registering both peers of a split-brain pair is wrong, so they need an explicit confirmation
+		if len(splitBrain) > 0 && !confirmSplitBrain(answers, l, len(splitBrain)) {
+			toRegister = withoutDevices(toRegister, splitBrain)
+			for _, device := range splitBrain {
+				device["result"] = "Skipped WildFire registration (HA split-brain, not confirmed)"
+			}
+			streamSkipped(stream, splitBrain, l)
+		}
+		// Registrations start in this order, so the most-at-risk devices can go first
+		toRegister = filters.OrderDevices(toRegister, orderBy, time.Now())
+		scheduled = append(scheduled, toRegister...)
+		processedResults = append(processedResults, registerCandidates(ctx, toRegister, registerDevice, conf, l)...)
+		// Candidates cancelled before their registration started were not streamed yet
+		streamSkipped(stream, cancelledRegistrations(toRegister), l)
+	}
+
+	if err := ctx.Err(); err != nil {
+		l.Warn("Run cancelled, the devices not processed yet are reported as failed:", err)
+	}
+
+	// Get device certificate status for all devices, unless it was already collected for filtering
+	// or in report-only mode
+	if certFilter == nil && !flags.ReportOnly {
+		consoleprint.PrintStartingDeviceCertificateVerification(l)
+
+		dm.GetDeviceCertificateStatus(ctx, deviceList)
+	}
+
+	// Registered devices may re-fetch their device certificate, which the check above can miss
+	if flags.CertRecheckGrace > 0 {
+		recheckCertificates(ctx, deviceList, registrationCandidates, dm, flags.CertRecheckGrace, l)
+	}
+
+	// Print out errors for each device
+	consoleprint.PrintDeviceErrors(deviceList, l)
+
+	// Effective settings of this run, listed in the report appendix
+	settings := config.EffectiveSettings(conf)
+
+	// Generate PDF report, falling back to a JSON report so the results are not lost
+	reportName := "device_report.pdf"
+	fallbackReport := filepath.Join("report", "device_report.json")
+	writeFallback := func() error {
+		if err := os.MkdirAll(filepath.Dir(fallbackReport), 0755); err != nil {
+			return err
+		}
+		report := buildJSONReport(flags.RunID, settings, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates)
+		if flags.JSONIncludeRaw {
+			report.IncludeRaw(deviceList)
+		}
+		return jsonreport.WriteFile(fallbackReport, report)
+	}
+	// An encrypted report must not be replaced by a plaintext one
+	if flags.ReportPassword != "" {
+		writeFallback = nil
+	}
+	err = generateReportWithFallback(func() error {
+		return pdf.GeneratePDFReport(deviceList, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates, settings, reportName, flags.ReportPassword)
+	}, writeFallback, l)
+	if err != nil {
+		l.Fatalf("Error generating report: %v", err)
+	}
+
+	// Write JSON report
+	if flags.JSONReport != "" {
+		report := buildJSONReport(flags.RunID, settings, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates)
+		if flags.JSONIncludeRaw {
+			report.IncludeRaw(deviceList)
+		}
+		if err := jsonreport.WriteFile(flags.JSONReport, report); err != nil {
+			l.Error("Failed to write JSON report:", err)
+		}
+	}
+
+	// Write JUnit report for CI
+	if flags.JUnitReport != "" {
+		report := buildJSONReport(flags.RunID, settings, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates)
+		if err := junit.WriteFile(flags.JUnitReport, junit.Build(report)); err != nil {
+			l.Error("Failed to write JUnit report:", err)
+		}
+	}
+
+	// Compare this run to the previous one for trend analysis
+	if previousRun != nil {
+		delta := jsonreport.Compare(*previousRun, buildJSONReport(flags.RunID, settings, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates))
+		consoleprint.PrintDelta(delta, l)
+		if flags.DeltaReport != "" {
+			if err := jsonreport.WriteDelta(flags.DeltaReport, delta); err != nil {
+				l.Error("Failed to write delta report:", err)
+			}
+		}
+	}
+
+	// Write the upgrade paths of the unsupported-version devices for remediation tickets
+	if flags.UpgradeExport != "" {
+		if err := remediation.WriteFile(flags.UpgradeExport, remediation.Build(unsupportedVersions)); err != nil {
+			l.Error("Failed to write upgrade export:", err)
+		}
+	}
+
+	// Write the step-by-step upgrade runbook of the unsupported-version devices
+	if flags.Runbook != "" {
+		if err := remediation.WriteRunbook(flags.Runbook, remediation.Build(unsupportedVersions)); err != nil {
+			l.Error("Failed to write remediation runbook:", err)
+		}
+	}
+
+	// Write the serials of the unsupported-version devices as a group for downstream automation
+	if groupFile != "" {
+		if err := remediation.WriteGroup(groupFile, remediation.BuildGroup(groupName, unsupportedVersions)); err != nil {
+			l.Error("Failed to write group export:", err)
+		}
+	}
+
+	// Print results, after the devices that could not be classified
+	consoleprint.PrintVersionParseFailures(versionParseFailed, l)
+	consoleprint.PrintResults(processedResults, scheduled, l)
+
+	// Write run metrics
+	runMetrics := metrics.FromResults(deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates, processedResults, time.Since(start).Seconds())
+	runMetrics.RunID = flags.RunID
+	if flags.MetricsFile != "" {
+		if err := metrics.WriteFile(flags.MetricsFile, runMetrics); err != nil {
+			l.Error("Failed to write metrics file:", err)
+		}
+	}
+
+	// Exit code of the run, recorded in the run summary
+	exitCode, exitReason := runExitCode(flags, runMetrics, len(scheduled))
+
+	// Write the machine-readable run summary for automation
+	if flags.SummaryJSON != "" {
+		report := buildJSONReport(flags.RunID, settings, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates)
+		if err := jsonreport.WriteSummary(flags.SummaryJSON, jsonreport.BuildSummary(report, exitCode)); err != nil {
+			l.Error("Failed to write run summary:", err)
+		}
+	}
+
+	// Remove stale generated files, keeping the outputs of this run
+	if flags.Retention > 0 {
+		keep := []string{filepath.Join("report", reportName), fallbackReport, flags.MetricsFile, flags.SummaryJSON, flags.JSONReport, flags.JUnitReport, flags.UpgradeExport, flags.Runbook, groupFile, flags.Export, flags.DeltaFrom, flags.DeltaReport}
+		removed, err := cleanup.RemoveStale(cleanup.DefaultDirectories, flags.Retention, keep, time.Now())
+		if err != nil {
+			l.Warn("Failed to remove stale generated files:", err)
+		}
+		l.Debug("Removed stale generated files:", removed)
+	}
+
+	if exitCode != 0 {
+		l.Error(exitReason)
+		os.Exit(exitCode)
+	}
+}
+
+// runExitCode returns the exit code of the run and, when it is not 0, the reason to log. Registration
+// failures fail the run in strict mode, which -summary-json implies for automation, and when too
+// large a share of the scheduled registrations failed.
+func runExitCode(flags *config.Flags, runMetrics metrics.RunMetrics, scheduled int) (int, string) {
+	if flags.Strict || flags.SummaryJSON != "" {
+		if code := strictExitCode(runMetrics, flags.StrictSoftFail); code != 0 {
+			return code, fmt.Sprintf("Strict mode: %d WildFire registration(s) failed, %d soft failure(s)", runMetrics.RegistrationFailed, runMetrics.RegistrationSoftFailed)
+		}
+	}
+	if flags.FailThresholdPct > 0 {
+		if code := failureRateExitCode(runMetrics, scheduled, flags.FailThresholdPct, flags.StrictSoftFail); code != 0 {
+			return code, fmt.Sprintf("Failure rate threshold exceeded: more than %g%% of %d WildFire registration(s) failed", flags.FailThresholdPct, scheduled)
+		}
+	}
+	return 0, ""
+}
+
+// failureRateExitCode returns the exit code of a run with a failure rate threshold: 1 if more than
+// thresholdPct percent of the scheduled registrations did not succeed and 0 otherwise. Scheduled
+// devices without a result count as failures; soft failures only count when includeSoftFailures is set.
+func failureRateExitCode(runMetrics metrics.RunMetrics, scheduled int, thresholdPct float64, includeSoftFailures bool) int {
+	if scheduled == 0 {
+		return 0
+	}
+	failures := scheduled - runMetrics.RegistrationSucceeded
+	if !includeSoftFailures {
+		failures -= runMetrics.RegistrationSoftFailed
+	}
+	if failureRate(failures, scheduled) > thresholdPct {
+		return 1
+	}
+	return 0
+}
+
+// failureRate returns the percentage of failures out of total
+func failureRate(failures, total int) float64 {
+	return float64(failures) * 100 / float64(total)
+}
+
+// strictExitCode returns the exit code of a strict run: 1 if any registration failed and 0 otherwise.
+// Soft failures are retryable and only count when includeSoftFailures is set.
+func strictExitCode(runMetrics metrics.RunMetrics, includeSoftFailures bool) int {
+	failures := runMetrics.RegistrationFailed
+	if includeSoftFailures {
+		failures += runMetrics.RegistrationSoftFailed
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// newRunID generates a unique run identifier from the start time and a random suffix,
+// e.g. "20240701T120000Z-0a1b2c3d".
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		// The timestamp alone still identifies the run in practice
+		return now.UTC().Format("20060102T150405Z")
+	}
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// buildJSONReport creates the JSON report of the run, tagged with the run ID and the effective settings.
+func buildJSONReport(runID string, settings []config.Setting, deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates []map[string]string) jsonreport.Report {
+	report := jsonreport.Build(deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates, time.Now())
+	report.RunID = runID
+	report.Configuration = settings
+	return report
+}
+
+// deviceCollector retrieves the list of devices to work on
+type deviceCollector interface {
+	GetDeviceList(ctx context.Context, noPanorama bool) ([]map[string]string, error)
+}
+
+// certChecker collects the device certificate status of devices
+type certChecker interface {
+	GetDeviceCertificateStatus(ctx context.Context, deviceList []map[string]string)
+}
+
+// certStatusSource retrieves the devices and their device certificate status
+type certStatusSource interface {
+	deviceCollector
+	certChecker
+}
+
+// certStatusCollector is a deviceCollector that collects the device certificate status right
+// after collecting the devices and keeps only the devices with one of the requested statuses.
+type certStatusCollector struct {
+	source    certStatusSource
+	statuses  []string
+	l         *logger.Logger
+	collected []map[string]string
+}
+
+// GetDeviceList collects the devices and their certificate status, and returns the devices
+// matching the requested certificate statuses, or every device when none is requested. Every
+// collected device is kept in collected.
+func (c *certStatusCollector) GetDeviceList(ctx context.Context, noPanorama bool) ([]map[string]string, error) {
+	deviceList, err := c.source.GetDeviceList(ctx, noPanorama)
+	if err != nil {
+		return nil, err
+	}
+
+	consoleprint.PrintStartingDeviceCertificateVerification(c.l)
+	c.source.GetDeviceCertificateStatus(ctx, deviceList)
+	c.collected = deviceList
+	if len(c.statuses) == 0 {
+		return deviceList, nil
+	}
+
+	filtered := filters.FilterDevicesByCertStatus(deviceList, c.statuses)
+	c.l.Info(fmt.Sprintf("Devices matching certificate status %s: %d out of %d", strings.Join(c.statuses, ","), len(filtered), len(deviceList)))
+	return filtered, nil
+}
+
+// runPreflight runs the connectivity preflight of every candidate with its credentials, at most
+// as many at once as workers and connections allow, and returns the results in the candidates' order
+func runPreflight(candidates []map[string]string, preflight func(device map[string]string, username, password string) wildfire.PreflightResult,
+	credentials func(device map[string]string) devices.Credentials, workers, connections *limiter.Limiter) []wildfire.PreflightResult {
+	results := make([]wildfire.PreflightResult, len(candidates))
+	var wg sync.WaitGroup
+	for i, device := range candidates {
+		wg.Add(1)
+		workers.Acquire()
+		go func(index int, dev map[string]string) {
+			defer wg.Done()
+			defer workers.Release()
+			connections.Acquire()
+			defer connections.Release()
+			c := credentials(dev)
+			results[index] = preflight(dev, c.Username, c.Password)
+		}(i, device)
+	}
+	wg.Wait()
+	return results
+}
+
+// registerFunc registers WildFire on a single device
+type registerFunc func(device map[string]string, username, password string, l *logger.Logger) error
+
+// withDeviceCredentials wraps a registerFunc so each device is registered with the credentials
+// returned for it, such as its own credentials from the inventory, instead of the shared ones
+func withDeviceCredentials(register registerFunc, credentials func(device map[string]string) devices.Credentials) registerFunc {
+	return func(device map[string]string, username, password string, l *logger.Logger) error {
+		c := credentials(device)
+		return register(device, c.Username, c.Password, l)
+	}
+}
+
+// limitConnections wraps a registerFunc so each registration holds a slot of the shared connection limiter
+func limitConnections(register registerFunc, connections *limiter.Limiter) registerFunc {
+	return func(device map[string]string, username, password string, l *logger.Logger) error {
+		connections.Acquire()
+		defer connections.Release()
+		return register(device, username, password, l)
+	}
+}
+
+// reportWithoutRegistration marks the registration candidates of a report-only run as skipped,
+// without connecting to them. The device certificate status of every device is still collected
+// from certs, as `show device-certificate status` does not change the devices, unless certs is nil.
+func reportWithoutRegistration(ctx context.Context, candidates, deviceList []map[string]string, certs certChecker, l *logger.Logger) {
+	for _, device := range candidates {
+		device["result"] = "Skipped WildFire registration (Report-only mode)"
+	}
+	if certs != nil {
+		consoleprint.PrintStartingDeviceCertificateVerification(l)
+		certs.GetDeviceCertificateStatus(ctx, deviceList)
+	}
+}
+
+// recheckCertificates waits grace for the devices registered by this run to re-fetch their device
+// certificate, then collects their certificate status again, so the reports show the updated status.
+// The candidates are copies of the collected devices, so the devices of deviceList that match a
+// registered candidate by serial and hostname are rechecked. Devices whose status changed are logged.
+// It returns the rechecked devices, or nil if ctx is done before the grace period ends.
+func recheckCertificates(ctx context.Context, deviceList, candidates []map[string]string, certs certChecker, grace time.Duration, l *logger.Logger) []map[string]string {
+	succeeded := make(map[string]bool)
+	for _, device := range candidates {
+		if strings.HasPrefix(device["result"], "Successfully registered") {
+			succeeded[device["serial"]+"|"+device["hostname"]] = true
+		}
+	}
+	var registered []map[string]string
+	for _, device := range deviceList {
+		if succeeded[device["serial"]+"|"+device["hostname"]] {
+			registered = append(registered, device)
+		}
+	}
+	if len(registered) == 0 {
+		return nil
+	}
+
+	before := make([]string, len(registered))
+	for i, device := range registered {
+		before[i] = filters.CertStatus(device)
+	}
+
+	l.Info(fmt.Sprintf("Waiting %s before rechecking the device certificate status of %d registered device(s)", grace, len(registered)))
+	select {
+	case <-time.After(grace):
+	case <-ctx.Done():
+		l.Warn("Device certificate status recheck cancelled:", ctx.Err())
+		return nil
+	}
+	certs.GetDeviceCertificateStatus(ctx, registered)
+
+	for i, device := range registered {
+		if after := filters.CertStatus(device); after != before[i] {
+			l.Info(fmt.Sprintf("Device certificate status of %s changed from %s to %s after registration", device["hostname"], before[i], after))
+		}
+	}
+	return registered
+}
+
+// streamResults wraps a registerFunc so the result of each registration is streamed as soon as
+// it is known, including the failure of a registration that panics
+func streamResults(register registerFunc, stream *jsonl.Writer) registerFunc {
+	return func(device map[string]string, username, password string, l *logger.Logger) (err error) {
+		start := time.Now()
+		defer func() {
+			r := recover()
+			result := registrationResult(err)
+			if r != nil {
+				result = fmt.Sprintf("Failed to register WildFire - panic: %v", r)
+			}
+			if err := stream.Device(device, result, time.Since(start)); err != nil {
+				l.Warn("Failed to stream registration result:", err)
+			}
+			// The panic is still recovered and reported by registerCandidates
+			if r != nil {
+				panic(r)
+			}
+		}()
+		return register(device, username, password, l)
+	}
+}
+
+// streamSkipped streams the result of devices that are not registered, if results are streamed
+func streamSkipped(stream *jsonl.Writer, skipped []map[string]string, l *logger.Logger) {
+	if stream == nil {
+		return
+	}
+	for _, device := range skipped {
+		if err := stream.Device(device, device["result"], 0); err != nil {
+			l.Warn("Failed to stream registration result:", err)
+		}
+	}
+}
+
+// recordCommandError records the command a device rejected during registration, and its output,
+// in the "failed_command" and "failed_output" fields of the device for the reports
+func recordCommandError(device map[string]string, err error) {
+	var commandErr *wildfire.CommandError
+	if errors.As(err, &commandErr) {
+		device["failed_command"] = commandErr.Command
+		device["failed_output"] = commandErr.Output
+	}
+}
+
+// registrationResult returns the result text of a registration that returned err
+func registrationResult(err error) string {
+	switch {
+	case wildfire.IsSoftFailure(err):
+		return fmt.Sprintf("Soft failure registering WildFire - %v", err)
+	case err != nil:
+		return fmt.Sprintf("Failed to register WildFire - %v", err)
+	default:
+		return "Successfully registered WildFire"
+	}
+}
+
+// collectAndClassify retrieves the device list once and splits it into ineligible hardware,
+// unsupported versions and registration candidates, plus the devices whose PAN-OS version could
+// not be parsed. The returned lists are reused for the rest of the run so that confirming and
+// registering never triggers a second collection.
+func collectAndClassify(ctx context.Context, collector deviceCollector, noPanorama bool) (deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates, versionParseFailed []map[string]string, err error) {
 	// Get device list
-	deviceList, err := dm.GetDeviceList(flags.NoPanorama)
+	deviceList, err = collector.GetDeviceList(ctx, noPanorama)
 	if err != nil {
-		l.Fatalf("Failed to get device list: %v", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("failed to get device list: %v", err)
 	}
 
 	// Check if we got any devices
 	if len(deviceList) == 0 {
-		l.Fatalf("No devices were successfully processed")
+		return nil, nil, nil, nil, nil, fmt.Errorf("no devices were successfully processed")
 	}
 
 	// Filter devices by hardware family
 	eligibleHardware, ineligibleHardware := filters.FilterDevicesByFamily(deviceList)
 
-	// Parse versions and update eligibleHardware
-	for i, device := range eligibleHardware {
+	// Parse versions and update eligibleHardware. A device whose version is blank or cannot be
+	// parsed is skipped and reported instead of failing the run.
+	eligibleHardware, versionParseFailed = parseVersions(eligibleHardware)
+
+	// Split eligible hardware devices into supported and unsupported versions
+	supportedVersions, unsupportedVersions := filters.SplitDevicesByVersion(eligibleHardware)
+
+	// Classification copies the devices and resets their result, which keeps the result of the
+	// devices already registered while they were collected
+	restoreSinglePassResults(supportedVersions, deviceList)
+
+	// The registrationCandidates are the devices with supported versions
+	return deviceList, ineligibleHardware, unsupportedVersions, supportedVersions, versionParseFailed, nil
+}
+
+// checkRegistrationSafety guards against registering devices that were not classified.
+// Every candidate must classify as a registration candidate on its own facts, and a candidate
+// set that covers the entire raw device list is refused unless noSafety is set, because it
+// implies that no filtering happened.
+func checkRegistrationSafety(deviceList, registrationCandidates []map[string]string, noSafety bool) error {
+	for _, device := range registrationCandidates {
+		if classification := filters.Classify(device); classification.Category != filters.Candidate {
+			return fmt.Errorf("device %s did not pass classification (%s): %s", device["hostname"], classification.Category, classification.Rationale)
+		}
+	}
+
+	if !noSafety && len(registrationCandidates) > 0 && len(registrationCandidates) == len(deviceList) {
+		return fmt.Errorf("all %d collected devices are registration candidates, which suggests classification was bypassed; use -no-safety to register them anyway", len(deviceList))
+	}
+
+	return nil
+}
+
+// generateReportWithFallback generates the PDF report and, if that fails or panics, writes the
+// fallback report instead and logs a warning. An error is only returned when both fail, or when
+// the PDF fails and writeFallback is nil.
+func generateReportWithFallback(generatePDF, writeFallback func() error, l *logger.Logger) error {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("PDF generator panicked: %v", r)
+			}
+		}()
+		return generatePDF()
+	}()
+	if err == nil {
+		return nil
+	}
+
+	if writeFallback == nil {
+		return fmt.Errorf("failed to generate PDF report: %w", err)
+	}
+
+	l.Warn("Failed to generate PDF report, writing a JSON report instead:", err)
+	if fallbackErr := writeFallback(); fallbackErr != nil {
+		return fmt.Errorf("failed to generate PDF report: %v; failed to write fallback JSON report: %w", err, fallbackErr)
+	}
+	return nil
+}
+
+// confirmRegistration asks the operator to approve registration of the listed candidates.
+// Only an explicit "y" or "yes" answer approves; anything else, including EOF, declines.
+func confirmRegistration(in *bufio.Reader, l *logger.Logger, candidateCount int) bool {
+	l.Console("Proceed with WildFire registration on %d device(s)? [y/N]: ", candidateCount)
+
+	answered, confirmed := readConfirmation(in)
+	switch {
+	case !answered:
+		l.Info("No confirmation received, skipping WildFire registration")
+	case !confirmed:
+		l.Info("WildFire registration not confirmed, skipping")
+	}
+	return confirmed
+}
+
+// confirmSplitBrain asks the operator to approve registration of the candidates of split-brain HA
+// pairs, with the same answers as confirmRegistration.
+func confirmSplitBrain(in *bufio.Reader, l *logger.Logger, deviceCount int) bool {
+	l.Console("Both HA peers report active on %d device(s). Register WildFire on them anyway? [y/N]: ", deviceCount)
+
+	answered, confirmed := readConfirmation(in)
+	switch {
+	case !answered:
+		l.Info("No confirmation received, skipping WildFire registration on split-brain HA peers")
+	case !confirmed:
+		l.Info("WildFire registration on split-brain HA peers not confirmed, skipping them")
+	}
+	return confirmed
+}
+
+// readConfirmation reads a single answer line, leaving the following lines for the next prompt. It
+// reports whether an answer was received and whether it is an explicit "y" or "yes".
+func readConfirmation(in *bufio.Reader) (answered, confirmed bool) {
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return false, false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return true, answer == "y" || answer == "yes"
+}
+
+// flagSplitBrain flags the registration candidates whose HA peer, found in deviceList, also
+// reports active by recording the peer serial in "ha_split_brain", and returns them.
+func flagSplitBrain(registrationCandidates, deviceList []map[string]string, l *logger.Logger) []map[string]string {
+	peers := filters.SplitBrainPeers(deviceList)
+
+	var flagged []map[string]string
+	for _, device := range registrationCandidates {
+		peer, ok := peers[device["serial"]]
+		if !ok {
+			continue
+		}
+		device["ha_split_brain"] = peer
+		flagged = append(flagged, device)
+		l.Warn(fmt.Sprintf("%s: both HA peers report active (peer serial %s), the pair may be in a split-brain state", device["hostname"], peer))
+	}
+	return flagged
+}
+
+// resultVersionNotCollected is the result of the devices whose PAN-OS version was not collected
+const resultVersionNotCollected = "Skipped WildFire registration (PAN-OS version not collected)"
+
+// resultVersionUnparseable is the result of the devices whose PAN-OS version could not be parsed
+const resultVersionUnparseable = "Skipped WildFire registration (unparseable PAN-OS version)"
+
+// parseVersions adds the parsed version components to the devices and returns them, followed by
+// the devices whose version is blank, e.g. a blank sw-version in their system information, or cannot
+// be parsed. The result of those records that they were skipped and their "version_error" why.
+func parseVersions(deviceList []map[string]string) (parsed, failed []map[string]string) {
+	parsed = make([]map[string]string, 0, len(deviceList))
+	for _, device := range deviceList {
 		swVersion := device["sw-version"]
+		if strings.TrimSpace(swVersion) == "" {
+			device["result"] = resultVersionNotCollected
+			device["version_error"] = "PAN-OS version was not collected, check that the device answers show system info"
+			failed = append(failed, device)
+			continue
+		}
 		parsedVersion, err := filters.ParseVersion(swVersion)
 		if err != nil {
-			l.Fatalf("Failed to parse version for device %s: %v", device["hostname"], err)
+			device["result"] = resultVersionUnparseable
+			device["version_error"] = fmt.Sprintf("failed to parse PAN-OS version %q: %v", swVersion, err)
+			failed = append(failed, device)
+			continue
 		}
 
 		// Add parsed version components to the device map
-		eligibleHardware[i]["parsed_version_major"] = fmt.Sprintf("%d", parsedVersion.Major)
-		eligibleHardware[i]["parsed_version_feature"] = fmt.Sprintf("%d", parsedVersion.Feature)
-		eligibleHardware[i]["parsed_version_maintenance"] = fmt.Sprintf("%d", parsedVersion.Maintenance)
-		eligibleHardware[i]["parsed_version_hotfix"] = fmt.Sprintf("%d", parsedVersion.Hotfix)
+		device["parsed_version_major"] = fmt.Sprintf("%d", parsedVersion.Major)
+		device["parsed_version_feature"] = fmt.Sprintf("%d", parsedVersion.Feature)
+		device["parsed_version_maintenance"] = fmt.Sprintf("%d", parsedVersion.Maintenance)
+		device["parsed_version_hotfix"] = fmt.Sprintf("%d", parsedVersion.Hotfix)
+		if parsedVersion.Prerelease != "" {
+			device["parsed_version_prerelease"] = parsedVersion.Prerelease
+		}
+		parsed = append(parsed, device)
 	}
+	return parsed, failed
+}
 
-	// Split eligible hardware devices into supported and unsupported versions
-	supportedVersions, unsupportedVersions, err := filters.SplitDevicesByVersion(eligibleHardware)
-	if err != nil {
-		l.Fatalf("Failed to split devices by version: %v", err)
+// resultSkippedByPolicy is the result of the candidates marked skip_registration
+const resultSkippedByPolicy = "registration skipped by policy"
+
+// skipByPolicy splits the candidates into the eligible ones, which are returned first, and the ones
+// marked skip_registration, whose result records that their registration is skipped.
+func skipByPolicy(registrationCandidates []map[string]string) (eligible, skipped []map[string]string) {
+	eligible = make([]map[string]string, 0, len(registrationCandidates))
+	for _, device := range registrationCandidates {
+		if filters.SkipsRegistration(device) {
+			device["result"] = resultSkippedByPolicy
+			skipped = append(skipped, device)
+			continue
+		}
+		eligible = append(eligible, device)
 	}
+	return eligible, skipped
+}
 
-	// The registrationCandidates are the devices with supported versions
-	registrationCandidates := supportedVersions
+// singlePassKey marks the devices registered while they were collected with -single-pass
+const singlePassKey = "single_pass"
 
-	// Print registration candidates list
-	consoleprint.PrintDeviceList(registrationCandidates, l, flags.Verbose)
+// singlePassRegistration returns the function registering an inventory device right after its facts
+// were collected, in the worker that collected them, for -single-pass. Only a device that classifies
+// as a registration candidate on its own facts, and that no policy, hardware selection or health
+// check skips, is registered. The others are left to the classification after collection, which sets
+// their result. A registered device records its result and is marked with singlePassKey, so the
+// registration phase does not register it again.
+func singlePassRegistration(register registerFunc, selection filters.HardwareSelection, conf *config.Config, l *logger.Logger) devices.CollectedFunc {
+	return func(ctx context.Context, device map[string]string) {
+		if filters.Classify(device).Category != filters.Candidate || filters.SkipsRegistration(device) || filters.IsDisconnected(device) || !selection.Matches(device) {
+			return
+		}
+		if conf.HealthGate && filters.UnhealthyReason(device) != "" {
+			return
+		}
+		// No registration is started once ctx is done, the registration phase then reports it as cancelled
+		if ctx.Err() != nil {
+			return
+		}
+		// The registration command depends on the parsed PAN-OS version, which Classify does not record
+		parseVersions([]map[string]string{device})
 
-	// Print message before starting firewall connections
-	consoleprint.PrintStartingFirewallConnections(l)
+		start := time.Now()
+		defer func() {
+			device["register_duration_ms"] = strconv.FormatInt(time.Since(start).Milliseconds(), 10)
+			device[singlePassKey] = "true"
+			// A panic while registering fails the device instead of dropping it from the collection
+			if r := recover(); r != nil {
+				l.Debug("Recovered panic:", r, string(debug.Stack()))
+				device["result"] = fmt.Sprintf("Failed to register WildFire - panic: %v", r)
+			}
+		}()
+		err := register(device, conf.Auth.Credentials.Firewall.Username, conf.Auth.Credentials.Firewall.Password, l)
+		device["result"] = registrationResult(err)
+	}
+}
 
-	var processedResults []string
+// restoreSinglePassResults copies the registration result of the devices registered with -single-pass
+// from the collected devices onto their classified copies, matched by serial and hostname
+func restoreSinglePassResults(registrationCandidates, deviceList []map[string]string) {
+	results := make(map[string]string)
+	for _, device := range deviceList {
+		if device[singlePassKey] == "true" {
+			results[device["serial"]+"|"+device["hostname"]] = device["result"]
+		}
+	}
+	for _, device := range registrationCandidates {
+		if result, ok := results[device["serial"]+"|"+device["hostname"]]; ok {
+			device["result"] = result
+		}
+	}
+}
 
-	if !flags.ReportOnly {
-		// Register WildFire for registration candidates
-		results := make(chan string, len(registrationCandidates))
-		var wg sync.WaitGroup
+// withoutSinglePass splits the candidates into the ones still to register and the ones registered
+// while they were collected with -single-pass
+func withoutSinglePass(registrationCandidates []map[string]string) (toRegister, registered []map[string]string) {
+	toRegister = make([]map[string]string, 0, len(registrationCandidates))
+	for _, device := range registrationCandidates {
+		if device[singlePassKey] == "true" {
+			registered = append(registered, device)
+			continue
+		}
+		toRegister = append(toRegister, device)
+	}
+	return toRegister, registered
+}
+
+// resultDisconnected is the result of the candidates Panorama reports as disconnected
+const resultDisconnected = "Skipped WildFire registration (disconnected from Panorama)"
 
+// skipDisconnected splits the candidates into the connected ones, which are returned first, and the
+// ones Panorama reports as disconnected with -panorama-scope all, whose result records that their
+// registration is skipped.
+func skipDisconnected(registrationCandidates []map[string]string) (connected, disconnected []map[string]string) {
+	connected = make([]map[string]string, 0, len(registrationCandidates))
+	for _, device := range registrationCandidates {
+		if filters.IsDisconnected(device) {
+			device["result"] = resultDisconnected
+			disconnected = append(disconnected, device)
+			continue
+		}
+		connected = append(connected, device)
+	}
+	return connected, disconnected
+}
+
+// resultNotSelected is the result of the candidates outside the -model and -family selection
+const resultNotSelected = "Skipped WildFire registration (model or family not selected)"
+
+// skipUnselectedHardware splits the candidates into the selected ones, which are returned first, and
+// the others, whose result records that their registration is skipped, see filters.HardwareSelection.
+func skipUnselectedHardware(registrationCandidates []map[string]string, selection filters.HardwareSelection) (selected, unselected []map[string]string) {
+	selected = make([]map[string]string, 0, len(registrationCandidates))
+	for _, device := range registrationCandidates {
+		if !selection.Matches(device) {
+			device["result"] = resultNotSelected
+			unselected = append(unselected, device)
+			continue
+		}
+		selected = append(selected, device)
+	}
+	return selected, unselected
+}
+
+// skipUnhealthy splits the candidates into the healthy ones, which are returned first, and the
+// unhealthy ones, whose result records why their registration is skipped, see filters.UnhealthyReason.
+func skipUnhealthy(registrationCandidates []map[string]string, l *logger.Logger) (healthy, unhealthy []map[string]string) {
+	healthy = make([]map[string]string, 0, len(registrationCandidates))
+	for _, device := range registrationCandidates {
+		if reason := filters.UnhealthyReason(device); reason != "" {
+			l.Warn(fmt.Sprintf("Skipping WildFire registration of %s: %s", device["hostname"], reason))
+			device["result"] = fmt.Sprintf("Skipped WildFire registration (unhealthy: %s)", reason)
+			unhealthy = append(unhealthy, device)
+			continue
+		}
+		healthy = append(healthy, device)
+	}
+	return healthy, unhealthy
+}
+
+// withoutDevices returns the devices of deviceList that are not in excluded.
+func withoutDevices(deviceList, excluded []map[string]string) []map[string]string {
+	skip := make(map[string]bool, len(excluded))
+	for _, device := range excluded {
+		skip[device["serial"]] = true
+	}
+
+	var remaining []map[string]string
+	for _, device := range deviceList {
+		if !skip[device["serial"]] {
+			remaining = append(remaining, device)
+		}
+	}
+	return remaining
+}
+
+// errRegistrationCancelled is the error of the candidates whose registration was not started
+// because the run was cancelled
+var errRegistrationCancelled = errors.New("registration cancelled")
+
+// cancelledRegistrations returns the candidates whose registration was not started because the run
+// was cancelled.
+func cancelledRegistrations(registrationCandidates []map[string]string) []map[string]string {
+	var cancelled []map[string]string
+	for _, device := range registrationCandidates {
+		if strings.HasPrefix(device["result"], registrationResult(errRegistrationCancelled)) {
+			cancelled = append(cancelled, device)
+		}
+	}
+	return cancelled
+}
+
+// registerCandidates registers WildFire on each candidate concurrently, at most conf.Concurrency at
+// a time, or one at a time when registrations are serialized, and records the outcome in the
+// candidate's "result" field. It returns the per-device result lines. Once ctx is done, no further
+// registration is started and the remaining candidates fail with errRegistrationCancelled, while
+// the registrations in flight run to completion.
+func registerCandidates(ctx context.Context, registrationCandidates []map[string]string, register registerFunc, conf *config.Config, l *logger.Logger) []string {
+	results := make(chan string, len(registrationCandidates))
+	var wg sync.WaitGroup
+
+	// registerDevice registers one device and returns its result. A panic while registering it
+	// becomes its failure instead of crashing the run.
+	registerDevice := func(dev map[string]string) (result string) {
+		defer func() {
+			if r := recover(); r != nil {
+				l.Debug("Recovered panic:", r, string(debug.Stack()))
+				result = fmt.Sprintf("%s: Failed to register WildFire - panic: %v", dev["hostname"], r)
+			}
+		}()
+		start := time.Now()
+		err := register(dev, conf.Auth.Credentials.Firewall.Username, conf.Auth.Credentials.Firewall.Password, l)
+		dev["register_duration_ms"] = strconv.FormatInt(time.Since(start).Milliseconds(), 10)
+		return dev["hostname"] + ": " + registrationResult(err)
+	}
+	cancelled := func(dev map[string]string, err error) string {
+		return dev["hostname"] + ": " + registrationResult(fmt.Errorf("%w: %v", errRegistrationCancelled, err))
+	}
+
+	// Serialized registrations run one after another in the candidates' order, waiting for the
+	// cool-down between devices, so the configuration changes they may trigger do not overlap a
+	// Panorama commit window
+	if conf.SerializeRegistrations || conf.RegistrationCooldown > 0 {
 		for i, device := range registrationCandidates {
-			wg.Add(1)
-			go func(dev map[string]string, index int) {
-				defer wg.Done()
-				err := wildfire.RegisterWildFire(dev, conf.Auth.Credentials.Firewall.Username, conf.Auth.Credentials.Firewall.Password, l)
-				if err != nil {
-					results <- fmt.Sprintf("%s: Failed to register WildFire - %v", dev["hostname"], err)
-				} else {
-					results <- fmt.Sprintf("%s: Successfully registered WildFire", dev["hostname"])
-				}
-			}(device, i)
-		}
-
-		// Wait for all goroutines to finish
-		wg.Wait()
-		close(results)
-
-		// Process results and update registrationCandidates
-		for result := range results {
-			processedResults = append(processedResults, result)
-			parts := strings.SplitN(result, ": ", 2)
-			if len(parts) == 2 {
-				hostname, resultText := parts[0], parts[1]
-				for i, device := range registrationCandidates {
-					if device["hostname"] == hostname {
-						registrationCandidates[i]["result"] = resultText
-						break
-					}
+			// No cool-down is needed before the first registration
+			if i > 0 && conf.RegistrationCooldown > 0 && ctx.Err() == nil {
+				l.Debug("Cooling down for", conf.RegistrationCooldown, "after registering", registrationCandidates[i-1]["hostname"])
+				select {
+				case <-time.After(conf.RegistrationCooldown):
+				case <-ctx.Done():
 				}
 			}
+			// A registration waiting for its turn is not started once ctx is done
+			if err := ctx.Err(); err != nil {
+				results <- cancelled(device, err)
+				continue
+			}
+			results <- registerDevice(device)
 		}
 	} else {
-		// Report-only mode: Set a message for registration candidates
-		for i := range registrationCandidates {
-			registrationCandidates[i]["result"] = "Skipped WildFire registration (Report-only mode)"
+		// At most Concurrency registrations run at once, ramping up to it if configured
+		workers := limiter.New(conf.Concurrency)
+		workers.RampUp(conf.RampUp)
+
+		// A worker slot is taken before starting each registration, so they start in the candidates' order
+		for _, device := range registrationCandidates {
+			if err := workers.AcquireContext(ctx); err != nil {
+				results <- cancelled(device, err)
+				continue
+			}
+			wg.Add(1)
+			go func(dev map[string]string) {
+				defer wg.Done()
+				defer workers.Release()
+				results <- registerDevice(dev)
+			}(device)
 		}
 	}
 
-	// Get device certificate status for all devices
-	consoleprint.PrintStartingDeviceCertificateVerification(l)
-
-	dm.GetDeviceCertificateStatus(deviceList)
+	// Wait for all goroutines to finish
+	wg.Wait()
+	close(results)
 
-	// Print out errors for each device
-	consoleprint.PrintDeviceErrors(deviceList, l)
+	// Process results and update registrationCandidates
+	var processedResults []string
+	attempted := make(map[string]bool, len(registrationCandidates))
+	for result := range results {
+		processedResults = append(processedResults, result)
+		parts := strings.SplitN(result, ": ", 2)
+		if len(parts) == 2 {
+			hostname, resultText := parts[0], parts[1]
+			attempted[hostname] = true
+			for i, device := range registrationCandidates {
+				if device["hostname"] == hostname {
+					registrationCandidates[i]["result"] = resultText
+					break
+				}
+			}
+		}
+	}
 
-	// Generate PDF report
-	err = pdf.GeneratePDFReport(deviceList, ineligibleHardware, unsupportedVersions, registrationCandidates, "device_report.pdf")
-	if err != nil {
-		log.Fatal("Error generating PDF report:", err)
+	// A candidate without a result was never attempted, which the report must not hide
+	for _, device := range registrationCandidates {
+		if !attempted[device["hostname"]] {
+			device["result"] = "Failed to register WildFire - not attempted, no registration result"
+		}
 	}
 
-	// Print results
-	consoleprint.PrintResults(processedResults, len(registrationCandidates), l)
+	return processedResults
 }
@@ -2,19 +2,37 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/consoleprint"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonl"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/metrics"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/pdf"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/wildfire"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/devices"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/scrapli/scrapligo/response"
+	"github.com/scrapli/scrapligo/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockConfig is a mock implementation of the config.Config struct
@@ -90,15 +108,18 @@ func TestMainLogic(t *testing.T) {
 				Panorama struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"panorama"`
 				Firewall struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				} `yaml:"firewall"`
 			}{
 				Firewall: struct {
 					Username string `yaml:"username"`
 					Password string `yaml:"password"`
+					ApiKey   string `yaml:"api_key"`
 				}{
 					Username: "testuser",
 					Password: "testpass",
@@ -222,3 +243,1153 @@ func TestMainLogic(t *testing.T) {
 	mockUtils.AssertExpectations(t)
 	mockWildfire.AssertExpectations(t)
 }
+
+// countingCollector is a deviceCollector that records how many times the devices were collected
+type countingCollector struct {
+	calls   int
+	devices []map[string]string
+}
+
+func (c *countingCollector) GetDeviceList(ctx context.Context, noPanorama bool) ([]map[string]string, error) {
+	c.calls++
+	return c.devices, nil
+}
+
+func TestConfirmFlowUsesCachedCandidates(t *testing.T) {
+	collector := &countingCollector{
+		devices: []map[string]string{
+			{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			{"hostname": "unsupported-fw", "family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"},
+			{"hostname": "ineligible-fw", "family": "400", "model": "PA-460", "sw-version": "10.1.0"},
+		},
+	}
+	l := logger.New(0, false)
+	conf := &config.Config{}
+
+	_, ineligible, unsupported, candidates, _, err := collectAndClassify(context.Background(), collector, false)
+	assert.NoError(t, err)
+	assert.Len(t, ineligible, 1)
+	assert.Len(t, unsupported, 1)
+	assert.Len(t, candidates, 1)
+
+	assert.True(t, confirmRegistration(bufio.NewReader(strings.NewReader("y\n")), l, len(candidates)))
+
+	var mu sync.Mutex
+	var registered []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		mu.Lock()
+		defer mu.Unlock()
+		registered = append(registered, device["hostname"])
+		return nil
+	}
+	results := registerCandidates(context.Background(), candidates, register, conf, l)
+
+	assert.Equal(t, 1, collector.calls, "devices must only be collected once")
+	assert.Equal(t, []string{"candidate-fw"}, registered)
+	assert.Equal(t, []string{"candidate-fw: Successfully registered WildFire"}, results)
+	assert.Equal(t, "Successfully registered WildFire", candidates[0]["result"])
+}
+
+func TestCollectAndClassifyBlankVersion(t *testing.T) {
+	collector := &countingCollector{
+		devices: []map[string]string{
+			{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			{"hostname": "blank-version-fw", "family": "3200", "model": "PA-3260", "sw-version": ""},
+			{"hostname": "ineligible-fw", "family": "400", "model": "PA-460", "sw-version": ""},
+		},
+	}
+
+	deviceList, ineligible, unsupported, candidates, parseFailed, err := collectAndClassify(context.Background(), collector, true)
+
+	require.NoError(t, err, "a blank version must not fail the run")
+	assert.Len(t, deviceList, 3)
+	assert.Len(t, ineligible, 1, "hardware is classified without a version")
+	assert.Empty(t, unsupported)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "candidate-fw", candidates[0]["hostname"])
+
+	require.Len(t, parseFailed, 1)
+	assert.Equal(t, "blank-version-fw", parseFailed[0]["hostname"])
+	assert.Equal(t, resultVersionNotCollected, parseFailed[0]["result"])
+	assert.Equal(t, jsonreport.StatusSkipped, jsonl.Status(parseFailed[0]["result"]))
+}
+
+func TestCollectAndClassifyUnparseableVersions(t *testing.T) {
+	collector := &countingCollector{
+		devices: []map[string]string{
+			{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			{"hostname": "garbled-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.x.beta"},
+			{"hostname": "unsupported-fw", "family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"},
+			{"hostname": "no-feature-fw", "family": "5200", "model": "PA-5250", "sw-version": "10"},
+			{"hostname": "ineligible-fw", "family": "400", "model": "PA-460", "sw-version": "10.1.0"},
+		},
+	}
+
+	deviceList, ineligible, unsupported, candidates, parseFailed, err := collectAndClassify(context.Background(), collector, false)
+
+	require.NoError(t, err, "unparseable versions must not fail the run")
+	assert.Len(t, deviceList, 5)
+	assert.Len(t, ineligible, 1)
+	require.Len(t, unsupported, 1)
+	assert.Equal(t, "unsupported-fw", unsupported[0]["hostname"])
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "candidate-fw", candidates[0]["hostname"])
+	assert.Equal(t, "11", candidates[0]["parsed_version_major"])
+
+	require.Len(t, parseFailed, 2)
+	assert.Equal(t, "garbled-fw", parseFailed[0]["hostname"])
+	assert.Equal(t, "no-feature-fw", parseFailed[1]["hostname"])
+	for _, device := range parseFailed {
+		assert.Equal(t, resultVersionUnparseable, device["result"])
+		assert.Contains(t, device["version_error"], "failed to parse PAN-OS version")
+		assert.NotContains(t, device, "parsed_version_major")
+	}
+}
+
+func TestConfirmRegistration(t *testing.T) {
+	l := logger.New(0, false)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{"Yes", "yes\n", true},
+		{"Y without newline", "Y", true},
+		{"No", "n\n", false},
+		{"Empty", "\n", false},
+		{"EOF", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, confirmRegistration(bufio.NewReader(strings.NewReader(tt.input)), l, 1))
+		})
+	}
+}
+
+func TestSplitBrainPairNeedsConfirmation(t *testing.T) {
+	l := logger.New(0, false)
+	newCandidates := func() []map[string]string {
+		return []map[string]string{
+			{"hostname": "fw-ha-a", "serial": "001", "ha-state": "active", "ha-peer-serial": "002"},
+			{"hostname": "fw-ha-b", "serial": "002", "ha-state": "active", "ha-peer-serial": "001"},
+			{"hostname": "fw-standalone", "serial": "003"},
+		}
+	}
+
+	candidates := newCandidates()
+	flagged := flagSplitBrain(candidates, candidates, l)
+	require.Len(t, flagged, 2)
+	assert.Equal(t, "002", candidates[0]["ha_split_brain"])
+	assert.Equal(t, "001", candidates[1]["ha_split_brain"])
+	assert.NotContains(t, candidates[2], "ha_split_brain")
+
+	remaining := withoutDevices(candidates, flagged)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, "fw-standalone", remaining[0]["hostname"])
+
+	assert.False(t, confirmSplitBrain(bufio.NewReader(strings.NewReader("")), l, len(flagged)), "no answer must not register split-brain peers")
+	assert.False(t, confirmSplitBrain(bufio.NewReader(strings.NewReader("n\n")), l, len(flagged)))
+	assert.True(t, confirmSplitBrain(bufio.NewReader(strings.NewReader("yes\n")), l, len(flagged)))
+
+	// Both prompts of a run read their answer from the same input
+	answers := bufio.NewReader(strings.NewReader("y\nyes\n"))
+	assert.True(t, confirmRegistration(answers, l, len(candidates)))
+	assert.True(t, confirmSplitBrain(answers, l, len(flagged)), "the second answer is not lost to the first prompt")
+	assert.False(t, confirmSplitBrain(answers, l, len(flagged)), "no answer is left")
+
+	// A healthy active/passive pair is not flagged
+	candidates = newCandidates()
+	candidates[1]["ha-state"] = "passive"
+	assert.Empty(t, flagSplitBrain(candidates, candidates, l))
+}
+
+func TestCheckRegistrationSafety(t *testing.T) {
+	candidate := map[string]string{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"}
+	unsupported := map[string]string{"hostname": "unsupported-fw", "family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"}
+	ineligible := map[string]string{"hostname": "ineligible-fw", "family": "400", "model": "PA-460", "sw-version": "10.1.0"}
+	deviceList := []map[string]string{candidate, unsupported, ineligible}
+
+	t.Run("Classified candidates", func(t *testing.T) {
+		assert.NoError(t, checkRegistrationSafety(deviceList, []map[string]string{candidate}, false))
+	})
+
+	t.Run("Classification bypassed", func(t *testing.T) {
+		err := checkRegistrationSafety(deviceList, deviceList, true)
+		assert.ErrorContains(t, err, "did not pass classification")
+	})
+
+	t.Run("Every device is a candidate", func(t *testing.T) {
+		all := []map[string]string{candidate}
+		err := checkRegistrationSafety(all, all, false)
+		assert.ErrorContains(t, err, "-no-safety")
+		assert.NoError(t, checkRegistrationSafety(all, all, true))
+	})
+
+	t.Run("No candidates", func(t *testing.T) {
+		assert.NoError(t, checkRegistrationSafety(deviceList, nil, false))
+	})
+}
+
+// certStatusFake is a certStatusSource that assigns a fixed certificate state per hostname
+type certStatusFake struct {
+	devices []map[string]string
+	states  map[string]string
+}
+
+func (f *certStatusFake) GetDeviceList(ctx context.Context, noPanorama bool) ([]map[string]string, error) {
+	return f.devices, nil
+}
+
+func (f *certStatusFake) GetDeviceCertificateStatus(ctx context.Context, deviceList []map[string]string) {
+	for _, device := range deviceList {
+		device["deviceCert"] = fmt.Sprintf(`{"state":%q}`, f.states[device["hostname"]])
+	}
+}
+
+func TestCertStatusCollector(t *testing.T) {
+	source := &certStatusFake{
+		devices: []map[string]string{
+			{"hostname": "expired-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			{"hostname": "valid-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			{"hostname": "expired-ineligible-fw", "family": "400", "model": "PA-460", "sw-version": "10.1.0"},
+		},
+		states: map[string]string{"expired-fw": "expired", "valid-fw": "valid", "expired-ineligible-fw": "expired"},
+	}
+	collector := &certStatusCollector{source: source, statuses: []string{filters.CertStatusExpired}, l: logger.New(0, false)}
+
+	deviceList, ineligible, unsupported, candidates, _, err := collectAndClassify(context.Background(), collector, false)
+	assert.NoError(t, err)
+	assert.Len(t, deviceList, 2)
+	assert.Len(t, ineligible, 1)
+	assert.Empty(t, unsupported)
+	assert.Len(t, candidates, 1)
+	assert.Equal(t, "expired-fw", candidates[0]["hostname"])
+	assert.Len(t, collector.collected, 3, "every collected device is kept for the safety guard")
+
+	assert.NoError(t, checkRegistrationSafety(collector.collected, candidates, false))
+}
+
+func TestCertStatusCollectorWithoutStatuses(t *testing.T) {
+	source := &certStatusFake{
+		devices: []map[string]string{{"hostname": "expired-fw"}, {"hostname": "valid-fw"}},
+		states:  map[string]string{"expired-fw": "expired", "valid-fw": "valid"},
+	}
+	collector := &certStatusCollector{source: source, l: logger.New(0, false)}
+
+	deviceList, err := collector.GetDeviceList(context.Background(), false)
+
+	assert.NoError(t, err)
+	assert.Len(t, deviceList, 2, "without statuses the devices are not filtered")
+	assert.Equal(t, `{"state":"valid"}`, deviceList[1]["deviceCert"])
+}
+
+func TestRegisterCandidatesStartInOrder(t *testing.T) {
+	candidates := filters.OrderDevices([]map[string]string{
+		{"hostname": "fw-c"}, {"hostname": "fw-a"}, {"hostname": "fw-b"}, {"hostname": "fw-d"},
+	}, filters.OrderHostname, time.Now())
+
+	var mu sync.Mutex
+	var started []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		mu.Lock()
+		started = append(started, device["hostname"])
+		mu.Unlock()
+		return nil
+	}
+
+	registerCandidates(context.Background(), candidates, register, &config.Config{Concurrency: 1}, logger.New(0, false))
+
+	assert.Equal(t, []string{"fw-a", "fw-b", "fw-c", "fw-d"}, started)
+}
+
+func TestRunPreflight(t *testing.T) {
+	candidates := []map[string]string{{"hostname": "fw-1"}, {"hostname": "fw-2", "password": "local"}, {"hostname": "fw-3"}}
+	credentials := func(device map[string]string) devices.Credentials {
+		if device["password"] != "" {
+			return devices.Credentials{Username: "admin", Password: device["password"]}
+		}
+		return devices.Credentials{Username: "admin", Password: "shared"}
+	}
+	preflight := func(device map[string]string, username, password string) wildfire.PreflightResult {
+		if password == "local" {
+			return wildfire.PreflightResult{Hostname: device["hostname"], Status: wildfire.PreflightAuthFailed}
+		}
+		return wildfire.PreflightResult{Hostname: device["hostname"], Status: wildfire.PreflightReachable}
+	}
+
+	results := runPreflight(candidates, preflight, credentials, limiter.New(2), nil)
+
+	assert.Equal(t, []wildfire.PreflightResult{
+		{Hostname: "fw-1", Status: wildfire.PreflightReachable},
+		{Hostname: "fw-2", Status: wildfire.PreflightAuthFailed},
+		{Hostname: "fw-3", Status: wildfire.PreflightReachable},
+	}, results)
+}
+
+func TestSkipRegistrationByPolicy(t *testing.T) {
+	source := &certStatusFake{
+		devices: []map[string]string{
+			{"hostname": "audited-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0", "tags": "dc1,skip_registration"},
+			{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+		},
+		states: map[string]string{"audited-fw": "expired", "candidate-fw": "valid"},
+	}
+	l := logger.New(0, false)
+
+	deviceList, _, _, candidates, _, err := collectAndClassify(context.Background(), source, false)
+	require.NoError(t, err)
+	require.Len(t, candidates, 2)
+
+	eligible, skipped := skipByPolicy(candidates)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, "audited-fw", skipped[0]["hostname"])
+	var buf bytes.Buffer
+	streamSkipped(jsonl.NewWriter(&buf, "run-1"), skipped, l)
+	var record jsonl.Record
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "audited-fw", record.Hostname, "a device skipped by policy is streamed")
+	assert.Equal(t, jsonreport.StatusSkipped, record.Status)
+	var registered []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		registered = append(registered, device["hostname"])
+		return nil
+	}
+	registerCandidates(context.Background(), eligible, register, &config.Config{Concurrency: 1}, l)
+	source.GetDeviceCertificateStatus(context.Background(), deviceList)
+
+	assert.Equal(t, []string{"candidate-fw"}, registered, "a device marked skip_registration is never registered")
+	assert.Equal(t, "registration skipped by policy", candidates[0]["result"])
+	assert.Equal(t, "Successfully registered WildFire", candidates[1]["result"])
+	assert.Equal(t, `{"state":"expired"}`, deviceList[0]["deviceCert"], "a device marked skip_registration is still cert-checked")
+}
+
+func TestReportOnlyCollectsCertStatus(t *testing.T) {
+	newSource := func() *certStatusFake {
+		return &certStatusFake{
+			devices: []map[string]string{
+				{"hostname": "candidate-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+				{"hostname": "unsupported-fw", "family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"},
+			},
+			states: map[string]string{"candidate-fw": "expired", "unsupported-fw": "valid"},
+		}
+	}
+	l := logger.New(0, false)
+
+	t.Run("Certificate check", func(t *testing.T) {
+		source := newSource()
+		deviceList, _, _, candidates, _, err := collectAndClassify(context.Background(), source, false)
+		require.NoError(t, err)
+		require.Len(t, candidates, 1)
+
+		reportWithoutRegistration(context.Background(), candidates, deviceList, source, l)
+
+		assert.Equal(t, "Skipped WildFire registration (Report-only mode)", candidates[0]["result"])
+		assert.NotContains(t, candidates[0], "register_duration_ms", "registration must not be attempted")
+		assert.Equal(t, `{"state":"expired"}`, deviceList[0]["deviceCert"])
+		assert.Equal(t, `{"state":"valid"}`, deviceList[1]["deviceCert"])
+
+		report := buildJSONReport("run", nil, deviceList, nil, nil, candidates)
+		assert.Equal(t, jsonreport.StatusSkipped, report.Devices[0].Status)
+	})
+
+	t.Run("Opted out with -cert-check=false", func(t *testing.T) {
+		source := newSource()
+		deviceList, _, _, candidates, _, err := collectAndClassify(context.Background(), source, false)
+		require.NoError(t, err)
+
+		reportWithoutRegistration(context.Background(), candidates, deviceList, nil, l)
+
+		assert.Equal(t, "Skipped WildFire registration (Report-only mode)", candidates[0]["result"])
+		for _, device := range deviceList {
+			assert.NotContains(t, device, "deviceCert")
+		}
+	})
+}
+
+// refetchingCertFake is a certChecker whose devices have fetched their device certificate once
+// fetchedAt has passed, and need enrollment before
+type refetchingCertFake struct {
+	fetchedAt time.Time
+	checked   []string
+}
+
+func (f *refetchingCertFake) GetDeviceCertificateStatus(ctx context.Context, deviceList []map[string]string) {
+	state := "needs device certificate enrollment"
+	if !time.Now().Before(f.fetchedAt) {
+		state = "valid"
+	}
+	for _, device := range deviceList {
+		f.checked = append(f.checked, device["hostname"])
+		device["deviceCert"] = fmt.Sprintf(`{"state":%q}`, state)
+	}
+}
+
+func TestRecheckCertificates(t *testing.T) {
+	l := logger.New(0, false)
+	registered := map[string]string{"hostname": "registered-fw", "serial": "001"}
+	failed := map[string]string{"hostname": "failed-fw", "serial": "002"}
+	deviceList := []map[string]string{registered, failed}
+
+	certs := &refetchingCertFake{fetchedAt: time.Now().Add(50 * time.Millisecond)}
+	certs.GetDeviceCertificateStatus(context.Background(), deviceList)
+	certs.checked = nil
+	require.Equal(t, filters.CertStatusNeedsEnrollment, filters.CertStatus(registered), "the immediate check shows the stale status")
+
+	// The candidates are copies of the collected devices, as made by the version classification
+	candidates := []map[string]string{
+		{"hostname": "registered-fw", "serial": "001", "result": "Successfully registered WildFire"},
+		{"hostname": "failed-fw", "serial": "002", "result": "Failed to register WildFire - connection refused"},
+	}
+
+	rechecked := recheckCertificates(context.Background(), deviceList, candidates, certs, 100*time.Millisecond, l)
+
+	assert.Equal(t, []map[string]string{registered}, rechecked)
+	assert.Equal(t, []string{"registered-fw"}, certs.checked, "only the registered devices are rechecked")
+	assert.Equal(t, filters.CertStatusValid, filters.CertStatus(registered), "the recheck after the grace period updates the collected device")
+	assert.Equal(t, filters.CertStatusNeedsEnrollment, filters.CertStatus(failed))
+
+	report := buildJSONReport("run", nil, deviceList, nil, nil, candidates)
+	assert.Equal(t, 100.0/2, report.Summary.CertificateCompliance)
+
+	assert.Nil(t, recheckCertificates(context.Background(), deviceList, candidates[1:], certs, time.Hour, l), "no wait without registered devices")
+}
+
+func TestSkipUnhealthy(t *testing.T) {
+	source := &certStatusFake{
+		devices: []map[string]string{
+			{"hostname": "maintenance-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0", "operational-mode": "maintenance"},
+			{"hostname": "healthy-fw", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0", "operational-mode": "normal"},
+		},
+	}
+	l := logger.New(0, false)
+
+	_, _, _, candidates, _, err := collectAndClassify(context.Background(), source, false)
+	require.NoError(t, err)
+	require.Len(t, candidates, 2)
+
+	healthy, unhealthy := skipUnhealthy(candidates, l)
+	var registered []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		registered = append(registered, device["hostname"])
+		return nil
+	}
+	registerCandidates(context.Background(), healthy, register, &config.Config{Concurrency: 1}, l)
+
+	assert.Equal(t, []string{"healthy-fw"}, registered, "a device in maintenance mode is never registered")
+	require.Len(t, unhealthy, 1)
+	assert.Equal(t, "Skipped WildFire registration (unhealthy: operational mode is maintenance)", candidates[0]["result"])
+	assert.Equal(t, "Successfully registered WildFire", candidates[1]["result"])
+
+	report := buildJSONReport("run", nil, candidates, nil, nil, candidates)
+	assert.Equal(t, jsonreport.StatusSkipped, report.Devices[0].Status)
+}
+
+// staticInventory is an inventory source returning fixed devices
+type staticInventory []config.InventoryDevice
+
+func (s staticInventory) InventoryDevices() ([]config.InventoryDevice, error) {
+	return s, nil
+}
+
+// fakeFirewalls answers the XML API and SSH connections of firewalls without contacting them,
+// recording the op commands and the SSH commands received by each address
+type fakeFirewalls struct {
+	mu          sync.Mutex
+	systemInfo  map[string]string
+	refusingSSH map[string]bool
+	opCalls     map[string][]string
+	sshCommands map[string][]string
+}
+
+func newFakeFirewalls() *fakeFirewalls {
+	return &fakeFirewalls{
+		systemInfo:  make(map[string]string),
+		refusingSSH: make(map[string]bool),
+		opCalls:     make(map[string][]string),
+		sshCommands: make(map[string][]string),
+	}
+}
+
+// add adds a firewall answering `show system info` with its facts
+func (f *fakeFirewalls) add(address, hostname, serial, model, family, version string) {
+	f.systemInfo[address] = fmt.Sprintf(`<response status="success"><result><system><hostname>%s</hostname><serial>%s</serial><ip-address>%s</ip-address><model>%s</model><family>%s</family><sw-version>%s</sw-version></system></result></response>`,
+		hostname, serial, address, model, family, version)
+}
+
+func (f *fakeFirewalls) client(hostname, username, password, apiKey string) devices.PanosClient {
+	return &fakeAPIClient{firewalls: f, address: hostname}
+}
+
+func (f *fakeFirewalls) driver(host string, opts ...util.Option) (wildfire.Driver, error) {
+	return &fakeSSHDriver{firewalls: f, address: host}, nil
+}
+
+// fakeAPIClient is the XML API client of a fake firewall
+type fakeAPIClient struct {
+	firewalls *fakeFirewalls
+	address   string
+}
+
+func (c *fakeAPIClient) Initialize() error {
+	return nil
+}
+
+func (c *fakeAPIClient) Op(cmd interface{}, vsys string, extras interface{}, ans interface{}) ([]byte, error) {
+	c.firewalls.mu.Lock()
+	defer c.firewalls.mu.Unlock()
+	c.firewalls.opCalls[c.address] = append(c.firewalls.opCalls[c.address], fmt.Sprint(cmd))
+	if cmd == config.CommandsForMajor(0).SystemInfo {
+		return []byte(c.firewalls.systemInfo[c.address]), nil
+	}
+	return []byte(`<response status="success"><result/></response>`), nil
+}
+
+// fakeSSHDriver is the SSH connection to a fake firewall, which triggers every registration
+type fakeSSHDriver struct {
+	firewalls *fakeFirewalls
+	address   string
+}
+
+func (d *fakeSSHDriver) Open() error {
+	if d.firewalls.refusingSSH[d.address] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (d *fakeSSHDriver) Close() error {
+	return nil
+}
+
+func (d *fakeSSHDriver) GetPrompt() (string, error) {
+	return "admin@PA-VM>", nil
+}
+
+func (d *fakeSSHDriver) SendCommand(command string, opts ...util.Option) (*response.Response, error) {
+	d.firewalls.mu.Lock()
+	defer d.firewalls.mu.Unlock()
+	d.firewalls.sshCommands[d.address] = append(d.firewalls.sshCommands[d.address], command)
+	return &response.Response{Result: "WildFire registration for Public Cloud is triggered"}, nil
+}
+
+func TestSinglePassRegistration(t *testing.T) {
+	firewalls := newFakeFirewalls()
+	firewalls.add("10.0.0.1", "candidate-fw", "001", "PA-3260", "3200", "11.2.0")
+	firewalls.add("10.0.0.2", "failing-fw", "002", "PA-3260", "3200", "11.2.0")
+	firewalls.add("10.0.0.3", "policy-fw", "003", "PA-3260", "3200", "11.2.0")
+	firewalls.add("10.0.0.4", "unsupported-fw", "004", "PA-3260", "3200", "10.1.3-h2")
+	firewalls.add("10.0.0.5", "ineligible-fw", "005", "PA-460", "400", "10.1.0")
+	firewalls.refusingSSH["10.0.0.2"] = true
+	wildfire.SetDriverFactory(firewalls.driver)
+	t.Cleanup(func() { wildfire.SetDriverFactory(nil) })
+
+	conf := &config.Config{HealthGate: true, Concurrency: 2}
+	conf.Auth.Credentials.Firewall.Username = "fw-user"
+	conf.Auth.Credentials.Firewall.Password = "fw-pass"
+	l := logger.New(0, false)
+	dm := devices.NewDeviceManager(conf, l)
+	dm.SetPanosClientFactory(firewalls.client)
+	dm.SetInventorySource(staticInventory{
+		{Hostname: "candidate-fw", IPAddress: "10.0.0.1"},
+		{Hostname: "failing-fw", IPAddress: "10.0.0.2"},
+		{Hostname: "policy-fw", IPAddress: "10.0.0.3", SkipRegistration: true},
+		{Hostname: "unsupported-fw", IPAddress: "10.0.0.4"},
+		{Hostname: "ineligible-fw", IPAddress: "10.0.0.5"},
+	})
+
+	// The registrations go through the real WildFire registration, over the fake SSH driver
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		err := wildfire.RegisterWildFire(context.Background(), device, username, password, wildfire.Options{}, l)
+		recordCommandError(device, err)
+		return err
+	}
+	onCollected := singlePassRegistration(withDeviceCredentials(register, dm.FirewallCredentials), filters.HardwareSelection{}, conf, l)
+	dm.SetOnCollected(onCollected)
+
+	deviceList, _, _, candidates, _, err := collectAndClassify(context.Background(), dm, true)
+
+	require.NoError(t, err)
+	require.Len(t, deviceList, 5)
+	for address := range firewalls.systemInfo {
+		assert.Contains(t, firewalls.opCalls[address], config.CommandsForMajor(0).SystemInfo, "every device is collected through the API client: %s", address)
+	}
+	assert.Equal(t, []string{"request wildfire registration channel public"}, firewalls.sshCommands["10.0.0.1"], "the candidate is registered while it is collected")
+	for _, address := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		assert.Empty(t, firewalls.sshCommands[address], "no registration command is sent to %s", address)
+	}
+
+	// The classified candidates are copies, which must keep the registration results
+	byHostname := make(map[string]map[string]string)
+	for _, device := range candidates {
+		byHostname[device["hostname"]] = device
+	}
+	eligible, _ := skipByPolicy(candidates)
+	toRegister, done := withoutSinglePass(eligible)
+	assert.Empty(t, toRegister, "registered devices must not be registered again")
+	require.Len(t, done, 2)
+	assert.Equal(t, "Successfully registered WildFire", byHostname["candidate-fw"]["result"])
+	assert.Equal(t, "Failed to register WildFire - failed to open connection: connection refused", byHostname["failing-fw"]["result"])
+	assert.NotEmpty(t, byHostname["candidate-fw"]["register_duration_ms"])
+
+	// The failed registration counts in the reports, the metrics and the exit code
+	report := buildJSONReport("run", nil, deviceList, nil, nil, candidates)
+	statuses := make(map[string]jsonreport.Status)
+	for _, device := range report.Devices {
+		statuses[device.Hostname] = device.Status
+	}
+	assert.Equal(t, jsonreport.StatusRegistered, statuses["candidate-fw"])
+	assert.Equal(t, jsonreport.StatusRegistrationFailed, statuses["failing-fw"])
+	var results []string
+	for _, device := range done {
+		results = append(results, device["hostname"]+": "+device["result"])
+	}
+	runMetrics := metrics.FromResults(deviceList, nil, nil, candidates, results, 0)
+	assert.Equal(t, 1, runMetrics.RegistrationFailed)
+	assert.Equal(t, 1, strictExitCode(runMetrics, false))
+
+	t.Run("Cancelled run", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		firewalls.add("10.0.0.6", "late-fw", "006", "PA-3260", "3200", "11.2.0")
+		device := map[string]string{"hostname": "late-fw", "serial": "006", "ip-address": "10.0.0.6", "family": "3200", "model": "PA-3260", "sw-version": "11.2.0"}
+
+		onCollected(ctx, device)
+
+		assert.Empty(t, firewalls.sshCommands["10.0.0.6"])
+		toRegister, _ := withoutSinglePass([]map[string]string{device})
+		assert.Len(t, toRegister, 1, "the registration phase reports the device as cancelled")
+	})
+}
+
+func TestSkipDisconnected(t *testing.T) {
+	candidates := []map[string]string{
+		{"hostname": "fw-up", "connected": "yes"},
+		{"hostname": "fw-down", "connected": "no"},
+		{"hostname": "fw-inventory"},
+	}
+
+	connected, disconnected := skipDisconnected(candidates)
+
+	require.Len(t, connected, 2)
+	assert.Equal(t, "fw-up", connected[0]["hostname"])
+	assert.Equal(t, "fw-inventory", connected[1]["hostname"])
+	require.Len(t, disconnected, 1)
+	assert.Equal(t, resultDisconnected, candidates[1]["result"])
+
+	report := buildJSONReport("run", nil, candidates, nil, nil, candidates)
+	require.Len(t, report.Devices, 3, "disconnected devices are still reported")
+	assert.Equal(t, jsonreport.StatusSkipped, report.Devices[1].Status)
+}
+
+func TestSkipUnselectedHardware(t *testing.T) {
+	candidates := []map[string]string{
+		{"hostname": "fw-vm", "family": "vm", "model": "PA-VM"},
+		{"hostname": "fw-3260", "family": "3200", "model": "PA-3260"},
+	}
+
+	selected, unselected := skipUnselectedHardware(candidates, filters.NewHardwareSelection("PA-VM", ""))
+
+	require.Len(t, selected, 1)
+	assert.Equal(t, "fw-vm", selected[0]["hostname"])
+	require.Len(t, unselected, 1)
+	assert.Equal(t, resultNotSelected, candidates[1]["result"])
+
+	report := buildJSONReport("run", nil, candidates, nil, nil, candidates)
+	assert.Equal(t, jsonreport.StatusSkipped, report.Devices[1].Status)
+}
+
+func TestGenerateReportWithFallback(t *testing.T) {
+	l := logger.New(0, false)
+
+	t.Run("PDF generated", func(t *testing.T) {
+		fallbackCalled := false
+		err := generateReportWithFallback(func() error { return nil }, func() error {
+			fallbackCalled = true
+			return nil
+		}, l)
+		assert.NoError(t, err)
+		assert.False(t, fallbackCalled)
+	})
+
+	t.Run("PDF error writes the JSON fallback", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "report", "device_report.json")
+		devices := []map[string]string{{"hostname": "fw-1", "serial": "001", "result": "Successfully registered WildFire"}}
+
+		err := generateReportWithFallback(func() error {
+			return errors.New("font not found")
+		}, func() error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return jsonreport.WriteFile(path, jsonreport.Build(devices, nil, nil, devices, time.Now()))
+		}, l)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), `"hostname": "fw-1"`)
+	})
+
+	t.Run("Undecodable header logo writes the JSON fallback", func(t *testing.T) {
+		dir := t.TempDir()
+		logo := filepath.Join(dir, "logo.png")
+		require.NoError(t, os.WriteFile(logo, []byte("not a png"), 0644))
+		original := pdf.LogoPath
+		pdf.LogoPath = logo
+		t.Cleanup(func() { pdf.LogoPath = original })
+		path := filepath.Join(dir, "report", "device_report.json")
+		devices := []map[string]string{{"hostname": "fw-1", "serial": "001", "result": "Successfully registered WildFire"}}
+
+		err := generateReportWithFallback(func() error {
+			return pdf.GeneratePDFReport(devices, nil, nil, nil, devices, nil, "device_report.pdf", "")
+		}, func() error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return jsonreport.WriteFile(path, jsonreport.Build(devices, nil, nil, devices, time.Now()))
+		}, l)
+
+		require.NoError(t, err, "the run survives the PDF error")
+		assert.FileExists(t, path)
+	})
+
+	t.Run("PDF panic writes the JSON fallback", func(t *testing.T) {
+		fallbackCalled := false
+		err := generateReportWithFallback(func() error {
+			panic("missing asset")
+		}, func() error {
+			fallbackCalled = true
+			return nil
+		}, l)
+		assert.NoError(t, err)
+		assert.True(t, fallbackCalled)
+	})
+
+	t.Run("Both fail", func(t *testing.T) {
+		err := generateReportWithFallback(func() error {
+			return errors.New("font not found")
+		}, func() error {
+			return errors.New("disk full")
+		}, l)
+		assert.ErrorContains(t, err, "font not found")
+		assert.ErrorContains(t, err, "disk full")
+	})
+
+	t.Run("No fallback returns the PDF error", func(t *testing.T) {
+		err := generateReportWithFallback(func() error {
+			return errors.New("font not found")
+		}, nil, l)
+		assert.ErrorContains(t, err, "font not found")
+	})
+}
+
+func TestStrictExitCodeSoftFailures(t *testing.T) {
+	candidates := []map[string]string{{"hostname": "fw-ok"}, {"hostname": "fw-busy"}, {"hostname": "fw-broken"}}
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		switch device["hostname"] {
+		case "fw-busy":
+			return &wildfire.UnexpectedOutputError{Output: "Device is busy, try again later"}
+		case "fw-broken":
+			return errors.New("failed to open connection: authentication failed")
+		}
+		return nil
+	}
+
+	t.Run("Soft failure only", func(t *testing.T) {
+		results := registerCandidates(context.Background(), candidates[:2], register, &config.Config{}, logger.New(0, false))
+		runMetrics := metrics.FromResults(candidates[:2], nil, nil, candidates[:2], results, 0)
+
+		assert.Equal(t, 1, runMetrics.RegistrationSoftFailed)
+		assert.Equal(t, 0, runMetrics.RegistrationFailed)
+		assert.Equal(t, 0, strictExitCode(runMetrics, false), "soft failures must not trip -strict")
+		assert.Equal(t, 1, strictExitCode(runMetrics, true))
+	})
+
+	t.Run("Hard failure", func(t *testing.T) {
+		results := registerCandidates(context.Background(), candidates, register, &config.Config{}, logger.New(0, false))
+		runMetrics := metrics.FromResults(candidates, nil, nil, candidates, results, 0)
+
+		assert.Equal(t, 1, runMetrics.RegistrationFailed)
+		assert.Equal(t, 1, strictExitCode(runMetrics, false))
+	})
+}
+
+func TestRunExitCode(t *testing.T) {
+	// Mixed results: 2 succeeded, 1 failed and 1 soft failed out of 4 scheduled registrations
+	mixed := metrics.FromResults(nil, nil, nil, nil, []string{
+		"fw-1: Successfully registered WildFire",
+		"fw-2: Successfully registered WildFire",
+		"fw-3: Failed to register WildFire - connection refused",
+		"fw-4: Soft failure registering WildFire - device busy",
+	}, 0)
+	softOnly := metrics.RunMetrics{RegistrationSucceeded: 3, RegistrationSoftFailed: 1}
+
+	tests := []struct {
+		name       string
+		flags      config.Flags
+		runMetrics metrics.RunMetrics
+		expected   int
+	}{
+		{"Default run ignores failures", config.Flags{}, mixed, 0},
+		{"Strict", config.Flags{Strict: true}, mixed, 1},
+		{"Strict ignores soft failures", config.Flags{Strict: true}, softOnly, 0},
+		{"Strict soft fail", config.Flags{Strict: true, StrictSoftFail: true}, softOnly, 1},
+		{"Summary JSON fails on a failed registration", config.Flags{SummaryJSON: "summary.json"}, mixed, 1},
+		{"Summary JSON ignores soft failures", config.Flags{SummaryJSON: "summary.json"}, softOnly, 0},
+		{"Summary JSON with strict soft fail", config.Flags{SummaryJSON: "summary.json", StrictSoftFail: true}, softOnly, 1},
+		{"Failure rate above threshold", config.Flags{FailThresholdPct: 20}, mixed, 1},
+		{"Failure rate below threshold", config.Flags{FailThresholdPct: 30}, mixed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := runExitCode(&tt.flags, tt.runMetrics, 4)
+			assert.Equal(t, tt.expected, code)
+			assert.Equal(t, code != 0, reason != "", "a failing run must log its reason")
+		})
+	}
+}
+
+func TestFailureRateExitCode(t *testing.T) {
+	// 10 scheduled registrations: 7 succeeded, 2 failed and 1 soft failed
+	runMetrics := metrics.RunMetrics{RegistrationSucceeded: 7, RegistrationFailed: 2, RegistrationSoftFailed: 1}
+
+	t.Run("Below threshold", func(t *testing.T) {
+		assert.Equal(t, 0, failureRateExitCode(runMetrics, 10, 25, false))
+		assert.Equal(t, 0, failureRateExitCode(runMetrics, 10, 35, true))
+	})
+
+	t.Run("At threshold", func(t *testing.T) {
+		assert.Equal(t, 0, failureRateExitCode(runMetrics, 10, 20, false), "a rate equal to the threshold must not fail the run")
+		assert.Equal(t, 0, failureRateExitCode(runMetrics, 10, 30, true))
+	})
+
+	t.Run("Above threshold", func(t *testing.T) {
+		assert.Equal(t, 1, failureRateExitCode(runMetrics, 10, 15, false))
+		assert.Equal(t, 1, failureRateExitCode(runMetrics, 10, 25, true), "soft failures count when included")
+	})
+
+	t.Run("Unattempted devices count as failures", func(t *testing.T) {
+		assert.Equal(t, 1, failureRateExitCode(runMetrics, 12, 30, false))
+	})
+
+	t.Run("Nothing scheduled", func(t *testing.T) {
+		assert.Equal(t, 0, failureRateExitCode(metrics.RunMetrics{}, 0, 10, true))
+	})
+}
+
+func TestRunIDInReportAndMetrics(t *testing.T) {
+	runID := newRunID(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	assert.Regexp(t, `^20240701T120000Z-[0-9a-f]{8}$`, runID)
+	assert.NotEqual(t, runID, newRunID(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)), "run IDs must be unique")
+
+	devices := []map[string]string{{"hostname": "fw-1", "serial": "001", "result": "Successfully registered WildFire"}}
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.NoError(t, jsonreport.WriteFile(path, buildJSONReport(runID, nil, devices, nil, nil, devices)))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), fmt.Sprintf(`"run_id": %q`, runID))
+
+	runMetrics := metrics.FromResults(devices, nil, nil, devices, []string{"fw-1: Successfully registered WildFire"}, 1)
+	runMetrics.RunID = runID
+	var buf bytes.Buffer
+	assert.NoError(t, runMetrics.WriteOpenMetrics(&buf))
+	assert.Contains(t, buf.String(), fmt.Sprintf(`cdss_run_info{run_id=%q} 1`, runID))
+}
+
+func TestRegisterCandidatesCooldownSerializes(t *testing.T) {
+	candidates := []map[string]string{{"hostname": "fw-1"}, {"hostname": "fw-2"}, {"hostname": "fw-3"}, {"hostname": "fw-4"}}
+	conf := &config.Config{RegistrationCooldown: 20 * time.Millisecond}
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	var starts, ends []time.Time
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		starts = append(starts, time.Now())
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		ends = append(ends, time.Now())
+		mu.Unlock()
+		return nil
+	}
+
+	results := registerCandidates(context.Background(), candidates, register, conf, logger.New(0, false))
+
+	assert.Len(t, results, 4)
+	assert.Equal(t, 1, maxActive, "registrations must not overlap when a cool-down is set")
+	// Each registration starts only after the previous one ended and the cool-down elapsed
+	for i := 1; i < len(starts); i++ {
+		assert.GreaterOrEqual(t, starts[i].Sub(ends[i-1]), conf.RegistrationCooldown)
+	}
+}
+
+func TestRegisterCandidatesSerializedOrder(t *testing.T) {
+	candidates := make([]map[string]string, 8)
+	for i := range candidates {
+		candidates[i] = map[string]string{"hostname": fmt.Sprintf("fw-%d", i)}
+	}
+	conf := &config.Config{SerializeRegistrations: true, Concurrency: 4}
+
+	var registered []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		registered = append(registered, device["hostname"])
+		return nil
+	}
+
+	results := registerCandidates(context.Background(), candidates, register, conf, logger.New(0, false))
+
+	assert.Equal(t, []string{"fw-0", "fw-1", "fw-2", "fw-3", "fw-4", "fw-5", "fw-6", "fw-7"}, registered, "serialized registrations keep the candidates' order")
+	assert.Len(t, results, len(candidates))
+}
+
+func TestRegisterCandidatesCancelled(t *testing.T) {
+	newCandidates := func() []map[string]string {
+		candidates := make([]map[string]string, 20)
+		for i := range candidates {
+			candidates[i] = map[string]string{"hostname": fmt.Sprintf("fw-%02d", i)}
+		}
+		return candidates
+	}
+
+	for _, tt := range []struct {
+		name string
+		conf *config.Config
+	}{
+		{"Concurrent", &config.Config{Concurrency: 2}},
+		{"Serialized with a cool-down", &config.Config{RegistrationCooldown: time.Hour}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			candidates := newCandidates()
+
+			var started int32
+			var once sync.Once
+			register := func(device map[string]string, username, password string, l *logger.Logger) error {
+				atomic.AddInt32(&started, 1)
+				// The run is cancelled while the first registrations are in flight
+				once.Do(cancel)
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			}
+
+			start := time.Now()
+			results := registerCandidates(ctx, candidates, register, tt.conf, logger.New(0, false))
+
+			assert.Less(t, time.Since(start), time.Second, "the loop must not wait for the remaining registrations or the cool-down")
+			assert.Len(t, results, len(candidates), "every candidate has a result")
+			assert.LessOrEqual(t, int(atomic.LoadInt32(&started)), 2, "no registration starts after the cancellation")
+			cancelled := cancelledRegistrations(candidates)
+			assert.Len(t, cancelled, len(candidates)-int(atomic.LoadInt32(&started)))
+			for _, device := range cancelled {
+				assert.Equal(t, "Failed to register WildFire - registration cancelled: context canceled", device["result"])
+				assert.NotContains(t, device, "register_duration_ms")
+			}
+		})
+	}
+}
+
+func TestLimitConnectionsAcrossPhases(t *testing.T) {
+	connections := limiter.New(2)
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	track := func() {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}
+
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		track()
+		return nil
+	}
+	candidates := make([]map[string]string, 6)
+	for i := range candidates {
+		candidates[i] = map[string]string{"hostname": fmt.Sprintf("fw-%d", i)}
+	}
+
+	// The registration phase overlaps a certificate check phase holding the same limiter
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connections.Acquire()
+			defer connections.Release()
+			track()
+		}()
+	}
+	results := registerCandidates(context.Background(), candidates, limitConnections(register, connections), &config.Config{}, logger.New(0, false))
+	wg.Wait()
+
+	assert.Len(t, results, 6)
+	assert.Equal(t, 2, maxActive, "the connection bound must hold across both phases")
+}
+
+func TestRegisterCandidatesRecoversPanic(t *testing.T) {
+	candidates := []map[string]string{{"hostname": "fw-1", "serial": "1"}, {"hostname": "fw-panic", "serial": "2"}, {"hostname": "fw-3", "serial": "3"}}
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		if device["hostname"] == "fw-panic" {
+			var facts map[string]string
+			facts["registered"] = "yes" // nil map write
+		}
+		return nil
+	}
+
+	results := registerCandidates(context.Background(), candidates, register, &config.Config{}, logger.New(0, false))
+
+	require.Len(t, results, 3, "every candidate must produce a result")
+	assert.Equal(t, "Successfully registered WildFire", candidates[0]["result"])
+	assert.Contains(t, candidates[1]["result"], "Failed to register WildFire - panic: assignment to entry in nil map")
+	assert.Equal(t, "Successfully registered WildFire", candidates[2]["result"])
+
+	// A panic in a serialized registration neither skips the cool-down nor stops the following ones
+	for _, device := range candidates {
+		delete(device, "result")
+	}
+	var starts []time.Time
+	serialized := func(device map[string]string, username, password string, l *logger.Logger) error {
+		starts = append(starts, time.Now())
+		return register(device, username, password, l)
+	}
+	conf := &config.Config{RegistrationCooldown: 10 * time.Millisecond}
+	results = registerCandidates(context.Background(), candidates, serialized, conf, logger.New(0, false))
+
+	require.Len(t, results, 3)
+	require.Len(t, starts, 3)
+	assert.GreaterOrEqual(t, starts[2].Sub(starts[1]), conf.RegistrationCooldown)
+	assert.Contains(t, candidates[1]["result"], "Failed to register WildFire - panic: assignment to entry in nil map")
+	assert.Equal(t, "Successfully registered WildFire", candidates[2]["result"])
+
+	// The run still produces its report
+	report := buildJSONReport("run", nil, candidates, nil, nil, candidates)
+	require.Len(t, report.Devices, 3)
+	assert.Equal(t, jsonreport.StatusRegistered, report.Devices[0].Status)
+	assert.Equal(t, jsonreport.StatusRegistrationFailed, report.Devices[1].Status)
+	assert.Equal(t, jsonreport.StatusRegistered, report.Devices[2].Status)
+}
+
+func TestRecordCommandError(t *testing.T) {
+	rejected := map[string]string{"hostname": "fw-rejected", "serial": "1"}
+	refused := map[string]string{"hostname": "fw-refused", "serial": "2"}
+	candidates := []map[string]string{rejected, refused}
+	commandErr := &wildfire.CommandError{Command: "request wildfire registration channel public", Output: "Invalid syntax.", Err: errors.New("operation failed")}
+
+	recordCommandError(rejected, fmt.Errorf("wrapped: %w", commandErr))
+	recordCommandError(refused, errors.New("failed to open connection: connection refused"))
+	rejected["result"] = registrationResult(commandErr)
+	refused["result"] = registrationResult(errors.New("failed to open connection: connection refused"))
+
+	report := buildJSONReport("run", nil, candidates, nil, nil, candidates)
+	require.Len(t, report.Devices, 2)
+	assert.Equal(t, "request wildfire registration channel public", report.Devices[0].FailedCommand)
+	assert.Equal(t, "Invalid syntax.", report.Devices[0].FailedOutput)
+	assert.Contains(t, report.Devices[0].Result, "Invalid syntax.")
+	assert.Empty(t, report.Devices[1].FailedCommand, "only rejected commands are recorded")
+}
+
+func TestStreamResults(t *testing.T) {
+	candidates := []map[string]string{{"hostname": "fw-1"}, {"hostname": "fw-busy"}, {"hostname": "fw-down"}, {"hostname": "fw-panic"}}
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		switch device["hostname"] {
+		case "fw-busy":
+			return &wildfire.UnexpectedOutputError{Output: "Device is busy"}
+		case "fw-down":
+			return errors.New("connection refused")
+		case "fw-panic":
+			panic("boom")
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	stream := jsonl.NewWriter(&buf, "run-1")
+	results := registerCandidates(context.Background(), candidates, streamResults(register, stream), &config.Config{Concurrency: 2}, logger.New(0, false))
+	require.Len(t, results, 4)
+
+	statuses := make(map[string]jsonreport.Status)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var record jsonl.Record
+		require.NoError(t, json.Unmarshal([]byte(line), &record), "line %q", line)
+		assert.Equal(t, "run-1", record.RunID)
+		assert.NotContains(t, statuses, record.Hostname, "one line per device")
+		statuses[record.Hostname] = record.Status
+	}
+	assert.Equal(t, map[string]jsonreport.Status{
+		"fw-1":     jsonreport.StatusRegistered,
+		"fw-busy":  jsonreport.StatusSoftFailed,
+		"fw-down":  jsonreport.StatusRegistrationFailed,
+		"fw-panic": jsonreport.StatusRegistrationFailed,
+	}, statuses)
+	assert.Contains(t, candidates[3]["result"], "panic: boom", "the panic is still reported by registerCandidates")
+}
+
+func TestWithDeviceCredentials(t *testing.T) {
+	var got []string
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		got = append(got, device["hostname"]+":"+username+":"+password)
+		return nil
+	}
+	credentials := func(device map[string]string) devices.Credentials {
+		if device["hostname"] == "fw-own" {
+			return devices.Credentials{Username: "local", Password: "own-pass"}
+		}
+		return devices.Credentials{Username: "admin", Password: "shared-pass"}
+	}
+
+	wrapped := withDeviceCredentials(register, credentials)
+	require.NoError(t, wrapped(map[string]string{"hostname": "fw-own"}, "admin", "shared-pass", logger.New(0, false)))
+	require.NoError(t, wrapped(map[string]string{"hostname": "fw-shared"}, "admin", "shared-pass", logger.New(0, false)))
+
+	assert.Equal(t, []string{"fw-own:local:own-pass", "fw-shared:admin:shared-pass"}, got)
+}
+
+func TestRegisterCandidatesConcurrencyBound(t *testing.T) {
+	var candidates []map[string]string
+	for i := 0; i < 12; i++ {
+		candidates = append(candidates, map[string]string{"hostname": fmt.Sprintf("fw-%d", i)})
+	}
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	register := func(device map[string]string, username, password string, l *logger.Logger) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	}
+
+	results := registerCandidates(context.Background(), candidates, register, &config.Config{Concurrency: 3}, logger.New(0, false))
+
+	assert.Len(t, results, 12)
+	assert.LessOrEqual(t, maxActive, 3, "no more than -concurrency registrations may run at once")
+	assert.Greater(t, maxActive, 1, "registrations should still run concurrently")
+}
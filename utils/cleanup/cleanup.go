@@ -0,0 +1,65 @@
+// Package cleanup utils/cleanup/cleanup.go
+package cleanup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDirectories are the directories the tool writes generated files to
+var DefaultDirectories = []string{"report"}
+
+// RemoveStale deletes regular files in dirs whose modification time is older than retention.
+// A retention of zero removes every file regardless of age. Files listed in keep (for example
+// the outputs the user explicitly asked for in this run) and .gitkeep placeholders are never
+// removed, and subdirectories are not descended into. It returns the paths that were removed.
+func RemoveStale(dirs []string, retention time.Duration, keep []string, now time.Time) ([]string, error) {
+	protected := make(map[string]bool)
+	for _, path := range keep {
+		if path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			protected[abs] = true
+		}
+	}
+
+	var removed []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || entry.Name() == ".gitkeep" {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if abs, err := filepath.Abs(path); err == nil && protected[abs] {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return removed, fmt.Errorf("failed to stat %s: %w", path, err)
+			}
+			if retention > 0 && now.Sub(info.ModTime()) < retention {
+				continue
+			}
+
+			if err := os.Remove(path); err != nil {
+				return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			removed = append(removed, path)
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,60 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFileWithAge creates a file whose modification time is age in the past
+func writeFileWithAge(t *testing.T, path string, age time.Duration, now time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+	modTime := now.Add(-age)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestRemoveStale(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+
+	oldReport := filepath.Join(dir, "old_report.pdf")
+	recentReport := filepath.Join(dir, "recent_report.pdf")
+	oldUserOutput := filepath.Join(dir, "device_report.pdf")
+	gitkeep := filepath.Join(dir, ".gitkeep")
+	subdir := filepath.Join(dir, "nested")
+
+	writeFileWithAge(t, oldReport, 48*time.Hour, now)
+	writeFileWithAge(t, recentReport, time.Hour, now)
+	writeFileWithAge(t, oldUserOutput, 48*time.Hour, now)
+	writeFileWithAge(t, gitkeep, 48*time.Hour, now)
+	require.NoError(t, os.Mkdir(subdir, 0755))
+
+	removed, err := RemoveStale([]string{dir, filepath.Join(dir, "missing")}, 24*time.Hour, []string{oldUserOutput}, now)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldReport}, removed)
+	assert.NoFileExists(t, oldReport)
+	assert.FileExists(t, recentReport)
+	assert.FileExists(t, oldUserOutput)
+	assert.FileExists(t, gitkeep)
+	assert.DirExists(t, subdir)
+}
+
+func TestRemoveStaleWithoutRetention(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+
+	recentReport := filepath.Join(dir, "recent_report.pdf")
+	writeFileWithAge(t, recentReport, time.Minute, now)
+
+	removed, err := RemoveStale([]string{dir}, 0, nil, now)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{recentReport}, removed)
+	assert.NoFileExists(t, recentReport)
+}
@@ -0,0 +1,48 @@
+// Package compliance utils/compliance/compliance.go
+package compliance
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// ExpiryWindow is how close to expiry a valid certificate may be and still count as compliant
+const ExpiryWindow = 30 * 24 * time.Hour
+
+// IsCompliant reports whether a device has a valid device certificate that does not expire
+// within ExpiryWindow and was not flagged for an issuer outside -cert-allowed-issuers. The device
+// certificate status is read from the device's "deviceCert" field.
+func IsCompliant(device map[string]string) bool {
+	var certStatus map[string]string
+	if err := json.Unmarshal([]byte(device["deviceCert"]), &certStatus); err != nil {
+		return false
+	}
+
+	if certStatus["state"] != "valid" || certStatus["issuer_allowed"] == "no" {
+		return false
+	}
+
+	seconds, err := strconv.ParseInt(certStatus["seconds-to-expire"], 10, 64)
+	if err != nil {
+		// A valid certificate without a usable expiry is not counted as expiring
+		return true
+	}
+	return time.Duration(seconds)*time.Second > ExpiryWindow
+}
+
+// Score returns the percentage of devices with a valid, non-expiring device certificate.
+// An empty device list scores 0.
+func Score(devices []map[string]string) float64 {
+	if len(devices) == 0 {
+		return 0
+	}
+
+	compliant := 0
+	for _, device := range devices {
+		if IsCompliant(device) {
+			compliant++
+		}
+	}
+	return float64(compliant) / float64(len(devices)) * 100
+}
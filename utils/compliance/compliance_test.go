@@ -0,0 +1,52 @@
+package compliance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func certDevice(state, secondsToExpire string) map[string]string {
+	return map[string]string{
+		"deviceCert": `{"state":"` + state + `","seconds-to-expire":"` + secondsToExpire + `"}`,
+	}
+}
+
+func TestIsCompliant(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   map[string]string
+		expected bool
+	}{
+		{"Valid with a year left", certDevice("valid", "31536000"), true},
+		{"Valid without expiry", certDevice("valid", ""), true},
+		{"Valid but expiring in a week", certDevice("valid", "604800"), false},
+		{"Expired", certDevice("expired", "0"), false},
+		{"Needs enrollment", certDevice("needs device certificate enrollment", ""), false},
+		{"No certificate status", map[string]string{}, false},
+		{"Valid from an allowed issuer", map[string]string{"deviceCert": `{"state":"valid","issuer_allowed":"yes"}`}, true},
+		{"Valid from a disallowed issuer", map[string]string{"deviceCert": `{"state":"valid","issuer_allowed":"no"}`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsCompliant(tt.device))
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	devices := []map[string]string{
+		certDevice("valid", "31536000"),
+		certDevice("valid", "15552000"),
+		certDevice("valid", "86400"),
+		certDevice("expired", "-10"),
+		certDevice("needs device certificate enrollment", ""),
+		{"hostname": "unreachable"},
+		certDevice("unknown", ""),
+		certDevice("valid", "7776000"),
+	}
+
+	assert.InDelta(t, 37.5, Score(devices), 0.001)
+	assert.Equal(t, float64(0), Score(nil))
+}
@@ -0,0 +1,71 @@
+// Package compliance utils/compliance/expiry.go
+package compliance
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// certTimeLayout is the layout of the not_valid_after time of `show device-certificate status`,
+// e.g. "Aug 28 13:01:06 2025 GMT"
+const certTimeLayout = "Jan _2 15:04:05 2006 MST"
+
+// ExpiryEntry is the device certificate expiry of a single device
+type ExpiryEntry struct {
+	Hostname      string
+	Serial        string
+	State         string
+	NotValidAfter string
+	// DaysToExpire is negative for an expired certificate and only meaningful when Known is set
+	DaysToExpire int
+	Known        bool
+}
+
+// Expiry returns the device certificate expiry of a device, read from its "deviceCert" field. The
+// days to expire come from "seconds-to-expire" or, when it is missing, from "not_valid_after"
+// relative to now.
+func Expiry(device map[string]string, now time.Time) ExpiryEntry {
+	entry := ExpiryEntry{Hostname: device["hostname"], Serial: device["serial"]}
+
+	var certStatus map[string]string
+	if err := json.Unmarshal([]byte(device["deviceCert"]), &certStatus); err != nil {
+		return entry
+	}
+	entry.State = certStatus["state"]
+	entry.NotValidAfter = certStatus["not_valid_after"]
+
+	if seconds, err := strconv.ParseInt(certStatus["seconds-to-expire"], 10, 64); err == nil {
+		entry.DaysToExpire, entry.Known = daysIn(time.Duration(seconds)*time.Second), true
+	} else if notValidAfter, err := time.Parse(certTimeLayout, entry.NotValidAfter); err == nil {
+		entry.DaysToExpire, entry.Known = daysIn(notValidAfter.Sub(now)), true
+	}
+	return entry
+}
+
+// SortByExpiry returns the certificate expiry of every device, soonest expiry first. Devices with
+// an unknown expiry are listed last, in their original order.
+func SortByExpiry(devices []map[string]string, now time.Time) []ExpiryEntry {
+	entries := make([]ExpiryEntry, 0, len(devices))
+	for _, device := range devices {
+		entries = append(entries, Expiry(device, now))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Known != entries[j].Known {
+			return entries[i].Known
+		}
+		return entries[i].DaysToExpire < entries[j].DaysToExpire
+	})
+	return entries
+}
+
+// daysIn returns the number of whole days in d, rounded down so that a certificate expiring later
+// today has 0 days left and an expired one a negative count
+func daysIn(d time.Duration) int {
+	days := d / (24 * time.Hour)
+	if d < 0 && d%(24*time.Hour) != 0 {
+		days--
+	}
+	return int(days)
+}
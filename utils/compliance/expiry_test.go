@@ -0,0 +1,33 @@
+package compliance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortByExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	devices := []map[string]string{
+		{"hostname": "fw-year", "deviceCert": `{"state":"valid","seconds-to-expire":"31536000"}`},
+		{"hostname": "fw-unknown", "deviceCert": `{"state":"needs device certificate enrollment"}`},
+		{"hostname": "fw-week", "deviceCert": `{"state":"valid","seconds-to-expire":"604800"}`},
+		{"hostname": "fw-no-status"},
+		{"hostname": "fw-month", "deviceCert": `{"state":"valid","not_valid_after":"Jan 31 00:00:00 2025 GMT"}`},
+		{"hostname": "fw-expired", "deviceCert": `{"state":"expired","not_valid_after":"Dec 30 12:00:00 2024 GMT"}`},
+	}
+
+	entries := SortByExpiry(devices, now)
+
+	var hostnames []string
+	var days []int
+	for _, entry := range entries {
+		hostnames = append(hostnames, entry.Hostname)
+		days = append(days, entry.DaysToExpire)
+	}
+	assert.Equal(t, []string{"fw-expired", "fw-week", "fw-month", "fw-year", "fw-unknown", "fw-no-status"}, hostnames)
+	assert.Equal(t, []int{-2, 7, 30, 365, 0, 0}, days)
+	assert.False(t, entries[4].Known)
+	assert.False(t, entries[5].Known)
+}
@@ -0,0 +1,50 @@
+package consoleprint
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+)
+
+// BufferSize is the size in bytes of the buffer collecting the console output of PrintDeviceList
+// and PrintResults, set from -console-buffer-size. 0 or less writes every block as it is printed.
+var BufferSize = 64 * 1024
+
+// consoleBuffer collects console blocks and writes them to the logger's writer in as few writes
+// as possible. A block is never split across writes, so log messages of other goroutines can
+// only appear between blocks.
+type consoleBuffer struct {
+	w io.Writer
+	b *bufio.Writer
+}
+
+// newConsoleBuffer returns a consoleBuffer writing to the logger's writer
+func newConsoleBuffer(l *logger.Logger) *consoleBuffer {
+	c := &consoleBuffer{w: l.Writer()}
+	if BufferSize > 0 {
+		c.b = bufio.NewWriterSize(c.w, BufferSize)
+	}
+	return c
+}
+
+// block buffers a block of output, flushing the buffered blocks first if it would not fit.
+// A block larger than the buffer is written on its own.
+func (c *consoleBuffer) block(s string) {
+	if c.b == nil {
+		_, _ = io.WriteString(c.w, s)
+		return
+	}
+	if c.b.Buffered() > 0 && c.b.Available() < len(s) {
+		c.flush()
+	}
+	_, _ = c.b.WriteString(s)
+}
+
+// flush writes the buffered blocks. It must be called before logging through the logger and once
+// printing is done, so the output keeps its order relative to the log messages.
+func (c *consoleBuffer) flush() {
+	if c.b != nil {
+		_ = c.b.Flush()
+	}
+}
@@ -2,66 +2,141 @@ package consoleprint
 
 import (
 	"fmt"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/reachability"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/wildfire"
 	"strings"
 )
 
+// PrintDeviceList prints the device list, one block per device.
+// The blocks are buffered and each is written whole, so concurrent output cannot split it.
 func PrintDeviceList(deviceList []map[string]string, l *logger.Logger, verbose bool) {
 	l.Info("Printing device list")
-	fmt.Println("Device List:")
+	out := newConsoleBuffer(l)
+	defer out.flush()
+	out.block("Device List:\n")
 	for i, device := range deviceList {
-		fmt.Printf("Device %d:\n", i+1)
+		var b strings.Builder
+		fmt.Fprintf(&b, "Device %d:\n", i+1)
 		if verbose {
 			for key, value := range device {
-				fmt.Printf("  %s: %s\n", key, value)
+				fmt.Fprintf(&b, "  %s: %s\n", key, config.RedactSecrets(key, value))
 			}
 		} else {
-			fmt.Printf("  Hostname: %s\n", device["hostname"])
-			fmt.Printf("  IP Address: %s\n", device["ip-address"])
-			fmt.Printf("  Parsed Version: %s.%s.%s-h%s\n",
+			fmt.Fprintf(&b, "  Hostname: %s\n", device["hostname"])
+			fmt.Fprintf(&b, "  IP Address: %s\n", device["ip-address"])
+			fmt.Fprintf(&b, "  Parsed Version: %s.%s.%s-h%s\n",
 				device["parsed_version_major"],
 				device["parsed_version_feature"],
 				device["parsed_version_maintenance"],
 				device["parsed_version_hotfix"])
 		}
-		fmt.Println()
+		b.WriteString("\n")
+		out.block(b.String())
 	}
 }
 
+// PrintReleaseGroups prints the devices grouped under their PAN-OS feature release, with the
+// per-group counts and the recommended fix, for upgrade planning.
+func PrintReleaseGroups(groups []filters.ReleaseGroup, l *logger.Logger) {
+	var b strings.Builder
+	b.WriteString("Devices by PAN-OS Feature Release:\n")
+	for _, group := range groups {
+		fmt.Fprintf(&b, "PAN-OS %s: %s\n", group.Release, group.Summary())
+		for _, device := range group.Devices {
+			fmt.Fprintf(&b, "  %s (%s, %s)", device["hostname"], device["sw-version"], device["model"])
+			if minFix := device["minimumUpdateRelease"]; minFix != "" {
+				fmt.Fprintf(&b, " upgrade to %s", minFix)
+			}
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n")
+	l.Console("%s", b.String())
+}
+
+// PrintVersionParseFailures lists the devices skipped because their PAN-OS version was not collected
+// or could not be parsed, with the reason of each. Nothing is printed when there are none.
+func PrintVersionParseFailures(devices []map[string]string, l *logger.Logger) {
+	if len(devices) == 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Skipped Because of Unparseable PAN-OS Versions (%d):\n", len(devices))
+	for _, device := range devices {
+		fmt.Fprintf(&b, "  %s: %s\n", device["hostname"], device["version_error"])
+	}
+	b.WriteString("\n")
+	l.Console("%s", b.String())
+}
+
 // PrintResults processes and displays WildFire registration results for multiple devices.
-func PrintResults(results []string, totalDevices int, l *logger.Logger) {
+// Scheduled devices without a result line were never attempted, e.g. because their registration
+// was dropped; they are listed by hostname and counted as failures.
+func PrintResults(results []string, scheduled []map[string]string, l *logger.Logger) {
 	l.Info("Processing WildFire registration results")
-	fmt.Println("WildFire Registration Results:")
+	out := newConsoleBuffer(l)
+	out.block("WildFire Registration Results:\n")
 	successCount := 0
+	softFailureCount := 0
 	failureCount := 0
 
 	for _, result := range results {
-		fmt.Println(result)
-		if strings.Contains(result, "Successfully registered") {
+		out.block(result + "\n")
+		switch {
+		case strings.Contains(result, "Successfully registered"):
 			successCount++
-		} else {
+		case strings.Contains(result, "Soft failure"):
+			softFailureCount++
+		default:
 			failureCount++
 		}
 	}
+	out.flush()
+
+	// Check if we have results for all scheduled devices
+	if missing := unattemptedHostnames(results, scheduled); len(missing) > 0 {
+		l.Warn(fmt.Sprintf("No registration result for %d of %d scheduled device(s), they were not attempted:", len(missing), len(scheduled)))
+		for _, hostname := range missing {
+			l.Warn("  " + hostname)
+		}
+		failureCount += len(missing)
+	}
+
+	l.Info(fmt.Sprintf("Registration complete. Attempted: %d of %d, Successes: %d, Soft failures: %d, Failures: %d", len(results), len(scheduled), successCount, softFailureCount, failureCount))
+}
 
-	// Check if we have results for all devices
-	if len(results) < totalDevices {
-		missingResults := totalDevices - len(results)
-		l.Info(fmt.Sprintf("Missing results for %d device(s)", missingResults))
-		failureCount += missingResults
+// unattemptedHostnames returns the hostnames of the scheduled devices without a "<hostname>: "
+// result line, in scheduling order.
+func unattemptedHostnames(results []string, scheduled []map[string]string) []string {
+	attempted := make(map[string]bool, len(results))
+	for _, result := range results {
+		if hostname, _, ok := strings.Cut(result, ": "); ok {
+			attempted[hostname] = true
+		}
 	}
 
-	l.Info(fmt.Sprintf("Registration complete. Successes: %d, Failures: %d", successCount, failureCount))
+	var missing []string
+	for _, device := range scheduled {
+		if !attempted[device["hostname"]] {
+			missing = append(missing, device["hostname"])
+		}
+	}
+	return missing
 }
 
 func PrintStartingFirewallConnections(l *logger.Logger) {
 	l.Info("Starting connections to firewalls using scrapli-go")
-	fmt.Println("Initiating connections to firewalls for WildFire registration...")
+	l.Console("Initiating connections to firewalls for WildFire registration...\n")
 }
 
 func PrintStartingDeviceCertificateVerification(l *logger.Logger) {
 	l.Info("Starting connections to firewalls using pango")
-	fmt.Println("Initiating connections to firewalls for Device Certificate Verification...")
+	l.Console("Initiating connections to firewalls for Device Certificate Verification...\n")
 }
 
 func PrintDeviceErrors(deviceList []map[string]string, l *logger.Logger) {
@@ -76,3 +151,108 @@ func PrintDeviceErrors(deviceList []map[string]string, l *logger.Logger) {
 		}
 	}
 }
+
+// PrintExplanation prints the classification of a single version and model.
+func PrintExplanation(device map[string]string, classification filters.Classification, l *logger.Logger) {
+	var affected string
+	switch classification.Category {
+	case filters.UnsupportedVersion:
+		affected = "affected"
+	case filters.Candidate:
+		affected = "unaffected"
+	case filters.IneligibleHardware:
+		// The version is only evaluated for affected hardware
+		affected = "not evaluated"
+	default:
+		affected = "unknown"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Version: %s\n", device["sw-version"])
+	fmt.Fprintf(&b, "Model: %s (family %q)\n", device["model"], device["family"])
+	fmt.Fprintf(&b, "Classification: %s\n", classification.Category)
+	fmt.Fprintf(&b, "Version status: %s\n", affected)
+	if classification.MinimumUpdateRelease != "" {
+		fmt.Fprintf(&b, "Minimum fix: %s\n", classification.MinimumUpdateRelease)
+	}
+	fmt.Fprintf(&b, "Rationale: %s\n", classification.Rationale)
+	l.Console("%s", b.String())
+}
+
+// PrintCertExpiryReport prints one line per device with the days until its device certificate
+// expires, in the order given. The whole report is written in a single call.
+func PrintCertExpiryReport(entries []compliance.ExpiryEntry, l *logger.Logger) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-30s %-15s %-8s %-26s %s\n", "Hostname", "Serial", "Days", "Not valid after", "State")
+	for _, entry := range entries {
+		days := "unknown"
+		if entry.Known {
+			days = fmt.Sprintf("%d", entry.DaysToExpire)
+		}
+		fmt.Fprintf(&b, "%-30s %-15s %-8s %-26s %s\n", entry.Hostname, entry.Serial, days, entry.NotValidAfter, entry.State)
+	}
+	l.Console("%s", b.String())
+}
+
+// PrintReachability prints whether each device accepted a TCP connection on every dialled port,
+// with the dial error of each port that did not, followed by the reachable and unreachable counts.
+func PrintReachability(results []reachability.Result, l *logger.Logger) {
+	reachable := 0
+	var b strings.Builder
+	b.WriteString("Inventory Reachability:\n")
+	for _, result := range results {
+		status := "unreachable"
+		if result.Reachable() {
+			status = "reachable"
+			reachable++
+		}
+		fmt.Fprintf(&b, "%-30s %-16s %s\n", result.Hostname, result.Address, status)
+		for _, port := range result.Ports {
+			if port.Err != nil {
+				fmt.Fprintf(&b, "  port %d: %v\n", port.Port, port.Err)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\nDevices: %d, Reachable: %d, Unreachable: %d\n", len(results), reachable, len(results)-reachable)
+	l.Console("%s", b.String())
+}
+
+// PrintPreflight prints the preflight status of each device, with the connection error of the
+// devices that are not reachable, followed by the count of each status.
+func PrintPreflight(results []wildfire.PreflightResult, l *logger.Logger) {
+	counts := make(map[string]int)
+	var b strings.Builder
+	b.WriteString("Preflight Results:\n")
+	for _, result := range results {
+		counts[result.Status]++
+		fmt.Fprintf(&b, "%-30s %s", result.Hostname, result.Status)
+		if result.Err != nil {
+			fmt.Fprintf(&b, " - %v", result.Err)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "Preflight complete. Devices: %d, Reachable: %d, Auth failed: %d, Unreachable: %d\n", len(results),
+		counts[wildfire.PreflightReachable], counts[wildfire.PreflightAuthFailed], counts[wildfire.PreflightUnreachable])
+	l.Console("%s", b.String())
+}
+
+// PrintDelta prints the devices whose state changed since the previous run, one section per change.
+func PrintDelta(delta jsonreport.Delta, l *logger.Logger) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes since run %s:\n", delta.PreviousRunID)
+	for _, section := range []struct {
+		title   string
+		devices []jsonreport.DeviceEntry
+	}{
+		{"Newly affected", delta.NewlyAffected},
+		{"Newly fixed", delta.NewlyFixed},
+		{"Newly registered", delta.NewlyRegistered},
+		{"Newly unreachable", delta.NewlyUnreachable},
+	} {
+		fmt.Fprintf(&b, "%s: %d\n", section.title, len(section.devices))
+		for _, device := range section.devices {
+			fmt.Fprintf(&b, "  %s (%s)\n", device.Hostname, device.Serial)
+		}
+	}
+	l.Console("%s", b.String())
+}
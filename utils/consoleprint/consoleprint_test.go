@@ -2,11 +2,21 @@ package consoleprint
 
 import (
 	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/reachability"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/wildfire"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -57,6 +67,101 @@ func TestPrintDeviceList(t *testing.T) {
 	assert.Contains(t, output, "Parsed Version: 10.1.0-h1")
 }
 
+// countingWriter counts the writes it receives, standing in for the write syscalls to stdout
+type countingWriter struct {
+	writes  int
+	endings []byte
+	buf     bytes.Buffer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > 0 {
+		w.endings = append(w.endings, p[len(p)-1])
+	}
+	return w.buf.Write(p)
+}
+
+// largeDeviceList returns n devices for the console buffering tests
+func largeDeviceList(n int) []map[string]string {
+	deviceList := make([]map[string]string, n)
+	for i := range deviceList {
+		deviceList[i] = map[string]string{
+			"hostname":                   fmt.Sprintf("fw-%04d", i),
+			"ip-address":                 fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			"parsed_version_major":       "10",
+			"parsed_version_feature":     "1",
+			"parsed_version_maintenance": "0",
+			"parsed_version_hotfix":      "1",
+		}
+	}
+	return deviceList
+}
+
+// useBufferSize sets BufferSize for the test
+func useBufferSize(tb testing.TB, size int) {
+	tb.Helper()
+	original := BufferSize
+	BufferSize = size
+	tb.Cleanup(func() { BufferSize = original })
+}
+
+func TestPrintDeviceListBuffered(t *testing.T) {
+	deviceList := largeDeviceList(1000)
+
+	unbuffered := &countingWriter{}
+	useBufferSize(t, 0)
+	l := logger.New(-1, false)
+	l.SetOutput(unbuffered)
+	PrintDeviceList(deviceList, l, false)
+
+	buffered := &countingWriter{}
+	useBufferSize(t, 4096)
+	l.SetOutput(buffered)
+	PrintDeviceList(deviceList, l, false)
+
+	assert.Equal(t, unbuffered.buf.String(), buffered.buf.String())
+	assert.Equal(t, len(deviceList)+1, unbuffered.writes)
+	assert.Less(t, buffered.writes, unbuffered.writes/10)
+	assert.Equal(t, strings.Repeat("\n", buffered.writes), string(buffered.endings), "every write ends on a line")
+}
+
+func TestPrintResultsFlushesBeforeLogging(t *testing.T) {
+	out := &countingWriter{}
+	l := logger.New(0, false)
+	l.SetOutput(out)
+	l.SetFlags(0)
+
+	PrintResults([]string{"Device1: Successfully registered WildFire"}, []map[string]string{{"hostname": "Device1"}, {"hostname": "Device2"}}, l)
+
+	lines := strings.Split(strings.TrimSpace(out.buf.String()), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, "[INFO] Processing WildFire registration results", lines[0])
+	assert.Equal(t, "WildFire Registration Results:", lines[1])
+	assert.Equal(t, "Device1: Successfully registered WildFire", lines[2])
+	assert.Contains(t, lines[3], "not attempted")
+	assert.Equal(t, "[WARN]   Device2", lines[4])
+	assert.Contains(t, lines[5], "Attempted: 1 of 2")
+}
+
+func BenchmarkPrintDeviceList(b *testing.B) {
+	deviceList := largeDeviceList(5000)
+	for _, size := range []int{0, 64 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			useBufferSize(b, size)
+			out := &countingWriter{}
+			l := logger.New(-1, false)
+			l.SetOutput(out)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				out.buf.Reset()
+				PrintDeviceList(deviceList, l, false)
+			}
+			b.ReportMetric(float64(out.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
 func TestPrintResults(t *testing.T) {
 	results := []string{ // Change this from chan string to []string
 		"Device1: Successfully registered WildFire",
@@ -65,11 +170,178 @@ func TestPrintResults(t *testing.T) {
 	}
 
 	output := captureOutput(t, func() {
-		PrintResults(results, 3, logger.New(0, false))
+		PrintResults(results, []map[string]string{{"hostname": "Device1"}, {"hostname": "Device2"}, {"hostname": "Device3"}}, logger.New(0, false))
 	})
 
 	assert.Contains(t, output, "WildFire Registration Results:")
 	assert.Contains(t, output, "Device1: Successfully registered WildFire")
 	assert.Contains(t, output, "Device2: Failed to register WildFire")
 	assert.Contains(t, output, "Device3: Successfully registered WildFire")
+	assert.NotContains(t, output, "not attempted")
+}
+
+func TestPrintResultsUnattempted(t *testing.T) {
+	results := []string{
+		"Device1: Successfully registered WildFire",
+		"Device3: Failed to register WildFire - timeout",
+	}
+	scheduled := []map[string]string{{"hostname": "Device1"}, {"hostname": "Device2"}, {"hostname": "Device3"}}
+
+	output := captureOutput(t, func() {
+		PrintResults(results, scheduled, logger.New(0, false))
+	})
+
+	assert.Contains(t, output, "No registration result for 1 of 3 scheduled device(s), they were not attempted:")
+	assert.Contains(t, output, "  Device2")
+	assert.Contains(t, output, "Attempted: 2 of 3, Successes: 1, Soft failures: 0, Failures: 2")
+}
+
+func TestPrintVersionParseFailures(t *testing.T) {
+	devices := []map[string]string{
+		{"hostname": "fw-garbled", "version_error": `failed to parse PAN-OS version "11.x": invalid feature version: x`},
+		{"hostname": "fw-blank", "version_error": "PAN-OS version was not collected"},
+	}
+
+	output := captureOutput(t, func() {
+		PrintVersionParseFailures(devices, logger.New(0, false))
+	})
+
+	assert.Contains(t, output, "Skipped Because of Unparseable PAN-OS Versions (2):")
+	assert.Contains(t, output, `fw-garbled: failed to parse PAN-OS version "11.x"`)
+	assert.Contains(t, output, "fw-blank: PAN-OS version was not collected")
+
+	assert.Empty(t, captureOutput(t, func() {
+		PrintVersionParseFailures(nil, logger.New(0, false))
+	}))
+}
+
+func TestPrintExplanation(t *testing.T) {
+	device, classification, err := filters.Explain("10.1.6-h2 PA-3260")
+	require.NoError(t, err)
+
+	output := captureOutput(t, func() {
+		PrintExplanation(device, classification, logger.New(0, false))
+	})
+
+	assert.Contains(t, output, "Classification: UnsupportedVersion")
+	assert.Contains(t, output, "Version status: affected")
+	assert.Contains(t, output, "Minimum fix: 10.1.6-h8")
+
+	for input, expected := range map[string]string{
+		"11.1.2-h3 PA-3260": "Version status: unaffected",
+		"10.1.6-h2 PA-440":  "Version status: not evaluated",
+		"garbage PA-3260":   "Version status: unknown",
+	} {
+		device, classification, err := filters.Explain(input)
+		require.NoError(t, err)
+
+		output := captureOutput(t, func() {
+			PrintExplanation(device, classification, logger.New(0, false))
+		})
+
+		assert.Contains(t, output, expected, input)
+	}
+}
+
+func TestPrintCertExpiryReport(t *testing.T) {
+	entries := []compliance.ExpiryEntry{
+		{Hostname: "fw-soon", Serial: "111", State: "valid", DaysToExpire: 3, Known: true},
+		{Hostname: "fw-unknown", Serial: "222"},
+	}
+
+	output := captureOutput(t, func() {
+		PrintCertExpiryReport(entries, logger.New(0, false))
+	})
+
+	assert.Regexp(t, `fw-soon\s+111\s+3\s`, output)
+	assert.Regexp(t, `fw-unknown\s+222\s+unknown`, output)
+	assert.Less(t, strings.Index(output, "fw-soon"), strings.Index(output, "fw-unknown"))
+}
+
+func TestPrintPreflight(t *testing.T) {
+	results := []wildfire.PreflightResult{
+		{Hostname: "fw-1", Status: wildfire.PreflightReachable},
+		{Hostname: "fw-2", Status: wildfire.PreflightAuthFailed, Err: errors.New("unable to authenticate")},
+		{Hostname: "fw-3", Status: wildfire.PreflightUnreachable, Err: errors.New("connection refused")},
+		{Hostname: "fw-4", Status: wildfire.PreflightReachable},
+	}
+
+	output := captureOutput(t, func() {
+		PrintPreflight(results, logger.New(0, false))
+	})
+
+	assert.Contains(t, output, "fw-2")
+	assert.Contains(t, output, "auth-failed - unable to authenticate")
+	assert.Contains(t, output, "unreachable - connection refused")
+	assert.Contains(t, output, "Devices: 4, Reachable: 2, Auth failed: 1, Unreachable: 1")
+}
+
+func TestPrintReachability(t *testing.T) {
+	results := []reachability.Result{
+		{Hostname: "fw-1", Address: "10.0.0.1", Ports: []reachability.PortResult{{Port: 443}, {Port: 22}}},
+		{Hostname: "fw-2", Address: "10.0.0.2", Ports: []reachability.PortResult{{Port: 443}, {Port: 22, Err: errors.New("i/o timeout")}}},
+	}
+
+	output := captureOutput(t, func() {
+		PrintReachability(results, logger.New(0, false))
+	})
+
+	assert.Regexp(t, `fw-1\s+10\.0\.0\.1\s+reachable`, output)
+	assert.Regexp(t, `fw-2\s+10\.0\.0\.2\s+unreachable`, output)
+	assert.Contains(t, output, "port 22: i/o timeout")
+	assert.NotContains(t, output, "port 443")
+	assert.Contains(t, output, "Devices: 2, Reachable: 1, Unreachable: 1")
+}
+
+func TestPrintDelta(t *testing.T) {
+	delta := jsonreport.Delta{
+		PreviousRunID: "run-1",
+		NewlyFixed:    []jsonreport.DeviceEntry{{Hostname: "fw-1", Serial: "111"}},
+	}
+
+	output := captureOutput(t, func() {
+		PrintDelta(delta, logger.New(0, false))
+	})
+
+	assert.Contains(t, output, "Changes since run run-1:")
+	assert.Contains(t, output, "Newly affected: 0")
+	assert.Contains(t, output, "Newly fixed: 1\n  fw-1 (111)")
+}
+
+func TestSecretRedactedInAllOutputs(t *testing.T) {
+	// A newly added secret-bearing flag, configuration value and device field
+	const secret = "s3cr3t-value"
+	flag.CommandLine.String("vault-token", "", "test-only secret flag")
+	require.NoError(t, flag.CommandLine.Set("vault-token", secret))
+
+	conf := &config.Config{}
+	conf.Auth.Credentials.Firewall.Username = "fw-user"
+	conf.Auth.Credentials.Firewall.Password = secret
+
+	// Report appendix
+	settings := config.EffectiveSettings(conf)
+	values := make(map[string]string)
+	for _, setting := range settings {
+		values[setting.Name] = setting.Value
+		assert.NotContains(t, setting.Value, secret, "secret leaked in %s", setting.Name)
+	}
+	assert.Equal(t, config.Redacted, values["-vault-token"])
+	assert.Equal(t, config.Redacted, values["auth.firewall.password"])
+	assert.Equal(t, "fw-user", values["auth.firewall.username"])
+
+	// -dump-config
+	for _, format := range []string{"json", "yaml"} {
+		dump, err := config.DumpSettings(settings, format)
+		require.NoError(t, err)
+		assert.NotContains(t, string(dump), secret)
+		assert.Contains(t, string(dump), "vault-token")
+	}
+
+	// Verbose device list
+	output := captureOutput(t, func() {
+		PrintDeviceList([]map[string]string{{"hostname": "fw-1", "api-key": secret}}, logger.New(0, false), true)
+	})
+	assert.NotContains(t, output, secret)
+	assert.Contains(t, output, "api-key: "+config.Redacted)
+	assert.Contains(t, output, "hostname: fw-1")
 }
@@ -0,0 +1,101 @@
+// Package dnscache utils/dnscache/dnscache.go
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+)
+
+// Cache resolves each hostname once and reuses the result for the rest of the run. It keeps every
+// address of a hostname, so callers can try the next one when an address does not answer.
+type Cache struct {
+	mu      sync.Mutex
+	lookup  func(ctx context.Context, host string) ([]string, error)
+	entries map[string]*entry
+}
+
+// entry is the resolution of a single hostname, performed at most once
+type entry struct {
+	once      sync.Once
+	addresses []string
+	err       error
+}
+
+// Default is the cache shared by device collection, WildFire registration and certificate checks
+var Default = New()
+
+// New creates an empty cache backed by the system resolver.
+func New() *Cache {
+	return &Cache{
+		lookup:  net.DefaultResolver.LookupHost,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Resolve returns the addresses of a hostname, in the order of the resolver, resolving it on first
+// use only. IP addresses and empty strings are returned unchanged without a lookup.
+func (c *Cache) Resolve(host string) ([]string, error) {
+	if host == "" || net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if !ok {
+		e = &entry{}
+		c.entries[host] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		addresses, err := c.lookup(context.Background(), host)
+		if err != nil {
+			e.err = err
+			return
+		}
+		if len(addresses) == 0 {
+			e.err = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+			return
+		}
+		e.addresses = addresses
+	})
+	return e.addresses, e.err
+}
+
+// Addresses returns the resolved addresses of a hostname to connect to, in turn, or the hostname
+// itself if it cannot be resolved, so the connection attempt reports the failure as it would
+// without the cache.
+func (c *Cache) Addresses(host string) []string {
+	addresses, err := c.Resolve(host)
+	if err != nil {
+		return []string{host}
+	}
+	return addresses
+}
+
+// Prefetch resolves the unique hostnames, at most concurrency at once (0 is unlimited), and waits
+// for all lookups to complete. Failures are cached as well and reported when the hostname is
+// resolved later.
+func (c *Cache) Prefetch(hosts []string, concurrency int) {
+	seen := make(map[string]bool)
+	workers := limiter.New(concurrency)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			workers.Acquire()
+			defer workers.Release()
+			_, _ = c.Resolve(host)
+		}(host)
+	}
+	wg.Wait()
+}
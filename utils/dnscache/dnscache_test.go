@@ -0,0 +1,97 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingLookup returns a lookup function that counts the lookups per hostname
+func countingLookup(addresses map[string][]string) (func(ctx context.Context, host string) ([]string, error), map[string]int, *sync.Mutex) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	return func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts[host]++
+		if resolved, ok := addresses[host]; ok {
+			return resolved, nil
+		}
+		return nil, errors.New("no such host")
+	}, counts, &mu
+}
+
+func TestResolveOnceAcrossPhases(t *testing.T) {
+	lookup, counts, mu := countingLookup(map[string][]string{
+		"fw1.example.com": {"192.0.2.1", "2001:db8::1"},
+		"fw2.example.com": {"192.0.2.2"},
+	})
+	c := New()
+	c.lookup = lookup
+
+	// Collection prefetches the inventory, including a duplicate hostname
+	c.Prefetch([]string{"fw1.example.com", "fw2.example.com", "fw1.example.com", "192.0.2.9"}, 2)
+
+	// Registration and certificate checks resolve the same hostnames concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, []string{"192.0.2.1", "2001:db8::1"}, c.Addresses("fw1.example.com"), "every address is kept")
+			assert.Equal(t, []string{"192.0.2.2"}, c.Addresses("fw2.example.com"))
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"fw1.example.com": 1, "fw2.example.com": 1}, counts)
+}
+
+func TestResolveFailures(t *testing.T) {
+	lookup, counts, _ := countingLookup(nil)
+	c := New()
+	c.lookup = lookup
+
+	_, err := c.Resolve("missing.example.com")
+	require.Error(t, err)
+	assert.Equal(t, []string{"missing.example.com"}, c.Addresses("missing.example.com"), "unresolvable hostnames are returned unchanged")
+	assert.Equal(t, 1, counts["missing.example.com"], "failures are cached too")
+
+	addresses, err := c.Resolve("2001:db8::1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8::1"}, addresses)
+	assert.Empty(t, counts["2001:db8::1"])
+}
+
+func TestPrefetchConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var running, peak int
+	c := New()
+	c.lookup = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return []string{"192.0.2.1"}, nil
+	}
+
+	hosts := make([]string, 20)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("fw%d.example.com", i)
+	}
+	c.Prefetch(hosts, 3)
+
+	assert.LessOrEqual(t, peak, 3, "at most concurrency lookups run at once")
+}
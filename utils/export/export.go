@@ -0,0 +1,90 @@
+// Package export utils/export/export.go
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Export formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// WriteFile writes the devices to the given path in the given format, FormatJSON or FormatCSV.
+func WriteFile(path, format string, devices []map[string]string) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(path, devices)
+	case FormatCSV:
+		return WriteCSV(path, devices)
+	default:
+		return fmt.Errorf("unsupported export format %q, expected %s or %s", format, FormatJSON, FormatCSV)
+	}
+}
+
+// WriteJSON writes the devices, with all their fields, to the given path as an indented JSON array.
+func WriteJSON(path string, devices []map[string]string) error {
+	if devices == nil {
+		devices = []map[string]string{}
+	}
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode device export: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write device export: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes the devices to the given path as CSV, one row per device. The header is the
+// sorted union of the fields of all devices, and a field missing from a device is left empty.
+func WriteCSV(path string, devices []map[string]string) error {
+	header := Columns(devices)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write device export: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write device export: %w", err)
+	}
+	for _, device := range devices {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = device[column]
+		}
+		if err := w.Write(record); err != nil {
+			return fmt.Errorf("failed to write device export: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write device export: %w", err)
+	}
+	return file.Close()
+}
+
+// Columns returns the sorted union of the fields of the devices.
+func Columns(devices []map[string]string) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, device := range devices {
+		for key := range device {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var devices = []map[string]string{
+	{"hostname": "fw-1", "serial": "111", "sw-version": "10.1.3-h2", "deviceCert": `{"state":"valid"}`},
+	{"hostname": "fw-2", "serial": "222", "tags": "dc1,core"},
+}
+
+func TestWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+
+	require.NoError(t, WriteJSON(path, devices))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded []map[string]string
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, devices, decoded)
+}
+
+func TestWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.csv")
+
+	require.NoError(t, WriteCSV(path, devices))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{"deviceCert", "hostname", "serial", "sw-version", "tags"}, records[0], "expected the sorted union of the device fields")
+
+	// Reading the rows back gives the original devices, without the fields they did not have
+	for i, record := range records[1:] {
+		decoded := make(map[string]string)
+		for j, column := range records[0] {
+			if record[j] != "" {
+				decoded[column] = record[j]
+			}
+		}
+		assert.Equal(t, devices[i], decoded)
+	}
+}
+
+func TestWriteFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteFile(filepath.Join(dir, "devices.json"), FormatJSON, devices))
+	require.NoError(t, WriteFile(filepath.Join(dir, "devices.csv"), FormatCSV, devices))
+	assert.Error(t, WriteFile(filepath.Join(dir, "devices.xml"), "xml", devices))
+}
@@ -0,0 +1,74 @@
+// Package filters utils/filters/certstatus.go
+package filters
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Certificate statuses accepted by -filter-cert-status
+const (
+	CertStatusValid           = "valid"
+	CertStatusExpired         = "expired"
+	CertStatusNeedsEnrollment = "needs-enrollment"
+	CertStatusUnknown         = "unknown"
+	// CertStatusInvalid matches every status other than valid
+	CertStatusInvalid = "invalid"
+)
+
+// certStates maps the certificate states recorded in a device's "deviceCert" field to their status
+var certStates = map[string]string{
+	"valid":                               CertStatusValid,
+	"expired":                             CertStatusExpired,
+	"needs device certificate enrollment": CertStatusNeedsEnrollment,
+}
+
+// CertStatus returns the certificate status of a device from its "deviceCert" field.
+// Devices whose certificate status was not collected are reported as unknown.
+func CertStatus(device map[string]string) string {
+	var certStatus map[string]string
+	if err := json.Unmarshal([]byte(device["deviceCert"]), &certStatus); err != nil {
+		return CertStatusUnknown
+	}
+	if status, ok := certStates[certStatus["state"]]; ok {
+		return status
+	}
+	return CertStatusUnknown
+}
+
+// ParseCertStatuses parses a comma-separated list of certificate statuses such as "expired,invalid".
+func ParseCertStatuses(list string) ([]string, error) {
+	var statuses []string
+	for _, status := range strings.Split(list, ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		switch status {
+		case "":
+			continue
+		case CertStatusValid, CertStatusExpired, CertStatusNeedsEnrollment, CertStatusUnknown, CertStatusInvalid:
+			statuses = append(statuses, status)
+		default:
+			return nil, fmt.Errorf("unknown certificate status %q, expected one of %s", status,
+				strings.Join([]string{CertStatusValid, CertStatusExpired, CertStatusNeedsEnrollment, CertStatusUnknown, CertStatusInvalid}, ", "))
+		}
+	}
+	if len(statuses) == 0 {
+		return nil, fmt.Errorf("no certificate status given")
+	}
+	return statuses, nil
+}
+
+// FilterDevicesByCertStatus keeps only the devices whose certificate status matches one of the given statuses.
+func FilterDevicesByCertStatus(devices []map[string]string, statuses []string) []map[string]string {
+	var filtered []map[string]string
+	for _, device := range devices {
+		status := CertStatus(device)
+		for _, wanted := range statuses {
+			if wanted == status || (wanted == CertStatusInvalid && status != CertStatusValid) {
+				filtered = append(filtered, device)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,54 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterDevicesByCertStatus(t *testing.T) {
+	valid := map[string]string{"hostname": "valid-fw", "deviceCert": `{"state":"valid"}`}
+	expired := map[string]string{"hostname": "expired-fw", "deviceCert": `{"state":"expired"}`}
+	enrollment := map[string]string{"hostname": "enrollment-fw", "deviceCert": `{"state":"needs device certificate enrollment"}`}
+	uncollected := map[string]string{"hostname": "uncollected-fw"}
+	devices := []map[string]string{valid, expired, enrollment, uncollected}
+
+	hostnames := func(devices []map[string]string) []string {
+		var names []string
+		for _, device := range devices {
+			names = append(names, device["hostname"])
+		}
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		statuses []string
+		expected []string
+	}{
+		{"Expired only", []string{CertStatusExpired}, []string{"expired-fw"}},
+		{"Valid only", []string{CertStatusValid}, []string{"valid-fw"}},
+		{"Invalid", []string{CertStatusInvalid}, []string{"expired-fw", "enrollment-fw", "uncollected-fw"}},
+		{"Expired and needs enrollment", []string{CertStatusExpired, CertStatusNeedsEnrollment}, []string{"expired-fw", "enrollment-fw"}},
+		{"Unknown", []string{CertStatusUnknown}, []string{"uncollected-fw"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, hostnames(FilterDevicesByCertStatus(devices, tt.statuses)))
+		})
+	}
+}
+
+func TestParseCertStatuses(t *testing.T) {
+	statuses, err := ParseCertStatuses(" Expired, invalid ,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{CertStatusExpired, CertStatusInvalid}, statuses)
+
+	_, err = ParseCertStatuses("revoked")
+	assert.ErrorContains(t, err, "unknown certificate status")
+
+	_, err = ParseCertStatuses(" , ")
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+// Package filters utils/filters/classify.go
+package filters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Category represents the outcome of classifying a device
+type Category int
+
+const (
+	// Unknown means the device could not be classified, e.g. its version could not be parsed
+	Unknown Category = iota
+	// IneligibleHardware means the hardware platform is unaffected and does not need registration
+	IneligibleHardware
+	// UnsupportedVersion means the PAN-OS version must be upgraded before registration
+	UnsupportedVersion
+	// Candidate means the device is eligible for WildFire registration
+	Candidate
+)
+
+// String returns the human-readable name of the category
+func (c Category) String() string {
+	switch c {
+	case IneligibleHardware:
+		return "IneligibleHardware"
+	case UnsupportedVersion:
+		return "UnsupportedVersion"
+	case Candidate:
+		return "Candidate"
+	default:
+		return "Unknown"
+	}
+}
+
+// Classification is the result of classifying a single device
+type Classification struct {
+	Category             Category
+	Rationale            string
+	MinimumUpdateRelease string
+}
+
+// Classify determines how a device should be handled based only on its collected facts.
+// It checks the hardware family first and then the PAN-OS version, using the "family",
+// "model" and "sw-version" keys of the device map and Policy. No device contact is required.
+func Classify(device map[string]string) Classification {
+	if !IsAffectedFamily(device["family"], device["model"]) {
+		return Classification{
+			Category:  IneligibleHardware,
+			Rationale: fmt.Sprintf("hardware model %q (family %q) is not affected", device["model"], device["family"]),
+		}
+	}
+
+	parsedVersion, err := ParseVersion(device["sw-version"])
+	if err != nil {
+		return Classification{
+			Category:  Unknown,
+			Rationale: fmt.Sprintf("unable to parse PAN-OS version: %v", err),
+		}
+	}
+
+	versionDevice := make(map[string]string, len(device)+4)
+	for k, v := range device {
+		versionDevice[k] = v
+	}
+	versionDevice["parsed_version_major"] = fmt.Sprintf("%d", parsedVersion.Major)
+	versionDevice["parsed_version_feature"] = fmt.Sprintf("%d", parsedVersion.Feature)
+	versionDevice["parsed_version_maintenance"] = fmt.Sprintf("%d", parsedVersion.Maintenance)
+	versionDevice["parsed_version_hotfix"] = fmt.Sprintf("%d", parsedVersion.Hotfix)
+	if parsedVersion.Prerelease != "" {
+		versionDevice["parsed_version_prerelease"] = parsedVersion.Prerelease
+	}
+
+	isAffected, minUpdateRelease, rationale := evaluateVersion(versionDevice)
+	if isAffected {
+		return Classification{
+			Category:             UnsupportedVersion,
+			Rationale:            rationale,
+			MinimumUpdateRelease: minUpdateRelease,
+		}
+	}
+
+	return Classification{
+		Category:  Candidate,
+		Rationale: fmt.Sprintf("hardware model %s is affected and %s", device["model"], rationale),
+	}
+}
+
+// Explain classifies a "<version> <model>" string such as "10.1.6-h2 PA-3260".
+// The model is resolved to its hardware family before classification.
+func Explain(input string) (map[string]string, Classification, error) {
+	fields := strings.Fields(input)
+	if len(fields) != 2 {
+		return nil, Classification{}, fmt.Errorf("expected \"<version> <model>\", got %q", input)
+	}
+
+	model := strings.ToUpper(fields[1])
+	device := map[string]string{
+		"sw-version": fields[0],
+		"model":      model,
+		"family":     FamilyForModel(model),
+	}
+	return device, Classify(device), nil
+}
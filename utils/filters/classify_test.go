@@ -0,0 +1,91 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name             string
+		device           map[string]string
+		expectedCategory Category
+		expectedMinFix   string
+	}{
+		{
+			name:             "Unaffected hardware",
+			device:           map[string]string{"family": "400", "model": "PA-460", "sw-version": "10.1.0"},
+			expectedCategory: IneligibleHardware,
+		},
+		{
+			name:             "Affected hardware with unpatched version",
+			device:           map[string]string{"family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"},
+			expectedCategory: UnsupportedVersion,
+			expectedMinFix:   "10.1.3-h3",
+		},
+		{
+			name:             "Affected hardware with patched version",
+			device:           map[string]string{"family": "3200", "model": "PA-3260", "sw-version": "11.2.0"},
+			expectedCategory: Candidate,
+		},
+		{
+			name:             "Unparseable version",
+			device:           map[string]string{"family": "vm", "model": "PA-VM", "sw-version": "invalid"},
+			expectedCategory: Unknown,
+		},
+		{
+			name:             "Unknown feature release",
+			device:           map[string]string{"family": "vm", "model": "PA-VM", "sw-version": "9.2.0"},
+			expectedCategory: UnsupportedVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classification := Classify(tt.device)
+			assert.Equal(t, tt.expectedCategory, classification.Category)
+			assert.Equal(t, tt.expectedMinFix, classification.MinimumUpdateRelease)
+			assert.NotEmpty(t, classification.Rationale)
+		})
+	}
+}
+
+func TestCategoryString(t *testing.T) {
+	assert.Equal(t, "IneligibleHardware", IneligibleHardware.String())
+	assert.Equal(t, "UnsupportedVersion", UnsupportedVersion.String())
+	assert.Equal(t, "Candidate", Candidate.String())
+	assert.Equal(t, "Unknown", Unknown.String())
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedFamily   string
+		expectedCategory Category
+		expectedMinFix   string
+		expectError      bool
+	}{
+		{"Affected model with unpatched version", "10.1.6-h2 PA-3260", "3200", UnsupportedVersion, "10.1.6-h8", false},
+		{"Affected model with patched version", "10.1.12 PA-3260", "3200", Candidate, "", false},
+		{"Unaffected model", "10.1.6-h2 PA-460", "400", IneligibleHardware, "", false},
+		{"Lowercase model", "11.1.0-h1 pa-220", "220", UnsupportedVersion, "11.1.0-h2", false},
+		{"Unknown model", "10.1.6-h2 PA-9999", "", IneligibleHardware, "", false},
+		{"Missing model", "10.1.6-h2", "", Unknown, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, classification, err := Explain(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedFamily, device["family"])
+			assert.Equal(t, tt.expectedCategory, classification.Category)
+			assert.Equal(t, tt.expectedMinFix, classification.MinimumUpdateRelease)
+		})
+	}
+}
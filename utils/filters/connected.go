@@ -0,0 +1,11 @@
+// Package filters utils/filters/connected.go
+package filters
+
+import "strings"
+
+// IsDisconnected reports whether Panorama reports a device it manages as disconnected, in the
+// "connected" field of the device. Devices whose connection state is unknown, such as inventory
+// devices, are not disconnected.
+func IsDisconnected(device map[string]string) bool {
+	return strings.EqualFold(strings.TrimSpace(device["connected"]), "no")
+}
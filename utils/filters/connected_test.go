@@ -0,0 +1,14 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDisconnected(t *testing.T) {
+	assert.True(t, IsDisconnected(map[string]string{"connected": "no"}))
+	assert.True(t, IsDisconnected(map[string]string{"connected": " No "}))
+	assert.False(t, IsDisconnected(map[string]string{"connected": "yes"}))
+	assert.False(t, IsDisconnected(map[string]string{}), "inventory devices have no connection state")
+}
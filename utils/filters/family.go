@@ -2,6 +2,9 @@
 package filters
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 )
 
@@ -17,6 +20,28 @@ func IsAffectedFamily(family string, model string) bool {
 	return false
 }
 
+// FamilyForModel looks up the hardware family of a model in the affected and unaffected family tables.
+// Families are searched in sorted order so the result is deterministic. It returns an empty
+// string when the model is unknown.
+func FamilyForModel(model string) string {
+	for _, families := range []map[string][]string{config.AffectedFamilies, config.UnaffectedFamilies} {
+		names := make([]string, 0, len(families))
+		for family := range families {
+			names = append(names, family)
+		}
+		sort.Strings(names)
+
+		for _, family := range names {
+			for _, m := range families[family] {
+				if strings.EqualFold(m, model) {
+					return family
+				}
+			}
+		}
+	}
+	return ""
+}
+
 // FilterDevicesByFamily separates devices into affected and unaffected based on their family and model
 func FilterDevicesByFamily(devices []map[string]string) (affected []map[string]string, unaffected []map[string]string) {
 	for _, device := range devices {
@@ -31,3 +56,50 @@ func FilterDevicesByFamily(devices []map[string]string) (affected []map[string]s
 	}
 	return affected, unaffected
 }
+
+// HardwareSelection restricts the registration candidates to the listed models and families, for
+// a phased rollout. An empty list does not restrict, and a device must match both lists when both
+// are set. Matching is exact against the "model" and "family" fields of the device.
+type HardwareSelection struct {
+	Models   []string
+	Families []string
+}
+
+// NewHardwareSelection builds the selection of the comma-separated -model and -family values
+func NewHardwareSelection(models, families string) HardwareSelection {
+	return HardwareSelection{Models: splitList(models), Families: splitList(families)}
+}
+
+// IsEmpty reports whether the selection keeps every device
+func (s HardwareSelection) IsEmpty() bool {
+	return len(s.Models) == 0 && len(s.Families) == 0
+}
+
+// Matches reports whether a device is part of the selection
+func (s HardwareSelection) Matches(device map[string]string) bool {
+	return matchesAny(s.Models, device["model"]) && matchesAny(s.Families, device["family"])
+}
+
+// matchesAny reports whether value is one of values, or values is empty
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -3,6 +3,8 @@ package filters
 import (
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestIsAffectedFamily(t *testing.T) {
@@ -59,3 +61,64 @@ func TestFilterDevicesByFamily(t *testing.T) {
 		t.Errorf("Unaffected devices mismatch.\nGot: %v\nWant: %v", unaffected, expectedUnaffected)
 	}
 }
+
+func TestFamilyForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected string
+	}{
+		{"PA-3260", "3200"},
+		{"PA-VM", "vm"},
+		{"PA-460", "400"},
+		{"PA-7050", "7000"},
+		{"PA-9999", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := FamilyForModel(tt.model); got != tt.expected {
+				t.Errorf("FamilyForModel(%q) = %q, want %q", tt.model, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHardwareSelection(t *testing.T) {
+	devices := []map[string]string{
+		{"hostname": "fw-vm", "family": "vm", "model": "PA-VM"},
+		{"hostname": "fw-3220", "family": "3200", "model": "PA-3220"},
+		{"hostname": "fw-3260", "family": "3200", "model": "PA-3260"},
+		{"hostname": "fw-850", "family": "800", "model": "PA-850"},
+	}
+	selected := func(selection HardwareSelection) []string {
+		var hostnames []string
+		for _, device := range devices {
+			if selection.Matches(device) {
+				hostnames = append(hostnames, device["hostname"])
+			}
+		}
+		return hostnames
+	}
+
+	tests := []struct {
+		name     string
+		models   string
+		families string
+		expected []string
+	}{
+		{"No selection", "", "", []string{"fw-vm", "fw-3220", "fw-3260", "fw-850"}},
+		{"Model only", "PA-VM", "", []string{"fw-vm"}},
+		{"Several models", "PA-3260, PA-850", "", []string{"fw-3260", "fw-850"}},
+		{"Family only", "", "3200", []string{"fw-3220", "fw-3260"}},
+		{"Model and family", "PA-3260,PA-VM", "3200", []string{"fw-3260"}},
+		{"Exact match only", "pa-vm,PA-32", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selection := NewHardwareSelection(tt.models, tt.families)
+			assert.Equal(t, tt.models == "" && tt.families == "", selection.IsEmpty())
+			assert.Equal(t, tt.expected, selected(selection))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Package filters utils/filters/ha.go
+package filters
+
+import "strings"
+
+// HAStateActive is the HA state of the active peer of an active/passive pair. The peers of an
+// active/active pair report "active-primary" and "active-secondary" instead, which is expected.
+const HAStateActive = "active"
+
+// SplitBrainPeers returns the serial numbers of the devices whose HA peer is also in devices and
+// both peers report the active state, mapped to the serial number of that peer. Such a pair is in
+// a split-brain state, and at most one of its peers should be registered.
+func SplitBrainPeers(devices []map[string]string) map[string]string {
+	active := make(map[string]string)
+	for _, device := range devices {
+		if strings.EqualFold(device["ha-state"], HAStateActive) && device["serial"] != "" && device["ha-peer-serial"] != "" {
+			active[device["serial"]] = device["ha-peer-serial"]
+		}
+	}
+
+	peers := make(map[string]string)
+	for serial, peer := range active {
+		if _, ok := active[peer]; ok {
+			peers[serial] = peer
+		}
+	}
+	return peers
+}
@@ -0,0 +1,28 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBrainPeers(t *testing.T) {
+	devices := []map[string]string{
+		// Split-brain pair: both peers report active
+		{"hostname": "fw-1a", "serial": "001", "ha-state": "active", "ha-peer-serial": "002"},
+		{"hostname": "fw-1b", "serial": "002", "ha-state": "Active", "ha-peer-serial": "001"},
+		// Healthy active/passive pair
+		{"hostname": "fw-2a", "serial": "003", "ha-state": "active", "ha-peer-serial": "004"},
+		{"hostname": "fw-2b", "serial": "004", "ha-state": "passive", "ha-peer-serial": "003"},
+		// Active/active pair
+		{"hostname": "fw-3a", "serial": "005", "ha-state": "active-primary", "ha-peer-serial": "006"},
+		{"hostname": "fw-3b", "serial": "006", "ha-state": "active-secondary", "ha-peer-serial": "005"},
+		// Active device whose peer was not collected
+		{"hostname": "fw-4a", "serial": "007", "ha-state": "active", "ha-peer-serial": "008"},
+		// Standalone device
+		{"hostname": "fw-5", "serial": "009"},
+	}
+
+	assert.Equal(t, map[string]string{"001": "002", "002": "001"}, SplitBrainPeers(devices))
+	assert.Empty(t, SplitBrainPeers(nil))
+}
@@ -0,0 +1,25 @@
+// Package filters utils/filters/health.go
+package filters
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OperationalModeNormal is the operational mode of a device that is up and not in maintenance
+const OperationalModeNormal = "normal"
+
+// UnhealthyReason returns why a device is not ready for WildFire registration, or an empty string
+// when it is. A device is unhealthy when its system information reports an operational mode other
+// than normal, such as maintenance mode, or when an auto-commit is running, e.g. after a reboot.
+// Facts that were not collected do not make a device unhealthy.
+func UnhealthyReason(device map[string]string) string {
+	if mode := strings.TrimSpace(device["operational-mode"]); mode != "" && !strings.EqualFold(mode, OperationalModeNormal) {
+		return fmt.Sprintf("operational mode is %s", mode)
+	}
+	if running, err := strconv.ParseBool(device["autocommit"]); err == nil && running {
+		return "auto-commit in progress"
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnhealthyReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		device map[string]string
+		want   string
+	}{
+		{"Normal", map[string]string{"operational-mode": "normal", "autocommit": "false"}, ""},
+		{"Facts not collected", map[string]string{"hostname": "fw-1"}, ""},
+		{"Maintenance mode", map[string]string{"operational-mode": "maintenance"}, "operational mode is maintenance"},
+		{"Normal in uppercase", map[string]string{"operational-mode": "Normal"}, ""},
+		{"Auto-commit running", map[string]string{"operational-mode": "normal", "autocommit": "true"}, "auto-commit in progress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, UnhealthyReason(tt.device))
+		})
+	}
+}
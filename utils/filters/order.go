@@ -0,0 +1,77 @@
+// Package filters utils/filters/order.go
+package filters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+)
+
+// Registration candidate orderings accepted by -order-by
+const (
+	// OrderVersion registers the lowest PAN-OS versions first
+	OrderVersion = "version"
+	// OrderCertExpiry registers the devices whose certificate expires soonest first
+	OrderCertExpiry = "cert-expiry"
+	// OrderHostname registers the devices in hostname order
+	OrderHostname = "hostname"
+)
+
+// ParseOrder validates an ordering strategy. An empty strategy keeps the classification order.
+func ParseOrder(strategy string) (string, error) {
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	switch strategy {
+	case "", OrderVersion, OrderCertExpiry, OrderHostname:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown ordering %q, expected one of %s", strategy, strings.Join([]string{OrderVersion, OrderCertExpiry, OrderHostname}, ", "))
+	}
+}
+
+// OrderDevices returns a copy of the devices sorted by the strategy, keeping the original order of
+// equal devices. Devices without a parseable version or a known certificate expiry are ordered last.
+func OrderDevices(devices []map[string]string, strategy string, now time.Time) []map[string]string {
+	type entry struct {
+		device  map[string]string
+		version *Version
+		expiry  compliance.ExpiryEntry
+	}
+	entries := make([]entry, len(devices))
+	for i, device := range devices {
+		entries[i].device = device
+		switch strategy {
+		case OrderVersion:
+			entries[i].version, _ = ParseVersion(device["sw-version"])
+		case OrderCertExpiry:
+			entries[i].expiry = compliance.Expiry(device, now)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch strategy {
+		case OrderVersion:
+			if a.version == nil || b.version == nil {
+				return a.version != nil
+			}
+			return a.version.IsLessThan(b.version)
+		case OrderCertExpiry:
+			if a.expiry.Known != b.expiry.Known {
+				return a.expiry.Known
+			}
+			return a.expiry.DaysToExpire < b.expiry.DaysToExpire
+		case OrderHostname:
+			return strings.ToLower(a.device["hostname"]) < strings.ToLower(b.device["hostname"])
+		}
+		return false
+	})
+
+	ordered := make([]map[string]string, len(entries))
+	for i, e := range entries {
+		ordered[i] = e.device
+	}
+	return ordered
+}
@@ -0,0 +1,56 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrder(t *testing.T) {
+	for _, strategy := range []string{"", OrderVersion, OrderCertExpiry, " Hostname "} {
+		_, err := ParseOrder(strategy)
+		assert.NoError(t, err, strategy)
+	}
+	order, err := ParseOrder("Cert-Expiry")
+	require.NoError(t, err)
+	assert.Equal(t, OrderCertExpiry, order)
+
+	_, err = ParseOrder("serial")
+	assert.ErrorContains(t, err, "unknown ordering")
+}
+
+func TestOrderDevices(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	devices := []map[string]string{
+		{"hostname": "fw-c", "sw-version": "10.2.9-h1", "deviceCert": `{"seconds-to-expire":"2592000"}`},
+		{"hostname": "fw-a", "sw-version": "11.1.0", "deviceCert": `{"not_valid_after":"Jan 11 00:00:00 2025 GMT"}`},
+		{"hostname": "FW-B", "sw-version": "10.2.9", "deviceCert": `{"seconds-to-expire":"-86400"}`},
+		{"hostname": "fw-d", "sw-version": "unknown"},
+		{"hostname": "fw-e", "sw-version": "9.1.17", "deviceCert": `{"seconds-to-expire":"31536000"}`},
+	}
+	hostnames := func(devices []map[string]string) []string {
+		var names []string
+		for _, device := range devices {
+			names = append(names, device["hostname"])
+		}
+		return names
+	}
+
+	tests := []struct {
+		strategy string
+		expected []string
+	}{
+		{"", []string{"fw-c", "fw-a", "FW-B", "fw-d", "fw-e"}},
+		{OrderVersion, []string{"fw-e", "FW-B", "fw-c", "fw-a", "fw-d"}},
+		{OrderCertExpiry, []string{"FW-B", "fw-a", "fw-c", "fw-e", "fw-d"}},
+		{OrderHostname, []string{"fw-a", "FW-B", "fw-c", "fw-d", "fw-e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			assert.Equal(t, tt.expected, hostnames(OrderDevices(devices, tt.strategy, now)))
+		})
+	}
+	assert.Equal(t, "fw-c", devices[0]["hostname"], "the devices are not reordered in place")
+}
@@ -0,0 +1,42 @@
+// Package filters utils/filters/policy.go
+package filters
+
+import "fmt"
+
+// AffectedPolicy decides whether the PAN-OS version of a device is affected, i.e. must be upgraded
+// before registration. The device map holds the collected facts and the "parsed_version_*" fields.
+// minFix is the minimum release fixing an affected version, and rationale explains the outcome.
+type AffectedPolicy func(device map[string]string) (affected bool, minFix, rationale string)
+
+// Policy decides whether a version is affected in Classify and SplitDevicesByVersion. Library
+// consumers can replace it to apply bespoke rules, and delegate to DefaultAffectedPolicy for the
+// devices they do not handle themselves.
+var Policy AffectedPolicy = DefaultAffectedPolicy
+
+// DefaultAffectedPolicy is the built-in policy based on IsAffectedVersion. A version that cannot
+// be evaluated, such as an unknown feature release, is reported as affected without a fix, so that
+// such a device is never registered.
+func DefaultAffectedPolicy(device map[string]string) (bool, string, string) {
+	affected, minFix, err := IsAffectedVersion(device, false)
+	if err != nil {
+		return true, "", fmt.Sprintf("unable to evaluate PAN-OS version %s: %v", device["sw-version"], err)
+	}
+	return affected, minFix, versionRationale(device["sw-version"], affected, minFix)
+}
+
+// evaluateVersion applies Policy to a device, explaining the outcome when the policy gives no rationale
+func evaluateVersion(device map[string]string) (bool, string, string) {
+	affected, minFix, rationale := Policy(device)
+	if rationale == "" {
+		rationale = versionRationale(device["sw-version"], affected, minFix)
+	}
+	return affected, minFix, rationale
+}
+
+// versionRationale explains the outcome of the version check
+func versionRationale(swVersion string, affected bool, minFix string) string {
+	if affected {
+		return fmt.Sprintf("PAN-OS %s does not support device certificate registration, upgrade to %s or later", swVersion, minFix)
+	}
+	return fmt.Sprintf("PAN-OS %s supports device certificate registration", swVersion)
+}
@@ -0,0 +1,80 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomAffectedPolicy(t *testing.T) {
+	t.Cleanup(func() { Policy = DefaultAffectedPolicy })
+
+	// 11.2.0 is patched according to the built-in rules, and 10.1.3-h2 is not
+	patched := map[string]string{"family": "3200", "model": "PA-3260", "sw-version": "11.2.0"}
+	unpatched := map[string]string{"family": "3200", "model": "PA-3260", "sw-version": "10.1.3-h2"}
+	require.Equal(t, Candidate, Classify(patched).Category)
+	require.Equal(t, UnsupportedVersion, Classify(unpatched).Category)
+
+	Policy = func(device map[string]string) (bool, string, string) {
+		if device["parsed_version_major"] == "11" {
+			return true, "11.2.1", "site policy requires 11.2.1"
+		}
+		return DefaultAffectedPolicy(device)
+	}
+
+	classification := Classify(patched)
+	assert.Equal(t, UnsupportedVersion, classification.Category)
+	assert.Equal(t, "11.2.1", classification.MinimumUpdateRelease)
+	assert.Equal(t, "site policy requires 11.2.1", classification.Rationale)
+
+	// Devices the custom policy delegates keep the default outcome
+	assert.Equal(t, UnsupportedVersion, Classify(unpatched).Category)
+
+	supported, unsupported := SplitDevicesByVersion([]map[string]string{
+		{"sw-version": "11.2.0", "parsed_version_major": "11", "parsed_version_feature": "2", "parsed_version_maintenance": "0", "parsed_version_hotfix": "0"},
+		{"sw-version": "10.1.3-h2", "parsed_version_major": "10", "parsed_version_feature": "1", "parsed_version_maintenance": "3", "parsed_version_hotfix": "2"},
+	})
+	assert.Empty(t, supported)
+	require.Len(t, unsupported, 2)
+	assert.Equal(t, "11.2.1", unsupported[0]["minimumUpdateRelease"])
+}
+
+func TestDefaultAffectedPolicy(t *testing.T) {
+	affected, minFix, rationale := DefaultAffectedPolicy(map[string]string{
+		"sw-version":                 "10.1.3-h2",
+		"parsed_version_major":       "10",
+		"parsed_version_feature":     "1",
+		"parsed_version_maintenance": "3",
+		"parsed_version_hotfix":      "2",
+	})
+	assert.True(t, affected)
+	assert.Equal(t, "10.1.3-h3", minFix)
+	assert.NotEmpty(t, rationale)
+
+	// An unknown feature release is conservatively reported as affected
+	affected, minFix, _ = DefaultAffectedPolicy(map[string]string{
+		"sw-version":                 "9.2.0",
+		"parsed_version_major":       "9",
+		"parsed_version_feature":     "2",
+		"parsed_version_maintenance": "0",
+		"parsed_version_hotfix":      "0",
+	})
+	assert.True(t, affected)
+	assert.Empty(t, minFix)
+}
+
+func TestUnknownReleaseConsistent(t *testing.T) {
+	// An unknown feature release is unsupported in both classification paths, without a fix
+	classification := Classify(map[string]string{"family": "3200", "model": "PA-3260", "sw-version": "9.2.0"})
+	assert.Equal(t, UnsupportedVersion, classification.Category)
+	assert.Empty(t, classification.MinimumUpdateRelease)
+	assert.Contains(t, classification.Rationale, "unable to evaluate PAN-OS version 9.2.0")
+
+	supported, unsupported := SplitDevicesByVersion([]map[string]string{
+		{"sw-version": "9.2.0", "parsed_version_major": "9", "parsed_version_feature": "2", "parsed_version_maintenance": "0", "parsed_version_hotfix": "0"},
+	})
+	assert.Empty(t, supported)
+	require.Len(t, unsupported, 1)
+	assert.Empty(t, unsupported[0]["minimumUpdateRelease"])
+}
@@ -0,0 +1,80 @@
+// Package filters utils/filters/release.go
+package filters
+
+import (
+	"fmt"
+	"sort"
+)
+
+// UnknownRelease groups the devices whose PAN-OS version cannot be parsed
+const UnknownRelease = "unknown"
+
+// ReleaseGroup holds the devices running the same PAN-OS feature release, e.g. "10.1"
+type ReleaseGroup struct {
+	Release string
+	Devices []map[string]string
+	// NeedsUpgrade counts the devices with a "minimumUpdateRelease", i.e. unsupported versions
+	NeedsUpgrade int
+	// RecommendedFix is the highest minimum update release of the group, which fixes all of its devices
+	RecommendedFix string
+}
+
+// GroupByFeatureRelease groups devices by the feature release of their "sw-version", in ascending
+// release order with unparseable versions last. The devices keep their order within each group.
+func GroupByFeatureRelease(devices []map[string]string) []ReleaseGroup {
+	groups := make(map[string]*ReleaseGroup)
+	versions := make(map[string]*Version)
+	fixVersions := make(map[string]*Version)
+
+	for _, device := range devices {
+		release := UnknownRelease
+		if version, err := ParseVersion(device["sw-version"]); err == nil {
+			release = fmt.Sprintf("%d.%d", version.Major, version.Feature)
+			versions[release] = &Version{Major: version.Major, Feature: version.Feature}
+		}
+
+		group, ok := groups[release]
+		if !ok {
+			group = &ReleaseGroup{Release: release}
+			groups[release] = group
+		}
+		group.Devices = append(group.Devices, device)
+
+		minFix := device["minimumUpdateRelease"]
+		if minFix == "" {
+			continue
+		}
+		group.NeedsUpgrade++
+		if fix, err := ParseVersion(minFix); err == nil {
+			if current := fixVersions[release]; current == nil || current.IsLessThan(fix) {
+				fixVersions[release] = fix
+				group.RecommendedFix = minFix
+			}
+		} else if group.RecommendedFix == "" {
+			group.RecommendedFix = minFix
+		}
+	}
+
+	result := make([]ReleaseGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		vi, vj := versions[result[i].Release], versions[result[j].Release]
+		if vi == nil || vj == nil {
+			return vj == nil && vi != nil
+		}
+		return vi.IsLessThan(vj)
+	})
+	return result
+}
+
+// Summary describes the group counts and its recommended fix, e.g.
+// "3 device(s), 2 need upgrade, recommended fix 10.1.14-h4"
+func (g ReleaseGroup) Summary() string {
+	summary := fmt.Sprintf("%d device(s), %d need upgrade", len(g.Devices), g.NeedsUpgrade)
+	if g.RecommendedFix != "" {
+		summary += ", recommended fix " + g.RecommendedFix
+	}
+	return summary
+}
@@ -0,0 +1,42 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupByFeatureRelease(t *testing.T) {
+	devices := []map[string]string{
+		{"hostname": "fw-1", "sw-version": "10.1.3-h2", "minimumUpdateRelease": "10.1.3-h3"},
+		{"hostname": "fw-2", "sw-version": "11.0.0", "minimumUpdateRelease": "11.0.1-h4"},
+		{"hostname": "fw-3", "sw-version": "10.1.9", "minimumUpdateRelease": "10.1.9-h3"},
+		{"hostname": "fw-4", "sw-version": "10.1.14-h4"},
+		{"hostname": "fw-5", "sw-version": "invalid"},
+		{"hostname": "fw-6", "sw-version": "9.1.17"},
+	}
+
+	groups := GroupByFeatureRelease(devices)
+
+	require.Len(t, groups, 4)
+	releases := make([]string, 0, len(groups))
+	for _, group := range groups {
+		releases = append(releases, group.Release)
+	}
+	assert.Equal(t, []string{"9.1", "10.1", "11.0", UnknownRelease}, releases)
+
+	tenOne := groups[1]
+	require.Len(t, tenOne.Devices, 3)
+	assert.Equal(t, "fw-1", tenOne.Devices[0]["hostname"])
+	assert.Equal(t, 2, tenOne.NeedsUpgrade)
+	assert.Equal(t, "10.1.9-h3", tenOne.RecommendedFix, "the highest minimum fix covers every device of the group")
+	assert.Equal(t, "3 device(s), 2 need upgrade, recommended fix 10.1.9-h3", tenOne.Summary())
+
+	assert.Equal(t, 1, groups[2].NeedsUpgrade)
+	assert.Equal(t, "11.0.1-h4", groups[2].RecommendedFix)
+
+	assert.Equal(t, 0, groups[0].NeedsUpgrade)
+	assert.Equal(t, "1 device(s), 0 need upgrade", groups[0].Summary())
+	assert.Len(t, groups[3].Devices, 1)
+}
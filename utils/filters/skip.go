@@ -0,0 +1,25 @@
+// Package filters utils/filters/skip.go
+package filters
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SkipRegistration is the inventory field and tag marking a device that is audited, including its
+// device certificate status, but never registered with WildFire
+const SkipRegistration = "skip_registration"
+
+// SkipsRegistration reports whether a device is marked with a true "skip_registration" field or a
+// "skip_registration" tag.
+func SkipsRegistration(device map[string]string) bool {
+	if skip, err := strconv.ParseBool(strings.TrimSpace(device[SkipRegistration])); err == nil && skip {
+		return true
+	}
+	for _, tag := range strings.Split(device["tags"], ",") {
+		if strings.TrimSpace(tag) == SkipRegistration {
+			return true
+		}
+	}
+	return false
+}
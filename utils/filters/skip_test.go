@@ -0,0 +1,16 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipsRegistration(t *testing.T) {
+	assert.True(t, SkipsRegistration(map[string]string{"skip_registration": "true"}))
+	assert.True(t, SkipsRegistration(map[string]string{"skip_registration": "1"}), "database columns may hold 1")
+	assert.True(t, SkipsRegistration(map[string]string{"tags": "dc1, skip_registration"}))
+	assert.False(t, SkipsRegistration(map[string]string{"skip_registration": "false", "tags": "dc1"}))
+	assert.False(t, SkipsRegistration(map[string]string{"tags": "skip_registration_later"}))
+	assert.False(t, SkipsRegistration(map[string]string{}))
+}
@@ -9,20 +9,44 @@ import (
 	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 )
 
+// Pre-release suffixes of PAN-OS engineering builds
+const (
+	// PrereleaseBeta marks a beta build, e.g. "11.0.0-b3"
+	PrereleaseBeta = "b"
+	// PrereleaseCandidate marks a release candidate build, e.g. "10.2.3-c12"
+	PrereleaseCandidate = "c"
+)
+
+// prereleaseRank orders the builds of a maintenance release: beta, then candidate, then the release
+var prereleaseRank = map[string]int{PrereleaseBeta: 0, PrereleaseCandidate: 1, "": 2}
+
+// prereleaseNames are the names of the pre-release suffixes in errors
+var prereleaseNames = map[string]string{PrereleaseBeta: "beta", PrereleaseCandidate: "candidate"}
+
 // Version represents a PAN-OS version
 type Version struct {
 	Major       int
 	Feature     int
 	Maintenance int
 	Hotfix      int
+	// Prerelease is PrereleaseBeta or PrereleaseCandidate for an engineering build of the
+	// maintenance release, which comes before the release itself, and empty otherwise
+	Prerelease string
+	// Build is the number of the engineering build
+	Build int
 }
 
-// ParseVersion parses a version string into a Version struct
+// ParseVersion parses a version string into a Version struct. Some sources report only the
+// feature release, e.g. "10.2": a missing maintenance release is parsed as 0, so "10.2" is
+// "10.2.0". A version without a feature release, such as "10", is invalid.
 func ParseVersion(version string) (*Version, error) {
 	parts := strings.Split(version, ".")
-	if len(parts) < 3 {
+	if len(parts) < 2 {
 		return nil, fmt.Errorf("invalid version format: %s", version)
 	}
+	if len(parts) == 2 {
+		parts = append(parts, "0")
+	}
 
 	// Initialize a Version struct
 	v := &Version{}
@@ -40,20 +64,35 @@ func ParseVersion(version string) (*Version, error) {
 		return nil, fmt.Errorf("invalid feature version: %s", parts[1])
 	}
 
-	// Split the maintenance and hotfix parts
-	maintenanceHotfix := strings.Split(parts[2], "-h")
+	// Split the maintenance part from the hotfix or engineering build suffix
+	maintenance, suffix, hasSuffix := strings.Cut(parts[2], "-")
 
 	// Parse the maintenance version part
-	v.Maintenance, err = strconv.Atoi(maintenanceHotfix[0])
+	v.Maintenance, err = strconv.Atoi(maintenance)
 	if err != nil {
-		return nil, fmt.Errorf("invalid maintenance version: %s", maintenanceHotfix[0])
+		return nil, fmt.Errorf("invalid maintenance version: %s", maintenance)
 	}
 
-	// If there's a hotfix part, parse it as well
-	if len(maintenanceHotfix) > 1 {
-		v.Hotfix, err = strconv.Atoi(maintenanceHotfix[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid hotfix version: %s", maintenanceHotfix[1])
+	// If there's a suffix, parse the hotfix or the beta or candidate build number
+	if hasSuffix {
+		if suffix == "" {
+			return nil, fmt.Errorf("invalid version suffix: %s", version)
+		}
+		kind, number := suffix[:1], suffix[1:]
+		switch kind {
+		case "h":
+			v.Hotfix, err = strconv.Atoi(number)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hotfix version: %s", number)
+			}
+		case PrereleaseBeta, PrereleaseCandidate:
+			v.Prerelease = kind
+			v.Build, err = strconv.Atoi(number)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s build: %s", prereleaseNames[kind], number)
+			}
+		default:
+			return nil, fmt.Errorf("invalid version suffix: %s", suffix)
 		}
 	}
 
@@ -61,7 +100,8 @@ func ParseVersion(version string) (*Version, error) {
 	return v, nil
 }
 
-// IsLessThan compares two Version structs
+// IsLessThan compares two Version structs. The beta and candidate builds of a maintenance
+// release come before the release and its hotfixes.
 func (v *Version) IsLessThan(other *Version) bool {
 	if v.Major != other.Major {
 		return v.Major < other.Major
@@ -72,6 +112,12 @@ func (v *Version) IsLessThan(other *Version) bool {
 	if v.Maintenance != other.Maintenance {
 		return v.Maintenance < other.Maintenance
 	}
+	if v.Prerelease != other.Prerelease {
+		return prereleaseRank[v.Prerelease] < prereleaseRank[other.Prerelease]
+	}
+	if v.Prerelease != "" {
+		return v.Build < other.Build
+	}
 	return v.Hotfix < other.Hotfix
 }
 
@@ -80,6 +126,7 @@ func IsAffectedVersion(device map[string]string, isGlobalProtect bool) (bool, st
 	feature, _ := strconv.Atoi(device["parsed_version_feature"])
 	maintenance, _ := strconv.Atoi(device["parsed_version_maintenance"])
 	hotfix, _ := strconv.Atoi(device["parsed_version_hotfix"])
+	prerelease := device["parsed_version_prerelease"]
 
 	// Check if the version is 11.2 or later
 	if major > 11 || (major == 11 && feature >= 2) {
@@ -108,7 +155,8 @@ func IsAffectedVersion(device map[string]string, isGlobalProtect bool) (bool, st
 	}
 
 	for _, minVersion := range minVersions {
-		if v.Maintenance < minVersion.Maintenance || (v.Maintenance == minVersion.Maintenance && v.Hotfix < minVersion.Hotfix) {
+		// A beta or candidate build comes before every hotfix of its maintenance release
+		if v.Maintenance < minVersion.Maintenance || (v.Maintenance == minVersion.Maintenance && (prerelease != "" || v.Hotfix < minVersion.Hotfix)) {
 			minUpdateRelease := fmt.Sprintf("%s.%d-h%d", featureRelease, minVersion.Maintenance, minVersion.Hotfix)
 			return true, minUpdateRelease, nil
 		}
@@ -117,12 +165,34 @@ func IsAffectedVersion(device map[string]string, isGlobalProtect bool) (bool, st
 	return false, "", nil
 }
 
-func SplitDevicesByVersion(deviceList []map[string]string) (supported []map[string]string, unsupported []map[string]string, err error) {
-	for _, device := range deviceList {
-		isAffected, minUpdateRelease, err := IsAffectedVersion(device, false) // Assuming no Global Protect for now
-		if err != nil {
-			return nil, nil, fmt.Errorf("error checking device %s: %v", device["hostname"], err)
+// LatestPatchedRelease returns the newest release of the minimum patched versions table in the
+// feature release of version, e.g. "10.1.12-h0" for "10.1.3-h3", or an empty string when the
+// feature release is not in the table.
+func LatestPatchedRelease(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	featureRelease := parts[0] + "." + parts[1]
+
+	patched := config.MinimumPatchedVersions[featureRelease]
+	if len(patched) == 0 {
+		return ""
+	}
+	latest := patched[0]
+	for _, candidate := range patched[1:] {
+		if candidate.Maintenance > latest.Maintenance || (candidate.Maintenance == latest.Maintenance && candidate.Hotfix > latest.Hotfix) {
+			latest = candidate
 		}
+	}
+	return fmt.Sprintf("%s.%d-h%d", featureRelease, latest.Maintenance, latest.Hotfix)
+}
+
+// SplitDevicesByVersion splits devices with parsed versions into supported and unsupported versions
+// according to Policy. With the default policy, a version that cannot be evaluated is unsupported.
+func SplitDevicesByVersion(deviceList []map[string]string) (supported []map[string]string, unsupported []map[string]string) {
+	for _, device := range deviceList {
+		isAffected, minUpdateRelease, _ := evaluateVersion(device)
 
 		deviceCopy := make(map[string]string)
 		for k, v := range device {
@@ -138,5 +208,5 @@ func SplitDevicesByVersion(deviceList []map[string]string) (supported []map[stri
 		}
 	}
 
-	return supported, unsupported, nil
+	return supported, unsupported
 }
@@ -12,13 +12,26 @@ func TestParseVersion(t *testing.T) {
 		want    *Version
 		wantErr bool
 	}{
-		{"Valid version", "10.1.6-h3", &Version{10, 1, 6, 3}, false},
-		{"Valid version no hotfix", "10.1.6", &Version{10, 1, 6, 0}, false},
-		{"Invalid version", "10.1", nil, true},
+		{"Valid version", "10.1.6-h3", &Version{10, 1, 6, 3, "", 0}, false},
+		{"Valid version no hotfix", "10.1.6", &Version{10, 1, 6, 0, "", 0}, false},
+		{"Feature release only", "10.2", &Version{10, 2, 0, 0, "", 0}, false},
+		{"Major version only", "10", nil, true},
+		{"Empty version", "", nil, true},
+		{"Trailing dot", "10.2.", nil, true},
+		{"Invalid feature of two components", "10.x", nil, true},
 		{"Invalid major", "a.1.6", nil, true},
 		{"Invalid feature", "10.b.6", nil, true},
 		{"Invalid maintenance", "10.1.c", nil, true},
 		{"Invalid hotfix", "10.1.6-hd", nil, true},
+		{"Candidate build", "10.2.3-c12", &Version{10, 2, 3, 0, PrereleaseCandidate, 12}, false},
+		{"Beta build", "11.0.0-b3", &Version{11, 0, 0, 0, PrereleaseBeta, 3}, false},
+		{"Hotfix unaffected by build parsing", "10.2.3-h12", &Version{10, 2, 3, 12, "", 0}, false},
+		{"Missing candidate build", "10.2.3-c", nil, true},
+		{"Invalid beta build", "11.0.0-bx", nil, true},
+		{"Hotfix of a candidate build", "10.2.3-c12-h1", nil, true},
+		{"Unknown suffix", "10.2.3-x1", nil, true},
+		{"Empty suffix", "10.2.3-", nil, true},
+		{"Uppercase suffix", "10.2.3-C12", nil, true},
 	}
 
 	for _, tt := range tests {
@@ -42,12 +55,17 @@ func TestVersion_IsLessThan(t *testing.T) {
 		other *Version
 		want  bool
 	}{
-		{"Less major", &Version{9, 1, 6, 3}, &Version{10, 1, 6, 3}, true},
-		{"Equal major, less feature", &Version{10, 0, 6, 3}, &Version{10, 1, 6, 3}, true},
-		{"Equal major and feature, less maintenance", &Version{10, 1, 5, 3}, &Version{10, 1, 6, 3}, true},
-		{"Equal major, feature, and maintenance, less hotfix", &Version{10, 1, 6, 2}, &Version{10, 1, 6, 3}, true},
-		{"Equal versions", &Version{10, 1, 6, 3}, &Version{10, 1, 6, 3}, false},
-		{"Greater version", &Version{10, 1, 6, 4}, &Version{10, 1, 6, 3}, false},
+		{"Less major", &Version{9, 1, 6, 3, "", 0}, &Version{10, 1, 6, 3, "", 0}, true},
+		{"Equal major, less feature", &Version{10, 0, 6, 3, "", 0}, &Version{10, 1, 6, 3, "", 0}, true},
+		{"Equal major and feature, less maintenance", &Version{10, 1, 5, 3, "", 0}, &Version{10, 1, 6, 3, "", 0}, true},
+		{"Equal major, feature, and maintenance, less hotfix", &Version{10, 1, 6, 2, "", 0}, &Version{10, 1, 6, 3, "", 0}, true},
+		{"Equal versions", &Version{10, 1, 6, 3, "", 0}, &Version{10, 1, 6, 3, "", 0}, false},
+		{"Greater version", &Version{10, 1, 6, 4, "", 0}, &Version{10, 1, 6, 3, "", 0}, false},
+		{"Beta before candidate", &Version{11, 0, 0, 0, PrereleaseBeta, 9}, &Version{11, 0, 0, 0, PrereleaseCandidate, 1}, true},
+		{"Candidate before release", &Version{10, 2, 3, 0, PrereleaseCandidate, 12}, &Version{10, 2, 3, 0, "", 0}, true},
+		{"Release after candidate", &Version{10, 2, 3, 0, "", 0}, &Version{10, 2, 3, 0, PrereleaseCandidate, 12}, false},
+		{"Less candidate build", &Version{10, 2, 3, 0, PrereleaseCandidate, 11}, &Version{10, 2, 3, 0, PrereleaseCandidate, 12}, true},
+		{"Candidate after previous maintenance hotfix", &Version{10, 2, 3, 0, PrereleaseCandidate, 1}, &Version{10, 2, 2, 9, "", 0}, false},
 	}
 
 	for _, tt := range tests {
@@ -107,6 +125,20 @@ func TestIsAffectedVersion(t *testing.T) {
 			wantMinUpdate:   "8.1.0",
 			wantErr:         false,
 		},
+		{
+			name: "Candidate build of a patched maintenance release",
+			device: map[string]string{
+				"parsed_version_major":       "10",
+				"parsed_version_feature":     "1",
+				"parsed_version_maintenance": "12",
+				"parsed_version_hotfix":      "0",
+				"parsed_version_prerelease":  PrereleaseCandidate,
+			},
+			isGlobalProtect: false,
+			want:            true,
+			wantMinUpdate:   "10.1.12-h0",
+			wantErr:         false,
+		},
 		{
 			name: "Version 11.2 or later",
 			device: map[string]string{
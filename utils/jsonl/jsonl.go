@@ -0,0 +1,78 @@
+// Package jsonl utils/jsonl/jsonl.go
+package jsonl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+)
+
+// Record is the JSON object streamed for a device once its result is known
+type Record struct {
+	RunID      string            `json:"run_id,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Hostname   string            `json:"hostname"`
+	Serial     string            `json:"serial,omitempty"`
+	IPAddress  string            `json:"ip_address,omitempty"`
+	SWVersion  string            `json:"sw_version,omitempty"`
+	Status     jsonreport.Status `json:"status"`
+	Result     string            `json:"result"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+}
+
+// Writer streams one JSON object per line. It is safe for concurrent use: each record is
+// written in a single write, so lines of concurrent registrations are never interleaved.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	runID string
+	now   func() time.Time
+}
+
+// NewWriter returns a Writer streaming the records of the run to w
+func NewWriter(w io.Writer, runID string) *Writer {
+	return &Writer{w: w, runID: runID, now: time.Now}
+}
+
+// Device writes the record of a device with its result and, if known, the registration duration
+func (w *Writer) Device(device map[string]string, result string, duration time.Duration) error {
+	return w.Write(Record{
+		Hostname:   device["hostname"],
+		Serial:     device["serial"],
+		IPAddress:  device["ip-address"],
+		SWVersion:  device["sw-version"],
+		Status:     Status(result),
+		Result:     result,
+		DurationMs: duration.Milliseconds(),
+	})
+}
+
+// Write writes a record as a single line, filling in the run ID and timestamp if unset
+func (w *Writer) Write(record Record) error {
+	if record.RunID == "" {
+		record.RunID = w.runID
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = w.now().UTC()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode result of %s: %w", record.Hostname, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write result of %s: %w", record.Hostname, err)
+	}
+	return nil
+}
+
+// Status returns the status of a registration result line, with the values of the JSON report
+func Status(result string) jsonreport.Status {
+	return jsonreport.RegistrationStatus(result)
+}
@@ -0,0 +1,61 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterOneLinePerDevice(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, "run-1")
+
+	const count = 50
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			device := map[string]string{"hostname": fmt.Sprintf("fw-%d", i), "serial": fmt.Sprint(i), "sw-version": "11.1.2"}
+			assert.NoError(t, w.Device(device, "Successfully registered WildFire", 1500*time.Millisecond))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), "line %q", scanner.Text())
+		assert.Equal(t, "run-1", record.RunID)
+		assert.Equal(t, jsonreport.StatusRegistered, record.Status)
+		assert.Equal(t, int64(1500), record.DurationMs)
+		assert.False(t, record.Timestamp.IsZero())
+		assert.False(t, seen[record.Hostname], "duplicate line for %s", record.Hostname)
+		seen[record.Hostname] = true
+	}
+	require.NoError(t, scanner.Err())
+	assert.Len(t, seen, count)
+}
+
+func TestStatus(t *testing.T) {
+	tests := map[string]jsonreport.Status{
+		"Successfully registered WildFire":                      jsonreport.StatusRegistered,
+		"Soft failure registering WildFire - device busy":       jsonreport.StatusSoftFailed,
+		"Failed to register WildFire - connection refused":      jsonreport.StatusRegistrationFailed,
+		"Skipped WildFire registration (Report-only mode)":      jsonreport.StatusSkipped,
+		"registration skipped by policy":                        jsonreport.StatusSkipped,
+		"Failed to register WildFire - not attempted, no reply": jsonreport.StatusRegistrationFailed,
+	}
+	for result, expected := range tests {
+		assert.Equal(t, expected, Status(result), result)
+	}
+}
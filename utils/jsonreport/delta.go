@@ -0,0 +1,99 @@
+// Package jsonreport utils/jsonreport/delta.go
+package jsonreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Delta holds the devices whose state changed between a previous and the current run
+type Delta struct {
+	PreviousRunID string `json:"previous_run_id,omitempty"`
+	RunID         string `json:"run_id,omitempty"`
+	// NewlyAffected devices run an unsupported PAN-OS version now but did not in the previous run
+	NewlyAffected []DeviceEntry `json:"newly_affected"`
+	// NewlyFixed devices ran an unsupported PAN-OS version in the previous run but no longer do
+	NewlyFixed []DeviceEntry `json:"newly_fixed"`
+	// NewlyRegistered devices were registered in this run but not in the previous one
+	NewlyRegistered []DeviceEntry `json:"newly_registered"`
+	// NewlyUnreachable devices were collected in the previous run but not in this one; their entry
+	// is the one of the previous run
+	NewlyUnreachable []DeviceEntry `json:"newly_unreachable"`
+}
+
+// ReadFile reads a report written by WriteFile, such as the JSON report of a previous run.
+func ReadFile(path string) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to read JSON report: %w", err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("failed to decode JSON report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Compare returns the delta from the previous to the current report. Devices are matched by serial,
+// or by hostname when they have no serial, and every set is in the order of its report.
+func Compare(previous, current Report) Delta {
+	delta := Delta{
+		PreviousRunID:    previous.RunID,
+		RunID:            current.RunID,
+		NewlyAffected:    []DeviceEntry{},
+		NewlyFixed:       []DeviceEntry{},
+		NewlyRegistered:  []DeviceEntry{},
+		NewlyUnreachable: []DeviceEntry{},
+	}
+
+	before := make(map[string]DeviceEntry, len(previous.Devices))
+	for _, device := range previous.Devices {
+		before[entryKey(device)] = device
+	}
+	now := make(map[string]bool, len(current.Devices))
+
+	for _, device := range current.Devices {
+		now[entryKey(device)] = true
+		old, seen := before[entryKey(device)]
+		affected := device.Status == StatusUnsupportedVersion
+		wasAffected := seen && old.Status == StatusUnsupportedVersion
+		switch {
+		case affected && !wasAffected:
+			delta.NewlyAffected = append(delta.NewlyAffected, device)
+		case !affected && wasAffected:
+			delta.NewlyFixed = append(delta.NewlyFixed, device)
+		}
+		if device.Status == StatusRegistered && !(seen && old.Status == StatusRegistered) {
+			delta.NewlyRegistered = append(delta.NewlyRegistered, device)
+		}
+	}
+
+	for _, device := range previous.Devices {
+		if !now[entryKey(device)] {
+			delta.NewlyUnreachable = append(delta.NewlyUnreachable, device)
+		}
+	}
+
+	return delta
+}
+
+// WriteDelta writes the delta as indented JSON to the given path.
+func WriteDelta(path string, delta Delta) error {
+	data, err := json.MarshalIndent(delta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode delta report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write delta report: %w", err)
+	}
+	return nil
+}
+
+// entryKey identifies a device across runs
+func entryKey(device DeviceEntry) string {
+	if device.Serial != "" {
+		return "serial:" + device.Serial
+	}
+	return "hostname:" + device.Hostname
+}
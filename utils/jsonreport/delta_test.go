@@ -0,0 +1,59 @@
+package jsonreport
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hostnames(entries []DeviceEntry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Hostname)
+	}
+	return names
+}
+
+func TestCompare(t *testing.T) {
+	previous := Report{RunID: "run-1", Devices: []DeviceEntry{
+		{Hostname: "fw-upgraded", Serial: "1", Status: StatusUnsupportedVersion},
+		{Hostname: "fw-still-affected", Serial: "2", Status: StatusUnsupportedVersion},
+		{Hostname: "fw-retried", Serial: "3", Status: StatusRegistrationFailed},
+		{Hostname: "fw-registered-before", Serial: "4", Status: StatusRegistered},
+		{Hostname: "fw-gone", Serial: "5", Status: StatusRegistered},
+		{Hostname: "fw-downgraded", Serial: "6", Status: StatusSkipped},
+	}}
+	current := Report{RunID: "run-2", Devices: []DeviceEntry{
+		{Hostname: "fw-upgraded", Serial: "1", Status: StatusRegistered},
+		{Hostname: "fw-still-affected", Serial: "2", Status: StatusUnsupportedVersion},
+		{Hostname: "fw-retried", Serial: "3", Status: StatusRegistered},
+		{Hostname: "fw-registered-before", Serial: "4", Status: StatusRegistered},
+		{Hostname: "fw-downgraded", Serial: "6", Status: StatusUnsupportedVersion},
+		{Hostname: "fw-new", Serial: "7", Status: StatusUnsupportedVersion},
+	}}
+
+	delta := Compare(previous, current)
+
+	assert.Equal(t, "run-1", delta.PreviousRunID)
+	assert.Equal(t, "run-2", delta.RunID)
+	assert.Equal(t, []string{"fw-downgraded", "fw-new"}, hostnames(delta.NewlyAffected))
+	assert.Equal(t, []string{"fw-upgraded"}, hostnames(delta.NewlyFixed))
+	assert.Equal(t, []string{"fw-upgraded", "fw-retried"}, hostnames(delta.NewlyRegistered))
+	assert.Equal(t, []string{"fw-gone"}, hostnames(delta.NewlyUnreachable))
+}
+
+func TestCompareRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "previous.json")
+	previous := Build([]map[string]string{{"hostname": "fw-1", "serial": "1"}}, nil, []map[string]string{{"hostname": "fw-1", "serial": "1"}}, nil, time.Now())
+	require.NoError(t, WriteFile(path, previous))
+
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	delta := Compare(read, Report{})
+
+	assert.Empty(t, delta.NewlyAffected)
+	assert.Equal(t, []string{"fw-1"}, hostnames(delta.NewlyUnreachable))
+}
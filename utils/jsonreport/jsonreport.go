@@ -0,0 +1,189 @@
+// Package jsonreport utils/jsonreport/jsonreport.go
+package jsonreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+)
+
+// Status is the final outcome of a device in the run
+type Status string
+
+// Device statuses
+const (
+	StatusRegistered         Status = "registered"
+	StatusRegistrationFailed Status = "registration_failed"
+	StatusSoftFailed         Status = "registration_soft_failed"
+	StatusSkipped            Status = "skipped"
+	StatusIneligibleHardware Status = "ineligible_hardware"
+	StatusUnsupportedVersion Status = "unsupported_version"
+	StatusUnknown            Status = "unknown"
+)
+
+// Report is the JSON representation of a run
+type Report struct {
+	RunID         string           `json:"run_id,omitempty"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+	Summary       Summary          `json:"summary"`
+	Devices       []DeviceEntry    `json:"devices"`
+	Configuration []config.Setting `json:"configuration,omitempty"`
+}
+
+// Summary holds the aggregate counts of a run
+type Summary struct {
+	DevicesTotal           int     `json:"devices_total"`
+	IneligibleHardware     int     `json:"ineligible_hardware"`
+	UnsupportedVersions    int     `json:"unsupported_versions"`
+	RegistrationCandidates int     `json:"registration_candidates"`
+	CertificateCompliance  float64 `json:"certificate_compliance_percent"`
+}
+
+// DeviceEntry is the JSON representation of a single device
+type DeviceEntry struct {
+	Hostname             string `json:"hostname"`
+	Serial               string `json:"serial"`
+	IPAddress            string `json:"ip_address"`
+	Model                string `json:"model"`
+	SWVersion            string `json:"sw_version"`
+	Source               string `json:"source,omitempty"`
+	MinimumUpdateRelease string `json:"minimum_update_release,omitempty"`
+	Result               string `json:"result,omitempty"`
+	Status               Status `json:"status"`
+	// FailedCommand and FailedOutput are the command a device rejected during registration and its output
+	FailedCommand      string `json:"failed_command,omitempty"`
+	FailedOutput       string `json:"failed_output,omitempty"`
+	CollectDurationMs  int64  `json:"collect_duration_ms"`
+	RegisterDurationMs int64  `json:"register_duration_ms"`
+	// Raw holds every collected field of the device, see Report.IncludeRaw
+	Raw map[string]string `json:"raw,omitempty"`
+}
+
+// Build creates the report from the classified device lists. The classified lists hold copies of
+// the collected devices, so the registration result and timings are read from the classified entry
+// when there is one. The timing fields are read from the "collect_duration_ms" and
+// "register_duration_ms" keys recorded on each device during the run.
+func Build(allDevices, ineligibleHardware, unsupportedVersions, registrationCandidates []map[string]string, generatedAt time.Time) Report {
+	classified := make(map[string]classifiedDevice)
+	for _, device := range ineligibleHardware {
+		classified[deviceKey(device)] = classifiedDevice{device: device, status: StatusIneligibleHardware}
+	}
+	for _, device := range unsupportedVersions {
+		classified[deviceKey(device)] = classifiedDevice{device: device, status: StatusUnsupportedVersion}
+	}
+	for _, device := range registrationCandidates {
+		classified[deviceKey(device)] = classifiedDevice{device: device, status: RegistrationStatus(device["result"])}
+	}
+
+	report := Report{
+		GeneratedAt: generatedAt,
+		Summary: Summary{
+			DevicesTotal:           len(allDevices),
+			IneligibleHardware:     len(ineligibleHardware),
+			UnsupportedVersions:    len(unsupportedVersions),
+			RegistrationCandidates: len(registrationCandidates),
+			CertificateCompliance:  compliance.Score(allDevices),
+		},
+		Devices: make([]DeviceEntry, 0, len(allDevices)),
+	}
+
+	for _, device := range allDevices {
+		entry, ok := classified[deviceKey(device)]
+		if !ok {
+			entry = classifiedDevice{device: device, status: StatusUnknown}
+		}
+		field := func(key string) string {
+			if value, ok := entry.device[key]; ok {
+				return value
+			}
+			return device[key]
+		}
+		report.Devices = append(report.Devices, DeviceEntry{
+			Hostname:             device["hostname"],
+			Serial:               device["serial"],
+			IPAddress:            device["ip-address"],
+			Model:                device["model"],
+			SWVersion:            device["sw-version"],
+			Source:               device["source"],
+			MinimumUpdateRelease: field("minimumUpdateRelease"),
+			Result:               field("result"),
+			Status:               entry.status,
+			FailedCommand:        field("failed_command"),
+			FailedOutput:         field("failed_output"),
+			CollectDurationMs:    parseMillis(field("collect_duration_ms")),
+			RegisterDurationMs:   parseMillis(field("register_duration_ms")),
+		})
+	}
+
+	return report
+}
+
+// IncludeRaw embeds the complete device map of each entry under its "raw" key, for debugging.
+// allDevices must be the list the report was built from. Secret fields, recognized by
+// config.IsSecretName, are left out.
+func (r *Report) IncludeRaw(allDevices []map[string]string) {
+	for i, device := range allDevices {
+		if i >= len(r.Devices) {
+			return
+		}
+		raw := make(map[string]string, len(device))
+		for key, value := range device {
+			if !config.IsSecretName(key) {
+				raw[key] = value
+			}
+		}
+		r.Devices[i].Raw = raw
+	}
+}
+
+// classifiedDevice is a device as found in one of the classified lists
+type classifiedDevice struct {
+	device map[string]string
+	status Status
+}
+
+// WriteFile writes the report as indented JSON to the given path.
+func WriteFile(path string, report Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write JSON report: %w", err)
+	}
+	return nil
+}
+
+// RegistrationStatus derives the status of a registration candidate from its result text
+func RegistrationStatus(result string) Status {
+	switch {
+	case strings.HasPrefix(result, "Successfully registered"):
+		return StatusRegistered
+	case strings.HasPrefix(result, "Failed"):
+		return StatusRegistrationFailed
+	case strings.HasPrefix(result, "Soft failure"):
+		return StatusSoftFailed
+	default:
+		return StatusSkipped
+	}
+}
+
+// deviceKey identifies a device across the classified lists
+func deviceKey(device map[string]string) string {
+	return device["serial"] + "|" + device["hostname"]
+}
+
+// parseMillis parses a recorded duration in milliseconds, returning 0 when it was not recorded
+func parseMillis(value string) int64 {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return ms
+}
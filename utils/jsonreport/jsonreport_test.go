@@ -0,0 +1,118 @@
+package jsonreport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	registered := map[string]string{"hostname": "fw-registered", "serial": "001", "collect_duration_ms": "120", "register_duration_ms": "3400", "result": "Successfully registered WildFire"}
+	failed := map[string]string{"hostname": "fw-failed", "serial": "002", "collect_duration_ms": "95", "register_duration_ms": "45000", "result": "Failed to register WildFire - timeout"}
+	skipped := map[string]string{"hostname": "fw-skipped", "serial": "003", "collect_duration_ms": "80", "result": "Skipped WildFire registration (Report-only mode)"}
+	ineligible := map[string]string{"hostname": "fw-ineligible", "serial": "004", "collect_duration_ms": "110"}
+	unsupported := map[string]string{"hostname": "fw-unsupported", "serial": "005", "collect_duration_ms": "not-a-number", "minimumUpdateRelease": "10.1.14-h2"}
+	unclassified := map[string]string{"hostname": "fw-unclassified", "serial": "006"}
+
+	allDevices := []map[string]string{registered, failed, skipped, ineligible, unsupported, unclassified}
+	report := Build(allDevices, []map[string]string{ineligible}, []map[string]string{unsupported}, []map[string]string{registered, failed, skipped}, time.Now())
+
+	assert.Equal(t, Summary{DevicesTotal: 6, IneligibleHardware: 1, UnsupportedVersions: 1, RegistrationCandidates: 3}, report.Summary)
+	require.Len(t, report.Devices, 6)
+
+	expectedStatuses := []Status{StatusRegistered, StatusRegistrationFailed, StatusSkipped, StatusIneligibleHardware, StatusUnsupportedVersion, StatusUnknown}
+	for i, entry := range report.Devices {
+		assert.Equal(t, allDevices[i]["hostname"], entry.Hostname)
+		assert.Equal(t, expectedStatuses[i], entry.Status, entry.Hostname)
+		assert.GreaterOrEqual(t, entry.CollectDurationMs, int64(0))
+		assert.GreaterOrEqual(t, entry.RegisterDurationMs, int64(0))
+	}
+
+	assert.Equal(t, int64(120), report.Devices[0].CollectDurationMs)
+	assert.Equal(t, int64(3400), report.Devices[0].RegisterDurationMs)
+	assert.Equal(t, int64(0), report.Devices[2].RegisterDurationMs, "skipped devices were never registered")
+	assert.Equal(t, int64(0), report.Devices[4].CollectDurationMs, "unparseable durations are reported as 0")
+	assert.Equal(t, "10.1.14-h2", report.Devices[4].MinimumUpdateRelease)
+}
+
+func TestBuildReadsClassifiedCopies(t *testing.T) {
+	collected := map[string]string{"hostname": "fw-1", "serial": "001", "collect_duration_ms": "50"}
+	candidate := map[string]string{"hostname": "fw-1", "serial": "001", "collect_duration_ms": "50", "register_duration_ms": "2000", "result": "Successfully registered WildFire"}
+
+	report := Build([]map[string]string{collected}, nil, nil, []map[string]string{candidate}, time.Now())
+
+	require.Len(t, report.Devices, 1)
+	assert.Equal(t, StatusRegistered, report.Devices[0].Status)
+	assert.Equal(t, int64(50), report.Devices[0].CollectDurationMs)
+	assert.Equal(t, int64(2000), report.Devices[0].RegisterDurationMs)
+	assert.Equal(t, "Successfully registered WildFire", report.Devices[0].Result)
+}
+
+func TestIncludeRaw(t *testing.T) {
+	devices := []map[string]string{{
+		"hostname":         "fw-1",
+		"serial":           "001",
+		"app-version":      "8799-8509",
+		"ha-state":         "active",
+		"inventory_token":  "s3cr3t",
+		"snmp-password":    "hunter2",
+		"operational-mode": "normal",
+	}}
+	report := Build(devices, nil, nil, nil, time.Now())
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"raw"`, "the raw map is only embedded on request")
+
+	report.IncludeRaw(devices)
+	data, err = json.Marshal(report)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Devices []struct {
+			Raw map[string]string `json:"raw"`
+		} `json:"devices"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Len(t, decoded.Devices, 1)
+	assert.Equal(t, map[string]string{
+		"hostname":         "fw-1",
+		"serial":           "001",
+		"app-version":      "8799-8509",
+		"ha-state":         "active",
+		"operational-mode": "normal",
+	}, decoded.Devices[0].Raw)
+	assert.NotContains(t, string(data), "s3cr3t")
+	assert.NotContains(t, string(data), "hunter2")
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	devices := []map[string]string{
+		{"hostname": "fw-1", "serial": "001", "collect_duration_ms": "42", "register_duration_ms": "1500", "result": "Successfully registered WildFire"},
+	}
+	report := Build(devices, nil, nil, devices, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+
+	require.NoError(t, WriteFile(path, report))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Devices []map[string]interface{} `json:"devices"`
+	}
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	require.Len(t, decoded.Devices, 1)
+
+	for _, field := range []string{"collect_duration_ms", "register_duration_ms", "status"} {
+		assert.Contains(t, decoded.Devices[0], field)
+	}
+	assert.Equal(t, "registered", decoded.Devices[0]["status"])
+	assert.Equal(t, float64(42), decoded.Devices[0]["collect_duration_ms"])
+	assert.Equal(t, float64(1500), decoded.Devices[0]["register_duration_ms"])
+}
@@ -0,0 +1,84 @@
+// Package jsonreport utils/jsonreport/summary.go
+package jsonreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RunSummary is the compact outcome of a run for automation, such as a pipeline deciding whether
+// to retry: the counts per status, the exit code of the process and the result of each device.
+type RunSummary struct {
+	RunID       string `json:"run_id,omitempty"`
+	ExitCode    int    `json:"exit_code"`
+	Total       int    `json:"total"`
+	Candidates  int    `json:"candidates"`
+	Succeeded   int    `json:"succeeded"`
+	Failed      int    `json:"failed"`
+	SoftFailed  int    `json:"soft_failed"`
+	Skipped     int    `json:"skipped"`
+	Ineligible  int    `json:"ineligible"`
+	Unsupported int    `json:"unsupported"`
+	// Unknown counts the devices that were collected but not classified, such as devices whose
+	// PAN-OS version could not be parsed
+	Unknown int             `json:"unknown"`
+	Devices []SummaryDevice `json:"devices"`
+}
+
+// SummaryDevice is the result of a single device in the run summary
+type SummaryDevice struct {
+	Hostname string `json:"hostname"`
+	Serial   string `json:"serial"`
+	Status   Status `json:"status"`
+	Result   string `json:"result,omitempty"`
+}
+
+// BuildSummary summarizes a report, counting its devices by status. The exit code is the one the
+// process exits with.
+func BuildSummary(report Report, exitCode int) RunSummary {
+	summary := RunSummary{
+		RunID:      report.RunID,
+		ExitCode:   exitCode,
+		Total:      len(report.Devices),
+		Candidates: report.Summary.RegistrationCandidates,
+		Devices:    make([]SummaryDevice, 0, len(report.Devices)),
+	}
+	for _, device := range report.Devices {
+		switch device.Status {
+		case StatusRegistered:
+			summary.Succeeded++
+		case StatusRegistrationFailed:
+			summary.Failed++
+		case StatusSoftFailed:
+			summary.SoftFailed++
+		case StatusSkipped:
+			summary.Skipped++
+		case StatusIneligibleHardware:
+			summary.Ineligible++
+		case StatusUnsupportedVersion:
+			summary.Unsupported++
+		default:
+			summary.Unknown++
+		}
+		summary.Devices = append(summary.Devices, SummaryDevice{
+			Hostname: device.Hostname,
+			Serial:   device.Serial,
+			Status:   device.Status,
+			Result:   device.Result,
+		})
+	}
+	return summary
+}
+
+// WriteSummary writes the run summary as indented JSON to the given path.
+func WriteSummary(path string, summary RunSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run summary: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package jsonreport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSummary(t *testing.T) {
+	all := []map[string]string{
+		{"hostname": "fw-ok", "serial": "1"},
+		{"hostname": "fw-failed", "serial": "2"},
+		{"hostname": "fw-busy", "serial": "3"},
+		{"hostname": "fw-policy", "serial": "4"},
+		{"hostname": "fw-old-hw", "serial": "5"},
+		{"hostname": "fw-old-os", "serial": "6"},
+		{"hostname": "fw-unparsed", "serial": "7", "result": "Skipped WildFire registration (PAN-OS version could not be parsed)"},
+	}
+	candidates := []map[string]string{
+		{"hostname": "fw-ok", "serial": "1", "result": "Successfully registered WildFire"},
+		{"hostname": "fw-failed", "serial": "2", "result": "Failed to register WildFire - connection refused"},
+		{"hostname": "fw-busy", "serial": "3", "result": "Soft failure registering WildFire - device busy"},
+		{"hostname": "fw-policy", "serial": "4", "result": "Skipped WildFire registration (skip_registration is set)"},
+	}
+	report := Build(all, all[4:5], all[5:6], candidates, time.Now())
+	report.RunID = "run-1"
+
+	summary := BuildSummary(report, 1)
+
+	assert.Equal(t, "run-1", summary.RunID)
+	assert.Equal(t, 1, summary.ExitCode)
+	assert.Equal(t, 7, summary.Total)
+	assert.Equal(t, 4, summary.Candidates)
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 1, summary.SoftFailed)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 1, summary.Ineligible)
+	assert.Equal(t, 1, summary.Unsupported)
+	assert.Equal(t, 1, summary.Unknown)
+
+	require.Len(t, summary.Devices, 7)
+	assert.Equal(t, SummaryDevice{Hostname: "fw-failed", Serial: "2", Status: StatusRegistrationFailed, Result: "Failed to register WildFire - connection refused"}, summary.Devices[1])
+	assert.Equal(t, StatusIneligibleHardware, summary.Devices[4].Status)
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	require.NoError(t, WriteSummary(path, summary))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded RunSummary
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, summary, decoded)
+	assert.Contains(t, string(data), `"exit_code": 1`)
+}
+
+func TestBuildSummaryEmptyRun(t *testing.T) {
+	summary := BuildSummary(Build(nil, nil, nil, nil, time.Now()), 0)
+
+	data, err := json.Marshal(summary)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"devices":[]`, "automation must not have to handle a null device list")
+}
@@ -0,0 +1,111 @@
+// Package junit utils/junit/junit.go
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+)
+
+// SuiteName is the name of the test suite holding the devices of a run
+const SuiteName = "wildfire-registration"
+
+// TestSuites is the root element of a JUnit XML report
+type TestSuites struct {
+	XMLName  xml.Name    `xml:"testsuites"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Suites   []TestSuite `xml:"testsuite"`
+}
+
+// TestSuite groups the devices of a run
+type TestSuite struct {
+	Name      string     `xml:"name,attr"`
+	Tests     int        `xml:"tests,attr"`
+	Failures  int        `xml:"failures,attr"`
+	Skipped   int        `xml:"skipped,attr"`
+	Time      string     `xml:"time,attr"`
+	Timestamp string     `xml:"timestamp,attr"`
+	TestCases []TestCase `xml:"testcase"`
+}
+
+// TestCase is a single device: passed when registered, failed when the registration failed or
+// soft failed, and skipped otherwise, e.g. for ineligible hardware or report-only runs.
+type TestCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	Time      string   `xml:"time,attr"`
+	Failure   *Message `xml:"failure,omitempty"`
+	Skipped   *Message `xml:"skipped,omitempty"`
+}
+
+// Message is the failure or skip reason of a test case
+type Message struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// Build creates the JUnit report of a run from its JSON report, with one test case per device.
+func Build(report jsonreport.Report) TestSuites {
+	suite := TestSuite{
+		Name:      SuiteName,
+		Timestamp: report.GeneratedAt.UTC().Format("2006-01-02T15:04:05"),
+	}
+
+	var totalMs int64
+	for _, device := range report.Devices {
+		durationMs := device.CollectDurationMs + device.RegisterDurationMs
+		totalMs += durationMs
+		testCase := TestCase{
+			Name:      device.Hostname,
+			ClassName: fmt.Sprintf("%s.%s", SuiteName, device.Model),
+			Time:      seconds(durationMs),
+		}
+
+		switch device.Status {
+		case jsonreport.StatusRegistered:
+		case jsonreport.StatusRegistrationFailed, jsonreport.StatusSoftFailed:
+			testCase.Failure = &Message{Message: device.Result, Type: string(device.Status), Text: device.Result}
+			suite.Failures++
+		default:
+			reason := device.Result
+			if reason == "" {
+				reason = string(device.Status)
+			}
+			testCase.Skipped = &Message{Message: reason}
+			suite.Skipped++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Tests = len(suite.TestCases)
+	suite.Time = seconds(totalMs)
+
+	return TestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []TestSuite{suite},
+	}
+}
+
+// WriteFile writes the JUnit report as indented XML to the given path.
+func WriteFile(path string, suites TestSuites) error {
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JUnit report: %w", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	return nil
+}
+
+// seconds formats a duration in milliseconds as seconds, the JUnit time unit
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
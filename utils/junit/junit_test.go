@@ -0,0 +1,61 @@
+package junit
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	registered := map[string]string{"hostname": "fw-registered", "serial": "001", "model": "PA-3260", "collect_duration_ms": "120", "register_duration_ms": "3400", "result": "Successfully registered WildFire"}
+	failed := map[string]string{"hostname": "fw-failed", "serial": "002", "model": "PA-3260", "result": "Failed to register WildFire - timeout"}
+	softFailed := map[string]string{"hostname": "fw-busy", "serial": "003", "model": "PA-3260", "result": "Soft failure registering WildFire - device busy"}
+	skipped := map[string]string{"hostname": "fw-skipped", "serial": "004", "model": "PA-3260", "result": "Skipped WildFire registration (Report-only mode)"}
+	ineligible := map[string]string{"hostname": "fw-ineligible", "serial": "005", "model": "PA-460"}
+
+	allDevices := []map[string]string{registered, failed, softFailed, skipped, ineligible}
+	report := jsonreport.Build(allDevices, []map[string]string{ineligible}, nil, []map[string]string{registered, failed, softFailed, skipped}, time.Now())
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	require.NoError(t, WriteFile(path, Build(report)))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), xml.Header))
+
+	var suites TestSuites
+	require.NoError(t, xml.Unmarshal(data, &suites), "the report must be valid XML")
+	assert.Equal(t, 5, suites.Tests)
+	assert.Equal(t, 2, suites.Failures)
+	assert.Equal(t, 2, suites.Skipped)
+	require.Len(t, suites.Suites, 1)
+
+	suite := suites.Suites[0]
+	assert.Equal(t, SuiteName, suite.Name)
+	assert.Equal(t, 5, suite.Tests)
+	assert.Equal(t, 2, suite.Failures)
+	assert.Equal(t, 2, suite.Skipped)
+	require.Len(t, suite.TestCases, 5)
+
+	passed := suite.TestCases[0]
+	assert.Equal(t, "fw-registered", passed.Name)
+	assert.Nil(t, passed.Failure)
+	assert.Nil(t, passed.Skipped)
+	assert.Equal(t, "3.520", passed.Time)
+
+	require.NotNil(t, suite.TestCases[1].Failure)
+	assert.Equal(t, "Failed to register WildFire - timeout", suite.TestCases[1].Failure.Message)
+	require.NotNil(t, suite.TestCases[2].Failure)
+	assert.Equal(t, string(jsonreport.StatusSoftFailed), suite.TestCases[2].Failure.Type)
+	require.NotNil(t, suite.TestCases[3].Skipped)
+	assert.Equal(t, "Skipped WildFire registration (Report-only mode)", suite.TestCases[3].Skipped.Message)
+	require.NotNil(t, suite.TestCases[4].Skipped)
+	assert.Equal(t, string(jsonreport.StatusIneligibleHardware), suite.TestCases[4].Skipped.Message)
+}
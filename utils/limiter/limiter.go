@@ -0,0 +1,75 @@
+// Package limiter utils/limiter/limiter.go
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter is a counting semaphore bounding the number of simultaneous device connections.
+// A single Limiter can be shared between phases, such as the certificate check and the WildFire
+// registration, so the total stays bounded even when they overlap. A nil Limiter does not limit.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// New returns a Limiter allowing at most max simultaneous holders, or nil when max is not positive.
+func New(max int) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, max)}
+}
+
+// Acquire blocks until a slot is available.
+func (l *Limiter) Acquire() {
+	if l == nil {
+		return
+	}
+	l.slots <- struct{}{}
+}
+
+// AcquireContext blocks until a slot is available or ctx is done. It returns the error of ctx,
+// without taking a slot, if ctx is done first; a done ctx never takes a slot, even if one is
+// free. A nil Limiter only checks ctx.
+func (l *Limiter) AcquireContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil || l == nil {
+		return err
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire or AcquireContext.
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// RampUp starts the Limiter at a single slot and frees the other slots one at a time, evenly
+// spread over d, so connections ramp up to the full limit instead of all starting at once. It must
+// be called before the first Acquire and returns immediately. A non-positive d does nothing.
+func (l *Limiter) RampUp(d time.Duration) {
+	if l == nil || d <= 0 || cap(l.slots) < 2 {
+		return
+	}
+	// Hold all slots but one; they are freed by the ramp below
+	reserved := cap(l.slots) - 1
+	for i := 0; i < reserved; i++ {
+		l.slots <- struct{}{}
+	}
+	go func() {
+		ticker := time.NewTicker(d / time.Duration(reserved))
+		defer ticker.Stop()
+		for i := 0; i < reserved; i++ {
+			<-ticker.C
+			<-l.slots
+		}
+	}()
+}
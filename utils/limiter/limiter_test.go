@@ -0,0 +1,107 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterSharedAcrossPhases(t *testing.T) {
+	l := New(3)
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	connect := func() {
+		l.Acquire()
+		defer l.Release()
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}
+
+	// Two phases with 10 connections each run at the same time
+	var wg sync.WaitGroup
+	for phase := 0; phase < 2; phase++ {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				connect()
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, maxActive)
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := New(0)
+	assert.Nil(t, l)
+
+	// A nil limiter never blocks
+	for i := 0; i < 100; i++ {
+		l.Acquire()
+	}
+	l.Release()
+}
+
+func TestLimiterAcquireContext(t *testing.T) {
+	l := New(1)
+	require.NoError(t, l.AcquireContext(context.Background()))
+
+	// The only slot is taken, so the wait ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.AcquireContext(ctx), context.DeadlineExceeded)
+
+	// A cancelled context never takes a slot, even a free one
+	l.Release()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.AcquireContext(cancelled), context.Canceled)
+	assert.ErrorIs(t, (*Limiter)(nil).AcquireContext(cancelled), context.Canceled)
+	assert.NoError(t, (*Limiter)(nil).AcquireContext(context.Background()))
+	require.NoError(t, l.AcquireContext(context.Background()), "the slot is still free")
+}
+
+func TestLimiterRampUp(t *testing.T) {
+	l := New(4)
+	l.RampUp(90 * time.Millisecond)
+
+	// Workers hold their slot until the end of the test
+	var active int32
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 10; i++ {
+		go func() {
+			l.Acquire()
+			atomic.AddInt32(&active, 1)
+			<-done
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&active), "the ramp starts with a single worker")
+
+	time.Sleep(40 * time.Millisecond)
+	midway := atomic.LoadInt32(&active)
+	assert.Greater(t, midway, int32(1), "workers are added during the ramp")
+	assert.Less(t, midway, int32(4), "the target is only reached at the end of the ramp")
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&active) == 4 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&active), "the limit still holds after the ramp")
+}
@@ -0,0 +1,105 @@
+// Package metrics utils/metrics/metrics.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+)
+
+// RunMetrics holds the counters collected during a single run
+type RunMetrics struct {
+	RunID                  string
+	DevicesTotal           int
+	IneligibleHardware     int
+	UnsupportedVersions    int
+	RegistrationCandidates int
+	RegistrationSucceeded  int
+	RegistrationFailed     int
+	RegistrationSoftFailed int
+	CertificateCompliance  float64
+	DurationSeconds        float64
+}
+
+// metric describes a single OpenMetrics sample
+type metric struct {
+	name   string
+	help   string
+	value  float64
+	labels string
+}
+
+// FromResults builds the run metrics from the classified device lists and the registration results.
+func FromResults(allDevices, ineligibleHardware, unsupportedVersions, registrationCandidates []map[string]string, results []string, durationSeconds float64) RunMetrics {
+	m := RunMetrics{
+		DevicesTotal:           len(allDevices),
+		IneligibleHardware:     len(ineligibleHardware),
+		UnsupportedVersions:    len(unsupportedVersions),
+		RegistrationCandidates: len(registrationCandidates),
+		CertificateCompliance:  compliance.Score(allDevices),
+		DurationSeconds:        durationSeconds,
+	}
+	for _, result := range results {
+		switch {
+		case strings.Contains(result, "Successfully registered"):
+			m.RegistrationSucceeded++
+		case strings.Contains(result, "Soft failure"):
+			m.RegistrationSoftFailed++
+		default:
+			m.RegistrationFailed++
+		}
+	}
+	return m
+}
+
+func (m RunMetrics) metrics() []metric {
+	metrics := []metric{
+		{"cdss_devices_total", "Number of devices collected.", float64(m.DevicesTotal), ""},
+		{"cdss_devices_ineligible_hardware", "Number of devices skipped because of their hardware platform.", float64(m.IneligibleHardware), ""},
+		{"cdss_devices_unsupported_version", "Number of devices that require a PAN-OS upgrade.", float64(m.UnsupportedVersions), ""},
+		{"cdss_registration_candidates", "Number of devices eligible for WildFire registration.", float64(m.RegistrationCandidates), ""},
+		{"cdss_registration_succeeded", "Number of successful WildFire registrations.", float64(m.RegistrationSucceeded), ""},
+		{"cdss_registration_failed", "Number of failed WildFire registrations.", float64(m.RegistrationFailed), ""},
+		{"cdss_registration_soft_failed", "Number of WildFire registrations that failed because of a transient condition.", float64(m.RegistrationSoftFailed), ""},
+		{"cdss_certificate_compliance_percent", "Percentage of devices with a valid, non-expiring device certificate.", m.CertificateCompliance, ""},
+		{"cdss_run_duration_seconds", "Duration of the run in seconds.", m.DurationSeconds, ""},
+	}
+	if m.RunID != "" {
+		metrics = append(metrics, metric{"cdss_run_info", "Identifier of the run, for correlation with its logs and reports.", 1, fmt.Sprintf("{run_id=%q}", m.RunID)})
+	}
+	return metrics
+}
+
+// WriteOpenMetrics writes the metrics in the OpenMetrics text exposition format.
+// The output is also accepted by Prometheus text format parsers.
+func (m RunMetrics) WriteOpenMetrics(w io.Writer) error {
+	var b strings.Builder
+	for _, metric := range m.metrics() {
+		fmt.Fprintf(&b, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", metric.name)
+		fmt.Fprintf(&b, "%s%s %s\n", metric.name, metric.labels, strconv.FormatFloat(metric.value, 'f', -1, 64))
+	}
+	b.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFile writes the metrics in OpenMetrics format to the given path.
+func WriteFile(path string, m RunMetrics) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create metrics file: %w", err)
+	}
+
+	if err := m.WriteOpenMetrics(file); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+
+	return file.Close()
+}
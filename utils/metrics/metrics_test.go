@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromResults(t *testing.T) {
+	devices := []map[string]string{{}, {}, {}, {}, {}}
+	results := []string{
+		"fw-1: Successfully registered WildFire",
+		"fw-2: Failed to register WildFire - timeout",
+		"fw-3: Soft failure registering WildFire - device is busy",
+	}
+
+	m := FromResults(devices, devices[:1], devices[1:2], devices[2:], results, 1.5)
+
+	assert.Equal(t, RunMetrics{
+		DevicesTotal:           5,
+		IneligibleHardware:     1,
+		UnsupportedVersions:    1,
+		RegistrationCandidates: 3,
+		RegistrationSucceeded:  1,
+		RegistrationFailed:     1,
+		RegistrationSoftFailed: 1,
+		DurationSeconds:        1.5,
+	}, m)
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	m := RunMetrics{
+		RunID:                  "20240701T120000Z-0a1b2c3d",
+		DevicesTotal:           10,
+		IneligibleHardware:     2,
+		UnsupportedVersions:    3,
+		RegistrationCandidates: 5,
+		RegistrationSucceeded:  4,
+		RegistrationFailed:     1,
+		RegistrationSoftFailed: 2,
+		CertificateCompliance:  62.5,
+		DurationSeconds:        12.25,
+	}
+
+	require.NoError(t, WriteFile(path, m))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	output := string(content)
+
+	for _, line := range []string{
+		"cdss_devices_total 10",
+		"cdss_devices_ineligible_hardware 2",
+		"cdss_devices_unsupported_version 3",
+		"cdss_registration_candidates 5",
+		"cdss_registration_succeeded 4",
+		"cdss_registration_failed 1",
+		"cdss_registration_soft_failed 2",
+		"cdss_certificate_compliance_percent 62.5",
+		"cdss_run_duration_seconds 12.25",
+		`cdss_run_info{run_id="20240701T120000Z-0a1b2c3d"} 1`,
+	} {
+		assert.Contains(t, output, line+"\n")
+	}
+
+	// Every line is either a HELP/TYPE comment or a sample, and the exposition ends with # EOF
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	assert.Equal(t, "# EOF", lines[len(lines)-1])
+
+	commentPattern := regexp.MustCompile(`^# (HELP|TYPE) [a-zA-Z_:][a-zA-Z0-9_:]* .+$`)
+	samplePattern := regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-z_]+="[^"]*"\})? -?[0-9]+(\.[0-9]+)?$`)
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "#") {
+			assert.Regexp(t, commentPattern, line)
+		} else {
+			assert.Regexp(t, samplePattern, line)
+		}
+	}
+}
+
+func TestWriteFileError(t *testing.T) {
+	err := WriteFile(filepath.Join(t.TempDir(), "missing", "metrics.prom"), RunMetrics{})
+	assert.Error(t, err)
+}
@@ -2,11 +2,18 @@
 package pdf
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
+	stdimage "image"
+	_ "image/png"
 	"os"
 	"path/filepath"
+	"strings"
 
+	appconfig "github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/compliance"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
 	"github.com/johnfercher/maroto/v2"
 	"github.com/johnfercher/maroto/v2/pkg/components/col"
 	"github.com/johnfercher/maroto/v2/pkg/components/image"
@@ -14,14 +21,21 @@ import (
 	"github.com/johnfercher/maroto/v2/pkg/components/text"
 	"github.com/johnfercher/maroto/v2/pkg/config"
 	"github.com/johnfercher/maroto/v2/pkg/consts/align"
+	"github.com/johnfercher/maroto/v2/pkg/consts/extension"
 	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/consts/protection"
 	"github.com/johnfercher/maroto/v2/pkg/core"
 	"github.com/johnfercher/maroto/v2/pkg/props"
 )
 
 // GeneratePDFReport creates a PDF report using the maroto library.
-func GeneratePDFReport(allDevices, ineligibleHardware, unsupportedVersions, registrationCandidates []map[string]string, reportName string) error {
-	m := GetMaroto(allDevices, ineligibleHardware, unsupportedVersions, registrationCandidates)
+// The effective settings of the run are listed in an appendix at the end of the report.
+// A non-empty password encrypts the report, which then cannot be opened without it.
+func GeneratePDFReport(allDevices, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates []map[string]string, settings []appconfig.Setting, reportName, password string) error {
+	m, err := GetMaroto(allDevices, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates, settings, password)
+	if err != nil {
+		return err
+	}
 	document, err := m.Generate()
 	if err != nil {
 		return err
@@ -45,47 +59,108 @@ func GeneratePDFReport(allDevices, ineligibleHardware, unsupportedVersions, regi
 	return nil
 }
 
-func GetMaroto(allDevices, ineligibleHardware, unsupportedVersions, registrationCandidates []map[string]string) core.Maroto {
-	cfg := config.NewBuilder().
+// MaxCellLength is the maximum number of characters of a table cell value, set from
+// -max-cell-length. Longer values, such as long error messages, are cut with an ellipsis instead
+// of overflowing their cell. 0 or less renders values in full.
+var MaxCellLength = appconfig.DefaultMaxCellLength
+
+// cellEllipsis marks a truncated cell value
+const cellEllipsis = "..."
+
+// truncateCell cuts a table cell value to MaxCellLength characters, ending it with an ellipsis.
+// The devices keep their full values, so only the PDF rendering is affected. The guidance and
+// DNS/NTP rows spanning the table are sentences rather than cell values and are never truncated.
+func truncateCell(value string) string {
+	runes := []rune(value)
+	if MaxCellLength <= 0 || len(runes) <= MaxCellLength {
+		return value
+	}
+	if MaxCellLength <= len(cellEllipsis) {
+		return string(runes[:MaxCellLength])
+	}
+	return string(runes[:MaxCellLength-len(cellEllipsis)]) + cellEllipsis
+}
+
+// rowChunkSize is the number of content rows appended to the document at a time.
+// It must be even so the alternating row background stays consistent across chunks.
+const rowChunkSize = 500
+
+// GetMaroto builds the report document. With a password, the document is encrypted and only
+// printing is permitted once it is opened. Errors building the page header, footer or tables are
+// returned, so the caller can fall back to another report.
+func GetMaroto(allDevices, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates []map[string]string, settings []appconfig.Setting, password string) (core.Maroto, error) {
+	builder := config.NewBuilder().
 		WithPageNumber().
 		WithLeftMargin(10).
 		WithTopMargin(15).
-		WithRightMargin(10).
-		Build()
+		WithRightMargin(10)
+	if password != "" {
+		builder = builder.WithProtection(protection.Print, password, password)
+	}
+	cfg := builder.Build()
 
 	mrt := maroto.New(cfg)
 	m := maroto.NewMetricsDecorator(mrt)
 
-	err := m.RegisterHeader(getPageHeader())
+	header, err := getPageHeader()
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
-
-	err = m.RegisterFooter(getPageFooter())
-	if err != nil {
-		log.Fatal(err.Error())
+	if err := m.RegisterHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to register the page header: %w", err)
+	}
+	if err := m.RegisterFooter(getPageFooter()); err != nil {
+		return nil, fmt.Errorf("failed to register the page footer: %w", err)
 	}
 
+	// Certificate compliance summary
+	m.AddRows(getComplianceRow(compliance.Score(allDevices), len(allDevices)))
+
 	// All Devices Table
-	addDevicesTable(m, allDevices, "All PAN-OS NGFW Devices", "List of all NGFW devices that will be considered for this job", "allDevices")
+	if err := addDevicesTable(m, allDevices, "All PAN-OS NGFW Devices", "List of all NGFW devices that will be considered for this job", "allDevices"); err != nil {
+		return nil, err
+	}
 
 	// Ineligible Hardware Table
-	addDevicesTable(m, ineligibleHardware, "Skipped Because of Hardware", "Devices with hardware platforms unaffected by services registration with Device Certificate", "ineligibleHardware")
+	if err := addDevicesTable(m, ineligibleHardware, "Skipped Because of Hardware", "Devices with hardware platforms unaffected by services registration with Device Certificate", "ineligibleHardware"); err != nil {
+		return nil, err
+	}
 
 	// Unsupported Versions Table
-	addDevicesTable(m, unsupportedVersions, "Skipped Because of PAN-OS Versions", "Devices that require a PAN-OS upgrade to support Device Certificate registration to CDSS services", "unsupportedVersions")
+	if err := addDevicesTable(m, unsupportedVersions, "Skipped Because of PAN-OS Versions", "Devices that require a PAN-OS upgrade to support Device Certificate registration to CDSS services", "unsupportedVersions"); err != nil {
+		return nil, err
+	}
+
+	// Unparseable Versions Table, only when a version could not be parsed
+	if len(versionParseFailed) > 0 {
+		if err := addDevicesTable(m, versionParseFailed, "Skipped Because of Unparseable PAN-OS Versions", "Devices whose PAN-OS version was not collected or could not be parsed, so they could not be classified", "versionParseFailed"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Devices grouped by PAN-OS feature release, for upgrade planning
+	addReleaseGroupsTable(m, filters.GroupByFeatureRelease(classifiedDevices(ineligibleHardware, unsupportedVersions, registrationCandidates)))
 
 	// Registration Candidates Table
-	addDevicesTable(m, registrationCandidates, "WildFire Registration Candidates", "Devices eligible for WildFire registration with device certificate", "registrationCandidates")
+	if err := addDevicesTable(m, registrationCandidates, "WildFire Registration Candidates", "Devices eligible for WildFire registration with device certificate", "registrationCandidates"); err != nil {
+		return nil, err
+	}
 
 	// All Devices Certificate Table
-	addDevicesTable(m, allDevices, "Device Certificate Status", "Status of the NGFW's Device Certificate", "deviceCertificateStatus")
+	if err := addDevicesTable(m, allDevices, "Device Certificate Status", "Status of the NGFW's Device Certificate", "deviceCertificateStatus"); err != nil {
+		return nil, err
+	}
 
-	return m
+	// Effective Configuration Appendix
+	if len(settings) > 0 {
+		addConfigurationAppendix(m, settings)
+	}
 
+	return m, nil
 }
 
-func addDevicesTable(m core.Maroto, devices []map[string]string, title, description, tableType string) {
+// addDevicesTable adds the table of tableType listing devices, with its title and description
+func addDevicesTable(m core.Maroto, devices []map[string]string, title, description, tableType string) error {
 	darkGrayColor := getDarkGrayColor()
 
 	m.AddRows(text.NewRow(10, title, props.Text{
@@ -101,37 +176,132 @@ func addDevicesTable(m core.Maroto, devices []map[string]string, title, descript
 		Align: align.Center,
 		Color: &props.WhiteColor,
 	})).WithStyle(&props.Cell{BackgroundColor: darkGrayColor})
-	m.AddRows(getDeviceRows(devices, tableType)...)
+	header, err := getHeaderRow(tableType)
+	if err != nil {
+		return err
+	}
+	m.AddRows(header)
+
+	// Append content rows in chunks so only one chunk of rows is held outside the document at a time
+	for start := 0; start < len(devices); start += rowChunkSize {
+		end := start + rowChunkSize
+		if end > len(devices) {
+			end = len(devices)
+		}
+		rows, err := getContentRows(devices[start:end], tableType)
+		if err != nil {
+			return err
+		}
+		m.AddRows(rows...)
+	}
+
+	// Add some space between tables
+	m.AddRow(10, col.New(12))
+	return nil
+}
+
+// classifiedDevices joins the classified lists, which hold the classification fields such as "minimumUpdateRelease"
+func classifiedDevices(lists ...[]map[string]string) []map[string]string {
+	var devices []map[string]string
+	for _, list := range lists {
+		devices = append(devices, list...)
+	}
+	return devices
+}
+
+// addReleaseGroupsTable lists the devices under their PAN-OS feature release, with the per-group
+// counts and recommended fix as the group heading.
+func addReleaseGroupsTable(m core.Maroto, groups []filters.ReleaseGroup) {
+	if len(groups) == 0 {
+		return
+	}
+
+	m.AddRows(text.NewRow(10, "Devices by PAN-OS Feature Release", props.Text{
+		Top:   3,
+		Size:  12,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+	}))
+	m.AddRow(7, text.NewCol(12, "Devices grouped by feature release, with the recommended fix for upgrade planning", props.Text{
+		Top:   1.5,
+		Size:  9,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+		Color: &props.WhiteColor,
+	})).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
+	m.AddRows(getReleaseGroupRows(groups)...)
 
 	// Add some space between tables
 	m.AddRow(10, col.New(12))
 }
 
-func getDeviceRows(deviceList []map[string]string, tableType string) []core.Row {
-	var headerRow core.Row
-	var contentRows []core.Row
+// getReleaseGroupRows returns a heading row per release group followed by one row per device
+func getReleaseGroupRows(groups []filters.ReleaseGroup) []core.Row {
+	var rows []core.Row
+	for _, group := range groups {
+		rows = append(rows, row.New(5).Add(
+			text.NewCol(12, fmt.Sprintf("PAN-OS %s: %s", group.Release, group.Summary()), props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		).WithStyle(&props.Cell{BackgroundColor: getGrayColor()}))
+		for _, device := range group.Devices {
+			rows = append(rows, row.New(4).Add(
+				text.NewCol(3, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+				text.NewCol(2, truncateCell(device["sw-version"]), props.Text{Size: 7, Align: align.Left}),
+				text.NewCol(3, truncateCell(device["model"]), props.Text{Size: 7, Align: align.Left}),
+				text.NewCol(4, truncateCell(device["minimumUpdateRelease"]), props.Text{Size: 7, Align: align.Left}),
+			))
+		}
+	}
+	return rows
+}
+
+func getDeviceRows(deviceList []map[string]string, tableType string) ([]core.Row, error) {
+	header, err := getHeaderRow(tableType)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := getContentRows(deviceList, tableType)
+	if err != nil {
+		return nil, err
+	}
+	return append([]core.Row{header}, rows...), nil
+}
 
+func getHeaderRow(tableType string) (core.Row, error) {
 	switch tableType {
 	case "allDevices":
-		headerRow = getAllDevicesHeaderRow()
-		contentRows = getAllDevicesContentRows(deviceList)
+		return getAllDevicesHeaderRow(), nil
 	case "ineligibleHardware":
-		headerRow = getIneligibleHardwareHeaderRow()
-		contentRows = getIneligibleHardwareContentRows(deviceList)
+		return getIneligibleHardwareHeaderRow(), nil
 	case "unsupportedVersions":
-		headerRow = getUnsupportedVersionsHeaderRow()
-		contentRows = getUnsupportedVersionsContentRows(deviceList)
+		return getUnsupportedVersionsHeaderRow(), nil
+	case "versionParseFailed":
+		return getVersionParseFailedHeaderRow(), nil
 	case "registrationCandidates":
-		headerRow = getRegistrationCandidatesHeaderRow()
-		contentRows = getRegistrationCandidatesContentRows(deviceList)
+		return getRegistrationCandidatesHeaderRow(), nil
 	case "deviceCertificateStatus":
-		headerRow = getDeviceCertificateStatusHeaderRow()
-		contentRows = getDeviceCertificateStatusContentRows(deviceList)
+		return getDeviceCertificateStatusHeaderRow(), nil
 	default:
-		log.Fatalf("Unknown table type: %s", tableType)
+		return nil, fmt.Errorf("unknown table type: %s", tableType)
 	}
+}
 
-	return append([]core.Row{headerRow}, contentRows...)
+func getContentRows(deviceList []map[string]string, tableType string) ([]core.Row, error) {
+	switch tableType {
+	case "allDevices":
+		return getAllDevicesContentRows(deviceList), nil
+	case "ineligibleHardware":
+		return getIneligibleHardwareContentRows(deviceList), nil
+	case "unsupportedVersions":
+		return getUnsupportedVersionsContentRows(deviceList), nil
+	case "versionParseFailed":
+		return getVersionParseFailedContentRows(deviceList), nil
+	case "registrationCandidates":
+		return getRegistrationCandidatesContentRows(deviceList), nil
+	case "deviceCertificateStatus":
+		return getDeviceCertificateStatusContentRows(deviceList), nil
+	default:
+		return nil, fmt.Errorf("unknown table type: %s", tableType)
+	}
 }
 
 func getIneligibleHardwareHeaderRow() core.Row {
@@ -148,11 +318,11 @@ func getIneligibleHardwareContentRows(deviceList []map[string]string) []core.Row
 	var rows []core.Row
 	for i, device := range deviceList {
 		r := row.New(4).Add(
-			text.NewCol(2, device["hostname"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["model"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["family"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["ip-address"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["serial"], props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["model"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["family"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(3, truncateCell(device["ip-address"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(3, truncateCell(device["serial"]), props.Text{Size: 7, Align: align.Left}),
 		)
 		if i%2 == 0 {
 			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
@@ -176,11 +346,37 @@ func getUnsupportedVersionsContentRows(deviceList []map[string]string) []core.Ro
 	var rows []core.Row
 	for i, device := range deviceList {
 		r := row.New(4).Add(
-			text.NewCol(2, device["hostname"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["sw-version"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["minimumUpdateRelease"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["model"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["ip-address"], props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["sw-version"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(3, truncateCell(device["minimumUpdateRelease"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["model"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(3, truncateCell(device["ip-address"]), props.Text{Size: 7, Align: align.Left}),
+		)
+		if i%2 == 0 {
+			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func getVersionParseFailedHeaderRow() core.Row {
+	return row.New(5).Add(
+		text.NewCol(2, "Hostname", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "SW Version", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "Model", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(6, "Reason", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+	)
+}
+
+func getVersionParseFailedContentRows(deviceList []map[string]string) []core.Row {
+	var rows []core.Row
+	for i, device := range deviceList {
+		r := row.New(4).Add(
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["sw-version"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["model"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(6, truncateCell(device["version_error"]), props.Text{Size: 7, Align: align.Left}),
 		)
 		if i%2 == 0 {
 			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
@@ -201,8 +397,8 @@ func getRegistrationCandidatesContentRows(deviceList []map[string]string) []core
 	var rows []core.Row
 	for i, device := range deviceList {
 		r := row.New(4).Add(
-			text.NewCol(2, device["hostname"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(10, device["result"], props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(10, truncateCell(device["result"]), props.Text{Size: 7, Align: align.Left}),
 		)
 		if i%2 == 0 {
 			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
@@ -217,8 +413,9 @@ func getAllDevicesHeaderRow() core.Row {
 		text.NewCol(2, "Hostname", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
 		text.NewCol(2, "SW Version", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
 		text.NewCol(2, "Model", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
-		text.NewCol(3, "IP Address", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
-		text.NewCol(3, "Serial", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "IP Address", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "Serial", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "Source", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
 	)
 }
 
@@ -226,11 +423,13 @@ func getAllDevicesContentRows(deviceList []map[string]string) []core.Row {
 	var rows []core.Row
 	for i, device := range deviceList {
 		r := row.New(4).Add(
-			text.NewCol(2, device["hostname"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["sw-version"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, device["model"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["ip-address"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, device["serial"], props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["sw-version"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["model"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["ip-address"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["serial"]), props.Text{Size: 7, Align: align.Left}),
+			// The Panorama or inventory the device was collected from, for reports combining several
+			text.NewCol(2, truncateCell(device["source"]), props.Text{Size: 7, Align: align.Left}),
 		)
 		if i%2 == 0 {
 			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
@@ -244,9 +443,10 @@ func getDeviceCertificateStatusHeaderRow() core.Row {
 	return row.New(5).Add(
 		text.NewCol(2, "Hostname", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
 		text.NewCol(2, "Status", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
-		text.NewCol(2, "Validity", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
-		text.NewCol(3, "Not Valid After", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
-		text.NewCol(3, "Seconds to Expire", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(1, "Validity", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "Not Valid After", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(2, "Seconds to Expire", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(3, "State", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
 	)
 }
 
@@ -261,30 +461,154 @@ func getDeviceCertificateStatusContentRows(deviceList []map[string]string) []cor
 				"validity":          "Unknown",
 				"not_valid_after":   "Unknown",
 				"seconds-to-expire": "Unknown",
+				"state":             "unknown",
 			}
 		}
 
 		r := row.New(4).Add(
-			text.NewCol(2, device["hostname"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, certStatus["status"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(2, certStatus["validity"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, certStatus["not_valid_after"], props.Text{Size: 7, Align: align.Left}),
-			text.NewCol(3, certStatus["seconds-to-expire"], props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(device["hostname"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(certStatus["status"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(1, truncateCell(certStatus["validity"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(certStatus["not_valid_after"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(2, truncateCell(certStatus["seconds-to-expire"]), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(3, truncateCell(certStatus["state"]), props.Text{Size: 7, Align: align.Left}),
 		)
 		if i%2 == 0 {
 			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
 		}
 		rows = append(rows, r)
+
+		// Devices that need action get a guidance row underneath
+		if guidance := certStatus["guidance"]; guidance != "" {
+			g := row.New(4).Add(
+				col.New(2),
+				text.NewCol(10, guidance, props.Text{Size: 6, Align: align.Left, Style: fontstyle.Italic}),
+			)
+			if i%2 == 0 {
+				g.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+			}
+			rows = append(rows, g)
+		}
+
+		// DNS and NTP are listed when they were collected with -collect-dns-ntp
+		if diagnostics := getDiagnosticsText(certStatus); diagnostics != "" {
+			d := row.New(4).Add(
+				col.New(2),
+				text.NewCol(10, diagnostics, props.Text{Size: 6, Align: align.Left}),
+			)
+			if i%2 == 0 {
+				d.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+			}
+			rows = append(rows, d)
+		}
 	}
 	return rows
 }
 
-func getPageHeader() core.Row {
-	return row.New(20).Add(
-		image.NewFromFileCol(3, "docs/assets/images/logo.png", props.Rect{
+// getDiagnosticsText describes the DNS servers and NTP status recorded with the certificate status,
+// or returns an empty string when they were not collected
+func getDiagnosticsText(certStatus map[string]string) string {
+	_, hasDNS := certStatus["dns_servers"]
+	_, hasNTP := certStatus["ntp_synched"]
+	if !hasDNS && !hasNTP {
+		return ""
+	}
+
+	dns := certStatus["dns_servers"]
+	if dns == "" {
+		dns = "none configured"
+	}
+	ntp := "not synchronized"
+	if synched := certStatus["ntp_synched"]; synched != "" && !strings.EqualFold(synched, "LOCAL") {
+		ntp = "synchronized to " + synched
+	}
+	if servers := certStatus["ntp_servers"]; servers != "" {
+		ntp += ", servers " + servers
+	}
+	return fmt.Sprintf("DNS: %s | NTP: %s", dns, ntp)
+}
+
+// addConfigurationAppendix lists the effective settings of the run, with secrets already redacted
+func addConfigurationAppendix(m core.Maroto, settings []appconfig.Setting) {
+	m.AddRows(text.NewRow(10, "Appendix: Effective Configuration", props.Text{
+		Top:   3,
+		Size:  12,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+	}))
+	m.AddRow(7, text.NewCol(12, "Flags and configuration used for this run, passwords are redacted", props.Text{
+		Top:   1.5,
+		Size:  9,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+		Color: &props.WhiteColor,
+	})).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
+	m.AddRows(getConfigurationRows(settings)...)
+}
+
+// getConfigurationRows renders the effective settings as a header row followed by one row per setting
+func getConfigurationRows(settings []appconfig.Setting) []core.Row {
+	rows := []core.Row{row.New(5).Add(
+		text.NewCol(4, "Setting", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+		text.NewCol(8, "Value", props.Text{Size: 8, Align: align.Left, Style: fontstyle.Bold}),
+	)}
+	for i, setting := range settings {
+		r := row.New(4).Add(
+			text.NewCol(4, truncateCell(setting.Name), props.Text{Size: 7, Align: align.Left}),
+			text.NewCol(8, truncateCell(setting.Value), props.Text{Size: 7, Align: align.Left}),
+		)
+		if i%2 == 0 {
+			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// getComplianceRow renders the aggregate device certificate compliance score
+func getComplianceRow(score float64, deviceCount int) core.Row {
+	return row.New(18).Add(
+		col.New(12).Add(
+			text.New(fmt.Sprintf("Device Certificate Compliance: %.1f%%", score), props.Text{
+				Top:   2,
+				Size:  14,
+				Style: fontstyle.Bold,
+				Align: align.Center,
+				Color: getBlueColor(),
+			}),
+			text.New(fmt.Sprintf("Share of the %d devices with a valid device certificate that does not expire within %d days", deviceCount, int(compliance.ExpiryWindow.Hours()/24)), props.Text{
+				Top:   10,
+				Size:  8,
+				Align: align.Center,
+			}),
+		),
+	)
+}
+
+// LogoPath is the image shown in the page header, relative to the working directory. A missing
+// image is left out, while an image that cannot be read or decoded as a PNG fails the report.
+var LogoPath = "docs/assets/images/logo.png"
+
+// getPageHeader decodes the logo up front, since maroto only fails on a broken image once the
+// page holding it is rendered
+func getPageHeader() (core.Row, error) {
+	logo := col.New(3)
+	content, err := os.ReadFile(LogoPath)
+	switch {
+	case err == nil:
+		if _, _, err := stdimage.DecodeConfig(bytes.NewReader(content)); err != nil {
+			return nil, fmt.Errorf("failed to decode the header logo %s: %w", LogoPath, err)
+		}
+		logo = image.NewFromBytesCol(3, content, extension.Png, props.Rect{
 			Center:  true,
 			Percent: 80,
-		}),
+		})
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read the header logo %s: %w", LogoPath, err)
+	}
+
+	return row.New(20).Add(
+		logo,
 		col.New(6),
 		col.New(3).Add(
 			text.New("CDSS Services Registration With Device Certificate Report", props.Text{
@@ -295,7 +619,7 @@ func getPageHeader() core.Row {
 				Color: getBlueColor(),
 			}),
 		),
-	)
+	), nil
 }
 
 func getPageFooter() core.Row {
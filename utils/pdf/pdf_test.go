@@ -0,0 +1,299 @@
+package pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	appconfig "github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/jsonreport"
+	"github.com/johnfercher/maroto/v2/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mustGetMaroto builds the report document, failing the test on error
+func mustGetMaroto(t testing.TB, allDevices, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates []map[string]string, settings []appconfig.Setting, password string) core.Maroto {
+	t.Helper()
+	m, err := GetMaroto(allDevices, ineligibleHardware, unsupportedVersions, versionParseFailed, registrationCandidates, settings, password)
+	require.NoError(t, err)
+	return m
+}
+
+// generateDevices builds a synthetic fleet of the given size for report tests and benchmarks
+func generateDevices(count int) []map[string]string {
+	devices := make([]map[string]string, 0, count)
+	for i := 0; i < count; i++ {
+		devices = append(devices, map[string]string{
+			"hostname":             fmt.Sprintf("fw-%05d", i),
+			"serial":               fmt.Sprintf("%012d", i),
+			"ip-address":           fmt.Sprintf("10.%d.%d.%d", i/65536%256, i/256%256, i%256),
+			"model":                "PA-3260",
+			"family":               "3200",
+			"sw-version":           "10.1.3-h2",
+			"minimumUpdateRelease": "10.1.3-h3",
+			"result":               "Successfully registered WildFire",
+			"deviceCert":           `{"status":"Valid","validity":"Valid","not_valid_after":"Aug 28 13:01:06 2025 GMT","seconds-to-expire":"28512000","state":"valid"}`,
+		})
+	}
+	return devices
+}
+
+func TestGetDeviceRows(t *testing.T) {
+	devices := generateDevices(3)
+	for _, tableType := range []string{"allDevices", "ineligibleHardware", "unsupportedVersions", "registrationCandidates", "deviceCertificateStatus"} {
+		t.Run(tableType, func(t *testing.T) {
+			rows, err := getDeviceRows(devices, tableType)
+			require.NoError(t, err)
+			assert.Len(t, rows, len(devices)+1, "expected a header row plus one row per device")
+		})
+	}
+}
+
+func TestDeviceCertificateStatusExpiryRow(t *testing.T) {
+	device := map[string]string{
+		"hostname":   "fw-expiring",
+		"deviceCert": `{"status":"Valid","validity":"Valid","not_valid_after":"Mar 14 09:30:00 2031 GMT","seconds-to-expire":"86400","state":"valid"}`,
+	}
+
+	rows, err := getDeviceRows([]map[string]string{device}, "deviceCertificateStatus")
+	require.NoError(t, err)
+	assert.Len(t, rows, 2, "expected a header row plus the expiry row")
+
+	document, err := mustGetMaroto(t, []map[string]string{device}, nil, nil, nil, nil, nil, "").Generate()
+	require.NoError(t, err)
+	content := string(document.GetBytes())
+	assert.Contains(t, content, "Device Certificate Status")
+	assert.Contains(t, content, "Mar 14 09:30:00 2031 GMT", "expected the not_valid_after of the device certificate in the report")
+}
+
+func TestAllDevicesSourceColumn(t *testing.T) {
+	devices := []map[string]string{
+		{"hostname": "fw-east", "serial": "111", "source": "panorama-east.example.com"},
+		{"hostname": "fw-lab", "serial": "222", "source": "inventory"},
+	}
+
+	document, err := mustGetMaroto(t, devices, nil, nil, nil, nil, nil, "").Generate()
+	require.NoError(t, err)
+	content := string(document.GetBytes())
+	assert.Contains(t, content, "Source")
+	assert.Contains(t, content, "panorama-east.example.com")
+	assert.Contains(t, content, "inventory")
+}
+
+// section returns the part of content between the first occurrence of start and the following
+// occurrence of end
+func section(t *testing.T, content, start, end string) string {
+	t.Helper()
+	from := strings.Index(content, start)
+	require.NotEqual(t, -1, from, "expected %q in the report", start)
+	to := strings.Index(content[from:], end)
+	require.NotEqual(t, -1, to, "expected %q after %q in the report", end, start)
+	return content[from : from+to]
+}
+
+func TestGetMarotoTablesRenderTheirOwnList(t *testing.T) {
+	ineligibleHardware := []map[string]string{{"hostname": "fw-unaffected-hardware", "model": "PA-220", "family": "220"}}
+	unsupportedVersions := []map[string]string{{"hostname": "fw-needs-upgrade", "model": "PA-3260", "family": "3200"}}
+	registrationCandidates := []map[string]string{{"hostname": "fw-candidate", "model": "PA-3260", "family": "3200"}}
+
+	// Without an all-devices list, each hostname can only come from its own table
+	document, err := mustGetMaroto(t, nil, ineligibleHardware, unsupportedVersions, nil, registrationCandidates, nil, "").Generate()
+	require.NoError(t, err)
+	content := string(document.GetBytes())
+
+	hardware := section(t, content, "Skipped Because of Hardware", "Skipped Because of PAN-OS Versions")
+	assert.Contains(t, hardware, "fw-unaffected-hardware")
+	assert.NotContains(t, hardware, "fw-needs-upgrade")
+	assert.NotContains(t, hardware, "fw-candidate")
+
+	// The feature release groups list every classified device, so the section ends before them
+	versions := section(t, content, "Skipped Because of PAN-OS Versions", "Devices by PAN-OS Feature Release")
+	assert.Contains(t, versions, "fw-needs-upgrade")
+	assert.NotContains(t, versions, "fw-unaffected-hardware")
+
+	candidates := section(t, content, "WildFire Registration Candidates", "Device Certificate Status")
+	assert.Contains(t, candidates, "fw-candidate")
+	assert.NotContains(t, candidates, "fw-unaffected-hardware")
+	assert.NotContains(t, candidates, "fw-needs-upgrade")
+}
+
+func TestVersionParseFailedTable(t *testing.T) {
+	parseFailed := []map[string]string{{"hostname": "fw-garbled", "sw-version": "11.x", "model": "PA-3260", "version_error": "failed to parse PAN-OS version"}}
+
+	document, err := mustGetMaroto(t, nil, nil, nil, parseFailed, nil, nil, "").Generate()
+	require.NoError(t, err)
+	content := string(document.GetBytes())
+	parseFailedSection := section(t, content, "Skipped Because of Unparseable PAN-OS Versions", "WildFire Registration Candidates")
+	assert.Contains(t, parseFailedSection, "fw-garbled")
+	assert.Contains(t, parseFailedSection, "failed to parse PAN-OS version")
+
+	document, err = mustGetMaroto(t, nil, nil, nil, nil, nil, nil, "").Generate()
+	require.NoError(t, err)
+	assert.NotContains(t, string(document.GetBytes()), "Unparseable PAN-OS Versions", "the table is only added when a version failed to parse")
+}
+
+func TestTruncateCell(t *testing.T) {
+	defer func(length int) { MaxCellLength = length }(MaxCellLength)
+
+	MaxCellLength = 10
+	assert.Equal(t, "short", truncateCell("short"))
+	assert.Equal(t, "exactly-10", truncateCell("exactly-10"))
+	assert.Equal(t, "much lo...", truncateCell("much longer than ten"))
+	assert.Equal(t, "ünïcödé...", truncateCell("ünïcödé-välüé"), "values are cut on characters, not bytes")
+
+	MaxCellLength = 2
+	assert.Equal(t, "ab", truncateCell("abcdef"))
+
+	MaxCellLength = 0
+	assert.Equal(t, "much longer than ten", truncateCell("much longer than ten"))
+}
+
+func TestLongCellTruncatedInPDFButNotInJSON(t *testing.T) {
+	defer func(length int) { MaxCellLength = length }(MaxCellLength)
+	MaxCellLength = 40
+
+	result := "Failed to register WildFire - " + strings.Repeat("connection reset by peer; ", 10)
+	candidates := []map[string]string{{"hostname": "fw-1", "serial": "111", "result": result}}
+
+	document, err := mustGetMaroto(t, candidates, nil, nil, nil, candidates, nil, "").Generate()
+	require.NoError(t, err)
+	content := string(document.GetBytes())
+	assert.Contains(t, content, "("+result[:37]+"...)")
+	assert.NotContains(t, content, result)
+
+	report := jsonreport.Build(candidates, nil, nil, candidates, time.Now())
+	require.Len(t, report.Devices, 1)
+	assert.Equal(t, result, report.Devices[0].Result)
+	encoded, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), result)
+}
+
+func TestGetConfigurationRows(t *testing.T) {
+	settings := []appconfig.Setting{
+		{Name: "-reportonly", Value: "true"},
+		{Name: "auth.firewall.password", Value: appconfig.Redacted},
+	}
+	rows := getConfigurationRows(settings)
+	assert.Len(t, rows, len(settings)+1, "expected a header row plus one row per setting")
+
+	m := mustGetMaroto(t, generateDevices(3), nil, nil, nil, nil, settings, "")
+	_, err := m.Generate()
+	require.NoError(t, err)
+}
+
+func TestGetReleaseGroupRows(t *testing.T) {
+	groups := filters.GroupByFeatureRelease([]map[string]string{
+		{"hostname": "fw-1", "sw-version": "10.1.3-h2", "minimumUpdateRelease": "10.1.3-h3"},
+		{"hostname": "fw-2", "sw-version": "10.1.9"},
+		{"hostname": "fw-3", "sw-version": "11.0.0"},
+	})
+	rows := getReleaseGroupRows(groups)
+	assert.Len(t, rows, len(groups)+3, "expected a heading row per group plus one row per device")
+}
+
+func TestGetDiagnosticsText(t *testing.T) {
+	assert.Empty(t, getDiagnosticsText(map[string]string{"status": "valid"}))
+	assert.Equal(t, "DNS: 10.0.0.53 | NTP: synchronized to 0.pool.ntp.org, servers 0.pool.ntp.org (reachable: yes)", getDiagnosticsText(map[string]string{
+		"dns_servers": "10.0.0.53",
+		"ntp_synched": "0.pool.ntp.org",
+		"ntp_servers": "0.pool.ntp.org (reachable: yes)",
+	}))
+	assert.Equal(t, "DNS: none configured | NTP: not synchronized", getDiagnosticsText(map[string]string{"dns_servers": "", "ntp_synched": "LOCAL"}))
+}
+
+func TestGetMarotoPassword(t *testing.T) {
+	devices := generateDevices(3)
+
+	plain, err := mustGetMaroto(t, devices, nil, nil, nil, nil, nil, "").Generate()
+	require.NoError(t, err)
+	assert.NotContains(t, string(plain.GetBytes()), "/Encrypt", "expected an unprotected report without a password")
+	assert.Contains(t, string(plain.GetBytes()), devices[0]["hostname"])
+
+	protected, err := mustGetMaroto(t, devices, nil, nil, nil, nil, nil, "s3cret").Generate()
+	require.NoError(t, err)
+	content := string(protected.GetBytes())
+	assert.Contains(t, content, "/Encrypt", "expected an encryption dictionary, so readers ask for the password")
+	for _, device := range devices {
+		assert.NotContains(t, content, device["hostname"], "expected no device data readable without the password")
+	}
+}
+
+func TestGetDeviceRowsUnknownTable(t *testing.T) {
+	_, err := getDeviceRows(generateDevices(1), "unknown")
+
+	assert.EqualError(t, err, "unknown table type: unknown", "the error is returned instead of exiting")
+}
+
+func TestGeneratePDFReportLogoError(t *testing.T) {
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	require.NoError(t, os.WriteFile(logo, []byte("not a png"), 0644))
+	original := LogoPath
+	LogoPath = logo
+	t.Cleanup(func() { LogoPath = original })
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer func() {
+		require.NoError(t, os.Chdir(wd))
+	}()
+
+	err = GeneratePDFReport(generateDevices(3), nil, nil, nil, nil, nil, "device_report.pdf", "")
+
+	assert.Error(t, err, "an undecodable logo fails the report with an error")
+	assert.NoFileExists(t, filepath.Join("report", "device_report.pdf"))
+}
+
+func TestGetMarotoLargeFleet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building a 5000-device report is slow")
+	}
+	devices := generateDevices(5000)
+
+	m := mustGetMaroto(t, devices, devices[:1000], devices[1000:2000], nil, devices[2000:], nil, "")
+	require.NotNil(t, m)
+
+	document, err := m.Generate()
+	require.NoError(t, err)
+	assert.NotEmpty(t, document.GetBytes())
+}
+
+// BenchmarkGetMaroto measures the time and allocations of building the report for a 5000-device fleet.
+// Run with: go test ./utils/pdf -bench GetMaroto -benchmem
+func BenchmarkGetMaroto(b *testing.B) {
+	devices := generateDevices(5000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m := mustGetMaroto(b, devices, nil, nil, nil, devices, nil, "")
+		if _, err := m.Generate(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGeneratePDFReport(b *testing.B) {
+	devices := generateDevices(5000)
+
+	// GeneratePDFReport writes into ./report, so run from a temporary directory
+	wd, err := os.Getwd()
+	require.NoError(b, err)
+	require.NoError(b, os.Chdir(b.TempDir()))
+	defer func() {
+		require.NoError(b, os.Chdir(wd))
+	}()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := GeneratePDFReport(devices, nil, nil, nil, devices, nil, "benchmark_report.pdf", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package reachability utils/reachability/reachability.go
+package reachability
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/limiter"
+)
+
+// HTTPSPort is the management port of the XML API
+const HTTPSPort = 443
+
+// DefaultTimeout bounds each TCP dial, short enough to check a large inventory quickly
+const DefaultTimeout = 3 * time.Second
+
+// Target is a device whose management ports are dialled
+type Target struct {
+	Hostname string
+	Address  string
+}
+
+// PortResult is the outcome of dialling one port of a device
+type PortResult struct {
+	Port int
+	// Err is the dial error of a port that is not reachable
+	Err error
+}
+
+// Result is the outcome of dialling the management ports of a device
+type Result struct {
+	Hostname string
+	Address  string
+	Ports    []PortResult
+}
+
+// Reachable reports whether every dialled port of the device accepted the connection
+func (r Result) Reachable() bool {
+	for _, port := range r.Ports {
+		if port.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Check opens and immediately closes a TCP connection to each port of the target, without any
+// authentication or command, waiting at most timeout for each port.
+func Check(ctx context.Context, target Target, ports []int, timeout time.Duration) Result {
+	result := Result{Hostname: target.Hostname, Address: target.Address}
+	dialer := net.Dialer{Timeout: timeout}
+	for _, port := range ports {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.Address, strconv.Itoa(port)))
+		if err == nil {
+			err = conn.Close()
+		}
+		result.Ports = append(result.Ports, PortResult{Port: port, Err: err})
+	}
+	return result
+}
+
+// CheckAll checks the targets, at most concurrency at once, and returns the results in the
+// targets' order. Once ctx is done, the targets not checked yet fail with its error.
+func CheckAll(ctx context.Context, targets []Target, ports []int, timeout time.Duration, concurrency int) []Result {
+	results := make([]Result, len(targets))
+	workers := limiter.New(concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(index int, target Target) {
+			defer wg.Done()
+			if err := workers.AcquireContext(ctx); err != nil {
+				results[index] = failed(target, ports, err)
+				return
+			}
+			defer workers.Release()
+			results[index] = Check(ctx, target, ports, timeout)
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// failed returns the result of a target whose ports were not dialled because of err
+func failed(target Target, ports []int, err error) Result {
+	result := Result{Hostname: target.Hostname, Address: target.Address}
+	for _, port := range ports {
+		result.Ports = append(result.Ports, PortResult{Port: port, Err: err})
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package reachability
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a local listener accepting connections and returns its port
+func listen(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+	return port
+}
+
+func TestCheck(t *testing.T) {
+	open, closed := listen(t), closedPort(t)
+	target := Target{Hostname: "fw-1", Address: "127.0.0.1"}
+
+	t.Run("Listening port", func(t *testing.T) {
+		result := Check(context.Background(), target, []int{open}, time.Second)
+		assert.True(t, result.Reachable())
+		require.Len(t, result.Ports, 1)
+		assert.NoError(t, result.Ports[0].Err)
+	})
+
+	t.Run("Closed port", func(t *testing.T) {
+		result := Check(context.Background(), target, []int{open, closed}, time.Second)
+		assert.False(t, result.Reachable())
+		require.Len(t, result.Ports, 2)
+		assert.NoError(t, result.Ports[0].Err)
+		assert.Error(t, result.Ports[1].Err)
+		assert.Contains(t, result.Ports[1].Err.Error(), strconv.Itoa(closed))
+	})
+}
+
+func TestCheckAll(t *testing.T) {
+	open := listen(t)
+	targets := []Target{
+		{Hostname: "fw-up", Address: "127.0.0.1"},
+		{Hostname: "fw-down", Address: "127.0.0.1"},
+	}
+	closed := closedPort(t)
+
+	results := CheckAll(context.Background(), targets[:1], []int{open}, time.Second, 2)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Reachable())
+
+	results = CheckAll(context.Background(), targets, []int{closed}, time.Second, 1)
+	require.Len(t, results, 2)
+	assert.Equal(t, "fw-up", results[0].Hostname, "results keep the order of the targets")
+	assert.False(t, results[1].Reachable())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = CheckAll(ctx, targets, []int{open}, time.Second, 1)
+	for _, result := range results {
+		assert.ErrorIs(t, result.Ports[0].Err, context.Canceled)
+	}
+}
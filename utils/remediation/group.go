@@ -0,0 +1,55 @@
+// Package remediation utils/remediation/group.go
+package remediation
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Group is a named set of device serials, such as the members of a Panorama dynamic group or an
+// Ansible group of the affected devices
+type Group struct {
+	Name    string   `yaml:"name"`
+	Serials []string `yaml:"serials"`
+}
+
+// ParseGroupExport splits a "<name>=<file>" export target into the group name and the file path.
+func ParseGroupExport(value string) (name, path string, err error) {
+	name, path, ok := strings.Cut(value, "=")
+	name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+	if !ok || name == "" || path == "" {
+		return "", "", fmt.Errorf("invalid group export %q, expected <name>=<file>", value)
+	}
+	return name, path, nil
+}
+
+// BuildGroup creates the named group of the serials of the devices, sorted and without duplicates.
+// Devices without a serial are left out.
+func BuildGroup(name string, devices []map[string]string) Group {
+	seen := make(map[string]bool)
+	serials := make([]string, 0, len(devices))
+	for _, device := range devices {
+		if serial := device["serial"]; serial != "" && !seen[serial] {
+			seen[serial] = true
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+	return Group{Name: name, Serials: serials}
+}
+
+// WriteGroup writes the group to the given path as YAML.
+func WriteGroup(path string, group Group) error {
+	data, err := yaml.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("failed to encode group export: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write group export: %w", err)
+	}
+	return nil
+}
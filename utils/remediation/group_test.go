@@ -0,0 +1,40 @@
+package remediation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestWriteGroup(t *testing.T) {
+	affected := append([]map[string]string{
+		{"hostname": "fw-1-duplicate", "serial": "111"},
+		{"hostname": "fw-no-serial"},
+	}, unsupportedDevices...)
+	path := filepath.Join(t.TempDir(), "group.yaml")
+
+	require.NoError(t, WriteGroup(path, BuildGroup("cdss-affected", affected)))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var group Group
+	require.NoError(t, yaml.Unmarshal(data, &group))
+	assert.Equal(t, "cdss-affected", group.Name)
+	assert.Equal(t, []string{"111", "222", "333"}, group.Serials, "expected exactly the affected serials")
+}
+
+func TestParseGroupExport(t *testing.T) {
+	name, path, err := ParseGroupExport("cdss-affected=report/group.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "cdss-affected", name)
+	assert.Equal(t, "report/group.yaml", path)
+
+	for _, value := range []string{"cdss-affected", "=group.yaml", "cdss-affected="} {
+		_, _, err := ParseGroupExport(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,84 @@
+// Package remediation utils/remediation/remediation.go
+package remediation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/filters"
+)
+
+// Entry is the upgrade path of a device whose PAN-OS version does not support registration
+type Entry struct {
+	Hostname       string `json:"hostname"`
+	Serial         string `json:"serial"`
+	IPAddress      string `json:"ip_address"`
+	Model          string `json:"model"`
+	CurrentVersion string `json:"current_version"`
+	// MinimumFix is the lowest release supporting device certificate registration
+	MinimumFix string `json:"minimum_fix"`
+	// RecommendedRelease is the newest patched release of the minimum fix's feature release
+	RecommendedRelease string `json:"recommended_release"`
+}
+
+// csvHeader lists the CSV columns, in the order of the Entry fields
+var csvHeader = []string{"hostname", "serial", "ip_address", "model", "current_version", "minimum_fix", "recommended_release"}
+
+// Build creates one entry per unsupported-version device, using its "minimumUpdateRelease" as the
+// minimum fix and the newest patched release of the same feature release as the recommendation.
+func Build(unsupportedVersions []map[string]string) []Entry {
+	entries := make([]Entry, 0, len(unsupportedVersions))
+	for _, device := range unsupportedVersions {
+		minimumFix := device["minimumUpdateRelease"]
+		entries = append(entries, Entry{
+			Hostname:           device["hostname"],
+			Serial:             device["serial"],
+			IPAddress:          device["ip-address"],
+			Model:              device["model"],
+			CurrentVersion:     device["sw-version"],
+			MinimumFix:         minimumFix,
+			RecommendedRelease: filters.LatestPatchedRelease(minimumFix),
+		})
+	}
+	return entries
+}
+
+// WriteFile writes the entries to the given path, as CSV when the path ends in ".csv" and as
+// indented JSON otherwise.
+func WriteFile(path string, entries []Entry) error {
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err = encodeCSV(entries)
+	} else {
+		data, err = json.MarshalIndent(entries, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode upgrade export: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upgrade export: %w", err)
+	}
+	return nil
+}
+
+// encodeCSV renders the entries as CSV with a header row
+func encodeCSV(entries []Entry) ([]byte, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		record := []string{entry.Hostname, entry.Serial, entry.IPAddress, entry.Model, entry.CurrentVersion, entry.MinimumFix, entry.RecommendedRelease}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return []byte(b.String()), w.Error()
+}
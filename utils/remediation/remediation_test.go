@@ -0,0 +1,70 @@
+package remediation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var unsupportedDevices = []map[string]string{
+	{"hostname": "fw-1", "serial": "111", "ip-address": "10.0.0.1", "model": "PA-3260", "sw-version": "10.1.3-h2", "minimumUpdateRelease": "10.1.3-h3"},
+	{"hostname": "fw-2", "serial": "222", "ip-address": "10.0.0.2", "model": "PA-5220", "sw-version": "9.1.10", "minimumUpdateRelease": "9.1.11-h5"},
+	{"hostname": "fw-3", "serial": "333", "ip-address": "10.0.0.3", "model": "PA-VM", "sw-version": "8.0.20", "minimumUpdateRelease": "8.1.0"},
+}
+
+func TestBuild(t *testing.T) {
+	entries := Build(unsupportedDevices)
+
+	require.Len(t, entries, 3)
+	assert.Equal(t, Entry{
+		Hostname:           "fw-1",
+		Serial:             "111",
+		IPAddress:          "10.0.0.1",
+		Model:              "PA-3260",
+		CurrentVersion:     "10.1.3-h2",
+		MinimumFix:         "10.1.3-h3",
+		RecommendedRelease: "10.1.12-h0",
+	}, entries[0])
+	assert.Equal(t, "9.1.11-h5", entries[1].MinimumFix)
+	assert.Equal(t, "9.1.17-h0", entries[1].RecommendedRelease)
+	assert.Equal(t, "8.1.0", entries[2].MinimumFix)
+	assert.Equal(t, "8.1.26-h0", entries[2].RecommendedRelease)
+}
+
+func TestWriteFile(t *testing.T) {
+	entries := Build(unsupportedDevices)
+	dir := t.TempDir()
+
+	t.Run("JSON", func(t *testing.T) {
+		path := filepath.Join(dir, "upgrades.json")
+		require.NoError(t, WriteFile(path, entries))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var decoded []map[string]string
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		require.Len(t, decoded, 3)
+		assert.Equal(t, "10.1.3-h2", decoded[0]["current_version"])
+		assert.Equal(t, "10.1.3-h3", decoded[0]["minimum_fix"])
+		assert.Equal(t, "10.1.12-h0", decoded[0]["recommended_release"])
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		path := filepath.Join(dir, "upgrades.csv")
+		require.NoError(t, WriteFile(path, entries))
+
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		records, err := csv.NewReader(file).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 4)
+		assert.Equal(t, csvHeader, records[0])
+		assert.Equal(t, []string{"fw-2", "222", "10.0.0.2", "PA-5220", "9.1.10", "9.1.11-h5", "9.1.17-h0"}, records[2])
+	})
+}
@@ -0,0 +1,84 @@
+// Package remediation utils/remediation/runbook.go
+package remediation
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TargetVersion returns the release to upgrade the device of the entry to, the recommended release
+// or, when the feature release is not in the patched versions table, the minimum fix. A "-h0"
+// suffix is dropped since PAN-OS names such releases without a hotfix.
+func (e Entry) TargetVersion() string {
+	target := e.RecommendedRelease
+	if target == "" {
+		target = e.MinimumFix
+	}
+	return strings.TrimSuffix(target, "-h0")
+}
+
+// Runbook renders a markdown runbook with the upgrade steps of every entry, in order.
+func Runbook(entries []Entry) string {
+	var b strings.Builder
+	b.WriteString("# PAN-OS Upgrade Runbook\n\n")
+	if len(entries) == 0 {
+		b.WriteString("No device requires a PAN-OS upgrade.\n")
+		return b.String()
+	}
+
+	b.WriteString("The following devices run a PAN-OS version that does not support device certificate registration. ")
+	b.WriteString("Upgrade each device during a maintenance window, upgrading the passive peer of an HA pair first.\n\n")
+	b.WriteString("| Device | Serial | Current version | Minimum fix | Target version |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", entry.Hostname, entry.Serial, entry.CurrentVersion, entry.MinimumFix, entry.TargetVersion())
+	}
+
+	for i, entry := range entries {
+		target := entry.TargetVersion()
+		fmt.Fprintf(&b, "\n## %d. %s\n\n", i+1, entry.Hostname)
+		fmt.Fprintf(&b, "- Model: %s\n- Management IP: %s\n- Current version: %s\n- Target version: %s\n\n", entry.Model, entry.IPAddress, entry.CurrentVersion, target)
+
+		step := 1
+		fmt.Fprintf(&b, "%d. Save and export a named configuration snapshot of the device\n", step)
+		step++
+		fmt.Fprintf(&b, "%d. Refresh the available software versions: `request system software check`\n", step)
+		step++
+		if base := baseImage(entry.CurrentVersion, target); base != "" && base != target {
+			fmt.Fprintf(&b, "%d. Download the base image of the new feature release: `request system software download version %s`\n", step, base)
+			step++
+		}
+		fmt.Fprintf(&b, "%d. Download the target version: `request system software download version %s`\n", step, target)
+		step++
+		fmt.Fprintf(&b, "%d. Install the target version: `request system software install version %s`\n", step, target)
+		step++
+		fmt.Fprintf(&b, "%d. Reboot the device: `request restart system`\n", step)
+		step++
+		fmt.Fprintf(&b, "%d. Verify the running version with `show system info` and rerun this tool to register WildFire\n", step)
+	}
+
+	return b.String()
+}
+
+// WriteRunbook writes the markdown runbook of the entries to the given path.
+func WriteRunbook(path string, entries []Entry) error {
+	if err := os.WriteFile(path, []byte(Runbook(entries)), 0644); err != nil {
+		return fmt.Errorf("failed to write remediation runbook: %w", err)
+	}
+	return nil
+}
+
+// baseImage returns the base image, e.g. "10.1.0", that must be downloaded before upgrading from
+// current to target, or an empty string when both are in the same feature release.
+func baseImage(current, target string) string {
+	currentParts := strings.SplitN(current, ".", 3)
+	targetParts := strings.SplitN(target, ".", 3)
+	if len(currentParts) < 2 || len(targetParts) < 2 {
+		return ""
+	}
+	if currentParts[0] == targetParts[0] && currentParts[1] == targetParts[1] {
+		return ""
+	}
+	return targetParts[0] + "." + targetParts[1] + ".0"
+}
@@ -0,0 +1,45 @@
+package remediation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRunbook(t *testing.T) {
+	entries := Build(unsupportedDevices)
+	path := filepath.Join(t.TempDir(), "runbook.md")
+	require.NoError(t, WriteRunbook(path, entries))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	runbook := string(data)
+
+	for i, entry := range entries {
+		target := entry.TargetVersion()
+		require.NotEmpty(t, target)
+		assert.Contains(t, runbook, "| "+entry.Hostname+" | "+entry.Serial+" | "+entry.CurrentVersion+" | "+entry.MinimumFix+" | "+target+" |")
+		assert.Contains(t, runbook, fmt.Sprintf("## %d. %s\n", i+1, entry.Hostname))
+		assert.Contains(t, runbook, "`request system software install version "+target+"`")
+	}
+	assert.Equal(t, len(entries), strings.Count(runbook, "`request restart system`"))
+
+	// Upgrading to another feature release downloads its base image first
+	assert.Contains(t, runbook, "`request system software download version 8.1.0`")
+	assert.Equal(t, 1, strings.Count(runbook, "Download the base image"))
+}
+
+func TestTargetVersion(t *testing.T) {
+	assert.Equal(t, "10.1.12", Entry{MinimumFix: "10.1.3-h3", RecommendedRelease: "10.1.12-h0"}.TargetVersion())
+	assert.Equal(t, "10.1.11-h4", Entry{MinimumFix: "10.1.3-h3", RecommendedRelease: "10.1.11-h4"}.TargetVersion())
+	assert.Equal(t, "8.1.0", Entry{MinimumFix: "8.1.0"}.TargetVersion())
+}
+
+func TestRunbookWithoutEntries(t *testing.T) {
+	assert.Contains(t, Runbook(nil), "No device requires a PAN-OS upgrade")
+}
@@ -0,0 +1,55 @@
+package wildfire
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/scrapli/scrapligo/util"
+)
+
+// Preflight statuses of a device
+const (
+	PreflightReachable   = "reachable"
+	PreflightAuthFailed  = "auth-failed"
+	PreflightUnreachable = "unreachable"
+)
+
+// sshAuthFailure starts the error of crypto/ssh when the device refuses every authentication
+// method; crypto/ssh has no error type for it
+const sshAuthFailure = "ssh: unable to authenticate"
+
+// PreflightResult is the outcome of the connectivity preflight of a device
+type PreflightResult struct {
+	Hostname string
+	Status   string
+	// Err is the connection error of a device that is not reachable
+	Err error
+}
+
+// Preflight opens and immediately closes the SSH connection used for WildFire registration,
+// confirming that the device is reachable and accepts the credentials, without sending any command.
+func Preflight(device map[string]string, username, password string, opts Options, l *logger.Logger) PreflightResult {
+	result := PreflightResult{Hostname: device["hostname"], Status: PreflightReachable}
+
+	d, err := connect(device, username, password, opts, l)
+	if err != nil {
+		result.Err = err
+		result.Status = PreflightUnreachable
+		if isAuthFailure(err) {
+			result.Status = PreflightAuthFailed
+		}
+		return result
+	}
+	if err := d.Close(); err != nil {
+		l.Debug("Failed to close connection:", err)
+	}
+	return result
+}
+
+// isAuthFailure reports whether a connection error was caused by the credentials or the SSH key
+// rather than by the network
+func isAuthFailure(err error) bool {
+	var keyErr *sshKeyError
+	return errors.Is(err, util.ErrAuthError) || errors.As(err, &keyErr) || strings.Contains(err.Error(), sshAuthFailure)
+}
@@ -0,0 +1,68 @@
+package wildfire
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/scrapli/scrapligo/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFailingMockDrivers replaces the driver factory with mock drivers whose Open fails with err
+func useFailingMockDrivers(t *testing.T, err error) {
+	t.Helper()
+	original := newDriver
+	newDriver = func(host string, opts ...util.Option) (Driver, error) {
+		return &mockDriver{host: host, openErr: err}, nil
+	}
+	t.Cleanup(func() { newDriver = original })
+}
+
+func TestPreflight(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+
+	t.Run("Reachable", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+
+		result := Preflight(device, "user", "pass", Options{}, l)
+
+		assert.Equal(t, PreflightResult{Hostname: "fw-1", Status: PreflightReachable}, result)
+		assert.Empty(t, drivers["10.0.0.1"].commands, "no command is sent")
+	})
+
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"Credentials refused", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain"), PreflightAuthFailed},
+		{"In-channel authentication error", fmt.Errorf("%w: password prompt seen multiple times", util.ErrAuthError), PreflightAuthFailed},
+		{"Connection refused", errors.New("dial tcp 10.0.0.1:22: connect: connection refused"), PreflightUnreachable},
+		{"Authentication in the hostname", errors.New("dial tcp: lookup authentication-failed.example.com: no such host"), PreflightUnreachable},
+		{"Timeout", util.ErrTimeoutError, PreflightUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFailingMockDrivers(t, tt.err)
+
+			result := Preflight(device, "user", "wrong", Options{}, l)
+
+			assert.Equal(t, tt.expected, result.Status)
+			assert.ErrorIs(t, result.Err, tt.err)
+		})
+	}
+
+	t.Run("Unreadable SSH key", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+
+		result := Preflight(device, "user", "pass", Options{SSHKey: filepath.Join(t.TempDir(), "missing")}, l)
+
+		assert.Equal(t, PreflightAuthFailed, result.Status)
+		assert.ErrorContains(t, result.Err, "failed to read SSH key")
+		assert.Empty(t, drivers, "no connection is attempted")
+	})
+}
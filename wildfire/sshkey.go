@@ -0,0 +1,141 @@
+package wildfire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/scrapli/scrapligo/driver/options"
+	"github.com/scrapli/scrapligo/transport"
+	"github.com/scrapli/scrapligo/util"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyError is an error reading or decrypting the SSH private key of the options
+type sshKeyError struct {
+	err error
+}
+
+// Error returns the message of the key error
+func (e *sshKeyError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the key error
+func (e *sshKeyError) Unwrap() error {
+	return e.err
+}
+
+// authOptions returns the driver options authenticating with the SSH private key of opts, when
+// set, and with the password, which is tried after the key. The key is parsed up front so that an
+// unreadable key or a wrong passphrase fails before connecting. scrapligo's standard transport
+// cannot decrypt a key, so an encrypted key is used through a keyTransport instead.
+func authOptions(password string, opts Options) ([]util.Option, error) {
+	authOpts := []util.Option{options.WithAuthPassword(password)}
+	if opts.SSHKey == "" {
+		return authOpts, nil
+	}
+
+	key, err := os.ReadFile(opts.SSHKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %v", err)
+	}
+	if opts.SSHKeyPassphrase == "" {
+		if _, err := ssh.ParsePrivateKey(key); err != nil {
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				return nil, fmt.Errorf("SSH key %s is encrypted and no passphrase is set", opts.SSHKey)
+			}
+			return nil, fmt.Errorf("failed to parse SSH key %s: %v", opts.SSHKey, err)
+		}
+		return append(authOpts, options.WithAuthPrivateKey(opts.SSHKey, "")), nil
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(opts.SSHKeyPassphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH key %s: %v", opts.SSHKey, err)
+	}
+	return append(authOpts, options.WithCustomTransport(&keyTransport{signer: signer})), nil
+}
+
+// keyTransport is a crypto/ssh transport authenticating with an already decrypted key, and with
+// the password when the key is refused. Like the standard transport with WithAuthNoStrictKey,
+// it does not check the host key.
+type keyTransport struct {
+	signer  ssh.Signer
+	client  *ssh.Client
+	session *ssh.Session
+	writer  io.WriteCloser
+	reader  io.Reader
+}
+
+// Open connects and starts an interactive shell on a pseudo terminal
+func (t *keyTransport) Open(a *transport.Args) error {
+	auth := []ssh.AuthMethod{ssh.PublicKeys(t.signer)}
+	if a.Password != "" {
+		auth = append(auth, ssh.Password(a.Password))
+	}
+	cfg := &ssh.ClientConfig{
+		User:            a.User,
+		Auth:            auth,
+		Timeout:         a.TimeoutSocket,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // #nosec G106 -- matches WithAuthNoStrictKey
+	}
+
+	var err error
+	if t.client, err = ssh.Dial("tcp", net.JoinHostPort(a.Host, strconv.Itoa(a.Port)), cfg); err != nil {
+		return err
+	}
+	if t.session, err = t.client.NewSession(); err != nil {
+		return err
+	}
+	if t.writer, err = t.session.StdinPipe(); err != nil {
+		return err
+	}
+	if t.reader, err = t.session.StdoutPipe(); err != nil {
+		return err
+	}
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 115200, ssh.TTY_OP_OSPEED: 115200}
+	if err := t.session.RequestPty("xterm", a.TermHeight, a.TermWidth, modes); err != nil {
+		return err
+	}
+	return t.session.Shell()
+}
+
+// Close closes the session and the connection
+func (t *keyTransport) Close() error {
+	if t.session != nil {
+		_ = t.session.Close()
+		t.session = nil
+	}
+	if t.client != nil {
+		err := t.client.Close()
+		t.client = nil
+		return err
+	}
+	return nil
+}
+
+// IsAlive reports whether the session is open
+func (t *keyTransport) IsAlive() bool {
+	return t.session != nil
+}
+
+// Read reads up to n bytes of the shell output
+func (t *keyTransport) Read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	n, err := t.reader.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+// Write writes b to the shell input
+func (t *keyTransport) Write(b []byte) error {
+	_, err := t.writer.Write(b)
+	return err
+}
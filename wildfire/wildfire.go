@@ -2,43 +2,337 @@
 package wildfire
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
 	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/utils/dnscache"
 	"github.com/scrapli/scrapligo/driver/generic"
 	"github.com/scrapli/scrapligo/driver/options"
+	"github.com/scrapli/scrapligo/response"
 	"github.com/scrapli/scrapligo/transport"
+	"github.com/scrapli/scrapligo/util"
 )
 
-// RegisterWildFire registers a device with WildFire public cloud service.
-// This function connects to a specified device using SSH, sends a WildFire
-// registration command, and verifies the output. It handles connection
-// errors and unexpected command outputs.
-func RegisterWildFire(device map[string]string, username, password string, l *logger.Logger) error {
-	l.Debug("Attempting to connect to", device["hostname"], "at", device["ip-address"])
+// WildFire registration channels
+const (
+	ChannelPublic  = "public"
+	ChannelPrivate = "private"
+)
+
+// versionSuccessMessages maps a PAN-OS major version to the output PAN-OS prints, per registration
+// channel, when registration is triggered, from that version onwards
+var versionSuccessMessages = map[int]map[string]string{
+	8: {
+		ChannelPublic: "WildFire registration is triggered",
+	},
+	9: {
+		ChannelPublic:  "WildFire registration for Public Cloud is triggered",
+		ChannelPrivate: "WildFire registration for Private Cloud is triggered",
+	},
+}
+
+// SetSuccessMessages layers the success messages of the command overrides of the config file onto
+// the compiled-in ones
+func SetSuccessMessages(overrides map[int]config.CommandOverride) {
+	for version, override := range overrides {
+		if len(override.SuccessMessages) == 0 {
+			continue
+		}
+		if versionSuccessMessages[version] == nil {
+			versionSuccessMessages[version] = make(map[string]string)
+		}
+		for channel, message := range override.SuccessMessages {
+			versionSuccessMessages[version][strings.ToLower(channel)] = message
+		}
+	}
+}
+
+// SuccessMessage returns the output expected from the registration command on a channel of a
+// device of a PAN-OS major version. The overrides, keyed by major version, are layered on top of
+// the defaults, and both apply from their version onwards like config.VersionCommands.
+func SuccessMessage(major int, channel string, overrides map[int]string) string {
+	messages := make(map[int]string)
+	for version, channelMessages := range versionSuccessMessages {
+		if message, ok := channelMessages[channel]; ok {
+			messages[version] = message
+		}
+	}
+	for version, message := range overrides {
+		messages[version] = message
+	}
+
+	versions := make([]int, 0, len(messages))
+	for version := range messages {
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+	return messages[config.NearestMajor(versions, major)]
+}
+
+// ParseSuccessMatches parses semicolon-separated "<major>=<output>" entries, e.g.
+// "8=WildFire registration is triggered;11=registration triggered", into success message overrides.
+func ParseSuccessMatches(value string) (map[int]string, error) {
+	overrides := make(map[int]string)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		versionText, message, ok := strings.Cut(entry, "=")
+		version, err := strconv.Atoi(strings.TrimSpace(versionText))
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid success match %q, expected <major>=<output>", entry)
+		}
+		if message = strings.TrimSpace(message); message == "" {
+			return nil, fmt.Errorf("empty success match for PAN-OS %d", version)
+		}
+		overrides[version] = message
+	}
+	return overrides, nil
+}
+
+// ParseFamilyTimeouts parses comma-separated "<family>=<duration>" entries, e.g. "7000=3m,5200=90s",
+// into SSH command timeout overrides keyed by the lowercase family.
+func ParseFamilyTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		family, durationText, ok := strings.Cut(entry, "=")
+		family = strings.ToLower(strings.TrimSpace(family))
+		if !ok || family == "" {
+			return nil, fmt.Errorf("invalid family timeout %q, expected <family>=<duration>", entry)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(durationText))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q for family %s, expected a positive duration", strings.TrimSpace(durationText), family)
+		}
+		timeouts[family] = timeout
+	}
+	return timeouts, nil
+}
+
+// opsTimeout returns the SSH command timeout of a device: the override of its family, otherwise
+// OpsTimeout, otherwise DefaultSSHTimeout
+func (o Options) opsTimeout(device map[string]string) time.Duration {
+	if timeout, ok := o.FamilyOpsTimeouts[strings.ToLower(strings.TrimSpace(device["family"]))]; ok {
+		return timeout
+	}
+	if o.OpsTimeout <= 0 {
+		return DefaultSSHTimeout
+	}
+	return o.OpsTimeout
+}
+
+// softFailureMessages match the PAN-OS messages of transient conditions that a later run can retry.
+// Each is anchored to the start of an output line, optionally after the "Server error :" prefix.
+var softFailureMessages = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)^\s*(server error\s*:\s*)?(the )?(server|device|system|management server) is (currently )?busy\b`),
+	regexp.MustCompile(`(?im)^\s*(server error\s*:\s*)?(the )?config(uration)? is (currently )?locked by\b`),
+	regexp.MustCompile(`(?im)^\s*(server error\s*:\s*)?(a )?(config|commit) lock is (currently )?held by\b`),
+	regexp.MustCompile(`(?im)^\s*(server error\s*:\s*)?(another |a )?commit is (currently )?in progress\b`),
+	regexp.MustCompile(`(?im)^\s*(server error\s*:\s*)?(the )?(service|server) is temporarily unavailable\b`),
+}
+
+// IsSoftFailure reports whether a registration error is caused by a transient condition, such as
+// a busy device or a configuration lock, rather than a hard failure. Only the output the device
+// answered to the registration command is considered, so connection and authentication failures
+// are always hard failures.
+func IsSoftFailure(err error) bool {
+	var output string
+	var commandErr *CommandError
+	var outputErr *UnexpectedOutputError
+	switch {
+	case errors.As(err, &commandErr):
+		output = commandErr.Output
+	case errors.As(err, &outputErr):
+		output = outputErr.Output
+	default:
+		return false
+	}
+	for _, message := range softFailureMessages {
+		if message.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredStatuses are the lowercase values of the "Status" line of `show wildfire status`
+// confirming that registration was triggered or completed
+var registeredStatuses = []string{"registering", "registered"}
+
+// completedStatuses are the lowercase values of the "Status" line of `show wildfire status`
+// confirming that registration completed
+var completedStatuses = []string{"registered"}
+
+// Options holds the settings used for WildFire registration
+type Options struct {
+	// Channel is the default registration channel, used when the device does not override it
+	Channel string
+	// Server, when set, is the WildFire cloud or appliance server to register with, e.g. a regional
+	// cloud for the public channel or a WF-500 appliance for the private channel
+	Server string
+	// RecheckDelay, when set, is how long to wait after an unexpected registration output before
+	// checking `show wildfire status` once to confirm the registration anyway
+	RecheckDelay time.Duration
+	// SuccessMatches override the expected registration output per PAN-OS major version, see SuccessMessage
+	SuccessMatches map[int]string
+	// VerifyTimeout, when set, is how long to wait for `show wildfire status` to show the device as
+	// registered after registration was triggered, checking every VerifyInterval
+	VerifyTimeout  time.Duration
+	VerifyInterval time.Duration
+	// SSHKey, when set, is the path of the SSH private key to authenticate with before trying the
+	// password, and SSHKeyPassphrase decrypts it if it is encrypted
+	SSHKey           string
+	SSHKeyPassphrase string
+	// Port, SocketTimeout and OpsTimeout configure the SSH connection, defaulting to DefaultSSHPort
+	// and DefaultSSHTimeout when zero
+	Port          int
+	SocketTimeout time.Duration
+	OpsTimeout    time.Duration
+	// FamilyOpsTimeouts override OpsTimeout for the devices of a hardware family, keyed by the
+	// lowercase family, as large chassis take longer to answer the registration command
+	FamilyOpsTimeouts map[string]time.Duration
+	// CommandPrefix, when set, is prepended to the registration and status commands, e.g. "run" for
+	// accounts that land in configuration mode. Without it, configuration mode is left before the
+	// registration command is sent.
+	CommandPrefix string
+}
+
+// Defaults of the SSH connection settings of Options
+const (
+	DefaultSSHPort    = 22
+	DefaultSSHTimeout = 45 * time.Second
+)
+
+// CommandError is the failure of a command the device rejected, such as an invalid or unauthorized
+// command, with the output it answered so operators see exactly what was rejected
+type CommandError struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+// Error returns the failed command and the reason, followed by the device output on a single line
+func (e *CommandError) Error() string {
+	message := fmt.Sprintf("command %q failed: %v", e.Command, e.Err)
+	if output := strings.Join(strings.Fields(e.Output), " "); output != "" {
+		message += "; device output: " + output
+	}
+	return message
+}
+
+// Unwrap returns the failure reported by the SSH driver
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// UnexpectedOutputError is the output of a registration command that the device accepted but that
+// does not confirm the registration
+type UnexpectedOutputError struct {
+	Output string
+}
+
+// Error returns the unexpected output
+func (e *UnexpectedOutputError) Error() string {
+	return "unexpected command output: " + e.Output
+}
+
+// sleep waits before a status check until ctx is done; tests replace it to avoid waiting
+var sleep = func(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Driver is the subset of the scrapligo generic driver used for WildFire registration
+type Driver interface {
+	Open() error
+	Close() error
+	GetPrompt() (string, error)
+	SendCommand(command string, opts ...util.Option) (*response.Response, error)
+}
+
+// DriverFactory creates the SSH driver connecting to a device address with the given options
+type DriverFactory func(host string, opts ...util.Option) (Driver, error)
+
+// newGenericDriver creates the scrapligo generic driver
+func newGenericDriver(host string, opts ...util.Option) (Driver, error) {
+	return generic.NewDriver(host, opts...)
+}
+
+// newDriver creates the SSH driver for a device; tests replace it with a mock
+var newDriver DriverFactory = newGenericDriver
+
+// SetDriverFactory replaces the SSH driver of the registrations, e.g. with drivers that do not
+// contact real devices. A nil factory restores the scrapligo generic driver.
+func SetDriverFactory(factory DriverFactory) {
+	if factory == nil {
+		factory = newGenericDriver
+	}
+	newDriver = factory
+}
 
-	d, err := generic.NewDriver(
-		device["ip-address"],
-		options.WithAuthNoStrictKey(),
-		options.WithAuthUsername(username),
-		options.WithAuthPassword(password),
-		options.WithTimeoutSocket(45*time.Second),
-		options.WithTimeoutOps(45*time.Second),
-		options.WithTransportType(transport.StandardTransport),
-		options.WithSSHConfigFile(""),
-		options.WithPort(22),
-	)
+// resolveAddresses returns the addresses to connect to for a device address; tests replace it to avoid lookups
+var resolveAddresses = dnscache.Default.Addresses
+
+// ChannelForDevice returns the registration channel for a device.
+// A "channel" field on the device, or a "wildfire-channel=<channel>" tag, overrides the default channel.
+func ChannelForDevice(device map[string]string, defaultChannel string) string {
+	if channel := strings.TrimSpace(device["channel"]); channel != "" {
+		return strings.ToLower(channel)
+	}
+	for _, tag := range strings.Split(device["tags"], ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(tag), "="); ok && key == "wildfire-channel" {
+			return strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	if defaultChannel == "" {
+		return ChannelPublic
+	}
+	return defaultChannel
+}
+
+// RegisterWildFire registers a device with the WildFire cloud service.
+// This function connects to a specified device using SSH, sends a WildFire
+// registration command for the device's registration channel, in the syntax of
+// the device's PAN-OS version, and verifies the output against the success message
+// of that version. It handles connection
+// errors and unexpected command outputs. Cancelling ctx stops waiting for the status checks.
+func RegisterWildFire(ctx context.Context, device map[string]string, username, password string, opts Options, l *logger.Logger) error {
+	channel := ChannelForDevice(device, opts.Channel)
+	if channel != ChannelPublic && channel != ChannelPrivate {
+		return fmt.Errorf("unsupported WildFire registration channel: %s", channel)
+	}
+	major := config.DeviceMajor(device)
+	commands := config.CommandsForMajor(major)
+	cmd, err := commands.WildFireRegistrationCommand(channel, strings.TrimSpace(opts.Server))
 	if err != nil {
-		l.Debug("Failed to create driver:", err)
-		return fmt.Errorf("failed to create driver: %v", err)
+		return err
+	}
+	successMessage := SuccessMessage(major, channel, opts.SuccessMatches)
+	statusCmd := commands.WildFireStatus
+	if prefix := strings.TrimSpace(opts.CommandPrefix); prefix != "" {
+		cmd = prefix + " " + cmd
+		statusCmd = prefix + " " + statusCmd
 	}
 
-	err = d.Open()
+	d, err := connect(device, username, password, opts, l)
 	if err != nil {
-		l.Debug("Failed to open connection:", err)
-		return fmt.Errorf("failed to open connection: %v", err)
+		return err
 	}
 	// Only defer Close() if the connection was successfully opened
 	defer func() {
@@ -49,7 +343,13 @@ func RegisterWildFire(device map[string]string, username, password string, l *lo
 
 	l.Debug("Successfully connected to", device["hostname"])
 
-	cmd := "request wildfire registration channel public"
+	// Operational commands are not available in configuration mode, unless prefixed
+	if opts.CommandPrefix == "" {
+		if err := ensureOperationalMode(d, device["hostname"], l); err != nil {
+			return err
+		}
+	}
+
 	l.Debug("Sending WildFire registration command to", device["hostname"], "Command:", cmd)
 
 	r, err := d.SendCommand(cmd)
@@ -58,17 +358,196 @@ func RegisterWildFire(device map[string]string, username, password string, l *lo
 		return fmt.Errorf("failed to send command: %v", err)
 	}
 	if r.Failed != nil {
-		l.Debug("Command failed:", r.Failed)
-		return fmt.Errorf("command failed: %v", r.Failed)
+		l.Debug("Command failed:", r.Failed, "Output:", r.Result)
+		return &CommandError{Command: cmd, Output: strings.TrimSpace(r.Result), Err: r.Failed}
 	}
 
 	l.Debug("Command output for", device["hostname"], ":", r.Result)
 
-	if !strings.Contains(r.Result, "WildFire registration for Public Cloud is triggered") {
+	if !strings.Contains(r.Result, successMessage) {
 		l.Debug("Unexpected command output for", device["hostname"])
-		return fmt.Errorf("unexpected command output: %s", r.Result)
+		// The output may be truncated although the registration was triggered, so recheck the status once
+		if opts.RecheckDelay <= 0 || !recheckStatus(ctx, d, statusCmd, opts.RecheckDelay, device["hostname"], l) {
+			return &UnexpectedOutputError{Output: r.Result}
+		}
+		l.Debug("WildFire status confirms the registration for", device["hostname"])
+	}
+
+	// Registration completes asynchronously after it was triggered, and can still fail
+	if opts.VerifyTimeout > 0 {
+		if err := verifyRegistration(ctx, d, statusCmd, opts.VerifyInterval, opts.VerifyTimeout, device["hostname"], l); err != nil {
+			return err
+		}
 	}
 
 	l.Debug("Successfully registered WildFire for", device["hostname"])
 	return nil
 }
+
+// connect opens an SSH connection to a device with the connection settings and SSH key of opts,
+// trying each address of its hostname in turn until one accepts the connection. Rejected
+// credentials are not tried against the other addresses. The caller must close the returned driver.
+func connect(device map[string]string, username, password string, opts Options, l *logger.Logger) (Driver, error) {
+	authOpts, err := authOptions(password, opts)
+	if err != nil {
+		return nil, &sshKeyError{err: err}
+	}
+
+	port, socketTimeout, opsTimeout := opts.Port, opts.SocketTimeout, opts.opsTimeout(device)
+	if port <= 0 {
+		port = DefaultSSHPort
+	}
+	if socketTimeout <= 0 {
+		socketTimeout = DefaultSSHTimeout
+	}
+
+	l.Debug("Attempting to connect to", device["hostname"], "at", device["ip-address"])
+
+	var openErr error
+	for _, address := range resolveAddresses(device["ip-address"]) {
+		d, err := newDriver(
+			address,
+			append([]util.Option{
+				options.WithAuthNoStrictKey(),
+				options.WithAuthUsername(username),
+				options.WithTimeoutSocket(socketTimeout),
+				options.WithTimeoutOps(opsTimeout),
+				options.WithTransportType(transport.StandardTransport),
+				options.WithSSHConfigFile(""),
+				options.WithPort(port),
+			}, authOpts...)...,
+		)
+		if err != nil {
+			l.Debug("Failed to create driver:", err)
+			return nil, fmt.Errorf("failed to create driver: %w", err)
+		}
+
+		if err := d.Open(); err != nil {
+			l.Debug("Failed to open connection to", address, ":", err)
+			openErr = fmt.Errorf("failed to open connection: %w", err)
+			if isAuthFailure(err) {
+				break
+			}
+			continue
+		}
+		return d, nil
+	}
+	return nil, openErr
+}
+
+// verifyRegistration checks `show wildfire status` every interval until it shows the registration
+// as completed, and returns an error when it does not within timeout or ctx is done.
+func verifyRegistration(ctx context.Context, d Driver, cmd string, interval, timeout time.Duration, hostname string, l *logger.Logger) error {
+	if interval <= 0 || interval > timeout {
+		interval = timeout
+	}
+
+	lastStatus := "none"
+	for waited := time.Duration(0); waited < timeout; {
+		wait := min(interval, timeout-waited)
+		sleep(ctx, wait)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("WildFire registration was triggered but not verified, last status: %s: %w", lastStatus, err)
+		}
+		waited += wait
+
+		r, err := d.SendCommand(cmd)
+		switch {
+		case err != nil:
+			l.Debug("Failed to check the WildFire status:", err)
+			lastStatus = err.Error()
+		case r.Failed != nil:
+			l.Debug("WildFire status command failed:", r.Failed)
+			lastStatus = r.Failed.Error()
+		default:
+			l.Debug("WildFire status for", hostname, "after", waited, ":", r.Result)
+			if registrationConfirmed(r.Result, completedStatuses) {
+				return nil
+			}
+			lastStatus = statusLine(r.Result)
+		}
+	}
+	return fmt.Errorf("WildFire registration was triggered but not completed within %s, last status: %s", timeout, lastStatus)
+}
+
+// statusLine returns the value of the "Status" line of `show wildfire status`, or the whole
+// trimmed output when it has none.
+func statusLine(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(key), "status") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return strings.TrimSpace(output)
+}
+
+// ensureOperationalMode leaves configuration mode, recognized by its "#" prompt, so operational
+// commands can be sent.
+func ensureOperationalMode(d Driver, hostname string, l *logger.Logger) error {
+	prompt, err := d.GetPrompt()
+	if err != nil {
+		l.Debug("Failed to read the prompt:", err)
+		return fmt.Errorf("failed to read the prompt: %v", err)
+	}
+	if !inConfigurationMode(prompt) {
+		return nil
+	}
+
+	l.Debug("Leaving configuration mode on", hostname, "Prompt:", prompt)
+	r, err := d.SendCommand("exit")
+	if err != nil {
+		return fmt.Errorf("failed to leave configuration mode: %v", err)
+	}
+	if r.Failed != nil {
+		return fmt.Errorf("failed to leave configuration mode: %v", r.Failed)
+	}
+	if prompt, err = d.GetPrompt(); err != nil || inConfigurationMode(prompt) {
+		return fmt.Errorf("failed to leave configuration mode, prompt: %q", prompt)
+	}
+	return nil
+}
+
+// inConfigurationMode reports whether a PAN-OS prompt, e.g. "admin@PA-VM#", is the configuration mode prompt
+func inConfigurationMode(prompt string) bool {
+	return strings.HasSuffix(strings.TrimSpace(prompt), "#")
+}
+
+// recheckStatus waits for delay and then reports whether `show wildfire status` confirms the registration.
+func recheckStatus(ctx context.Context, d Driver, cmd string, delay time.Duration, hostname string, l *logger.Logger) bool {
+	l.Debug("Rechecking the WildFire status of", hostname, "in", delay)
+	sleep(ctx, delay)
+	if ctx.Err() != nil {
+		return false
+	}
+
+	r, err := d.SendCommand(cmd)
+	if err != nil {
+		l.Debug("Failed to recheck the WildFire status:", err)
+		return false
+	}
+	if r.Failed != nil {
+		l.Debug("WildFire status command failed:", r.Failed)
+		return false
+	}
+	l.Debug("WildFire status for", hostname, ":", r.Result)
+	return registrationConfirmed(r.Result, registeredStatuses)
+}
+
+// registrationConfirmed reports whether the output of `show wildfire status` shows the device as
+// registered with a "Status" line in statuses. The "Device registered" line is not enough, as it
+// still reads "yes" while a re-registration is in progress or has failed.
+func registrationConfirmed(output string, statuses []string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "status") {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+		for _, status := range statuses {
+			if value == status {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,713 @@
+package wildfire
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdot65/pan-os-cdss-certificate-registration/config"
+	"github.com/cdot65/pan-os-cdss-certificate-registration/logger"
+	"github.com/scrapli/scrapligo/channel"
+	"github.com/scrapli/scrapligo/response"
+	"github.com/scrapli/scrapligo/transport"
+	"github.com/scrapli/scrapligo/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// mockDriver is a Driver that records the commands it receives and replies with canned output.
+// A command with sequences replies with the next output of its sequence, repeating the last one.
+type mockDriver struct {
+	mu        sync.Mutex
+	host      string
+	opts      []util.Option
+	prompt    string
+	commands  []string
+	outputs   map[string]string
+	sequences map[string][]string
+	calls     map[string]int
+	openErr   error
+	// failed holds the failure reported for a command, as scrapligo does for failed_when_contains output
+	failed map[string]error
+}
+
+func (d *mockDriver) Open() error  { return d.openErr }
+func (d *mockDriver) Close() error { return nil }
+
+func (d *mockDriver) GetPrompt() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.prompt, nil
+}
+
+func (d *mockDriver) SendCommand(command string, opts ...util.Option) (*response.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.commands = append(d.commands, command)
+	// exit leaves configuration mode, like PAN-OS
+	if command == "exit" && strings.HasSuffix(d.prompt, "#") {
+		d.prompt = strings.TrimSuffix(d.prompt, "#") + ">"
+	}
+	if sequence := d.sequences[command]; len(sequence) > 0 {
+		output := sequence[min(d.calls[command], len(sequence)-1)]
+		d.calls[command]++
+		return &response.Response{Result: output}, nil
+	}
+	return &response.Response{Result: d.outputs[command], Failed: d.failed[command]}, nil
+}
+
+// useMockDrivers replaces the driver factory for the duration of the test.
+// Each host gets its own mockDriver in operational mode, returned in the drivers map.
+func useMockDrivers(t *testing.T, outputs map[string]string) map[string]*mockDriver {
+	t.Helper()
+	return useMockDriversWithPrompt(t, "admin@PA-VM>", outputs)
+}
+
+// useMockDriversWithPrompt is useMockDrivers with the given initial prompt.
+func useMockDriversWithPrompt(t *testing.T, prompt string, outputs map[string]string) map[string]*mockDriver {
+	t.Helper()
+	return useSequencedMockDrivers(t, prompt, outputs, nil)
+}
+
+// useSequencedMockDrivers is useMockDriversWithPrompt with output sequences for some commands.
+func useSequencedMockDrivers(t *testing.T, prompt string, outputs map[string]string, sequences map[string][]string) map[string]*mockDriver {
+	t.Helper()
+	var mu sync.Mutex
+	drivers := make(map[string]*mockDriver)
+
+	original := newDriver
+	newDriver = func(host string, opts ...util.Option) (Driver, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		d := &mockDriver{host: host, opts: opts, prompt: prompt, outputs: outputs, sequences: sequences, calls: make(map[string]int)}
+		drivers[host] = d
+		return d, nil
+	}
+	t.Cleanup(func() { newDriver = original })
+
+	return drivers
+}
+
+var defaultOutputs = map[string]string{
+	"request wildfire registration channel public":  "WildFire registration for Public Cloud is triggered",
+	"request wildfire registration channel private": "WildFire registration for Private Cloud is triggered",
+}
+
+func TestChannelForDevice(t *testing.T) {
+	tests := []struct {
+		name           string
+		device         map[string]string
+		defaultChannel string
+		expected       string
+	}{
+		{"Global default", map[string]string{}, ChannelPrivate, ChannelPrivate},
+		{"Empty default", map[string]string{}, "", ChannelPublic},
+		{"Device field", map[string]string{"channel": "Private"}, ChannelPublic, ChannelPrivate},
+		{"Device tag", map[string]string{"tags": "lab, wildfire-channel=private"}, ChannelPublic, ChannelPrivate},
+		{"Field wins over tag", map[string]string{"channel": "public", "tags": "wildfire-channel=private"}, ChannelPrivate, ChannelPublic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ChannelForDevice(tt.device, tt.defaultChannel))
+		})
+	}
+}
+
+func TestRegisterWildFireMixedChannels(t *testing.T) {
+	drivers := useMockDrivers(t, defaultOutputs)
+	l := logger.New(0, false)
+	opts := Options{Channel: ChannelPublic}
+
+	devices := []map[string]string{
+		{"hostname": "fw-public", "ip-address": "10.0.0.1"},
+		{"hostname": "fw-private", "ip-address": "10.0.0.2", "channel": "private"},
+		{"hostname": "fw-tagged", "ip-address": "10.0.0.3", "tags": "wildfire-channel=private"},
+	}
+	for _, device := range devices {
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", opts, l))
+	}
+
+	assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	assert.Equal(t, []string{"request wildfire registration channel private"}, drivers["10.0.0.2"].commands)
+	assert.Equal(t, []string{"request wildfire registration channel private"}, drivers["10.0.0.3"].commands)
+}
+
+func TestRegisterWildFireChannelCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		device   map[string]string
+		expected string
+		output   string
+	}{
+		{"Public default", Options{}, nil, "request wildfire registration channel public", "WildFire registration for Public Cloud is triggered"},
+		{"Private", Options{Channel: ChannelPrivate}, nil, "request wildfire registration channel private", "WildFire registration for Private Cloud is triggered"},
+		{"Public regional cloud", Options{Server: "eu.wildfire.paloaltonetworks.com"}, nil, "request wildfire registration channel public server eu.wildfire.paloaltonetworks.com", "WildFire registration for Public Cloud is triggered"},
+		{"Private appliance", Options{Channel: ChannelPrivate, Server: "10.1.1.50"}, nil, "request wildfire registration channel private server 10.1.1.50", "WildFire registration for Private Cloud is triggered"},
+		{"Device channel with server", Options{Server: "10.1.1.50"}, map[string]string{"channel": "private"}, "request wildfire registration channel private server 10.1.1.50", "WildFire registration for Private Cloud is triggered"},
+	}
+
+	l := logger.New(0, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drivers := useMockDrivers(t, map[string]string{tt.expected: tt.output})
+			device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+			for key, value := range tt.device {
+				device[key] = value
+			}
+
+			require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", tt.opts, l))
+			assert.Equal(t, []string{tt.expected}, drivers["10.0.0.1"].commands)
+		})
+	}
+
+	t.Run("Wrong channel output", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{"request wildfire registration channel private": "WildFire registration for Public Cloud is triggered"})
+		err := RegisterWildFire(context.Background(), map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}, "user", "pass", Options{Channel: ChannelPrivate}, l)
+		assert.ErrorContains(t, err, "unexpected command output")
+	})
+}
+
+func TestRegisterWildFireVersionCommands(t *testing.T) {
+	drivers := useMockDrivers(t, map[string]string{
+		"request wildfire registration":                "WildFire registration is triggered",
+		"request wildfire registration channel public": "WildFire registration for Public Cloud is triggered",
+	})
+	l := logger.New(0, false)
+
+	panos81 := map[string]string{"hostname": "fw-81", "ip-address": "10.0.0.1", "sw-version": "8.1.21-h3", "parsed_version_major": "8"}
+	panos111 := map[string]string{"hostname": "fw-111", "ip-address": "10.0.0.2", "sw-version": "11.1.0-h2", "parsed_version_major": "11"}
+	require.NoError(t, RegisterWildFire(context.Background(), panos81, "user", "pass", Options{}, l))
+	require.NoError(t, RegisterWildFire(context.Background(), panos111, "user", "pass", Options{}, l))
+
+	assert.Equal(t, []string{"request wildfire registration"}, drivers["10.0.0.1"].commands)
+	assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.2"].commands)
+
+	panos81["channel"] = ChannelPrivate
+	assert.ErrorContains(t, RegisterWildFire(context.Background(), panos81, "user", "pass", Options{}, l), "not supported")
+}
+
+func TestSuccessMessage(t *testing.T) {
+	assert.Equal(t, "WildFire registration is triggered", SuccessMessage(8, ChannelPublic, nil))
+	assert.Equal(t, "WildFire registration for Public Cloud is triggered", SuccessMessage(11, ChannelPublic, nil))
+	assert.Equal(t, "WildFire registration for Private Cloud is triggered", SuccessMessage(10, ChannelPrivate, nil))
+	assert.Equal(t, "WildFire registration for Public Cloud is triggered", SuccessMessage(0, ChannelPublic, nil), "unknown versions use the newest message")
+
+	overrides := map[int]string{11: "registration triggered"}
+	assert.Equal(t, "WildFire registration is triggered", SuccessMessage(8, ChannelPublic, overrides))
+	assert.Equal(t, "WildFire registration for Public Cloud is triggered", SuccessMessage(10, ChannelPublic, overrides))
+	assert.Equal(t, "registration triggered", SuccessMessage(11, ChannelPublic, overrides))
+	assert.Equal(t, "registration triggered", SuccessMessage(12, ChannelPrivate, overrides))
+}
+
+func TestRegisterWildFireVersionSuccessMessages(t *testing.T) {
+	l := logger.New(0, false)
+	panos81 := map[string]string{"hostname": "fw-81", "ip-address": "10.0.0.1", "parsed_version_major": "8"}
+	panos111 := map[string]string{"hostname": "fw-111", "ip-address": "10.0.0.2", "parsed_version_major": "11"}
+
+	t.Run("Each version expects its own output", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{
+			"request wildfire registration":                "WildFire registration is triggered",
+			"request wildfire registration channel public": "WildFire registration for Public Cloud is triggered",
+		})
+		assert.NoError(t, RegisterWildFire(context.Background(), panos81, "user", "pass", Options{}, l))
+		assert.NoError(t, RegisterWildFire(context.Background(), panos111, "user", "pass", Options{}, l))
+	})
+
+	t.Run("Output of another version does not match", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{
+			"request wildfire registration":                "WildFire registration for Public Cloud is triggered",
+			"request wildfire registration channel public": "WildFire registration is triggered",
+		})
+		assert.ErrorContains(t, RegisterWildFire(context.Background(), panos81, "user", "pass", Options{}, l), "unexpected command output")
+		assert.ErrorContains(t, RegisterWildFire(context.Background(), panos111, "user", "pass", Options{}, l), "unexpected command output")
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{
+			"request wildfire registration channel public": "Registration with Public Cloud started",
+		})
+		opts := Options{SuccessMatches: map[int]string{11: "Registration with Public Cloud started"}}
+		assert.NoError(t, RegisterWildFire(context.Background(), panos111, "user", "pass", opts, l))
+	})
+}
+
+func TestParseSuccessMatches(t *testing.T) {
+	overrides, err := ParseSuccessMatches("8=WildFire registration is triggered; 11 = registration, triggered;")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{8: "WildFire registration is triggered", 11: "registration, triggered"}, overrides)
+
+	for _, value := range []string{"registration triggered", "x=registration triggered", "0=registration triggered", "11="} {
+		_, err := ParseSuccessMatches(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRegisterWildFireCommandMode(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+
+	t.Run("Operational mode", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l))
+		assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	})
+
+	t.Run("Configuration mode is left first", func(t *testing.T) {
+		drivers := useMockDriversWithPrompt(t, "admin@PA-VM#", defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l))
+		assert.Equal(t, []string{"exit", "request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	})
+
+	t.Run("Custom prefix", func(t *testing.T) {
+		drivers := useMockDriversWithPrompt(t, "admin@PA-VM#", map[string]string{
+			"run request wildfire registration channel public": "WildFire registration for Public Cloud is triggered",
+		})
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{CommandPrefix: "run"}, l))
+		assert.Equal(t, []string{"run request wildfire registration channel public"}, drivers["10.0.0.1"].commands, "a prefixed command is sent in the current mode")
+	})
+
+	t.Run("Custom prefix on status recheck", func(t *testing.T) {
+		originalSleep := sleep
+		sleep = func(context.Context, time.Duration) {}
+		t.Cleanup(func() { sleep = originalSleep })
+		drivers := useMockDriversWithPrompt(t, "admin@PA-VM#", map[string]string{
+			"run show wildfire status": "Status: Registered",
+		})
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{CommandPrefix: "run", RecheckDelay: time.Second}, l))
+		assert.Equal(t, []string{"run request wildfire registration channel public", "run show wildfire status"}, drivers["10.0.0.1"].commands)
+	})
+}
+
+func TestRegisterWildFireErrors(t *testing.T) {
+	l := logger.New(0, false)
+
+	t.Run("Unsupported channel", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		err := RegisterWildFire(context.Background(), map[string]string{"ip-address": "10.0.0.1", "channel": "regional"}, "user", "pass", Options{}, l)
+		assert.ErrorContains(t, err, "unsupported WildFire registration channel")
+		assert.Empty(t, drivers)
+	})
+
+	t.Run("Unexpected output", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{"request wildfire registration channel public": "Server error"})
+		err := RegisterWildFire(context.Background(), map[string]string{"ip-address": "10.0.0.1"}, "user", "pass", Options{}, l)
+		assert.ErrorContains(t, err, "unexpected command output")
+	})
+}
+
+func TestRegisterWildFireStatusRecheck(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+
+	var waited time.Duration
+	originalSleep := sleep
+	sleep = func(_ context.Context, d time.Duration) { waited += d }
+	t.Cleanup(func() { sleep = originalSleep })
+
+	t.Run("Ambiguous output confirmed by status", func(t *testing.T) {
+		waited = 0
+		drivers := useMockDrivers(t, map[string]string{
+			"request wildfire registration channel public": "WildFire registration for Pub",
+			"show wildfire status":                         "Connection info:\n        Wildfire cloud:                wildfire.paloaltonetworks.com\n        Status:                        Registering\n",
+		})
+
+		err := RegisterWildFire(context.Background(), device, "user", "pass", Options{RecheckDelay: 10 * time.Second}, l)
+
+		require.NoError(t, err)
+		assert.Equal(t, 10*time.Second, waited)
+		assert.Equal(t, []string{"request wildfire registration channel public", "show wildfire status"}, drivers["10.0.0.1"].commands)
+	})
+
+	t.Run("Status does not confirm", func(t *testing.T) {
+		useMockDrivers(t, map[string]string{
+			"request wildfire registration channel public": "Server error",
+			"show wildfire status":                         "Connection info:\n        Status:                        Disabled due to configuration\n",
+		})
+
+		err := RegisterWildFire(context.Background(), device, "user", "pass", Options{RecheckDelay: time.Second}, l)
+
+		assert.ErrorContains(t, err, "unexpected command output")
+	})
+
+	t.Run("Recheck disabled", func(t *testing.T) {
+		drivers := useMockDrivers(t, map[string]string{
+			"request wildfire registration channel public": "WildFire registration for Pub",
+			"show wildfire status":                         "Status: Registering",
+		})
+
+		err := RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l)
+
+		assert.ErrorContains(t, err, "unexpected command output")
+		assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	})
+}
+
+func TestRegistrationConfirmed(t *testing.T) {
+	assert.False(t, registrationConfirmed("Device registered :   yes\n", registeredStatuses), "only the Status line confirms the registration")
+	assert.False(t, registrationConfirmed("Device registered : yes\nStatus: Registration failed", completedStatuses))
+	assert.True(t, registrationConfirmed("  Status:   Registered", registeredStatuses))
+	assert.True(t, registrationConfirmed("Status: Registering", registeredStatuses))
+	assert.False(t, registrationConfirmed("Status: Registering", completedStatuses), "registration is still in progress")
+	assert.False(t, registrationConfirmed("Device registered : no\nStatus: Idle", registeredStatuses))
+	assert.False(t, registrationConfirmed("", registeredStatuses))
+}
+
+func TestRegisterWildFireVerification(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+	opts := Options{VerifyInterval: 10 * time.Second, VerifyTimeout: 30 * time.Second}
+
+	var waits []time.Duration
+	originalSleep := sleep
+	sleep = func(_ context.Context, d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { sleep = originalSleep })
+
+	t.Run("Triggered then failing status", func(t *testing.T) {
+		waits = nil
+		drivers := useSequencedMockDrivers(t, "admin@PA-VM>", defaultOutputs, map[string][]string{
+			"show wildfire status": {"Status: Registering", "Device registered : no\nStatus: Registration failed"},
+		})
+
+		err := RegisterWildFire(context.Background(), device, "user", "pass", opts, l)
+
+		assert.EqualError(t, err, "WildFire registration was triggered but not completed within 30s, last status: Registration failed")
+		assert.False(t, IsSoftFailure(err))
+		assert.Equal(t, []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second}, waits)
+		assert.Equal(t, []string{"request wildfire registration channel public", "show wildfire status", "show wildfire status", "show wildfire status"}, drivers["10.0.0.1"].commands)
+	})
+
+	t.Run("Triggered then registered", func(t *testing.T) {
+		waits = nil
+		drivers := useSequencedMockDrivers(t, "admin@PA-VM>", defaultOutputs, map[string][]string{
+			"show wildfire status": {"Status: Registering", "Device registered : yes\nStatus: Registered"},
+		})
+
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", opts, l))
+		assert.Len(t, waits, 2)
+		assert.Len(t, drivers["10.0.0.1"].commands, 3)
+	})
+
+	t.Run("Cancelled while waiting", func(t *testing.T) {
+		waits = nil
+		drivers := useSequencedMockDrivers(t, "admin@PA-VM>", defaultOutputs, map[string][]string{
+			"show wildfire status": {"Status: Registering"},
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := RegisterWildFire(ctx, device, "user", "pass", opts, l)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Len(t, waits, 1, "no further status check once cancelled")
+		assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l))
+		assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.1"].commands)
+	})
+}
+
+func TestIsSoftFailure(t *testing.T) {
+	command := "request wildfire registration channel public"
+	assert.True(t, IsSoftFailure(&UnexpectedOutputError{Output: "Server is busy, try again later"}))
+	assert.True(t, IsSoftFailure(&CommandError{Command: command, Output: "Server error : Config is locked by admin", Err: errors.New("operation failed")}))
+	assert.True(t, IsSoftFailure(fmt.Errorf("registration: %w", &UnexpectedOutputError{Output: "\nAnother commit is in progress. Please try again later.\n"})))
+
+	// The same words outside the registration output, or not at the start of a message, are hard failures
+	assert.False(t, IsSoftFailure(errors.New("unexpected command output: Server is busy, try again later")), "only typed registration errors are considered")
+	assert.False(t, IsSoftFailure(errors.New("failed to open connection: ssh: handshake failed: resource temporarily unavailable")))
+	assert.False(t, IsSoftFailure(errors.New("failed to open connection: account locked")))
+	assert.False(t, IsSoftFailure(&UnexpectedOutputError{Output: "WildFire registration failed: the device certificate is locked in progress"}))
+	assert.False(t, IsSoftFailure(&CommandError{Command: command, Output: "Invalid syntax.", Err: errors.New("operation failed")}))
+	assert.False(t, IsSoftFailure(nil))
+}
+
+// writeSSHKey writes a new ed25519 private key, encrypted if passphrase is set, and returns its path
+func writeSSHKey(t *testing.T, passphrase string) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	return path
+}
+
+// transportArgs applies driver options to the transport arguments they configure
+func transportArgs(opts []util.Option) (*transport.Args, *transport.SSHArgs) {
+	args, sshArgs := &transport.Args{}, &transport.SSHArgs{}
+	for _, opt := range opts {
+		_ = opt(args)
+		_ = opt(sshArgs)
+	}
+	return args, sshArgs
+}
+
+// channelArgs applies driver options to the channel settings they configure
+func channelArgs(opts []util.Option) *channel.Channel {
+	c := &channel.Channel{}
+	for _, opt := range opts {
+		_ = opt(c)
+	}
+	return c
+}
+
+func TestRegisterWildFireConnectionSettings(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+	tests := []struct {
+		name          string
+		opts          Options
+		port          int
+		socketTimeout time.Duration
+		opsTimeout    time.Duration
+	}{
+		{"Defaults", Options{}, 22, 45 * time.Second, 45 * time.Second},
+		{"Custom", Options{Port: 2222, SocketTimeout: 2 * time.Minute, OpsTimeout: 90 * time.Second}, 2222, 2 * time.Minute, 90 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drivers := useMockDrivers(t, defaultOutputs)
+			require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", tt.opts, l))
+
+			opts := drivers["10.0.0.1"].opts
+			args, _ := transportArgs(opts)
+			assert.Equal(t, tt.port, args.Port)
+			assert.Equal(t, tt.socketTimeout, args.TimeoutSocket)
+			assert.Equal(t, tt.opsTimeout, channelArgs(opts).TimeoutOps)
+		})
+	}
+}
+
+func TestRegisterWildFireFamilyTimeout(t *testing.T) {
+	l := logger.New(0, false)
+	opts := Options{OpsTimeout: 45 * time.Second, FamilyOpsTimeouts: map[string]time.Duration{"7000": 3 * time.Minute}}
+	tests := []struct {
+		name       string
+		device     map[string]string
+		opsTimeout time.Duration
+	}{
+		{"PA-7000 gets the family override", map[string]string{"hostname": "fw-7080", "ip-address": "10.0.0.1", "family": "7000", "model": "PA-7080"}, 3 * time.Minute},
+		{"PA-220 keeps the default", map[string]string{"hostname": "fw-220", "ip-address": "10.0.0.1", "family": "220", "model": "PA-220"}, 45 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drivers := useMockDrivers(t, defaultOutputs)
+			require.NoError(t, RegisterWildFire(context.Background(), tt.device, "user", "pass", opts, l))
+			assert.Equal(t, tt.opsTimeout, channelArgs(drivers["10.0.0.1"].opts).TimeoutOps)
+		})
+	}
+}
+
+func TestParseFamilyTimeouts(t *testing.T) {
+	timeouts, err := ParseFamilyTimeouts("7000=3m, VM = 90s,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"7000": 3 * time.Minute, "vm": 90 * time.Second}, timeouts)
+
+	for _, value := range []string{"7000", "=3m", "7000=3", "7000=0s", "7000=-1m"} {
+		_, err := ParseFamilyTimeouts(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRegisterWildFireCommandFailure(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+	command := "request wildfire registration channel public"
+	failure := errors.New("operation failed, output contains 'Invalid syntax.'")
+
+	original := newDriver
+	newDriver = func(host string, opts ...util.Option) (Driver, error) {
+		return &mockDriver{
+			host:    host,
+			prompt:  "admin@PA-VM>",
+			outputs: map[string]string{command: "\n  request wildfire registration\n  Invalid syntax.\n"},
+			failed:  map[string]error{command: failure},
+			calls:   make(map[string]int),
+		}, nil
+	}
+	t.Cleanup(func() { newDriver = original })
+
+	err := RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l)
+
+	var commandErr *CommandError
+	require.ErrorAs(t, err, &commandErr)
+	assert.Equal(t, command, commandErr.Command)
+	assert.Equal(t, "request wildfire registration\n  Invalid syntax.", commandErr.Output)
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, `command "request wildfire registration channel public" failed: operation failed, output contains 'Invalid syntax.'; device output: request wildfire registration Invalid syntax.`, err.Error())
+}
+
+func TestRegisterWildFireAddressFallback(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "fw-1.example.com"}
+	original := resolveAddresses
+	resolveAddresses = func(host string) []string { return []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} }
+	t.Cleanup(func() { resolveAddresses = original })
+
+	t.Run("Next address after a connection failure", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		opened := newDriver
+		newDriver = func(host string, opts ...util.Option) (Driver, error) {
+			d, err := opened(host, opts...)
+			if host == "192.0.2.1" {
+				d.(*mockDriver).openErr = errors.New("dial tcp 192.0.2.1:22: connect: no route to host")
+			}
+			return d, err
+		}
+
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l))
+
+		assert.Empty(t, drivers["192.0.2.1"].commands)
+		assert.NotEmpty(t, drivers["192.0.2.2"].commands, "the registration runs on the first address that answers")
+		assert.NotContains(t, drivers, "192.0.2.3")
+	})
+
+	t.Run("Rejected credentials are not tried again", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		opened := newDriver
+		newDriver = func(host string, opts ...util.Option) (Driver, error) {
+			d, err := opened(host, opts...)
+			d.(*mockDriver).openErr = util.ErrAuthError
+			return d, err
+		}
+
+		err := RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l)
+
+		assert.ErrorIs(t, err, util.ErrAuthError)
+		assert.Len(t, drivers, 1)
+	})
+}
+
+func TestRegisterWildFireSSHKey(t *testing.T) {
+	l := logger.New(0, false)
+	device := map[string]string{"hostname": "fw-1", "ip-address": "10.0.0.1"}
+	plainKey := writeSSHKey(t, "")
+	encryptedKey := writeSSHKey(t, "s3cret")
+
+	t.Run("Password only", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{}, l))
+
+		args, sshArgs := transportArgs(drivers["10.0.0.1"].opts)
+		assert.Equal(t, "pass", args.Password)
+		assert.Empty(t, sshArgs.PrivateKeyPath)
+		assert.Nil(t, args.UserImplementation)
+	})
+
+	t.Run("Key with password fallback", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{SSHKey: plainKey}, l))
+
+		args, sshArgs := transportArgs(drivers["10.0.0.1"].opts)
+		assert.Equal(t, "pass", args.Password)
+		assert.Equal(t, plainKey, sshArgs.PrivateKeyPath)
+		assert.Nil(t, args.UserImplementation)
+	})
+
+	t.Run("Encrypted key", func(t *testing.T) {
+		drivers := useMockDrivers(t, defaultOutputs)
+		require.NoError(t, RegisterWildFire(context.Background(), device, "user", "pass", Options{SSHKey: encryptedKey, SSHKeyPassphrase: "s3cret"}, l))
+
+		args, _ := transportArgs(drivers["10.0.0.1"].opts)
+		assert.Equal(t, "pass", args.Password)
+		assert.IsType(t, &keyTransport{}, args.UserImplementation)
+	})
+
+	errorTests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"Encrypted key without passphrase", Options{SSHKey: encryptedKey}, "is encrypted and no passphrase is set"},
+		{"Wrong passphrase", Options{SSHKey: encryptedKey, SSHKeyPassphrase: "wrong"}, "failed to decrypt SSH key"},
+		{"Missing key", Options{SSHKey: filepath.Join(t.TempDir(), "missing")}, "failed to read SSH key"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			drivers := useMockDrivers(t, defaultOutputs)
+			assert.ErrorContains(t, RegisterWildFire(context.Background(), device, "user", "pass", tt.opts, l), tt.expected)
+			assert.Empty(t, drivers, "no connection is attempted")
+		})
+	}
+}
+
+func TestRegisterWildFireConfigCommands(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "panorama.yaml")
+	secretsFile := filepath.Join(dir, ".secrets.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+panorama:
+  - hostname: panorama.example.com
+commands:
+  12:
+    wildfire_registration: 'request wildfire cloud-registration channel %s'
+    success_messages:
+      public: 'Cloud registration started'
+`), 0o600))
+	require.NoError(t, os.WriteFile(secretsFile, []byte("auth: {}\n"), 0o600))
+
+	conf, err := config.Load(configFile, secretsFile, &config.Flags{})
+	require.NoError(t, err)
+
+	originalCommands := make(map[int]config.Commands)
+	for version, commands := range config.VersionCommands {
+		originalCommands[version] = commands
+	}
+	originalMessages := make(map[int]map[string]string)
+	for version, messages := range versionSuccessMessages {
+		originalMessages[version] = messages
+	}
+	t.Cleanup(func() {
+		config.VersionCommands = originalCommands
+		versionSuccessMessages = originalMessages
+	})
+	config.ApplyCommandOverrides(conf.Commands)
+	SetSuccessMessages(conf.Commands)
+
+	drivers := useMockDrivers(t, map[string]string{
+		"request wildfire cloud-registration channel public": "Cloud registration started",
+		"request wildfire registration channel public":       "WildFire registration for Public Cloud is triggered",
+	})
+	l := logger.New(0, false)
+	panos12 := map[string]string{"hostname": "fw-12", "ip-address": "10.0.0.1", "sw-version": "12.1.0"}
+	panos11 := map[string]string{"hostname": "fw-11", "ip-address": "10.0.0.2", "sw-version": "11.1.0"}
+
+	require.NoError(t, RegisterWildFire(context.Background(), panos12, "user", "pass", Options{}, l))
+	require.NoError(t, RegisterWildFire(context.Background(), panos11, "user", "pass", Options{}, l))
+
+	assert.Equal(t, []string{"request wildfire cloud-registration channel public"}, drivers["10.0.0.1"].commands)
+	assert.Equal(t, []string{"request wildfire registration channel public"}, drivers["10.0.0.2"].commands, "older versions keep the compiled-in commands")
+}
+
+func TestLoadInvalidCommandTemplate(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "panorama.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("commands:\n  12:\n    wildfire_registration: 'request wildfire registration %s %d'\n"), 0o600))
+
+	_, err := config.Load(configFile, filepath.Join(dir, "missing.yaml"), &config.Flags{})
+
+	assert.ErrorContains(t, err, "single %s")
+}